@@ -11,11 +11,17 @@ import (
 	"ruff.io/tio/config"
 )
 
-func OpenapiConfig() restfulspec.Config {
+// OpenapiConfig the enrichers are applied after the default enrichment, eg: to add docs of dynamic definitions
+func OpenapiConfig(enrichers ...func(*spec.Swagger)) restfulspec.Config {
 	c := restfulspec.Config{
-		WebServices:                   restful.RegisteredWebServices(), // you control what services are visible
-		APIPath:                       "/apidocs.json",
-		PostBuildSwaggerObjectHandler: enrichSwaggerObject,
+		WebServices: restful.RegisteredWebServices(), // you control what services are visible
+		APIPath:     "/apidocs.json",
+		PostBuildSwaggerObjectHandler: func(swo *spec.Swagger) {
+			enrichSwaggerObject(swo)
+			for _, e := range enrichers {
+				e(swo)
+			}
+		},
 		ModelTypeNameHandler: func(t reflect.Type) (string, bool) {
 			key := t.String()
 			key = strings.ReplaceAll(key, "/", ".")
@@ -26,6 +32,27 @@ func OpenapiConfig() restfulspec.Config {
 	return c
 }
 
+// OpenapiService serves the api docs like restfulspec.NewOpenAPIService,
+// but rebuilds the docs on every request so that dynamic definitions are up-to-date.
+func OpenapiService(c restfulspec.Config) *restful.WebService {
+	ws := new(restful.WebService)
+	ws.Path(c.APIPath)
+	ws.Produces(restful.MIME_JSON)
+	if !c.DisableCORS {
+		ws.Filter(func(r *restful.Request, w *restful.Response, chain *restful.FilterChain) {
+			if origin := r.HeaderParameter(restful.HEADER_Origin); origin != "" &&
+				w.Header().Get(restful.HEADER_AccessControlAllowOrigin) == "" {
+				w.AddHeader(restful.HEADER_AccessControlAllowOrigin, origin)
+			}
+			chain.ProcessFilter(r, w)
+		})
+	}
+	ws.Route(ws.GET("/").To(func(r *restful.Request, w *restful.Response) {
+		_ = w.WriteAsJson(restfulspec.BuildSwagger(c))
+	}))
+	return ws
+}
+
 func enrichSwaggerObject(swo *spec.Swagger) {
 	swo.Security = []map[string][]string{{"basic": {}}}
 	swo.SecurityDefinitions = map[string]*spec.SecurityScheme{
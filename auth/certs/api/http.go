@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	"ruff.io/tio/auth/certs"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+)
+
+// Service adds routes of thing certificates to thingWs, and returns the web service of CA certificates
+func Service(ctx context.Context, svc certs.Service, thingWs *restful.WebService) *restful.WebService {
+	addCertRoutes(ctx, svc, thingWs)
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/caCerts").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	tags := []string{"certificates"}
+
+	ws.Route(ws.GET("/").
+		To(func(r *restful.Request, w *restful.Response) {
+			p, err := svc.QueryCA(ctx, getPageQuery(r))
+			sendResp(w, p, err)
+		}).
+		Operation("query-ca").
+		Doc("get CA certificates").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(certs.CAPage{})))
+
+	ws.Route(ws.POST("/").
+		To(func(r *restful.Request, w *restful.Response) {
+			var req certs.RegisterCAReq
+			if err := r.ReadEntity(&req); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			ca, err := svc.RegisterCA(ctx, req)
+			sendResp(w, ca, err)
+		}).
+		Operation("register-ca").
+		Doc("register CA certificate").
+		Notes("Certificates signed by the CA, with thing id as their subject common name, "+
+			"are registered to the thing automatically at its first connection.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(certs.RegisterCAReq{}).
+		Returns(200, "OK", rest.RespOK(certs.CA{})))
+
+	ws.Route(ws.GET("/{fingerprint}").
+		To(func(r *restful.Request, w *restful.Response) {
+			ca, err := svc.GetCA(ctx, r.PathParameter("fingerprint"))
+			sendResp(w, ca, err)
+		}).
+		Operation("get-ca").
+		Doc("get CA certificate").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("fingerprint", "SHA-256 fingerprint of the certificate")).
+		Returns(200, "OK", rest.RespOK(certs.CA{})))
+
+	ws.Route(ws.POST("/{fingerprint}/revoke").
+		To(func(r *restful.Request, w *restful.Response) {
+			ca, err := svc.RevokeCA(ctx, r.PathParameter("fingerprint"))
+			sendResp(w, ca, err)
+		}).
+		Operation("revoke-ca").
+		Doc("revoke CA certificate").
+		Notes("Certificates registered automatically from the CA are refused, and things using them are disconnected.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("fingerprint", "SHA-256 fingerprint of the certificate")).
+		Returns(200, "OK", rest.RespOK(certs.CA{})))
+
+	ws.Route(ws.DELETE("/{fingerprint}").
+		To(func(r *restful.Request, w *restful.Response) {
+			err := svc.DeleteCA(ctx, r.PathParameter("fingerprint"))
+			sendResp(w, "", err)
+		}).
+		Operation("delete-ca").
+		Doc("delete CA certificate").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("fingerprint", "SHA-256 fingerprint of the certificate")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	return ws
+}
+
+func addCertRoutes(ctx context.Context, svc certs.Service, ws *restful.WebService) {
+	tags := []string{"certificates"}
+
+	ws.Route(ws.GET("/{id}/certs").
+		To(func(r *restful.Request, w *restful.Response) {
+			l, err := svc.ListCerts(ctx, r.PathParameter("id"))
+			sendResp(w, l, err)
+		}).
+		Operation("list-certs").
+		Doc("get certificates of the thing").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Returns(200, "OK", rest.RespOK([]certs.Cert{})))
+
+	ws.Route(ws.POST("/{id}/certs").
+		To(func(r *restful.Request, w *restful.Response) {
+			var req certs.RegisterCertReq
+			if err := r.ReadEntity(&req); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			c, err := svc.RegisterCert(ctx, r.PathParameter("id"), req)
+			sendResp(w, c, err)
+		}).
+		Operation("register-cert").
+		Doc("register certificate to the thing").
+		Notes("The thing should be of auth type certs, it can connect to tls listeners of the broker with the certificate.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Reads(certs.RegisterCertReq{}).
+		Returns(200, "OK", rest.RespOK(certs.Cert{})))
+
+	ws.Route(ws.GET("/{id}/certs/{fingerprint}").
+		To(func(r *restful.Request, w *restful.Response) {
+			c, err := svc.GetCert(ctx, r.PathParameter("id"), r.PathParameter("fingerprint"))
+			sendResp(w, c, err)
+		}).
+		Operation("get-cert").
+		Doc("get certificate of the thing").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.PathParameter("fingerprint", "SHA-256 fingerprint of the certificate")).
+		Returns(200, "OK", rest.RespOK(certs.Cert{})))
+
+	ws.Route(ws.POST("/{id}/certs/{fingerprint}/revoke").
+		To(func(r *restful.Request, w *restful.Response) {
+			c, err := svc.RevokeCert(ctx, r.PathParameter("id"), r.PathParameter("fingerprint"))
+			sendResp(w, c, err)
+		}).
+		Operation("revoke-cert").
+		Doc("revoke certificate of the thing, the thing is disconnected").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.PathParameter("fingerprint", "SHA-256 fingerprint of the certificate")).
+		Returns(200, "OK", rest.RespOK(certs.Cert{})))
+
+	ws.Route(ws.DELETE("/{id}/certs/{fingerprint}").
+		To(func(r *restful.Request, w *restful.Response) {
+			err := svc.DeleteCert(ctx, r.PathParameter("id"), r.PathParameter("fingerprint"))
+			sendResp(w, "", err)
+		}).
+		Operation("delete-cert").
+		Doc("delete certificate of the thing").
+		Notes("A deleted certificate signed by registered CA is registered again at next connection, revoke it to refuse the certificate.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.PathParameter("fingerprint", "SHA-256 fingerprint of the certificate")).
+		Returns(200, "OK", rest.RespOK("")))
+}
+
+func sendResp[T any](w *restful.Response, data T, err error) {
+	if err != nil {
+		log.Infof("Certificate api error: %v", err)
+		checkErrAndSend(err, w)
+		return
+	}
+	rest.SendRespOK(w, data)
+}
+
+func getPageQuery(r *restful.Request) model.PageQuery {
+	var err error
+	q := model.PageQuery{}
+	if q.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex")); err != nil || q.PageIndex < 1 {
+		q.PageIndex = 1
+	}
+	if q.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize")); err != nil || q.PageSize < 1 {
+		q.PageSize = 10
+	}
+	return q
+}
+
+func checkErrAndSend(err error, w http.ResponseWriter) {
+	var he model.HttpErr
+	if ok := errors.As(err, &he); ok {
+		rest.SendResp(w, he.HttpCode, rest.Resp[string]{Code: he.Code, Message: err.Error()})
+	} else {
+		rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+	}
+}
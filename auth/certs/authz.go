@@ -0,0 +1,34 @@
+package certs
+
+import (
+	"context"
+	"crypto/x509"
+
+	"ruff.io/tio/connector/mqtt/embed"
+	"ruff.io/tio/pkg/log"
+)
+
+func AuthzMqttClient(ctx context.Context, svc Service) embed.CertAuthzFn {
+	return func(connParams embed.ConnectParams, chain []*x509.Certificate) (string, bool) {
+		user, clientId := string(connParams.Username), connParams.ClientIdentifier
+		th, err := svc.Authenticate(ctx, chain)
+		if err != nil {
+			log.Infof("Mqtt client user %s client %s is not authorized by certificate: %v", user, clientId, err)
+			return "", false
+		}
+		if user != "" && user != th.Id {
+			log.Infof("Mqtt client user %s client %s is not authorized cause user is not thing %q of the certificate",
+				user, clientId, th.Id)
+			return "", false
+		}
+		if !th.Enabled {
+			log.Infof("Mqtt client thing %s client %s is not authorized cause thing is not Enabled", th.Id, clientId)
+			return "", false
+		}
+		if !connParams.Clean {
+			log.Warnf("Mqtt client thing %s client %s authz error: things can not be allowed to use cleanSession false", th.Id, clientId)
+			return "", false
+		}
+		return th.Id, true
+	}
+}
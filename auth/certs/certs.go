@@ -0,0 +1,132 @@
+package certs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/thing"
+)
+
+// X.509 client certificate authentication of things, for things of auth type certs.
+// A certificate of a thing is either registered to it explicitly by its fingerprint,
+// or signed by a registered CA with the thing id as its subject common name,
+// in which case it's registered to the thing automatically at its first connection.
+// Revoked certificates, and certificates registered automatically from a revoked CA, are refused.
+
+type Status string
+
+const (
+	StatusActive  Status = "ACTIVE"
+	StatusRevoked Status = "REVOKED"
+)
+
+type Cert struct {
+	Fingerprint    string     `json:"fingerprint" description:"SHA-256 fingerprint of the certificate in hex"`
+	ThingId        string     `json:"thingId"`
+	CaFingerprint  string     `json:"caFingerprint,omitempty" description:"fingerprint of the CA, only for certificates registered automatically"`
+	Subject        string     `json:"subject"`
+	Issuer         string     `json:"issuer"`
+	SerialNumber   string     `json:"serialNumber"`
+	NotBefore      time.Time  `json:"notBefore"`
+	NotAfter       time.Time  `json:"notAfter"`
+	Status         Status     `json:"status"`
+	CertificatePem string     `json:"certificatePem"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type CA struct {
+	Fingerprint    string     `json:"fingerprint" description:"SHA-256 fingerprint of the certificate in hex"`
+	Description    string     `json:"description"`
+	Subject        string     `json:"subject"`
+	NotBefore      time.Time  `json:"notBefore"`
+	NotAfter       time.Time  `json:"notAfter"`
+	Status         Status     `json:"status"`
+	CertificatePem string     `json:"certificatePem"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type RegisterCertReq struct {
+	CertificatePem string `json:"certificatePem" description:"PEM encoded certificate"`
+}
+
+type RegisterCAReq struct {
+	CertificatePem string `json:"certificatePem" description:"PEM encoded CA certificate"`
+	Description    string `json:"description" optional:"true"`
+}
+
+type CAPage = model.PageData[CA]
+
+type Service interface {
+	RegisterCert(ctx context.Context, thingId string, req RegisterCertReq) (Cert, error)
+	GetCert(ctx context.Context, thingId, fingerprint string) (Cert, error)
+	ListCerts(ctx context.Context, thingId string) ([]Cert, error)
+	// RevokeCert refuses the certificate and closes connection of the thing
+	RevokeCert(ctx context.Context, thingId, fingerprint string) (Cert, error)
+	DeleteCert(ctx context.Context, thingId, fingerprint string) error
+
+	RegisterCA(ctx context.Context, req RegisterCAReq) (CA, error)
+	GetCA(ctx context.Context, fingerprint string) (CA, error)
+	QueryCA(ctx context.Context, pq model.PageQuery) (CAPage, error)
+	// RevokeCA refuses certificates signed by the CA and closes connections of things using them
+	RevokeCA(ctx context.Context, fingerprint string) (CA, error)
+	DeleteCA(ctx context.Context, fingerprint string) error
+
+	// Authenticate verifies the certificate chain presented by a client, the first one is its own certificate.
+	// It returns the thing the certificate belongs to, or model.ErrAuthentication.
+	Authenticate(ctx context.Context, chain []*x509.Certificate) (*thing.Thing, error)
+}
+
+type Repo interface {
+	CreateCert(ctx context.Context, e *CertEntity) error
+	GetCert(ctx context.Context, fingerprint string) (*CertEntity, error)
+	ListCerts(ctx context.Context, thingId string) ([]CertEntity, error)
+	// ThingsOfCA returns things having active certificates registered from the CA
+	ThingsOfCA(ctx context.Context, caFingerprint string) ([]string, error)
+	UpdateCertStatus(ctx context.Context, fingerprint string, status Status, revokedAt *time.Time) error
+	DeleteCert(ctx context.Context, fingerprint string) error
+	// DeleteByThing deletes certificates of the thing, it's a thing.DeleteHook
+	DeleteByThing(ctx context.Context, thingId string) error
+
+	CreateCA(ctx context.Context, e *CAEntity) error
+	GetCA(ctx context.Context, fingerprint string) (*CAEntity, error)
+	QueryCA(ctx context.Context, pq model.PageQuery) (model.PageData[CAEntity], error)
+	ListActiveCA(ctx context.Context) ([]CAEntity, error)
+	UpdateCAStatus(ctx context.Context, fingerprint string, status Status, revokedAt *time.Time) error
+	DeleteCA(ctx context.Context, fingerprint string) error
+}
+
+// Fingerprint is SHA-256 of the DER encoded certificate in hex
+func Fingerprint(c *x509.Certificate) string {
+	h := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(h[:])
+}
+
+// ParsePem parses the first certificate of PEM encoded data
+func ParsePem(s string) (*x509.Certificate, error) {
+	rest := []byte(s)
+	for {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			return nil, errors.WithMessage(model.ErrInvalidParams, "no certificate found in pem")
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, errors.WithMessagef(model.ErrInvalidParams, "parse certificate: %v", err)
+		}
+		return c, nil
+	}
+}
@@ -0,0 +1,109 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+)
+
+type CertEntity struct {
+	Fingerprint   string `gorm:"primaryKey;size:64"`
+	ThingId       string `gorm:"size:64;NOT NULL;index"`
+	CaFingerprint string `gorm:"size:64;NOT NULL;default:'';index"`
+	Subject       string `gorm:"size:512;NOT NULL;default:''"`
+	Issuer        string `gorm:"size:512;NOT NULL;default:''"`
+	SerialNumber  string `gorm:"size:128;NOT NULL;default:''"`
+	NotBefore     time.Time
+	NotAfter      time.Time
+	Status        Status `gorm:"size:16;NOT NULL"`
+	Pem           string `gorm:"type:text;NOT NULL"`
+	RevokedAt     *time.Time
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (CertEntity) TableName() string {
+	return "thing_cert"
+}
+
+type CAEntity struct {
+	Fingerprint string `gorm:"primaryKey;size:64"`
+	Description string `gorm:"size:512;NOT NULL;default:''"`
+	Subject     string `gorm:"size:512;NOT NULL;default:''"`
+	NotBefore   time.Time
+	NotAfter    time.Time
+	Status      Status `gorm:"size:16;NOT NULL"`
+	Pem         string `gorm:"type:text;NOT NULL"`
+	RevokedAt   *time.Time
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (CAEntity) TableName() string {
+	return "ca_cert"
+}
+
+func newCertEntity(thingId string, c *x509.Certificate) CertEntity {
+	return CertEntity{
+		Fingerprint:  Fingerprint(c),
+		ThingId:      thingId,
+		Subject:      c.Subject.String(),
+		Issuer:       c.Issuer.String(),
+		SerialNumber: c.SerialNumber.Text(16),
+		NotBefore:    c.NotBefore,
+		NotAfter:     c.NotAfter,
+		Status:       StatusActive,
+		Pem:          toPem(c),
+	}
+}
+
+func newCAEntity(description string, c *x509.Certificate) CAEntity {
+	return CAEntity{
+		Fingerprint: Fingerprint(c),
+		Description: description,
+		Subject:     c.Subject.String(),
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		Status:      StatusActive,
+		Pem:         toPem(c),
+	}
+}
+
+func toPem(c *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}))
+}
+
+func toCert(e CertEntity) Cert {
+	return Cert{
+		Fingerprint:    e.Fingerprint,
+		ThingId:        e.ThingId,
+		CaFingerprint:  e.CaFingerprint,
+		Subject:        e.Subject,
+		Issuer:         e.Issuer,
+		SerialNumber:   e.SerialNumber,
+		NotBefore:      e.NotBefore,
+		NotAfter:       e.NotAfter,
+		Status:         e.Status,
+		CertificatePem: e.Pem,
+		RevokedAt:      e.RevokedAt,
+		UpdatedAt:      e.UpdatedAt,
+		CreatedAt:      e.CreatedAt,
+	}
+}
+
+func toCA(e CAEntity) CA {
+	return CA{
+		Fingerprint:    e.Fingerprint,
+		Description:    e.Description,
+		Subject:        e.Subject,
+		NotBefore:      e.NotBefore,
+		NotAfter:       e.NotAfter,
+		Status:         e.Status,
+		CertificatePem: e.Pem,
+		RevokedAt:      e.RevokedAt,
+		UpdatedAt:      e.UpdatedAt,
+		CreatedAt:      e.CreatedAt,
+	}
+}
@@ -0,0 +1,119 @@
+package certs
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ruff.io/tio/pkg/model"
+)
+
+type repo struct {
+	db *gorm.DB
+}
+
+var _ Repo = (*repo)(nil)
+
+func NewRepo(db *gorm.DB) Repo {
+	return &repo{db: db}
+}
+
+func (r *repo) CreateCert(ctx context.Context, e *CertEntity) error {
+	// the same certificate may be registered by concurrent connections
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(e).Error
+	return errors.Wrap(err, "create thing cert")
+}
+
+func (r *repo) GetCert(ctx context.Context, fingerprint string) (*CertEntity, error) {
+	var e CertEntity
+	err := r.db.WithContext(ctx).Where("fingerprint = ?", fingerprint).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get thing cert")
+	}
+	return &e, nil
+}
+
+func (r *repo) ListCerts(ctx context.Context, thingId string) ([]CertEntity, error) {
+	l := make([]CertEntity, 0)
+	err := r.db.WithContext(ctx).Where("thing_id = ?", thingId).Order("created_at").Find(&l).Error
+	return l, errors.Wrap(err, "list thing cert")
+}
+
+func (r *repo) ThingsOfCA(ctx context.Context, caFingerprint string) ([]string, error) {
+	var l []string
+	err := r.db.WithContext(ctx).Model(&CertEntity{}).
+		Distinct("thing_id").
+		Where("ca_fingerprint = ? AND status = ?", caFingerprint, StatusActive).
+		Pluck("thing_id", &l).Error
+	return l, errors.Wrap(err, "get things of ca")
+}
+
+func (r *repo) UpdateCertStatus(ctx context.Context, fingerprint string, status Status, revokedAt *time.Time) error {
+	err := r.db.WithContext(ctx).Model(&CertEntity{}).
+		Where("fingerprint = ?", fingerprint).
+		Updates(map[string]any{"status": status, "revoked_at": revokedAt}).Error
+	return errors.Wrap(err, "update thing cert status")
+}
+
+func (r *repo) DeleteCert(ctx context.Context, fingerprint string) error {
+	err := r.db.WithContext(ctx).Where("fingerprint = ?", fingerprint).Delete(&CertEntity{}).Error
+	return errors.Wrap(err, "delete thing cert")
+}
+
+func (r *repo) DeleteByThing(ctx context.Context, thingId string) error {
+	err := r.db.WithContext(ctx).Where("thing_id = ?", thingId).Delete(&CertEntity{}).Error
+	return errors.Wrap(err, "delete certs of thing")
+}
+
+func (r *repo) CreateCA(ctx context.Context, e *CAEntity) error {
+	err := r.db.WithContext(ctx).Create(e).Error
+	return errors.Wrap(err, "create ca cert")
+}
+
+func (r *repo) GetCA(ctx context.Context, fingerprint string) (*CAEntity, error) {
+	var e CAEntity
+	err := r.db.WithContext(ctx).Where("fingerprint = ?", fingerprint).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get ca cert")
+	}
+	return &e, nil
+}
+
+func (r *repo) QueryCA(ctx context.Context, pq model.PageQuery) (model.PageData[CAEntity], error) {
+	tx := r.db.WithContext(ctx).Model(&CAEntity{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[CAEntity]{}, errors.Wrap(err, "count ca cert")
+	}
+	l := make([]CAEntity, 0)
+	if err := tx.Order("created_at").Offset(pq.Offset()).Limit(pq.Limit()).Find(&l).Error; err != nil {
+		return model.PageData[CAEntity]{}, errors.Wrap(err, "query ca cert")
+	}
+	return model.PageData[CAEntity]{Total: total, Content: l}, nil
+}
+
+func (r *repo) ListActiveCA(ctx context.Context) ([]CAEntity, error) {
+	var l []CAEntity
+	err := r.db.WithContext(ctx).Where("status = ?", StatusActive).Find(&l).Error
+	return l, errors.Wrap(err, "list active ca cert")
+}
+
+func (r *repo) UpdateCAStatus(ctx context.Context, fingerprint string, status Status, revokedAt *time.Time) error {
+	err := r.db.WithContext(ctx).Model(&CAEntity{}).
+		Where("fingerprint = ?", fingerprint).
+		Updates(map[string]any{"status": status, "revoked_at": revokedAt}).Error
+	return errors.Wrap(err, "update ca cert status")
+}
+
+func (r *repo) DeleteCA(ctx context.Context, fingerprint string) error {
+	err := r.db.WithContext(ctx).Where("fingerprint = ?", fingerprint).Delete(&CAEntity{}).Error
+	return errors.Wrap(err, "delete ca cert")
+}
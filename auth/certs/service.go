@@ -0,0 +1,312 @@
+package certs
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/thing"
+)
+
+type svcImpl struct {
+	repo     Repo
+	thingSvc thing.Service
+	conn     connector.Connectivity
+}
+
+var _ Service = (*svcImpl)(nil)
+
+func NewSvc(r Repo, thingSvc thing.Service, conn connector.Connectivity) Service {
+	return &svcImpl{repo: r, thingSvc: thingSvc, conn: conn}
+}
+
+func (s *svcImpl) RegisterCert(ctx context.Context, thingId string, req RegisterCertReq) (Cert, error) {
+	th, err := s.thingSvc.Get(ctx, thingId)
+	if err != nil {
+		return Cert{}, err
+	}
+	if th.AuthType != thing.AuthTypeCerts {
+		return Cert{}, errors.WithMessagef(model.ErrInvalidParams, "auth type of thing is %q", th.AuthType)
+	}
+	c, err := ParsePem(req.CertificatePem)
+	if err != nil {
+		return Cert{}, err
+	}
+	if c.IsCA {
+		return Cert{}, errors.WithMessage(model.ErrInvalidParams, "CA certificate can't be registered to thing")
+	}
+	fp := Fingerprint(c)
+	if old, err := s.repo.GetCert(ctx, fp); err != nil {
+		return Cert{}, err
+	} else if old != nil {
+		return Cert{}, errors.WithMessagef(model.ErrDuplicated, "certificate %s registered to thing %q", fp, old.ThingId)
+	}
+	e := newCertEntity(thingId, c)
+	if err := s.repo.CreateCert(ctx, &e); err != nil {
+		return Cert{}, err
+	}
+	log.Infof("Registered certificate %s of thing %q", fp, thingId)
+	return s.GetCert(ctx, thingId, fp)
+}
+
+func (s *svcImpl) GetCert(ctx context.Context, thingId, fingerprint string) (Cert, error) {
+	e, err := s.repo.GetCert(ctx, fingerprint)
+	if err != nil {
+		return Cert{}, err
+	}
+	if e == nil || e.ThingId != thingId {
+		return Cert{}, errors.WithMessagef(model.ErrNotFound, "certificate %s of thing %q", fingerprint, thingId)
+	}
+	return toCert(*e), nil
+}
+
+func (s *svcImpl) ListCerts(ctx context.Context, thingId string) ([]Cert, error) {
+	l, err := s.repo.ListCerts(ctx, thingId)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Cert, len(l))
+	for i, e := range l {
+		res[i] = toCert(e)
+	}
+	return res, nil
+}
+
+func (s *svcImpl) RevokeCert(ctx context.Context, thingId, fingerprint string) (Cert, error) {
+	c, err := s.GetCert(ctx, thingId, fingerprint)
+	if err != nil {
+		return Cert{}, err
+	}
+	if c.Status == StatusRevoked {
+		return c, nil
+	}
+	now := time.Now()
+	if err := s.repo.UpdateCertStatus(ctx, fingerprint, StatusRevoked, &now); err != nil {
+		return Cert{}, err
+	}
+	s.closeThing(thingId)
+	log.Infof("Revoked certificate %s of thing %q", fingerprint, thingId)
+	return s.GetCert(ctx, thingId, fingerprint)
+}
+
+func (s *svcImpl) DeleteCert(ctx context.Context, thingId, fingerprint string) error {
+	c, err := s.GetCert(ctx, thingId, fingerprint)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteCert(ctx, fingerprint); err != nil {
+		return err
+	}
+	if c.Status == StatusActive {
+		s.closeThing(thingId)
+	}
+	log.Infof("Deleted certificate %s of thing %q", fingerprint, thingId)
+	return nil
+}
+
+func (s *svcImpl) RegisterCA(ctx context.Context, req RegisterCAReq) (CA, error) {
+	if len(req.Description) > 512 {
+		return CA{}, errors.WithMessage(model.ErrInvalidParams, "description length should be less than 512")
+	}
+	c, err := ParsePem(req.CertificatePem)
+	if err != nil {
+		return CA{}, err
+	}
+	if !c.BasicConstraintsValid || !c.IsCA {
+		return CA{}, errors.WithMessage(model.ErrInvalidParams, "not a CA certificate")
+	}
+	fp := Fingerprint(c)
+	if old, err := s.repo.GetCA(ctx, fp); err != nil {
+		return CA{}, err
+	} else if old != nil {
+		return CA{}, errors.WithMessagef(model.ErrDuplicated, "CA %s", fp)
+	}
+	e := newCAEntity(req.Description, c)
+	if err := s.repo.CreateCA(ctx, &e); err != nil {
+		return CA{}, err
+	}
+	log.Infof("Registered CA %s, subject=%q", fp, e.Subject)
+	return s.GetCA(ctx, fp)
+}
+
+func (s *svcImpl) GetCA(ctx context.Context, fingerprint string) (CA, error) {
+	e, err := s.repo.GetCA(ctx, fingerprint)
+	if err != nil {
+		return CA{}, err
+	}
+	if e == nil {
+		return CA{}, errors.WithMessagef(model.ErrNotFound, "CA %s", fingerprint)
+	}
+	return toCA(*e), nil
+}
+
+func (s *svcImpl) QueryCA(ctx context.Context, pq model.PageQuery) (CAPage, error) {
+	p, err := s.repo.QueryCA(ctx, pq)
+	if err != nil {
+		return CAPage{}, err
+	}
+	res := CAPage{Total: p.Total, Content: make([]CA, len(p.Content))}
+	for i, e := range p.Content {
+		res.Content[i] = toCA(e)
+	}
+	return res, nil
+}
+
+func (s *svcImpl) RevokeCA(ctx context.Context, fingerprint string) (CA, error) {
+	ca, err := s.GetCA(ctx, fingerprint)
+	if err != nil {
+		return CA{}, err
+	}
+	if ca.Status == StatusRevoked {
+		return ca, nil
+	}
+	now := time.Now()
+	if err := s.repo.UpdateCAStatus(ctx, fingerprint, StatusRevoked, &now); err != nil {
+		return CA{}, err
+	}
+	s.closeThingsOfCA(ctx, fingerprint)
+	log.Infof("Revoked CA %s", fingerprint)
+	return s.GetCA(ctx, fingerprint)
+}
+
+func (s *svcImpl) DeleteCA(ctx context.Context, fingerprint string) error {
+	ca, err := s.GetCA(ctx, fingerprint)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteCA(ctx, fingerprint); err != nil {
+		return err
+	}
+	if ca.Status == StatusActive {
+		s.closeThingsOfCA(ctx, fingerprint)
+	}
+	log.Infof("Deleted CA %s", fingerprint)
+	return nil
+}
+
+func (s *svcImpl) Authenticate(ctx context.Context, chain []*x509.Certificate) (*thing.Thing, error) {
+	if len(chain) == 0 {
+		return nil, errors.WithMessage(model.ErrAuthentication, "no certificate")
+	}
+	leaf := chain[0]
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return nil, errors.WithMessage(model.ErrAuthentication, "certificate is expired or not yet valid")
+	}
+	fp := Fingerprint(leaf)
+	e, err := s.repo.GetCert(ctx, fp)
+	if err != nil {
+		return nil, err
+	}
+	registered := e != nil
+	if !registered {
+		if e, err = s.verifyByCA(ctx, leaf, chain[1:]); err != nil {
+			return nil, err
+		}
+	} else if err := s.checkRegistered(ctx, e); err != nil {
+		return nil, err
+	}
+
+	th, err := s.thingSvc.Get(ctx, e.ThingId)
+	if err != nil {
+		return nil, err
+	}
+	if th.AuthType != thing.AuthTypeCerts {
+		return nil, errors.WithMessagef(model.ErrAuthentication, "auth type of thing is %q", th.AuthType)
+	}
+	if registered && e.CreatedAt.Before(th.CreatedAt) {
+		// registered to a deleted thing with the same id
+		return nil, errors.WithMessagef(model.ErrAuthentication, "certificate %s is registered before the thing created", fp)
+	}
+	if !registered {
+		if err := s.repo.CreateCert(ctx, e); err != nil {
+			return nil, err
+		}
+		log.Infof("Registered certificate %s of thing %q signed by CA %s", e.Fingerprint, e.ThingId, e.CaFingerprint)
+	}
+	return th, nil
+}
+
+func (s *svcImpl) checkRegistered(ctx context.Context, e *CertEntity) error {
+	if e.Status == StatusRevoked {
+		return errors.WithMessagef(model.ErrAuthentication, "certificate %s is revoked", e.Fingerprint)
+	}
+	if e.CaFingerprint == "" {
+		return nil
+	}
+	ca, err := s.repo.GetCA(ctx, e.CaFingerprint)
+	if err != nil {
+		return err
+	}
+	if ca == nil || ca.Status == StatusRevoked {
+		return errors.WithMessagef(model.ErrAuthentication, "CA %s of certificate is revoked", e.CaFingerprint)
+	}
+	return nil
+}
+
+// verifyByCA verifies the certificate with registered CAs, the certificate belongs to the thing named by its common name
+func (s *svcImpl) verifyByCA(ctx context.Context, leaf *x509.Certificate, intermediates []*x509.Certificate) (*CertEntity, error) {
+	cas, err := s.repo.ListActiveCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(cas) == 0 {
+		return nil, errors.WithMessage(model.ErrAuthentication, "certificate is not registered")
+	}
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		c, err := ParsePem(ca.Pem)
+		if err != nil {
+			log.Errorf("Parse CA %s error: %v", ca.Fingerprint, err)
+			continue
+		}
+		roots.AddCert(c)
+	}
+	inter := x509.NewCertPool()
+	for _, c := range intermediates {
+		inter.AddCert(c)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(model.ErrAuthentication, "certificate is not registered or signed by a registered CA: %v", err)
+	}
+	thingId := leaf.Subject.CommonName
+	if !thing.IdValid(thingId) {
+		return nil, errors.WithMessagef(model.ErrAuthentication, "common name %q of certificate is not a valid thing id", thingId)
+	}
+	root := chains[0][len(chains[0])-1]
+	e := newCertEntity(thingId, leaf)
+	e.CaFingerprint = Fingerprint(root)
+	return &e, nil
+}
+
+func (s *svcImpl) closeThingsOfCA(ctx context.Context, caFingerprint string) {
+	l, err := s.repo.ThingsOfCA(ctx, caFingerprint)
+	if err != nil {
+		log.Errorf("Get things of CA %s to close connections error: %v", caFingerprint, err)
+		return
+	}
+	for _, id := range l {
+		s.closeThing(id)
+	}
+}
+
+func (s *svcImpl) closeThing(thingId string) {
+	if err := s.conn.Close(thingId); err != nil {
+		log.Debugf("Close connection of thing %q: %v", thingId, err)
+	}
+}
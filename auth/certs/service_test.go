@@ -0,0 +1,169 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/auth/certs"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+	shadowMock "ruff.io/tio/shadow/mock"
+	shadowWire "ruff.io/tio/shadow/wire"
+	"ruff.io/tio/thing"
+	thingWire "ruff.io/tio/thing/wire"
+)
+
+var ctx = context.Background()
+
+func newTestSvc(t *testing.T) (certs.Service, thing.Service) {
+	db := dbMock.NewSqliteConnTest()
+	err := db.AutoMigrate(thing.Entity{}, thing.GroupMemberEntity{}, shadow.Entity{}, shadow.ConnStatusEntity{},
+		certs.CertEntity{}, certs.CAEntity{})
+	require.NoError(t, err)
+	conn := shadowMock.NewConnectivity()
+	conn.On("Close", mock.Anything).Return(nil)
+	conn.On("Remove", mock.Anything).Return(nil)
+	shadowSvc := shadowWire.InitSvc(db, conn, nil, nil)
+	repo := certs.NewRepo(db)
+	thingSvc := thingWire.InitSvc(ctx, db, shadowSvc, conn, nil, []thing.DeleteHook{repo.DeleteByThing})
+	return certs.NewSvc(repo, thingSvc, conn), thingSvc
+}
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func (k keyPair) pem() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.cert.Raw}))
+}
+
+// newCert creates a certificate signed by parent, or self-signed if parent is nil
+func newCert(t *testing.T, cn string, isCA bool, parent *keyPair) keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	pCert, pKey := tmpl, key
+	if parent != nil {
+		pCert, pKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, pCert, &key.PublicKey, pKey)
+	require.NoError(t, err)
+	c, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return keyPair{cert: c, key: key}
+}
+
+func TestCertSvc_RegisteredCert(t *testing.T) {
+	svc, thingSvc := newTestSvc(t)
+	th, err := thingSvc.Create(ctx, thing.Thing{Id: "cert-thing", Enabled: true, AuthType: thing.AuthTypeCerts})
+	require.NoError(t, err)
+	require.Empty(t, th.AuthValue)
+
+	kp := newCert(t, "any-name", false, nil)
+	_, err = svc.Authenticate(ctx, []*x509.Certificate{kp.cert})
+	require.ErrorIs(t, err, model.ErrAuthentication, "should refuse certificate not registered")
+
+	c, err := svc.RegisterCert(ctx, th.Id, certs.RegisterCertReq{CertificatePem: kp.pem()})
+	require.NoError(t, err)
+	require.Equal(t, certs.Fingerprint(kp.cert), c.Fingerprint)
+	require.Equal(t, certs.StatusActive, c.Status)
+
+	_, err = svc.RegisterCert(ctx, th.Id, certs.RegisterCertReq{CertificatePem: kp.pem()})
+	require.ErrorIs(t, err, model.ErrDuplicated)
+
+	got, err := svc.Authenticate(ctx, []*x509.Certificate{kp.cert})
+	require.NoError(t, err)
+	require.Equal(t, th.Id, got.Id)
+
+	_, err = svc.RevokeCert(ctx, th.Id, c.Fingerprint)
+	require.NoError(t, err)
+	_, err = svc.Authenticate(ctx, []*x509.Certificate{kp.cert})
+	require.ErrorIs(t, err, model.ErrAuthentication, "should refuse revoked certificate")
+
+	t.Run("thing of password auth type", func(t *testing.T) {
+		pt, err := thingSvc.Create(ctx, thing.Thing{Id: "password-thing", Enabled: true})
+		require.NoError(t, err)
+		_, err = svc.RegisterCert(ctx, pt.Id, certs.RegisterCertReq{CertificatePem: newCert(t, pt.Id, false, nil).pem()})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+}
+
+func TestCertSvc_CA(t *testing.T) {
+	svc, thingSvc := newTestSvc(t)
+	_, err := thingSvc.Create(ctx, thing.Thing{Id: "ca-thing", Enabled: true, AuthType: thing.AuthTypeCerts})
+	require.NoError(t, err)
+
+	ca := newCert(t, "test ca", true, nil)
+	leaf := newCert(t, "ca-thing", false, &ca)
+
+	_, err = svc.Authenticate(ctx, []*x509.Certificate{leaf.cert})
+	require.ErrorIs(t, err, model.ErrAuthentication, "should refuse certificate before CA registered")
+
+	_, err = svc.RegisterCA(ctx, certs.RegisterCAReq{CertificatePem: leaf.pem()})
+	require.ErrorIs(t, err, model.ErrInvalidParams, "should refuse certificate not CA")
+	reg, err := svc.RegisterCA(ctx, certs.RegisterCAReq{CertificatePem: ca.pem(), Description: "test"})
+	require.NoError(t, err)
+	require.Equal(t, certs.Fingerprint(ca.cert), reg.Fingerprint)
+
+	th, err := svc.Authenticate(ctx, []*x509.Certificate{leaf.cert})
+	require.NoError(t, err)
+	require.Equal(t, "ca-thing", th.Id)
+	l, err := svc.ListCerts(ctx, "ca-thing")
+	require.NoError(t, err)
+	require.Len(t, l, 1, "certificate should be registered automatically")
+	require.Equal(t, reg.Fingerprint, l[0].CaFingerprint)
+
+	t.Run("common name not a thing", func(t *testing.T) {
+		other := newCert(t, "not-exist-thing", false, &ca)
+		_, err := svc.Authenticate(ctx, []*x509.Certificate{other.cert})
+		require.ErrorIs(t, err, model.ErrNotFound)
+		l, err := svc.ListCerts(ctx, "not-exist-thing")
+		require.NoError(t, err)
+		require.Empty(t, l, "certificate of thing not exist should not be registered")
+	})
+
+	t.Run("thing recreated with the same id", func(t *testing.T) {
+		require.NoError(t, thingSvc.Delete(ctx, "ca-thing"))
+		l, err := svc.ListCerts(ctx, "ca-thing")
+		require.NoError(t, err)
+		require.Empty(t, l, "certificates should be deleted with the thing")
+
+		_, err = thingSvc.Create(ctx, thing.Thing{Id: "ca-thing", Enabled: true, AuthType: thing.AuthTypeCerts})
+		require.NoError(t, err)
+		th, err := svc.Authenticate(ctx, []*x509.Certificate{leaf.cert})
+		require.NoError(t, err, "certificate should be verified by the CA again")
+		require.Equal(t, "ca-thing", th.Id)
+	})
+
+	_, err = svc.RevokeCA(ctx, reg.Fingerprint)
+	require.NoError(t, err)
+	_, err = svc.Authenticate(ctx, []*x509.Certificate{leaf.cert})
+	require.ErrorIs(t, err, model.ErrAuthentication, "should refuse certificate of revoked CA")
+}
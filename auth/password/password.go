@@ -2,6 +2,7 @@ package password
 
 import (
 	"context"
+	"crypto/subtle"
 
 	"ruff.io/tio/connector/mqtt/embed"
 
@@ -14,31 +15,24 @@ func AuthzMqttClient(ctx context.Context, superUsers []config.UserPassword, thin
 	return func(connParams embed.ConnectParams) bool {
 		user, password, clientId := string(connParams.Username), string(connParams.Password), connParams.ClientIdentifier
 		for _, u := range superUsers {
-			if user == u.Name && password == u.Password {
+			if user == u.Name && subtle.ConstantTimeCompare([]byte(password), []byte(u.Password)) == 1 {
 				log.Infof("Mqtt client user %s is authorized by default users", u.Name)
 				return true
 			}
 		}
-		th, err := thingSvc.Get(ctx, user)
+		th, err := thingSvc.Authenticate(ctx, user, password)
 		if err != nil {
-			log.Infof("Mqtt client user %s client %s authz error: %v", user, clientId, err)
+			log.Infof("Mqtt client user %s client %s is not authorized: %v", user, clientId, err)
 			return false
 		}
 		if !th.Enabled {
-			log.Infof("Mqtt client user %s client %s password %s is not authorized cause thing is not Enabled",
-				user, clientId, password)
+			log.Infof("Mqtt client user %s client %s is not authorized cause thing is not Enabled", user, clientId)
 			return false
 		}
-		if th.AuthValue == password {
-			if !connParams.Clean {
-				log.Warnf("Mqtt client user %s client %s authz error: things can not be allowed to use cleanSession false", user, clientId)
-				return false
-			}
-			return true
-		} else {
-			log.Infof("Mqtt client user %s client %s password %s is not authorized cause password is wrong",
-				user, clientId, password)
+		if !connParams.Clean {
+			log.Warnf("Mqtt client user %s client %s authz error: things can not be allowed to use cleanSession false", user, clientId)
 			return false
 		}
+		return true
 	}
 }
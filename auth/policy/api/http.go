@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	"ruff.io/tio/auth/policy"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+)
+
+// Service of acl policies, and the explain api is added to thing web service
+func Service(ctx context.Context, svc policy.Service, thingWs *restful.WebService) *restful.WebService {
+	thingWs.Route(thingWs.GET("/{id}/aclExplain").
+		To(func(r *restful.Request, w *restful.Response) {
+			res, err := svc.Explain(ctx, r.PathParameter("id"), r.QueryParameter("topic"), r.QueryParameter("action"))
+			sendResp(w, res, err)
+		}).
+		Operation("explain-acl").
+		Doc("explain topic access of thing").
+		Notes("Shows whether the thing is allowed to publish or subscribe the topic, and the policy statement deciding it.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"thing"}).
+		Param(thingWs.PathParameter("id", "thing id")).
+		Param(thingWs.QueryParameter("topic", "topic or topic filter").DataType("string").Required(true)).
+		Param(thingWs.QueryParameter("action", "").DataType("string").
+			PossibleValues([]string{policy.ActionPublish, policy.ActionSubscribe}).Required(true)).
+		Returns(200, "OK", rest.RespOK(policy.Explanation{})))
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/aclPolicies").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	tags := []string{"acl policy"}
+
+	ws.Route(ws.GET("/").
+		To(func(r *restful.Request, w *restful.Response) {
+			p, err := svc.Query(ctx, getPageQuery(r))
+			sendResp(w, p, err)
+		}).
+		Operation("query-acl-policies").
+		Doc("get acl policies").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(policy.PolicyPage{})))
+
+	ws.Route(ws.POST("/").
+		To(func(r *restful.Request, w *restful.Response) {
+			var p policy.Policy
+			if err := r.ReadEntity(&p); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			res, err := svc.Create(ctx, p)
+			sendResp(w, res, err)
+		}).
+		Operation("create-acl-policy").
+		Doc("create acl policy").
+		Notes("Statements allow or deny things to publish or subscribe topics, deny takes precedence over allow. "+
+			"Topics not matched by any statement fall back to the default rule, "+
+			"which allows things to access their own topics only. "+
+			"Topic filters support variables `${thingId}` and `${thingType}`.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(policy.Policy{}).
+		Returns(200, "OK", rest.RespOK(policy.Policy{})))
+
+	ws.Route(ws.GET("/{name}").
+		To(func(r *restful.Request, w *restful.Response) {
+			res, err := svc.Get(ctx, r.PathParameter("name"))
+			sendResp(w, res, err)
+		}).
+		Operation("get-acl-policy").
+		Doc("get acl policy").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "policy name")).
+		Returns(200, "OK", rest.RespOK(policy.Policy{})))
+
+	ws.Route(ws.PUT("/{name}").
+		To(func(r *restful.Request, w *restful.Response) {
+			var p policy.Policy
+			if err := r.ReadEntity(&p); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			p.Name = r.PathParameter("name")
+			res, err := svc.Update(ctx, p)
+			sendResp(w, res, err)
+		}).
+		Operation("update-acl-policy").
+		Doc("update acl policy").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "policy name")).
+		Reads(policy.Policy{}).
+		Returns(200, "OK", rest.RespOK(policy.Policy{})))
+
+	ws.Route(ws.DELETE("/{name}").
+		To(func(r *restful.Request, w *restful.Response) {
+			err := svc.Delete(ctx, r.PathParameter("name"))
+			sendResp(w, "", err)
+		}).
+		Operation("delete-acl-policy").
+		Doc("delete acl policy").
+		Notes("Attachments of the policy are deleted together.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "policy name")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	ws.Route(ws.GET("/{name}/attachments").
+		To(func(r *restful.Request, w *restful.Response) {
+			res, err := svc.ListAttachments(ctx, r.PathParameter("name"))
+			sendResp(w, res, err)
+		}).
+		Operation("list-acl-policy-attachments").
+		Doc("list targets the policy is attached to").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "policy name")).
+		Returns(200, "OK", rest.RespOK([]policy.Attachment{})))
+
+	ws.Route(ws.POST("/{name}/attachments").
+		To(func(r *restful.Request, w *restful.Response) {
+			var a policy.Attachment
+			if err := r.ReadEntity(&a); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			a.Policy = r.PathParameter("name")
+			res, err := svc.Attach(ctx, a)
+			sendResp(w, res, err)
+		}).
+		Operation("attach-acl-policy").
+		Doc("attach policy to thing, thing type or group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "policy name")).
+		Reads(policy.Attachment{}).
+		Returns(200, "OK", rest.RespOK(policy.Attachment{})))
+
+	ws.Route(ws.DELETE("/{name}/attachments/{targetType}/{target}").
+		To(func(r *restful.Request, w *restful.Response) {
+			err := svc.Detach(ctx, policy.Attachment{
+				Policy:     r.PathParameter("name"),
+				TargetType: r.PathParameter("targetType"),
+				Target:     r.PathParameter("target"),
+			})
+			sendResp(w, "", err)
+		}).
+		Operation("detach-acl-policy").
+		Doc("detach policy from thing, thing type or group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "policy name")).
+		Param(ws.PathParameter("targetType", "").PossibleValues(
+			[]string{policy.TargetThing, policy.TargetThingType, policy.TargetGroup})).
+		Param(ws.PathParameter("target", "id of thing, name of thing type or group")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	return ws
+}
+
+func sendResp[T any](w *restful.Response, data T, err error) {
+	if err != nil {
+		log.Infof("Acl policy api error: %v", err)
+		checkErrAndSend(err, w)
+		return
+	}
+	rest.SendRespOK(w, data)
+}
+
+func getPageQuery(r *restful.Request) model.PageQuery {
+	var err error
+	q := model.PageQuery{}
+	if q.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex")); err != nil || q.PageIndex < 1 {
+		q.PageIndex = 1
+	}
+	if q.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize")); err != nil || q.PageSize < 1 {
+		q.PageSize = 10
+	}
+	return q
+}
+
+func checkErrAndSend(err error, w http.ResponseWriter) {
+	var he model.HttpErr
+	if ok := errors.As(err, &he); ok {
+		rest.SendResp(w, he.HttpCode, rest.Resp[string]{Code: he.Code, Message: err.Error()})
+	} else {
+		rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+	}
+}
@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache is a LRU cache of policies resolved for things.
+// It's created before the thing and group services, whose changes invalidate it by the hooks.
+type Cache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	// the front is the most recently used
+	lru *list.List
+	// gen is increased by invalidation, policies resolved before it are not cached
+	gen uint64
+}
+
+type cacheItem struct {
+	thingId  string
+	policies []boundPolicy
+	expireAt time.Time
+}
+
+func NewCache() *Cache {
+	return newPolicyCache(cacheSize)
+}
+
+func newPolicyCache(size int) *Cache {
+	return &Cache{size: size, items: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get the policies of the thing, they may be expired
+func (c *Cache) get(thingId string) (cacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[thingId]
+	if !ok {
+		return cacheItem{}, false
+	}
+	c.lru.MoveToFront(el)
+	return *el.Value.(*cacheItem), true
+}
+
+func (c *Cache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put the policies resolved in generation gen, the least recently used one is evicted if the cache is full
+func (c *Cache) put(thingId string, policies []boundPolicy, expireAt time.Time, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[thingId]; ok {
+		el.Value = &cacheItem{thingId: thingId, policies: policies, expireAt: expireAt}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[thingId] = c.lru.PushFront(&cacheItem{thingId: thingId, policies: policies, expireAt: expireAt})
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*cacheItem).thingId)
+	}
+}
+
+// invalidate the policies of the thing, or of all things if thingId is empty
+func (c *Cache) invalidate(thingId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if thingId == "" {
+		c.items = make(map[string]*list.Element)
+		c.lru.Init()
+		return
+	}
+	if el, ok := c.items[thingId]; ok {
+		c.lru.Remove(el)
+		delete(c.items, thingId)
+	}
+}
+
+// ThingDeleted invalidates the policies of the deleted thing, it's a thing.DeleteHook
+func (c *Cache) ThingDeleted(_ context.Context, thingId string) error {
+	c.invalidate(thingId)
+	return nil
+}
+
+// GroupChanged invalidates the policies of all things, for policies may be attached to the group, it's a thing.GroupChangeHook
+func (c *Cache) GroupChanged(_ context.Context, _ string) {
+	c.invalidate("")
+}
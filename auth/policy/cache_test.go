@@ -0,0 +1,35 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCache(t *testing.T) {
+	c := newPolicyCache(2)
+	exp := time.Now().Add(time.Minute)
+	c.put("a", []boundPolicy{{name: "pa"}}, exp, c.generation())
+	c.put("b", []boundPolicy{{name: "pb"}}, exp, c.generation())
+	_, ok := c.get("a")
+	require.True(t, ok)
+	c.put("c", []boundPolicy{{name: "pc"}}, exp, c.generation())
+	_, ok = c.get("b")
+	require.False(t, ok, "the least recently used should be evicted")
+	it, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, "pa", it.policies[0].name)
+
+	gen := c.generation()
+	c.invalidate("a")
+	_, ok = c.get("a")
+	require.False(t, ok)
+	c.put("a", []boundPolicy{{name: "stale"}}, exp, gen)
+	_, ok = c.get("a")
+	require.False(t, ok, "policies resolved before invalidation should not be cached")
+
+	c.invalidate("")
+	_, ok = c.get("c")
+	require.False(t, ok)
+}
@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/datatypes"
+)
+
+type Entity struct {
+	Name        string `gorm:"primaryKey;size:64"`
+	Description string `gorm:"size:512;NOT NULL;default:''"`
+	Statements  datatypes.JSON
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (Entity) TableName() string {
+	return "acl_policy"
+}
+
+type AttachmentEntity struct {
+	PolicyName string    `gorm:"primaryKey;size:64"`
+	TargetType string    `gorm:"primaryKey;size:16;index:idx_acl_policy_target"`
+	Target     string    `gorm:"primaryKey;size:64;index:idx_acl_policy_target"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+func (AttachmentEntity) TableName() string {
+	return "acl_policy_attachment"
+}
+
+func toEntity(p Policy) (Entity, error) {
+	b, err := json.Marshal(p.Statements)
+	if err != nil {
+		return Entity{}, errors.Wrap(err, "marshal statements")
+	}
+	return Entity{
+		Name:        p.Name,
+		Description: p.Description,
+		Statements:  b,
+		CreatedAt:   p.CreatedAt,
+	}, nil
+}
+
+func toPolicy(e Entity) (Policy, error) {
+	p := Policy{
+		Name:        e.Name,
+		Description: e.Description,
+		UpdatedAt:   e.UpdatedAt,
+		CreatedAt:   e.CreatedAt,
+	}
+	if err := json.Unmarshal(e.Statements, &p.Statements); err != nil {
+		return p, errors.Wrapf(err, "unmarshal statements of policy %q", e.Name)
+	}
+	return p, nil
+}
+
+func toAttachment(e AttachmentEntity) Attachment {
+	return Attachment{
+		Policy:     e.PolicyName,
+		TargetType: e.TargetType,
+		Target:     e.Target,
+		CreatedAt:  e.CreatedAt,
+	}
+}
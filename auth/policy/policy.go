@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/thing"
+)
+
+// MQTT ACL policies.
+// A policy is a list of statements allowing or denying things to publish or subscribe topics,
+// it's attached to things, thing types or thing groups.
+//
+// A topic access of a thing is evaluated as:
+//  1. super users are allowed
+//  2. denied if any statement of its policies denies
+//  3. allowed if any statement of its policies allows
+//  4. otherwise the default rule, see thing.GatewayTopicAcl
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+
+	ActionPublish   = "publish"
+	ActionSubscribe = "subscribe"
+
+	TargetThing     = "thing"
+	TargetThingType = "thingType"
+	TargetGroup     = "group"
+
+	// VarThingId variable in topic filters, replaced by id of the thing
+	VarThingId = "${thingId}"
+	// VarThingType variable in topic filters, replaced by type of the thing, statements with it don't apply to things without type
+	VarThingType = "${thingType}"
+
+	MaxStatements = 100
+)
+
+// reasons of explanation
+const (
+	ReasonSuperUser = "superUser"
+	ReasonDeny      = "deny"
+	ReasonAllow     = "allow"
+	ReasonDefault   = "default"
+)
+
+type Statement struct {
+	Effect  string   `json:"effect" enum:"allow|deny"`
+	Actions []string `json:"actions" description:"publish, subscribe"`
+	Topics  []string `json:"topics" description:"topic filters, wildcards + and # are supported, with variables ${thingId} and ${thingType}"`
+}
+
+type Policy struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Statements  []Statement `json:"statements"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+type PolicyPage = model.PageData[Policy]
+
+type Attachment struct {
+	Policy     string    `json:"policy"`
+	TargetType string    `json:"targetType" enum:"thing|thingType|group"`
+	Target     string    `json:"target" description:"id of thing, name of thing type or group"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Explanation of topic access evaluation
+type Explanation struct {
+	ThingId string `json:"thingId"`
+	Topic   string `json:"topic"`
+	Action  string `json:"action"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason" enum:"superUser|deny|allow|default"`
+	// Policy and Statement which decides, for reason deny or allow
+	Policy    string `json:"policy,omitempty" optional:"true"`
+	Statement *int   `json:"statement,omitempty" optional:"true" description:"index of the statement in policy"`
+	// Policies applied to the thing
+	Policies []string `json:"policies"`
+}
+
+type Service interface {
+	Create(ctx context.Context, p Policy) (Policy, error)
+	Update(ctx context.Context, p Policy) (Policy, error)
+	// Delete the policy and its attachments
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (Policy, error)
+	Query(ctx context.Context, pq model.PageQuery) (PolicyPage, error)
+
+	Attach(ctx context.Context, a Attachment) (Attachment, error)
+	Detach(ctx context.Context, a Attachment) error
+	ListAttachments(ctx context.Context, policy string) ([]Attachment, error)
+
+	// TopicAcl checks topic access of mqtt user, write is true for publish
+	TopicAcl(user string, topic string, write bool) bool
+	IsSuperUser(user string) bool
+	// Explain how topic access of the thing is evaluated
+	Explain(ctx context.Context, thingId, topic, action string) (Explanation, error)
+}
+
+type Repo interface {
+	Create(ctx context.Context, e *Entity) error
+	Save(ctx context.Context, e *Entity) error
+	// Delete the policy and its attachments
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (*Entity, error)
+	Query(ctx context.Context, pq model.PageQuery) (model.PageData[Entity], error)
+
+	CreateAttachment(ctx context.Context, e *AttachmentEntity) error
+	DeleteAttachment(ctx context.Context, e AttachmentEntity) (bool, error)
+	ListAttachments(ctx context.Context, policy string) ([]AttachmentEntity, error)
+	// PoliciesOf gets policies attached to any of the targets, targets are keyed by type
+	PoliciesOf(ctx context.Context, targets map[string][]string) ([]Entity, error)
+}
+
+func (s Statement) valid() error {
+	if s.Effect != EffectAllow && s.Effect != EffectDeny {
+		return errors.WithMessagef(model.ErrInvalidParams, "effect %q", s.Effect)
+	}
+	if len(s.Actions) == 0 {
+		return errors.WithMessage(model.ErrInvalidParams, "actions can't be empty")
+	}
+	for _, a := range s.Actions {
+		if a != ActionPublish && a != ActionSubscribe {
+			return errors.WithMessagef(model.ErrInvalidParams, "action %q", a)
+		}
+	}
+	if len(s.Topics) == 0 {
+		return errors.WithMessage(model.ErrInvalidParams, "topics can't be empty")
+	}
+	for _, t := range s.Topics {
+		if !filterValid(t) {
+			return errors.WithMessagef(model.ErrInvalidParams, "topic filter %q", t)
+		}
+	}
+	return nil
+}
+
+func (p Policy) valid() error {
+	if !thing.IdValid(p.Name) || len(p.Name) > 64 {
+		return errors.WithMessagef(model.ErrInvalidParams, "policy name %q", p.Name)
+	}
+	if len(p.Description) > 512 {
+		return errors.WithMessage(model.ErrInvalidParams, "description length should be less than 512")
+	}
+	if len(p.Statements) == 0 || len(p.Statements) > MaxStatements {
+		return errors.WithMessagef(model.ErrInvalidParams, "count of statements should be in [1, %d]", MaxStatements)
+	}
+	for i, s := range p.Statements {
+		if err := s.valid(); err != nil {
+			return errors.WithMessagef(err, "statement %d", i)
+		}
+	}
+	return nil
+}
+
+func (s Statement) hasAction(write bool) bool {
+	action := ActionSubscribe
+	if write {
+		action = ActionPublish
+	}
+	for _, a := range s.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// bind replaces variables in topic filters of the statement,
+// filters with variables not available are dropped
+func (s Statement) bind(th *thing.Thing) Statement {
+	topics := make([]string, 0, len(s.Topics))
+	for _, t := range s.Topics {
+		if strings.Contains(t, VarThingType) {
+			if th.ThingType == "" {
+				continue
+			}
+			t = strings.ReplaceAll(t, VarThingType, th.ThingType)
+		}
+		topics = append(topics, strings.ReplaceAll(t, VarThingId, th.Id))
+	}
+	s.Topics = topics
+	return s
+}
+
+// match checks if the statement applies to the topic access.
+// A subscription is allowed only if it's covered by the filter, and it's denied if it overlaps the filter.
+func (s Statement) match(topic string, write bool) bool {
+	if !s.hasAction(write) {
+		return false
+	}
+	for _, f := range s.Topics {
+		if s.Effect == EffectDeny && filterOverlaps(f, topic) {
+			return true
+		}
+		if s.Effect == EffectAllow && filterCovers(f, topic) {
+			return true
+		}
+	}
+	return false
+}
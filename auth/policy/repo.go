@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ruff.io/tio/pkg/model"
+)
+
+type repo struct {
+	db *gorm.DB
+}
+
+var _ Repo = (*repo)(nil)
+
+func NewRepo(db *gorm.DB) Repo {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, e *Entity) error {
+	err := r.db.WithContext(ctx).Create(e).Error
+	return errors.Wrap(err, "create policy")
+}
+
+func (r *repo) Save(ctx context.Context, e *Entity) error {
+	err := r.db.WithContext(ctx).Save(e).Error
+	return errors.Wrap(err, "save policy")
+}
+
+func (r *repo) Delete(ctx context.Context, name string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("policy_name = ?", name).Delete(&AttachmentEntity{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&Entity{}).Error
+	})
+	return errors.Wrap(err, "delete policy")
+}
+
+func (r *repo) Get(ctx context.Context, name string) (*Entity, error) {
+	var e Entity
+	err := r.db.WithContext(ctx).Where("name = ?", name).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get policy")
+	}
+	return &e, nil
+}
+
+func (r *repo) Query(ctx context.Context, pq model.PageQuery) (model.PageData[Entity], error) {
+	tx := r.db.WithContext(ctx).Model(&Entity{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[Entity]{}, errors.Wrap(err, "count policy")
+	}
+	l := make([]Entity, 0)
+	if err := tx.Order("name").Offset(pq.Offset()).Limit(pq.Limit()).Find(&l).Error; err != nil {
+		return model.PageData[Entity]{}, errors.Wrap(err, "query policy")
+	}
+	return model.PageData[Entity]{Total: total, Content: l}, nil
+}
+
+func (r *repo) CreateAttachment(ctx context.Context, e *AttachmentEntity) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(e).Error
+	return errors.Wrap(err, "create policy attachment")
+}
+
+func (r *repo) DeleteAttachment(ctx context.Context, e AttachmentEntity) (bool, error) {
+	res := r.db.WithContext(ctx).
+		Where("policy_name = ? AND target_type = ? AND target = ?", e.PolicyName, e.TargetType, e.Target).
+		Delete(&AttachmentEntity{})
+	return res.RowsAffected > 0, errors.Wrap(res.Error, "delete policy attachment")
+}
+
+func (r *repo) ListAttachments(ctx context.Context, policy string) ([]AttachmentEntity, error) {
+	l := make([]AttachmentEntity, 0)
+	err := r.db.WithContext(ctx).Where("policy_name = ?", policy).Order("target_type, target").Find(&l).Error
+	return l, errors.Wrap(err, "list policy attachments")
+}
+
+func (r *repo) PoliciesOf(ctx context.Context, targets map[string][]string) ([]Entity, error) {
+	cond := r.db.Where("1 = 0")
+	for typ, l := range targets {
+		if len(l) > 0 {
+			cond = cond.Or("target_type = ? AND target IN ?", typ, l)
+		}
+	}
+	var names []string
+	err := r.db.WithContext(ctx).Model(&AttachmentEntity{}).Distinct("policy_name").Where(cond).Pluck("policy_name", &names).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "get attached policy names")
+	}
+	l := make([]Entity, 0, len(names))
+	if len(names) == 0 {
+		return l, nil
+	}
+	err = r.db.WithContext(ctx).Where("name IN ?", names).Order("name").Find(&l).Error
+	return l, errors.Wrap(err, "get attached policies")
+}
@@ -0,0 +1,381 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+	"ruff.io/tio/config"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/thing"
+)
+
+const (
+	// cacheTTL of policies resolved for a thing. Changes of policies, attachments, groups and things
+	// invalidate the cache immediately, while membership of dynamic groups changed by shadows takes effect after it expires.
+	// Expired policies are still used while they are resolved again in background.
+	cacheTTL = 30 * time.Second
+	// cacheSize max count of things whose policies are cached
+	cacheSize = 10000
+
+	resolveTimeout = 5 * time.Second
+)
+
+type boundPolicy struct {
+	name       string
+	statements []Statement
+}
+
+type svcImpl struct {
+	repo       Repo
+	thingSvc   thing.Service
+	typeSvc    thing.TypeService
+	groupSvc   thing.GroupService
+	superUsers []config.UserPassword
+
+	cache *Cache
+	// concurrent resolutions of the same thing are done once
+	flight singleflight.Group
+}
+
+var _ Service = (*svcImpl)(nil)
+
+// NewSvc cache should be invalidated by the hooks of thingSvc and groupSvc, see Cache.ThingDeleted and Cache.GroupChanged
+func NewSvc(r Repo, thingSvc thing.Service, typeSvc thing.TypeService, groupSvc thing.GroupService,
+	cache *Cache, superUsers []config.UserPassword) Service {
+	return &svcImpl{
+		repo:       r,
+		thingSvc:   thingSvc,
+		typeSvc:    typeSvc,
+		groupSvc:   groupSvc,
+		superUsers: superUsers,
+		cache:      cache,
+	}
+}
+
+func (s *svcImpl) Create(ctx context.Context, p Policy) (Policy, error) {
+	if err := p.valid(); err != nil {
+		return Policy{}, err
+	}
+	if old, err := s.repo.Get(ctx, p.Name); err != nil {
+		return Policy{}, err
+	} else if old != nil {
+		return Policy{}, errors.WithMessagef(model.ErrDuplicated, "policy %q", p.Name)
+	}
+	e, err := toEntity(p)
+	if err != nil {
+		return Policy{}, err
+	}
+	if err := s.repo.Create(ctx, &e); err != nil {
+		return Policy{}, err
+	}
+	log.Infof("Created acl policy %q", p.Name)
+	return s.Get(ctx, p.Name)
+}
+
+func (s *svcImpl) Update(ctx context.Context, p Policy) (Policy, error) {
+	if err := p.valid(); err != nil {
+		return Policy{}, err
+	}
+	old, err := s.getEntity(ctx, p.Name)
+	if err != nil {
+		return Policy{}, err
+	}
+	e, err := toEntity(p)
+	if err != nil {
+		return Policy{}, err
+	}
+	e.CreatedAt = old.CreatedAt
+	if err := s.repo.Save(ctx, &e); err != nil {
+		return Policy{}, err
+	}
+	s.clearCache()
+	log.Infof("Updated acl policy %q", p.Name)
+	return s.Get(ctx, p.Name)
+}
+
+func (s *svcImpl) Delete(ctx context.Context, name string) error {
+	err := s.repo.Delete(ctx, name)
+	if err == nil {
+		s.clearCache()
+		log.Infof("Deleted acl policy %q", name)
+	}
+	return err
+}
+
+func (s *svcImpl) Get(ctx context.Context, name string) (Policy, error) {
+	e, err := s.getEntity(ctx, name)
+	if err != nil {
+		return Policy{}, err
+	}
+	return toPolicy(*e)
+}
+
+func (s *svcImpl) getEntity(ctx context.Context, name string) (*Entity, error) {
+	e, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, errors.WithMessagef(model.ErrNotFound, "policy %q", name)
+	}
+	return e, nil
+}
+
+func (s *svcImpl) Query(ctx context.Context, pq model.PageQuery) (PolicyPage, error) {
+	p, err := s.repo.Query(ctx, pq)
+	if err != nil {
+		return PolicyPage{}, err
+	}
+	res := PolicyPage{Total: p.Total, Content: make([]Policy, len(p.Content))}
+	for i, e := range p.Content {
+		if res.Content[i], err = toPolicy(e); err != nil {
+			return PolicyPage{}, err
+		}
+	}
+	return res, nil
+}
+
+func (s *svcImpl) Attach(ctx context.Context, a Attachment) (Attachment, error) {
+	if _, err := s.getEntity(ctx, a.Policy); err != nil {
+		return Attachment{}, err
+	}
+	if err := s.checkTarget(ctx, a.TargetType, a.Target); err != nil {
+		return Attachment{}, err
+	}
+	e := AttachmentEntity{PolicyName: a.Policy, TargetType: a.TargetType, Target: a.Target}
+	if err := s.repo.CreateAttachment(ctx, &e); err != nil {
+		return Attachment{}, err
+	}
+	s.clearCache()
+	log.Infof("Attached acl policy %q to %s %q", a.Policy, a.TargetType, a.Target)
+	return toAttachment(e), nil
+}
+
+func (s *svcImpl) checkTarget(ctx context.Context, typ, target string) error {
+	switch typ {
+	case TargetThing:
+		ok, err := s.thingSvc.Exist(ctx, target)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.WithMessagef(model.ErrNotFound, "thing %q", target)
+		}
+		return nil
+	case TargetThingType:
+		_, err := s.typeSvc.Get(ctx, target)
+		return err
+	case TargetGroup:
+		_, err := s.groupSvc.Get(ctx, target)
+		return err
+	default:
+		return errors.WithMessagef(model.ErrInvalidParams, "target type %q", typ)
+	}
+}
+
+func (s *svcImpl) Detach(ctx context.Context, a Attachment) error {
+	ok, err := s.repo.DeleteAttachment(ctx, AttachmentEntity{PolicyName: a.Policy, TargetType: a.TargetType, Target: a.Target})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.WithMessagef(model.ErrNotFound, "attachment of policy %q to %s %q", a.Policy, a.TargetType, a.Target)
+	}
+	s.clearCache()
+	log.Infof("Detached acl policy %q from %s %q", a.Policy, a.TargetType, a.Target)
+	return nil
+}
+
+func (s *svcImpl) ListAttachments(ctx context.Context, policy string) ([]Attachment, error) {
+	if _, err := s.getEntity(ctx, policy); err != nil {
+		return nil, err
+	}
+	l, err := s.repo.ListAttachments(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Attachment, len(l))
+	for i, e := range l {
+		res[i] = toAttachment(e)
+	}
+	return res, nil
+}
+
+func (s *svcImpl) TopicAcl(user string, topic string, write bool) bool {
+	if s.IsSuperUser(user) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	policies, err := s.cachedPoliciesOf(ctx, user)
+	if err != nil {
+		log.Errorf("Mqtt acl deny user %q topic %q, get policies error: %v", user, topic, err)
+		return false
+	}
+	return evaluate(policies, topic, write, func() bool {
+		return thing.GatewayTopicAcl(ctx, s.thingSvc, nil, user, topic, write)
+	}).Allowed
+}
+
+func (s *svcImpl) Explain(ctx context.Context, thingId, topic, action string) (Explanation, error) {
+	if action != ActionPublish && action != ActionSubscribe {
+		return Explanation{}, errors.WithMessagef(model.ErrInvalidParams, "action %q", action)
+	}
+	if !filterValid(topic) {
+		return Explanation{}, errors.WithMessagef(model.ErrInvalidParams, "topic %q", topic)
+	}
+	write := action == ActionPublish
+	var exp Explanation
+	if s.IsSuperUser(thingId) {
+		exp = Explanation{Allowed: true, Reason: ReasonSuperUser, Policies: []string{}}
+	} else {
+		if _, err := s.thingSvc.Get(ctx, thingId); err != nil {
+			return Explanation{}, err
+		}
+		policies, err := s.policiesOf(ctx, thingId)
+		if err != nil {
+			return Explanation{}, err
+		}
+		exp = evaluate(policies, topic, write, func() bool {
+			return thing.GatewayTopicAcl(ctx, s.thingSvc, nil, thingId, topic, write)
+		})
+	}
+	exp.ThingId, exp.Topic, exp.Action = thingId, topic, action
+	return exp, nil
+}
+
+// evaluate the topic access with policies, deny statements take precedence over allow statements,
+// the default rule decides if no statement matches
+func evaluate(policies []boundPolicy, topic string, write bool, defaultRule func() bool) Explanation {
+	exp := Explanation{Policies: make([]string, len(policies))}
+	for i, p := range policies {
+		exp.Policies[i] = p.name
+	}
+	decide := func(effect string) bool {
+		for _, p := range policies {
+			for i, st := range p.statements {
+				if st.Effect == effect && st.match(topic, write) {
+					exp.Policy, exp.Statement = p.name, &i
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case decide(EffectDeny):
+		exp.Allowed, exp.Reason = false, ReasonDeny
+	case decide(EffectAllow):
+		exp.Allowed, exp.Reason = true, ReasonAllow
+	default:
+		exp.Allowed, exp.Reason = defaultRule(), ReasonDefault
+	}
+	return exp
+}
+
+func (s *svcImpl) IsSuperUser(user string) bool {
+	for _, u := range s.superUsers {
+		if u.Name == user {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *svcImpl) cachedPoliciesOf(ctx context.Context, thingId string) ([]boundPolicy, error) {
+	c, ok := s.cache.get(thingId)
+	if ok {
+		if time.Now().After(c.expireAt) {
+			s.resolve(thingId)
+		}
+		return c.policies, nil
+	}
+	select {
+	case r := <-s.resolve(thingId):
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.([]boundPolicy), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve policies of the thing and cache them, it doesn't wait for the result
+func (s *svcImpl) resolve(thingId string) <-chan singleflight.Result {
+	return s.flight.DoChan(thingId, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		gen := s.cache.generation()
+		l, err := s.policiesOf(ctx, thingId)
+		if err != nil {
+			log.Errorf("Resolve acl policies of thing %q error: %v", thingId, err)
+			return nil, err
+		}
+		s.cache.put(thingId, l, time.Now().Add(cacheTTL), gen)
+		return l, nil
+	})
+}
+
+func (s *svcImpl) clearCache() {
+	s.cache.invalidate("")
+}
+
+// policiesOf resolves policies attached to the thing, its type and groups, with variables bound
+func (s *svcImpl) policiesOf(ctx context.Context, thingId string) ([]boundPolicy, error) {
+	th, err := s.thingSvc.Get(ctx, thingId)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.groupSvc.GroupsOfThing(ctx, thingId)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string][]string{
+		TargetThing: {thingId},
+		TargetGroup: groups,
+	}
+	if th.ThingType != "" {
+		targets[TargetThingType] = []string{th.ThingType}
+	}
+	l, err := s.repo.PoliciesOf(ctx, targets)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]boundPolicy, 0, len(l))
+	for _, e := range l {
+		p, err := toPolicy(e)
+		if err != nil {
+			return nil, err
+		}
+		bp := boundPolicy{name: p.Name, statements: make([]Statement, len(p.Statements))}
+		for i, st := range p.Statements {
+			bp.statements[i] = st.bind(th)
+		}
+		res = append(res, bp)
+	}
+	return res, nil
+}
+
+// GroupRefsOf gets policies attached to the thing group,
+// it's given to the thing group service, so that groups can't be deleted while policies are attached to them.
+func GroupRefsOf(r Repo) thing.GroupRefFunc {
+	return func(ctx context.Context, group string) ([]string, error) {
+		l, err := r.PoliciesOf(ctx, map[string][]string{TargetGroup: {group}})
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, len(l))
+		for i, e := range l {
+			res[i] = fmt.Sprintf("acl policy %q", e.Name)
+		}
+		return res, nil
+	}
+}
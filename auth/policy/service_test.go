@@ -0,0 +1,236 @@
+package policy_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"ruff.io/tio/auth/policy"
+	"ruff.io/tio/config"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+	shadowMock "ruff.io/tio/shadow/mock"
+	shadowWire "ruff.io/tio/shadow/wire"
+	"ruff.io/tio/thing"
+	thingWire "ruff.io/tio/thing/wire"
+)
+
+var (
+	ctx = context.Background()
+
+	// shadow service is a singleton, so the database is shared by tests
+	dbOnce sync.Once
+	db     *gorm.DB
+)
+
+type testEnv struct {
+	thingSvc thing.Service
+	typeSvc  thing.TypeService
+	groupSvc thing.GroupService
+}
+
+func newTestSvc(t *testing.T) (policy.Service, testEnv) {
+	dbOnce.Do(func() {
+		db = dbMock.NewSqliteConnTest()
+		err := db.AutoMigrate(thing.Entity{}, thing.TypeEntity{}, thing.GroupEntity{}, thing.GroupMemberEntity{},
+			shadow.Entity{}, shadow.ConnStatusEntity{}, shadow.MethodDefEntity{},
+			policy.Entity{}, policy.AttachmentEntity{})
+		require.NoError(t, err)
+	})
+	conn := shadowMock.NewConnectivity()
+	conn.On("Close", mock.Anything).Return(nil)
+	conn.On("Remove", mock.Anything).Return(nil)
+	shadowSvc := shadowWire.InitSvc(db, conn, nil, nil)
+	cache := policy.NewCache()
+	repo := policy.NewRepo(db)
+	env := testEnv{
+		thingSvc: thingWire.InitSvc(ctx, db, shadowSvc, conn, nil, []thing.DeleteHook{cache.ThingDeleted}),
+		typeSvc:  thing.NewTypeSvc(thing.NewTypeRepo(db), shadow.NewMethodDefSvc(shadow.NewMethodDefRepo(db))),
+		groupSvc: thing.NewGroupSvc(thing.NewGroupRepo(db), shadowSvc, []thing.GroupChangeHook{cache.GroupChanged},
+			[]thing.GroupRefFunc{policy.GroupRefsOf(repo)}),
+	}
+	superUsers := []config.UserPassword{{Name: "admin", Password: "admin"}}
+	svc := policy.NewSvc(repo, env.thingSvc, env.typeSvc, env.groupSvc, cache, superUsers)
+	return svc, env
+}
+
+func createThing(t *testing.T, env testEnv, id, thingType string) {
+	_, err := env.thingSvc.Create(ctx, thing.Thing{Id: id, ThingType: thingType, Enabled: true, AuthValue: "pwd12345"})
+	require.NoError(t, err)
+}
+
+func TestPolicySvc_Policy(t *testing.T) {
+	svc, env := newTestSvc(t)
+	createThing(t, env, "policy-crud-1", "")
+
+	_, err := svc.Create(ctx, policy.Policy{Name: "empty"})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+	_, err = svc.Create(ctx, policy.Policy{Name: "bad-filter", Statements: []policy.Statement{
+		{Effect: policy.EffectAllow, Actions: []string{policy.ActionPublish}, Topics: []string{"a/#/b"}},
+	}})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+	_, err = svc.Create(ctx, policy.Policy{Name: "bad-action", Statements: []policy.Statement{
+		{Effect: policy.EffectAllow, Actions: []string{"connect"}, Topics: []string{"a"}},
+	}})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+
+	p, err := svc.Create(ctx, policy.Policy{Name: "crud", Statements: []policy.Statement{
+		{Effect: policy.EffectDeny, Actions: []string{policy.ActionPublish}, Topics: []string{"data/${thingId}"}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, p.Statements, 1)
+	_, err = svc.Create(ctx, p)
+	require.ErrorIs(t, err, model.ErrDuplicated)
+
+	p.Description = "updated"
+	p, err = svc.Update(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, "updated", p.Description)
+	_, err = svc.Update(ctx, policy.Policy{Name: "not-exist", Statements: p.Statements})
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "crud", TargetType: policy.TargetThing, Target: "not-exist"})
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "crud", TargetType: policy.TargetGroup, Target: "not-exist"})
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "crud", TargetType: "user", Target: "policy-crud-1"})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "crud", TargetType: policy.TargetThing, Target: "policy-crud-1"})
+	require.NoError(t, err)
+	l, err := svc.ListAttachments(ctx, "crud")
+	require.NoError(t, err)
+	require.Len(t, l, 1)
+	require.False(t, svc.TopicAcl("policy-crud-1", "data/policy-crud-1", true))
+
+	require.NoError(t, svc.Delete(ctx, "crud"))
+	_, err = svc.Get(ctx, "crud")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.True(t, svc.TopicAcl("policy-crud-1", "data/policy-crud-1", true), "cache should be cleared")
+	err = svc.Detach(ctx, policy.Attachment{Policy: "crud", TargetType: policy.TargetThing, Target: "policy-crud-1"})
+	require.ErrorIs(t, err, model.ErrNotFound, "attachments should be deleted with policy")
+}
+
+func TestPolicySvc_TopicAcl(t *testing.T) {
+	svc, env := newTestSvc(t)
+	_, err := env.typeSvc.Create(ctx, thing.ThingType{Name: "acl-sensor"})
+	require.NoError(t, err)
+	_, err = env.groupSvc.Create(ctx, thing.Group{Name: "acl-group", Type: thing.GroupTypeStatic})
+	require.NoError(t, err)
+	createThing(t, env, "acl-1", "acl-sensor")
+	createThing(t, env, "acl-2", "")
+	require.NoError(t, env.groupSvc.AddThings(ctx, "acl-group", []string{"acl-2"}))
+
+	_, err = svc.Create(ctx, policy.Policy{Name: "sensor", Statements: []policy.Statement{
+		{Effect: policy.EffectAllow, Actions: []string{policy.ActionPublish}, Topics: []string{"telemetry/${thingType}/${thingId}/#"}},
+		{Effect: policy.EffectAllow, Actions: []string{policy.ActionSubscribe}, Topics: []string{"$iothub/things/+/presence"}},
+	}})
+	require.NoError(t, err)
+	_, err = svc.Create(ctx, policy.Policy{Name: "readonly", Statements: []policy.Statement{
+		{Effect: policy.EffectDeny, Actions: []string{policy.ActionPublish}, Topics: []string{"$iothub/things/${thingId}/shadows/name/default/update"}},
+		{Effect: policy.EffectAllow, Actions: []string{policy.ActionPublish, policy.ActionSubscribe}, Topics: []string{"telemetry/#"}},
+		{Effect: policy.EffectDeny, Actions: []string{policy.ActionSubscribe}, Topics: []string{"telemetry/secret/#"}},
+	}})
+	require.NoError(t, err)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "sensor", TargetType: policy.TargetThingType, Target: "acl-sensor"})
+	require.NoError(t, err)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "sensor", TargetType: policy.TargetGroup, Target: "acl-group"})
+	require.NoError(t, err)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "readonly", TargetType: policy.TargetGroup, Target: "acl-group"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		user    string
+		topic   string
+		write   bool
+		allowed bool
+	}{
+		{"super user", "admin", "$iothub/things/acl-1/shadows/name/default/update", true, true},
+		{"allow other thing", "acl-1", "$iothub/things/acl-2/presence", false, true},
+		{"action not allowed", "acl-1", "$iothub/things/acl-2/presence", true, false},
+		{"subscribe covered", "acl-1", "$iothub/things/+/presence", false, true},
+		{"subscribe not covered", "acl-1", "$iothub/things/#", false, false},
+		{"default own topic", "acl-1", "$iothub/things/acl-1/shadows/name/default/update", true, true},
+		{"default others topic", "acl-1", "$iothub/things/acl-2/shadows/name/default/update", true, false},
+		{"deny own topic", "acl-2", "$iothub/things/acl-2/shadows/name/default/update", true, false},
+		{"allow by group", "acl-2", "telemetry/any", false, true},
+		{"deny overlapped subscription", "acl-2", "telemetry/+/key", false, false},
+		{"unknown thing", "not-exist", "telemetry/any", true, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.allowed, svc.TopicAcl(c.user, c.topic, c.write))
+		})
+	}
+
+	t.Run("invalidated by group changes", func(t *testing.T) {
+		topic := "$iothub/things/acl-1/shadows/name/default/update"
+		require.True(t, svc.TopicAcl("acl-1", topic, true))
+		require.NoError(t, env.groupSvc.AddThings(ctx, "acl-group", []string{"acl-1"}))
+		require.False(t, svc.TopicAcl("acl-1", topic, true), "policies of the group should apply")
+		require.NoError(t, env.groupSvc.RemoveThings(ctx, "acl-group", []string{"acl-1"}))
+		require.True(t, svc.TopicAcl("acl-1", topic, true))
+	})
+
+	t.Run("group with policies attached can't be deleted", func(t *testing.T) {
+		err := env.groupSvc.Delete(ctx, "acl-group")
+		require.ErrorIs(t, err, model.ErrInUse)
+		require.ErrorContains(t, err, `acl policy "readonly"`)
+	})
+
+	exp, err := svc.Explain(ctx, "acl-1", "telemetry/acl-sensor/acl-1/temp", policy.ActionPublish)
+	require.NoError(t, err)
+	require.Equal(t, policy.ReasonAllow, exp.Reason, "variables should be bound")
+	require.Equal(t, []string{"sensor"}, exp.Policies)
+	exp, err = svc.Explain(ctx, "acl-1", "telemetry/acl-sensor/acl-2/temp", policy.ActionPublish)
+	require.NoError(t, err)
+	require.Equal(t, policy.ReasonDefault, exp.Reason)
+	exp, err = svc.Explain(ctx, "acl-2", "telemetry/acl-sensor/acl-2/temp", policy.ActionPublish)
+	require.NoError(t, err)
+	require.Equal(t, "readonly", exp.Policy, "filters with thing type should not apply to thing without type")
+}
+
+func TestPolicySvc_Explain(t *testing.T) {
+	svc, env := newTestSvc(t)
+	createThing(t, env, "explain-1", "")
+	_, err := svc.Create(ctx, policy.Policy{Name: "explain", Statements: []policy.Statement{
+		{Effect: policy.EffectAllow, Actions: []string{policy.ActionSubscribe}, Topics: []string{"news/#"}},
+		{Effect: policy.EffectDeny, Actions: []string{policy.ActionSubscribe}, Topics: []string{"news/private"}},
+	}})
+	require.NoError(t, err)
+	_, err = svc.Attach(ctx, policy.Attachment{Policy: "explain", TargetType: policy.TargetThing, Target: "explain-1"})
+	require.NoError(t, err)
+
+	exp, err := svc.Explain(ctx, "explain-1", "news/private", policy.ActionSubscribe)
+	require.NoError(t, err)
+	require.False(t, exp.Allowed)
+	require.Equal(t, policy.ReasonDeny, exp.Reason)
+	require.Equal(t, "explain", exp.Policy)
+	require.Equal(t, 1, *exp.Statement)
+	require.Equal(t, []string{"explain"}, exp.Policies)
+
+	exp, err = svc.Explain(ctx, "explain-1", "news/public", policy.ActionSubscribe)
+	require.NoError(t, err)
+	require.True(t, exp.Allowed)
+	require.Equal(t, policy.ReasonAllow, exp.Reason)
+	require.Equal(t, 0, *exp.Statement)
+
+	exp, err = svc.Explain(ctx, "explain-1", "news/public", policy.ActionPublish)
+	require.NoError(t, err)
+	require.True(t, exp.Allowed)
+	require.Equal(t, policy.ReasonDefault, exp.Reason)
+	require.Nil(t, exp.Statement)
+
+	exp, err = svc.Explain(ctx, "admin", "news/private", policy.ActionSubscribe)
+	require.NoError(t, err)
+	require.Equal(t, policy.ReasonSuperUser, exp.Reason)
+
+	_, err = svc.Explain(ctx, "explain-1", "news/public", "connect")
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+	_, err = svc.Explain(ctx, "not-exist", "news/public", policy.ActionPublish)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
@@ -0,0 +1,66 @@
+package policy
+
+import "strings"
+
+// filterValid checks mqtt topic filter, variables are allowed
+func filterValid(f string) bool {
+	if f == "" {
+		return false
+	}
+	levels := strings.Split(f, "/")
+	for i, l := range levels {
+		if strings.Contains(l, "#") && (l != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(l, "+") && l != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// filterCovers checks if all topics matching filter t match filter f too, t can be a topic name
+func filterCovers(f, t string) bool {
+	fl, tl := strings.Split(f, "/"), strings.Split(t, "/")
+	for i, l := range fl {
+		if l == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		switch {
+		case l == "+":
+			if tl[i] == "#" {
+				return false
+			}
+		case l != tl[i]:
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// filterOverlaps checks if any topic matches both filters
+func filterOverlaps(a, b string) bool {
+	al, bl := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(al) && i < len(bl); i++ {
+		if al[i] == "#" || bl[i] == "#" {
+			return true
+		}
+		if al[i] != "+" && bl[i] != "+" && al[i] != bl[i] {
+			return false
+		}
+	}
+	if len(al) == len(bl) {
+		return true
+	}
+	// `a/#` matches `a` too
+	if len(al) == len(bl)+1 {
+		return al[len(bl)] == "#"
+	}
+	if len(bl) == len(al)+1 {
+		return bl[len(al)] == "#"
+	}
+	return false
+}
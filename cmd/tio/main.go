@@ -11,7 +11,9 @@ import (
 	"time"
 
 	"ruff.io/tio/job"
+	"ruff.io/tio/message"
 	"ruff.io/tio/ntp"
+	"ruff.io/tio/pkg/uuid"
 	"ruff.io/tio/rule"
 
 	"ruff.io/tio"
@@ -19,22 +21,30 @@ import (
 	"ruff.io/tio/connector/mqtt/client"
 	"ruff.io/tio/connector/mqtt/embed"
 
+	"ruff.io/tio/auth/certs"
+	certsApi "ruff.io/tio/auth/certs/api"
 	"ruff.io/tio/auth/password"
+	"ruff.io/tio/auth/policy"
+	policyApi "ruff.io/tio/auth/policy/api"
 
-	restfulspec "github.com/emicklei/go-restful-openapi/v2"
 	"github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
 	"ruff.io/tio/config"
 	mq "ruff.io/tio/connector/mqtt"
 	"ruff.io/tio/db/mysql"
 	"ruff.io/tio/db/sqlite"
 	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
 
 	"ruff.io/tio/shadow"
 	shadowWire "ruff.io/tio/shadow/wire"
 
 	jobApi "ruff.io/tio/job/api"
 	jobWire "ruff.io/tio/job/wire"
+	msgApi "ruff.io/tio/message/api"
+	"ruff.io/tio/provision"
+	provisionApi "ruff.io/tio/provision/api"
 	shadowApi "ruff.io/tio/shadow/api"
 	"ruff.io/tio/thing"
 	thingApi "ruff.io/tio/thing/api"
@@ -80,24 +90,81 @@ func main() {
 	mqttClient := client.NewClient(cfg.Connector.MqttClient)
 	connector := mq.InitConnector(cfg.Connector, mqttClient)
 
-	methodHandler := shadow.NewMethodHandler(connector)
 	shadowStateHandler := shadow.NewShadowHandler(connector)
 	ntpHandler := ntp.NewNtpHandler(connector)
 
+	// direct method, validated by method definitions and recorded in audit log
+	methodLogSvc := shadow.NewMethodLogSvc(shadow.NewMethodLogRepo(dbConn), shadow.MethodLogOptions{
+		MaxDataSize:   cfg.MethodLog.MaxDataSize,
+		RetentionDays: cfg.MethodLog.RetentionDays,
+	})
+	presenceLogSvc := shadow.NewPresenceLogSvc(shadow.NewPresenceLogRepo(dbConn), connector, shadow.PresenceLogOptions{
+		RetentionDays: cfg.PresenceLog.RetentionDays,
+	})
+	methodDefSvc := shadow.NewMethodDefSvc(shadow.NewMethodDefRepo(dbConn))
+	methodHandler := shadow.NewLoggedMethodHandler(
+		shadow.NewValidatedMethodHandler(shadow.NewMethodHandler(connector), methodDefSvc, thingTypeOf(thing.NewThingRepo(dbConn))),
+		methodLogSvc,
+	)
+
 	// services
-	shadowSvc := shadowWire.InitSvc(dbConn, connector)
-	thingSvc := thingWire.InitSvc(ctx, dbConn, shadowSvc, connector)
+	thingTypeSvc := thing.NewTypeSvc(thing.NewTypeRepo(dbConn), methodDefSvc)
+	thingGroupRepo := thing.NewGroupRepo(dbConn)
+	shadowSvc := shadowWire.InitSvc(dbConn, connector, thingTypeSvc.StateSchemaOf, thing.GroupDefsOf(thingGroupRepo))
+	policyCache := policy.NewCache()
+	policyRepo := policy.NewRepo(dbConn)
+	thingGroupSvc := thing.NewGroupSvc(thingGroupRepo, shadowSvc, []thing.GroupChangeHook{policyCache.GroupChanged},
+		[]thing.GroupRefFunc{job.GroupRefsOf(job.NewRepo(dbConn)), policy.GroupRefsOf(policyRepo)})
+	provisionRepo := provision.NewRepo(dbConn)
+	certRepo := certs.NewRepo(dbConn)
+	msgRepo := message.NewRepo(dbConn)
+	thingSvc := thingWire.InitSvc(ctx, dbConn, shadowSvc, connector, methodHandler, []thing.DeleteHook{
+		thingGroupSvc.RemoveFromGroups,
+		provisionRepo.DeleteProvisioned,
+		certRepo.DeleteByThing,
+		msgRepo.DeleteByThing,
+		policyCache.ThingDeleted,
+	})
 
-	jobCenter := job.NewCenter(job.CenterOptions{
-		ScheduleInterval:       time.Millisecond * 100,
-		CheckJobStatusInterval: time.Millisecond * 100,
-	}, job.NewRepo(dbConn), connector, connector, methodHandler, shadowSvc)
-	jobMgrSvc := jobWire.InitSvc(dbConn, jobCenter)
+	// a center is created for every term of leadership, topics are subscribed once for all terms
+	jobPubSub := job.NewTermPubSub(connector)
+	newJobCenter := func() job.Center {
+		return job.NewCenter(job.CenterOptions{
+			ScheduleInterval:       time.Millisecond * 100,
+			CheckJobStatusInterval: time.Millisecond * 100,
+			ContinuousJobInterval:  time.Second * 10,
+		}, job.NewRepo(dbConn), jobPubSub, connector, methodHandler, shadowSvc, shadowSvc)
+	}
+	var jobCenter job.Center
+	if le := cfg.JobCenter.LeaderElection; le.Enabled {
+		jobCenter = job.NewLeaderCenter(job.LeaderOptions{
+			InstanceId: instanceId(),
+			LeaseTTL:   time.Duration(le.LeaseSeconds) * time.Second,
+		}, job.NewLeaderRepo(dbConn), newJobCenter)
+	} else {
+		jobCenter = newJobCenter()
+	}
+	jobMgrSvc := jobWire.InitSvc(dbConn, jobCenter, shadowSvc, func(ctx context.Context, thingType, method string) (*shadow.MethodDef, error) {
+		return shadow.FindMethodDef(ctx, methodDefSvc, thingType, method)
+	})
+	jobTemplateSvc := job.NewTemplateSvc(job.NewTemplateRepo(dbConn))
+	jobScheduleSvc := job.NewScheduleSvc(job.NewScheduleRepo(dbConn), jobMgrSvc, job.ScheduleOptions{})
+
+	msgSvc := message.NewSvc(message.Options{}, msgRepo, connector, uuid.New())
+	certSvc := certs.NewSvc(certRepo, thingSvc, connector)
+	gatewaySvc := thing.NewGatewaySvc(thingSvc, connector)
+	policySvc := policy.NewSvc(policyRepo, thingSvc, thingTypeSvc, thingGroupSvc, policyCache,
+		cfg.Connector.MqttBroker.SuperUsers)
+	provisionSvc := provision.NewSvc(provisionRepo, thingSvc, shadowSvc, connector, uuid.New())
 
 	// embedded mqtt broker
 	if cfg.Connector.Typ == config.ConnectorMqttEmbed {
 		authzFn := password.AuthzMqttClient(ctx, cfg.Connector.MqttBroker.SuperUsers, thingSvc)
-		startMqttBroker(ctx, cfg.Connector.MqttBroker, authzFn)
+		certAuthzFn := certs.AuthzMqttClient(ctx, certSvc)
+		prefixAuthz := map[string]embed.UserAuthzFn{
+			provision.UserPrefix: provision.AuthzMqttClient(ctx, provisionSvc),
+		}
+		startMqttBroker(ctx, cfg.Connector.MqttBroker, authzFn, certAuthzFn, prefixAuthz, policySvc)
 	}
 
 	// boot data integration rule
@@ -110,12 +177,28 @@ func main() {
 	if err := shadowSvc.SyncConnStatus(ctx); err != nil {
 		log.Fatalf("Sync Conn Status error: %v", err)
 	}
+	shadow.NewConnSweeper(shadow.NewShadowRepo(dbConn), connector, shadow.ConnSweepOptions{
+		Interval:   time.Duration(cfg.ConnSweep.IntervalSeconds) * time.Second,
+		StaleAfter: time.Duration(cfg.ConnSweep.StaleSeconds) * time.Second,
+	}).Start(ctx)
+	thing.NewSecretSweeper(thing.NewThingRepo(dbConn), shadowSvc, 0).Start(ctx)
 	if err := methodHandler.InitMethodHandler(ctx); err != nil {
 		log.Fatalf("Init method handler error: %v", err)
 	}
 	if err := ntpHandler.InitNtpHandler(ctx); err != nil {
 		log.Fatalf("Init ntp handler error: %v", err)
 	}
+	methodLogSvc.Start(ctx)
+	presenceLogSvc.Start(ctx)
+	if err := msgSvc.Start(ctx); err != nil {
+		log.Fatalf("Message service start error: %v", err)
+	}
+	if err := gatewaySvc.Start(ctx); err != nil {
+		log.Fatalf("Gateway service start error: %v", err)
+	}
+	if err := provisionSvc.Start(ctx); err != nil {
+		log.Fatalf("Provisioning service start error: %v", err)
+	}
 
 	if err := shadow.Link(ctx, shadowStateHandler, shadowSvc); err != nil {
 		log.Fatalf("Link shadow service to connector error %v", err)
@@ -126,6 +209,7 @@ func main() {
 	if err := jobCenter.Start(ctx); err != nil {
 		log.Fatalf("JobCenter start error: %v", err)
 	}
+	jobScheduleSvc.Start(ctx)
 
 	// htt api
 
@@ -135,10 +219,22 @@ func main() {
 	thingWs := thingApi.Service(ctx, thingSvc).
 		Filter(api.LoggingMiddleware).
 		Filter(azf)
-	shadowApi.Service(ctx, thingWs, shadowSvc, thingSvc, methodHandler)
+	shadowApi.Service(ctx, thingWs, shadowSvc, thingSvc, methodHandler, methodLogSvc)
+	shadowApi.ServiceForPresenceLog(ctx, presenceLogSvc, thingWs)
+
+	msgApi.Service(ctx, msgSvc, thingSvc, thingWs)
+	caWs := certsApi.Service(ctx, certSvc, thingWs).Filter(api.LoggingMiddleware).Filter(azf)
+	provisionWs := provisionApi.Service(ctx, provisionSvc).Filter(api.LoggingMiddleware).Filter(azf)
+	policyWs := policyApi.Service(ctx, policySvc, thingWs).Filter(api.LoggingMiddleware).Filter(azf)
 
 	jobWs := jobApi.Service(ctx, jobMgrSvc, thingWs)
 	jobWs.Filter(api.LoggingMiddleware).Filter(azf)
+	jobScheduleWs := jobApi.ServiceForSchedule(ctx, jobScheduleSvc).Filter(api.LoggingMiddleware).Filter(azf)
+	jobTemplateWs := jobApi.ServiceForTemplate(ctx, jobTemplateSvc).Filter(api.LoggingMiddleware).Filter(azf)
+
+	thingTypeWs := thingApi.ServiceForThingType(ctx, thingTypeSvc).Filter(api.LoggingMiddleware).Filter(azf)
+	methodDefWs := shadowApi.ServiceForMethodDef(ctx, methodDefSvc, thingTypeWs).Filter(api.LoggingMiddleware).Filter(azf)
+	thingGroupWs := thingApi.ServiceForThingGroup(ctx, thingGroupSvc, thingWs).Filter(api.LoggingMiddleware).Filter(azf)
 
 	mqWs := mq.Service(ctx, connector).Filter(api.LoggingMiddleware).Filter(azf)
 	cfgWs := config.Service(ctx, cfg)
@@ -146,16 +242,27 @@ func main() {
 	restful.DefaultContainer.Add(thingWs)
 	restful.DefaultContainer.Add(mqWs)
 	restful.DefaultContainer.Add(jobWs)
+	restful.DefaultContainer.Add(jobScheduleWs)
+	restful.DefaultContainer.Add(jobTemplateWs)
+	restful.DefaultContainer.Add(thingTypeWs)
+	restful.DefaultContainer.Add(methodDefWs)
+	restful.DefaultContainer.Add(thingGroupWs)
+	restful.DefaultContainer.Add(caWs)
+	restful.DefaultContainer.Add(provisionWs)
+	restful.DefaultContainer.Add(policyWs)
 	restful.DefaultContainer.Add(cfgWs)
-	restful.DefaultContainer.Add(thingApi.ServiceForEmqxIntegration())
-	restful.DefaultContainer.Add(restfulspec.NewOpenAPIService(api.OpenapiConfig()))
+	restful.DefaultContainer.Add(thingApi.ServiceForEmqxIntegration(provision.Acl(policySvc.IsSuperUser, policySvc.TopicAcl)))
+	restful.DefaultContainer.Add(api.OpenapiService(api.OpenapiConfig(
+		shadowApi.MethodDefSwaggerEnricher(ctx, methodDefSvc, thingWs.RootPath()),
+	)))
 	if cfg.API.Cors {
 		restful.DefaultContainer.Filter(restful.OPTIONSFilter())
 	}
 	startHttpSvr(ctx, cfg, nil)
 
-	// wait some seconds before shutting down
+	// wait some seconds before shutting down, and for the audit logs of direct methods saved
 	time.Sleep(1 * time.Second)
+	<-methodLogSvc.Stopped()
 }
 
 func startHttpSvr(ctx context.Context, cfg config.Config, handler http.Handler) {
@@ -185,30 +292,80 @@ func startHttpSvr(ctx context.Context, cfg config.Config, handler http.Handler)
 func autoMigrate(conn *gorm.DB) {
 	err := conn.AutoMigrate(
 		&thing.Entity{},
+		&thing.TypeEntity{},
+		&thing.GroupEntity{},
+		&thing.GroupMemberEntity{},
 		&shadow.Entity{},
 		&shadow.ConnStatusEntity{},
+		&shadow.MethodLogEntity{},
+		&shadow.MethodDefEntity{},
+		&shadow.PresenceLogEntity{},
 		&job.Entity{},
 		&job.TaskEntity{},
+		&job.TemplateEntity{},
+		&job.ScheduleEntity{},
+		&job.ScheduleRunEntity{},
+		&job.LeaseEntity{},
+		&job.MgrMsgEntity{},
+		&message.Entity{},
+		&certs.CertEntity{},
+		&certs.CAEntity{},
+		&provision.TemplateEntity{},
+		&provision.ProvisionedEntity{},
+		&policy.Entity{},
+		&policy.AttachmentEntity{},
 	)
 	if err != nil {
 		log.Fatalf("auto migrate db error: %v", err)
 	}
+	if err := thing.MigrateAuthValue(conn); err != nil {
+		log.Fatalf("migrate thing auth value error: %v", err)
+	}
 	time.Sleep(time.Millisecond * 100)
 }
 
-func startMqttBroker(ctx context.Context, cfg config.InnerMqttBroker, authzFn embed.AuthzFn) embed.Broker {
+// instanceId identity of the process among instances
+func instanceId() string {
+	host, _ := os.Hostname()
+	id, err := uuid.New().ID()
+	if err != nil {
+		log.Fatalf("Generate instance id error: %v", err)
+	}
+	return host + "-" + id
+}
+
+// thingTypeOf gets type of the thing by the repo, for the method handler is given to the thing service
+func thingTypeOf(r thing.Repo) shadow.ThingTypeFunc {
+	return func(ctx context.Context, thingId string) (string, error) {
+		th, err := r.Get(ctx, thingId)
+		if err != nil {
+			return "", err
+		}
+		if th == nil {
+			return "", errors.WithMessagef(model.ErrNotFound, "thing %q", thingId)
+		}
+		return th.ThingType, nil
+	}
+}
+
+func startMqttBroker(ctx context.Context, cfg config.InnerMqttBroker,
+	authzFn embed.AuthzFn, certAuthzFn embed.CertAuthzFn, prefixAuthz map[string]embed.UserAuthzFn, policySvc policy.Service) embed.Broker {
 	return embed.InitBroker(embed.MochiConfig{
-		TcpPort:    cfg.TcpPort,
-		TcpSslPort: cfg.TcpSslPort,
-		WsPort:     cfg.WsPort,
-		WssPort:    cfg.WssPort,
-		KeyFile:    cfg.KeyFile,
-		CertFile:   cfg.CertFile,
-		Storage:    cfg.Storage,
-		AuthzFn:    authzFn,
-		AclFn: func(user string, topic string, write bool) bool {
-			return thing.TopicAcl(cfg.SuperUsers, user, topic, write)
+		TcpPort:     cfg.TcpPort,
+		TcpSslPort:  cfg.TcpSslPort,
+		WsPort:      cfg.WsPort,
+		WssPort:     cfg.WssPort,
+		KeyFile:     cfg.KeyFile,
+		CertFile:    cfg.CertFile,
+		Storage:     cfg.Storage,
+		AuthzFn:     authzFn,
+		CertAuthzFn: certAuthzFn,
+		PrefixAuthz: prefixAuthz,
+		PresenceDamping: embed.PresenceDamping{
+			Debounce:      time.Duration(cfg.Presence.DebounceSeconds) * time.Second,
+			FlapThreshold: cfg.Presence.FlapThreshold,
 		},
+		AclFn:      provision.Acl(policySvc.IsSuperUser, policySvc.TopicAcl),
 		SuperUsers: cfg.SuperUsers,
 	})
 }
@@ -68,6 +68,13 @@ type InnerMqttBroker struct {
 	KeyFile          string           `json:"-"`
 	Storage          InnerMqttStorage `json:"storage"`
 	SuperUsers       []UserPassword   `json:"superUsers"`
+	Presence         PresenceDamping  `json:"presence"`
+}
+
+// PresenceDamping of presence events published by the embedded broker
+type PresenceDamping struct {
+	DebounceSeconds int `json:"debounceSeconds"` // delay before publishing disconnected, 0 disables it
+	FlapThreshold   int `json:"flapThreshold"`   // connects and disconnects per minute to flag a thing flapping, 0 disables it
 }
 
 type Config struct {
@@ -82,7 +89,36 @@ type Config struct {
 		Mysql  mysql.Config  `json:"mysql"`
 		Sqlite sqlite.Config `json:"sqlite"`
 	} `json:"db"`
-	Connector Connector `json:"connector"`
+	Connector   Connector   `json:"connector"`
+	MethodLog   MethodLog   `json:"methodLog"`
+	PresenceLog PresenceLog `json:"presenceLog"`
+	ConnSweep   ConnSweep   `json:"connSweep"`
+	JobCenter   JobCenter   `json:"jobCenter"`
+}
+
+// MethodLog audit log for direct method invocations
+type MethodLog struct {
+	RetentionDays int `json:"retentionDays"`
+	MaxDataSize   int `json:"maxDataSize"` // max size of request data saved, in byte
+}
+
+// ConnSweep marks things disconnected if they are connected in database but not in broker
+type ConnSweep struct {
+	IntervalSeconds int `json:"intervalSeconds"`
+	StaleSeconds    int `json:"staleSeconds"` // things disagreeing longer than it are marked disconnected
+}
+
+// JobCenter runs jobs, only the leader runs it if leader election is enabled for multiple instances
+type JobCenter struct {
+	LeaderElection struct {
+		Enabled      bool `json:"enabled"`
+		LeaseSeconds int  `json:"leaseSeconds"`
+	} `json:"leaderElection"`
+}
+
+// PresenceLog connection history of things
+type PresenceLog struct {
+	RetentionDays int `json:"retentionDays"`
 }
 
 func ReadConfig() Config {
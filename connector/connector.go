@@ -31,6 +31,8 @@ type ClientInfo struct {
 	DisconnectedAt   *time.Time `json:"disconnectedAt"`
 	DisconnectReason string     `json:"disconnectReason"`
 	RemoteAddr       string     `json:"remoteAddr"`
+	// Flapping the thing connects and disconnects frequently
+	Flapping bool `json:"flapping,omitempty"`
 }
 
 type Connector interface {
@@ -74,6 +76,12 @@ type Connectivity interface {
 	Remove(thingId string) error
 }
 
+// PresenceReporter reports presence of things connected indirectly, eg. sub-devices behind gateways.
+// The event is handled as if the thing connected to the broker, it's published to presence topics and OnConnect.
+type PresenceReporter interface {
+	ReportPresence(evt PresenceEvent) error
+}
+
 type ConnectChecker interface {
 	IsConnected(thingId string) (bool, error)
 	OnConnect() <-chan PresenceEvent
@@ -88,6 +96,7 @@ type PresenceEvent struct {
 	ClientId         string `json:"clientId"`
 	RemoteAddr       string `json:"remoteAddr"`
 	DisconnectReason string `json:"disconnectReason,omitempty"`
+	Flapping         bool   `json:"flapping,omitempty"`
 }
 
 func TopicPresence(thingId string) string {
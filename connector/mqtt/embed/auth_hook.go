@@ -2,6 +2,10 @@ package embed
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"reflect"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
@@ -9,8 +13,10 @@ import (
 
 type authHook struct {
 	mqtt.HookBase
-	authzFn AuthzFn
-	aclFn   AclFn
+	authzFn     AuthzFn
+	certAuthzFn CertAuthzFn
+	prefixAuthz map[string]UserAuthzFn
+	aclFn       AclFn
 }
 
 func (a *authHook) ID() string {
@@ -25,9 +31,52 @@ func (a *authHook) Provides(b byte) bool {
 }
 
 func (a *authHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	for prefix, fn := range a.prefixAuthz {
+		if bytes.HasPrefix(pk.Connect.Username, []byte(prefix)) {
+			identity, ok := fn(ConnectParams(pk.Connect), peerCertificates(cl))
+			if ok {
+				cl.Properties.Username = []byte(identity)
+			}
+			return ok
+		}
+	}
+	if chain := peerCertificates(cl); len(chain) > 0 && a.certAuthzFn != nil {
+		if thingId, ok := a.certAuthzFn(ConnectParams(pk.Connect), chain); ok {
+			// acl and presence are keyed on the username, use the thing id derived from the certificate
+			cl.Properties.Username = []byte(thingId)
+			return true
+		}
+		// not a certificate of things, eg. super users with their own certificates, try the password
+	}
 	return a.authzFn(ConnectParams(pk.Connect))
 }
 
+// peerCertificates gets client certificates of tls connection.
+// Websocket connections of mochi wrap the underlying tls connection in their embedded field Conn.
+func peerCertificates(cl *mqtt.Client) []*x509.Certificate {
+	conn := cl.Net.Conn
+	for conn != nil {
+		if c, ok := conn.(*tls.Conn); ok {
+			return c.ConnectionState().PeerCertificates
+		}
+		conn = wrappedConn(conn)
+	}
+	return nil
+}
+
+func wrappedConn(c net.Conn) net.Conn {
+	v := reflect.ValueOf(c)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.Elem().FieldByName("Conn")
+	if !f.IsValid() || !f.CanInterface() {
+		return nil
+	}
+	inner, _ := f.Interface().(net.Conn)
+	return inner
+}
+
 func (a *authHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
 	return a.aclFn(string(cl.Properties.Username), topic, write)
 }
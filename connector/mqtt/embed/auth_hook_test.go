@@ -0,0 +1,115 @@
+package embed
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pahoMqtt "github.com/eclipse/paho.mqtt.golang"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthHook_Wss(t *testing.T) {
+	srvCert, err := tls.X509KeyPair(selfSignedCert(t, "localhost"))
+	require.NoError(t, err)
+	thingCert, err := tls.X509KeyPair(selfSignedCert(t, "thing-1"))
+	require.NoError(t, err)
+	adminCert, err := tls.X509KeyPair(selfSignedCert(t, "admin"))
+	require.NoError(t, err)
+
+	wssPort := 21894
+	var certChecked atomic.Int32
+	svr := mqtt.New(&mqtt.Options{InlineClient: true})
+	require.NoError(t, svr.AddHook(&authHook{
+		authzFn: func(p ConnectParams) bool {
+			return string(p.Username) == "admin" && string(p.Password) == "pwd"
+		},
+		certAuthzFn: func(p ConnectParams, chain []*x509.Certificate) (string, bool) {
+			certChecked.Add(1)
+			return chain[0].Subject.CommonName, chain[0].Subject.CommonName == "thing-1"
+		},
+		aclFn: func(user string, topic string, write bool) bool {
+			return true
+		},
+	}, nil))
+	require.NoError(t, svr.AddListener(listeners.NewWebsocket(listeners.Config{
+		ID:        "test-wss",
+		Address:   fmt.Sprintf(":%d", wssPort),
+		TLSConfig: tlsConfig(srvCert),
+	})))
+	require.NoError(t, svr.Serve())
+	defer func() { _ = svr.Close() }()
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", wssPort))
+		if err == nil {
+			_ = c.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "wss listener should be serving")
+
+	connect := func(clientId, user, password string, cert tls.Certificate) error {
+		opts := pahoMqtt.NewClientOptions().
+			AddBroker(fmt.Sprintf("wss://localhost:%d", wssPort)).
+			SetClientID(clientId).
+			SetUsername(user).
+			SetPassword(password).
+			SetConnectRetry(false).
+			SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true})
+		cl := pahoMqtt.NewClient(opts)
+		tk := cl.Connect()
+		if !tk.WaitTimeout(3 * time.Second) {
+			return fmt.Errorf("connect timeout")
+		}
+		if tk.Error() == nil {
+			t.Cleanup(func() { cl.Disconnect(0) })
+		}
+		return tk.Error()
+	}
+
+	t.Run("thing certificate", func(t *testing.T) {
+		require.NoError(t, connect("thing-1", "", "", thingCert))
+		cl, ok := svr.Clients.Get("thing-1")
+		require.True(t, ok)
+		require.Equal(t, "thing-1", string(cl.Properties.Username), "username is the thing id of the certificate")
+	})
+
+	t.Run("fall back to password", func(t *testing.T) {
+		n := certChecked.Load()
+		require.NoError(t, connect("admin-1", "admin", "pwd", adminCert))
+		require.Equal(t, n+1, certChecked.Load(), "certificate is checked before the password")
+		require.Error(t, connect("admin-2", "admin", "wrong", adminCert))
+	})
+}
+
+// selfSignedCert returns PEM of a self-signed certificate and its key
+func selfSignedCert(t *testing.T, cn string) (certPem []byte, keyPem []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
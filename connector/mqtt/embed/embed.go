@@ -15,6 +15,7 @@ type embedMqttAdapter struct {
 }
 
 var _ connector.Connectivity = (*embedMqttAdapter)(nil)
+var _ connector.PresenceReporter = (*embedMqttAdapter)(nil)
 
 func NewEmbedAdapter() connector.Connectivity {
 	return &embedMqttAdapter{}
@@ -39,6 +40,14 @@ func (m *embedMqttAdapter) AllClientInfo() ([]connector.ClientInfo, error) {
 	return BrokerInstance().AllClientInfo()
 }
 
+func (m *embedMqttAdapter) ReportPresence(evt connector.PresenceEvent) error {
+	if BrokerInstance() == nil {
+		return errors.New("mochi embed mqtt server is not initialized")
+	}
+	BrokerInstance().ReportPresence(evt)
+	return nil
+}
+
 func (m *embedMqttAdapter) Start(ctx context.Context) error {
 	return nil
 }
@@ -5,12 +5,14 @@ package embed
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"ruff.io/tio/connector"
 	"ruff.io/tio/shadow"
@@ -32,7 +34,15 @@ const presenceEventName = "presence"
 
 type ConnectParams packets.ConnectParams
 type AuthzFn func(connParam ConnectParams) bool
+
+// CertAuthzFn authenticates client by certificates of tls connection, the first one is its own certificate.
+// It returns id of the thing which the certificate belongs to.
+type CertAuthzFn func(connParam ConnectParams, chain []*x509.Certificate) (thingId string, ok bool)
 type AclFn func(user string, topic string, write bool) bool
+
+// UserAuthzFn authenticates clients of special usernames, eg. claim clients of provisioning.
+// It returns the identity used for acl instead of the username.
+type UserAuthzFn func(connParam ConnectParams, chain []*x509.Certificate) (identity string, ok bool)
 type MochiConfig struct {
 	TcpPort    int
 	TcpSslPort int
@@ -41,9 +51,16 @@ type MochiConfig struct {
 	CertFile   string
 	KeyFile    string
 	AuthzFn    AuthzFn
-	AclFn      AclFn
-	Storage    config.InnerMqttStorage
-	SuperUsers []config.UserPassword
+	// CertAuthzFn is used for clients with certificates on tls listeners, AuthzFn is used if it is nil or fails
+	CertAuthzFn CertAuthzFn
+	// PrefixAuthz authenticates clients whose username has the prefix key, before other authentications
+	PrefixAuthz map[string]UserAuthzFn
+	AclFn       AclFn
+	Storage     config.InnerMqttStorage
+	SuperUsers  []config.UserPassword
+
+	// PresenceDamping of presence events published
+	PresenceDamping PresenceDamping
 }
 
 var newOnce sync.Once
@@ -58,6 +75,8 @@ type Broker interface {
 	OnConnect() <-chan connector.PresenceEvent
 	ClientInfo(clientId string) (connector.ClientInfo, error)
 	AllClientInfo() ([]connector.ClientInfo, error)
+	// ReportPresence of things not connected directly, eg. sub-devices behind gateways
+	ReportPresence(evt connector.PresenceEvent)
 	Close() error
 	CloseClient(clientId string) bool
 	StatsInfo() *system.Info
@@ -79,9 +98,10 @@ func InitBroker(c MochiConfig) Broker {
 	newOnce.Do(func() {
 		ctx, cancel := context.WithCancel(context.Background())
 		evtBus := eventbus.NewEventBus[connector.PresenceEvent]()
-		s := initBroker(ctx, c, evtBus)
+		s, presenceHk := initBroker(ctx, c, evtBus)
 		broker = &embedBroker{
 			impl:             s,
+			presence:         presenceHk,
 			presenceEventBus: evtBus,
 			ctx:              ctx,
 			cancel:           cancel,
@@ -99,6 +119,7 @@ func InitBroker(c MochiConfig) Broker {
 
 type embedBroker struct {
 	impl             *mqtt.Server
+	presence         *presenceHook
 	clients          sync.Map // map[string]shadow.ClientInfo
 	presenceEventBus *eventbus.EventBus[connector.PresenceEvent]
 
@@ -146,6 +167,10 @@ func (e *embedBroker) IsConnected(clientId string) bool {
 	if ok {
 		return !c.Closed()
 	}
+	// things connected indirectly have no client of broker
+	if i, ok := e.clients.Load(clientId); ok {
+		return i.(connector.ClientInfo).Connected
+	}
 	return false
 }
 
@@ -170,13 +195,13 @@ func (e *embedBroker) AllClientInfo() ([]connector.ClientInfo, error) {
 	return clients, nil
 }
 
-func initBroker(ctx context.Context, cfg MochiConfig, evtBus *eventbus.EventBus[connector.PresenceEvent]) *mqtt.Server {
+func initBroker(ctx context.Context, cfg MochiConfig, evtBus *eventbus.EventBus[connector.PresenceEvent]) (*mqtt.Server, *presenceHook) {
 	svr := mqtt.New(&mqtt.Options{
 		InlineClient:           true,
 		SysTopicResendInterval: 5,
 	})
 
-	authHk := &authHook{authzFn: cfg.AuthzFn, aclFn: cfg.AclFn}
+	authHk := &authHook{authzFn: cfg.AuthzFn, certAuthzFn: cfg.CertAuthzFn, prefixAuthz: cfg.PrefixAuthz, aclFn: cfg.AclFn}
 	err := svr.AddHook(authHk, nil)
 	if err != nil {
 		log.Fatalf("broker add hook: %v", err)
@@ -212,10 +237,8 @@ func initBroker(ctx context.Context, cfg MochiConfig, evtBus *eventbus.EventBus[
 		}
 	}
 
-	presenceHk := &presenceHook{
-		getClientFn:    getClientFn(svr),
-		publishEventFn: publishEventFn(svr, evtBus),
-	}
+	presenceHk := newPresenceHook(cfg.PresenceDamping, publishEventFn(svr, evtBus), getClientFn(svr))
+	presenceHk.damper.start(ctx)
 	err = svr.AddHook(presenceHk, nil)
 	if err != nil {
 		log.Fatalf("broker add hook: %v", err)
@@ -236,7 +259,7 @@ func initBroker(ctx context.Context, cfg MochiConfig, evtBus *eventbus.EventBus[
 			ID:        "tio-tcp-ssl",
 			Type:      "tcp",
 			Address:   addr,
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			TLSConfig: tlsConfig(cert),
 		})
 		err = svr.AddListener(tcpSsl)
 		if err != nil {
@@ -251,11 +274,11 @@ func initBroker(ctx context.Context, cfg MochiConfig, evtBus *eventbus.EventBus[
 			cert = readCert(cfg.KeyFile, cfg.CertFile)
 		}
 		addr = fmt.Sprintf(":%d", cfg.WssPort)
-		wss := listeners.NewTCP(listeners.Config{
+		wss := listeners.NewWebsocket(listeners.Config{
 			ID:        "tio-wss",
 			Type:      "ws",
 			Address:   addr,
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			TLSConfig: tlsConfig(cert),
 		})
 		err = svr.AddListener(wss)
 		if err != nil {
@@ -272,7 +295,16 @@ func initBroker(ctx context.Context, cfg MochiConfig, evtBus *eventbus.EventBus[
 		log.Fatalf("Add mqtt broker websocket listener failed: %v", err)
 	}
 
-	return svr
+	return svr, presenceHk
+}
+
+// tlsConfig requests client certificates without verifying,
+// they are verified by CertAuthzFn, and clients without certificates are authenticated by AuthzFn
+func tlsConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	}
 }
 
 func readCert(keyFile, certFile string) tls.Certificate {
@@ -284,7 +316,7 @@ func readCert(keyFile, certFile string) tls.Certificate {
 	if err != nil {
 		log.Fatalf("Read cert file %v", err)
 	}
-	cert, err := tls.X509KeyPair(keyBytes, certBytes)
+	cert, err := tls.X509KeyPair(certBytes, keyBytes)
 	if err != nil {
 		log.Fatalf("Wrong cert or key file: %v", err)
 	}
@@ -315,6 +347,32 @@ func (e *embedBroker) updateClient(c connector.ClientInfo) {
 	e.clients.Store(c.ClientId, c)
 }
 
+func (e *embedBroker) ReportPresence(evt connector.PresenceEvent) {
+	t := time.UnixMilli(evt.Timestamp)
+	c := connector.ClientInfo{
+		ClientId:   evt.ThingId,
+		Username:   evt.ThingId,
+		Connected:  evt.EventType == connector.EventConnected,
+		RemoteAddr: evt.RemoteAddr,
+	}
+	if c.Connected {
+		c.ConnectedAt = &t
+	} else {
+		c.DisconnectedAt = &t
+		c.DisconnectReason = evt.DisconnectReason
+	}
+	// damped as things connected directly
+	flapping, flapChanged := e.presence.damper.transition(evt.ThingId, t)
+	c.Flapping = flapping
+	e.updateClient(c)
+	evt.Flapping = flapping
+	if c.Connected {
+		e.presence.damper.connected(evt, flapChanged)
+	} else {
+		e.presence.damper.disconnected(evt)
+	}
+}
+
 func (e *embedBroker) CloseClient(clientId string) bool {
 	c, ok := e.impl.Clients.Get(clientId)
 	if ok {
@@ -325,19 +383,26 @@ func (e *embedBroker) CloseClient(clientId string) bool {
 	return false
 }
 
-func publishEventFn(e *mqtt.Server, evtBus *eventbus.EventBus[connector.PresenceEvent]) func(topic string, retain bool, evt connector.PresenceEvent) {
-	return func(topic string, retain bool, evt connector.PresenceEvent) {
+// publishEventFn publishes the presence event to the event bus once,
+// and to the retained presence topic and the presence event topic
+func publishEventFn(e *mqtt.Server, evtBus *eventbus.EventBus[connector.PresenceEvent]) func(evt connector.PresenceEvent) {
+	return func(evt connector.PresenceEvent) {
+		evtBus.Publish(presenceEventName, evt)
 		payload, err := json.Marshal(evt)
 		if err != nil {
-			log.Errorf("Unmarshal event payload %#v: %v", evt, err)
+			log.Errorf("Marshal event payload %#v: %v", evt, err)
 			return
 		}
-		evtBus.Publish(presenceEventName, evt)
-		err = e.Publish(topic, payload, retain, 1)
-		if err != nil {
-			log.Errorf("Publish %s event %#v error: %v", evt.EventType, evt, err)
-		} else {
-			log.Infof("Published %s event, topic=%q event=%v", evt.EventType, topic, evt)
+		for _, t := range []struct {
+			topic  string
+			retain bool
+		}{{connector.TopicPresence(evt.ThingId), true}, {connector.TopicPresenceEvent(evt.ThingId), false}} {
+			err = e.Publish(t.topic, payload, t.retain, 1)
+			if err != nil {
+				log.Errorf("Publish %s event %#v error: %v", evt.EventType, evt, err)
+			} else {
+				log.Infof("Published %s event, topic=%q event=%v", evt.EventType, t.topic, evt)
+			}
 		}
 	}
 }
@@ -0,0 +1,199 @@
+package embed
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"ruff.io/tio/connector"
+)
+
+// PresenceDamping damps presence events of things on flaky networks
+type PresenceDamping struct {
+	// Debounce delays publishing disconnected events,
+	// a thing reconnecting in it publishes neither the disconnected nor the connected event. 0 disables it.
+	Debounce time.Duration
+	// FlapThreshold connects and disconnects in a minute for a thing to be flagged flapping. 0 disables it.
+	FlapThreshold int
+}
+
+const (
+	flapWindow = time.Minute
+	// presenceQueueSize max count of presence changes waiting to be published
+	presenceQueueSize = 1024
+)
+
+type pendingDisconnect struct {
+	timer *time.Timer
+}
+
+// presenceTask is a connect or disconnect of a thing, or a debounced disconnect expiring
+type presenceTask struct {
+	evt         connector.PresenceEvent
+	flapChanged bool
+	expired     *pendingDisconnect
+}
+
+// presenceDamper handles presence changes of things in order by a single worker, see start
+type presenceDamper struct {
+	opt     PresenceDamping
+	publish func(evt connector.PresenceEvent)
+	queue   chan presenceTask
+
+	mu sync.Mutex
+	// pending disconnected events in debounce window, by thing id
+	pending map[string]*pendingDisconnect
+	// connects and disconnects in flap window, by thing id
+	transitions map[string][]time.Time
+	flapping    map[string]bool
+}
+
+func newPresenceDamper(opt PresenceDamping, publish func(evt connector.PresenceEvent)) *presenceDamper {
+	return &presenceDamper{
+		opt:         opt,
+		publish:     publish,
+		queue:       make(chan presenceTask, presenceQueueSize),
+		pending:     make(map[string]*pendingDisconnect),
+		transitions: make(map[string][]time.Time),
+		flapping:    make(map[string]bool),
+	}
+}
+
+// start handling presence changes, and pruning transitions out of flap window until context done
+func (d *presenceDamper) start(ctx context.Context) {
+	go func() {
+		tick := time.NewTicker(flapWindow)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-d.queue:
+				d.handle(t)
+			case now := <-tick.C:
+				d.prune(now)
+			}
+		}
+	}()
+}
+
+// transition records a connect or disconnect of the thing, returns whether it's flapping and whether the flag changed
+func (d *presenceDamper) transition(thingId string, t time.Time) (flapping, changed bool) {
+	if d.opt.FlapThreshold <= 0 {
+		return false, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l := append(d.transitions[thingId], t)
+	i := 0
+	for i < len(l) && t.Sub(l[i]) >= flapWindow {
+		i++
+	}
+	l = l[i:]
+	d.transitions[thingId] = l
+
+	flapping = len(l) >= d.opt.FlapThreshold
+	changed = flapping != d.flapping[thingId]
+	if flapping {
+		d.flapping[thingId] = true
+	} else {
+		delete(d.flapping, thingId)
+	}
+	if changed {
+		slog.Info("Mqtt presence flapping changed", "thingId", thingId, "flapping", flapping, "transitions", len(l))
+	}
+	return flapping, changed
+}
+
+// prune forgets things without transitions in flap window, they are no longer flapping.
+// The flag is cleared by the event of the next connect or disconnect.
+func (d *presenceDamper) prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, l := range d.transitions {
+		if len(l) == 0 || now.Sub(l[len(l)-1]) >= flapWindow {
+			delete(d.transitions, id)
+			delete(d.flapping, id)
+		}
+	}
+}
+
+// connected queues the connect of the thing, see onConnected
+func (d *presenceDamper) connected(evt connector.PresenceEvent, flapChanged bool) {
+	d.enqueue(presenceTask{evt: evt, flapChanged: flapChanged})
+}
+
+// disconnected queues the disconnect of the thing, see onDisconnected
+func (d *presenceDamper) disconnected(evt connector.PresenceEvent) {
+	d.enqueue(presenceTask{evt: evt})
+}
+
+func (d *presenceDamper) enqueue(t presenceTask) {
+	select {
+	case d.queue <- t:
+	default:
+		// the conn sweeper corrects conn status of the thing later
+		slog.Warn("Mqtt presence queue is full, dropped event",
+			"thingId", t.evt.ThingId, "eventType", t.evt.EventType)
+	}
+}
+
+func (d *presenceDamper) handle(t presenceTask) {
+	switch {
+	case t.expired != nil:
+		d.onExpired(t.evt, t.expired)
+	case t.evt.EventType == connector.EventConnected:
+		d.onConnected(t.evt, t.flapChanged)
+	default:
+		d.onDisconnected(t.evt)
+	}
+}
+
+// onConnected publishes the event, unless it cancels a pending disconnected event and the flapping flag is unchanged
+func (d *presenceDamper) onConnected(evt connector.PresenceEvent, flapChanged bool) {
+	d.mu.Lock()
+	p, ok := d.pending[evt.ThingId]
+	if ok {
+		p.timer.Stop()
+		delete(d.pending, evt.ThingId)
+	}
+	d.mu.Unlock()
+	if ok && !flapChanged {
+		slog.Debug("Mqtt presence reconnected in debounce window", "thingId", evt.ThingId)
+		return
+	}
+	d.publish(evt)
+}
+
+// onDisconnected publishes the event after debounce window
+func (d *presenceDamper) onDisconnected(evt connector.PresenceEvent) {
+	if d.opt.Debounce <= 0 {
+		d.publish(evt)
+		return
+	}
+	p := &pendingDisconnect{}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, ok := d.pending[evt.ThingId]; ok {
+		old.timer.Stop()
+	}
+	d.pending[evt.ThingId] = p
+	// queued to be published in order with the following changes
+	p.timer = time.AfterFunc(d.opt.Debounce, func() {
+		d.enqueue(presenceTask{evt: evt, expired: p})
+	})
+}
+
+// onExpired publishes the disconnected event if it's still pending
+func (d *presenceDamper) onExpired(evt connector.PresenceEvent, p *pendingDisconnect) {
+	d.mu.Lock()
+	cur := d.pending[evt.ThingId]
+	if cur == p {
+		delete(d.pending, evt.ThingId)
+	}
+	d.mu.Unlock()
+	if cur == p {
+		d.publish(evt)
+	}
+}
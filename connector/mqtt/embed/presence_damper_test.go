@@ -0,0 +1,128 @@
+package embed
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/connector"
+)
+
+type publishedEvents struct {
+	mu sync.Mutex
+	l  []connector.PresenceEvent
+}
+
+func (p *publishedEvents) publish(evt connector.PresenceEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.l = append(p.l, evt)
+}
+
+func (p *publishedEvents) types() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]string, len(p.l))
+	for i, e := range p.l {
+		res[i] = e.EventType
+	}
+	return res
+}
+
+func (p *publishedEvents) eventually(t *testing.T, types ...string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return slices.Equal(types, p.types())
+	}, time.Second, 5*time.Millisecond, "published %v", p.types())
+}
+
+func startDamper(t *testing.T, opt PresenceDamping, pub *publishedEvents) *presenceDamper {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d := newPresenceDamper(opt, pub.publish)
+	d.start(ctx)
+	return d
+}
+
+func TestPresenceDamper_Debounce(t *testing.T) {
+	var pub publishedEvents
+	d := startDamper(t, PresenceDamping{Debounce: 50 * time.Millisecond}, &pub)
+	conn := connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventConnected}
+	disc := connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventDisconnected}
+
+	d.connected(conn, false)
+	d.disconnected(disc)
+	d.connected(conn, false)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, []string{connector.EventConnected}, pub.types(), "reconnecting in debounce window should publish nothing")
+
+	d.disconnected(disc)
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, pub.types(), 1, "disconnected should be delayed")
+	pub.eventually(t, connector.EventConnected, connector.EventDisconnected)
+
+	d.connected(conn, false)
+	pub.eventually(t, connector.EventConnected, connector.EventDisconnected, connector.EventConnected)
+}
+
+func TestPresenceDamper_InOrder(t *testing.T) {
+	var pub publishedEvents
+	d := startDamper(t, PresenceDamping{}, &pub)
+	var want []string
+	for i := 0; i < 100; i++ {
+		d.connected(connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventConnected}, false)
+		d.disconnected(connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventDisconnected})
+		want = append(want, connector.EventConnected, connector.EventDisconnected)
+	}
+	pub.eventually(t, want...)
+}
+
+func TestPresenceDamper_Flapping(t *testing.T) {
+	var pub publishedEvents
+	d := newPresenceDamper(PresenceDamping{FlapThreshold: 3}, pub.publish)
+	now := time.Now()
+
+	f, changed := d.transition("th-1", now)
+	require.False(t, f)
+	require.False(t, changed)
+	_, _ = d.transition("th-1", now.Add(time.Second))
+	f, changed = d.transition("th-1", now.Add(2*time.Second))
+	require.True(t, f)
+	require.True(t, changed)
+	f, changed = d.transition("th-1", now.Add(3*time.Second))
+	require.True(t, f)
+	require.False(t, changed)
+
+	f, _ = d.transition("th-2", now.Add(3*time.Second))
+	require.False(t, f, "flapping should be counted by thing")
+
+	f, changed = d.transition("th-1", now.Add(time.Minute+2*time.Second))
+	require.False(t, f, "transitions out of window should be dropped")
+	require.True(t, changed)
+}
+
+func TestPresenceDamper_Prune(t *testing.T) {
+	d := newPresenceDamper(PresenceDamping{FlapThreshold: 2}, func(connector.PresenceEvent) {})
+	now := time.Now()
+	_, _ = d.transition("th-1", now)
+	_, _ = d.transition("th-1", now.Add(time.Second))
+	_, _ = d.transition("th-2", now.Add(30*time.Second))
+
+	d.prune(now.Add(time.Minute + time.Second))
+	require.NotContains(t, d.transitions, "th-1")
+	require.NotContains(t, d.flapping, "th-1")
+	require.Contains(t, d.transitions, "th-2", "transitions in flap window should be kept")
+}
+
+func TestPresenceDamper_FlapChangePublished(t *testing.T) {
+	var pub publishedEvents
+	d := startDamper(t, PresenceDamping{Debounce: time.Second, FlapThreshold: 2}, &pub)
+
+	d.disconnected(connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventDisconnected})
+	d.connected(connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventConnected, Flapping: true}, true)
+	pub.eventually(t, connector.EventConnected)
+	require.True(t, pub.l[0].Flapping, "change of flapping flag should be published")
+}
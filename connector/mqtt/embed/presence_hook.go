@@ -15,8 +15,17 @@ import (
 
 type presenceHook struct {
 	mqtt.HookBase
-	publishEventFn func(topic string, retain bool, evt connector.PresenceEvent)
+	publishEventFn func(evt connector.PresenceEvent)
 	getClientFn    func(id string) (*mqtt.Client, bool)
+	damper         *presenceDamper
+}
+
+func newPresenceHook(opt PresenceDamping,
+	publishEventFn func(evt connector.PresenceEvent),
+	getClientFn func(id string) (*mqtt.Client, bool)) *presenceHook {
+	h := &presenceHook{publishEventFn: publishEventFn, getClientFn: getClientFn}
+	h.damper = newPresenceDamper(opt, publishEventFn)
+	return h
 }
 
 func (h *presenceHook) ID() string {
@@ -42,14 +51,14 @@ func (h *presenceHook) OnSessionEstablished(cl *mqtt.Client, pk packets.Packet)
 		return
 	}
 	now := time.Now()
+	flapping, flapChanged := h.damper.transition(username, now)
 	cinfo := toClientInfo(cl, true, &now, nil, nil)
+	cinfo.Flapping = flapping
 	broker.updateClient(cinfo)
 	if isPublishPresent(username) {
 		evt := toEvent(cl, connector.EventConnected, now, "")
-		go func() {
-			h.publishEventFn(connector.TopicPresence(username), true, evt)
-			h.publishEventFn(connector.TopicPresenceEvent(username), false, evt)
-		}()
+		evt.Flapping = flapping
+		h.damper.connected(evt, flapChanged)
 	}
 }
 
@@ -66,14 +75,14 @@ func (h *presenceHook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
 		return
 	}
 	now := time.Now()
+	flapping, _ := h.damper.transition(username, now)
 	cinfo := toClientInfo(cl, false, nil, &now, err)
+	cinfo.Flapping = flapping
 	broker.updateClient(cinfo)
 	if isPublishPresent(string(cl.Properties.Username)) {
 		evt := toEvent(cl, connector.EventDisconnected, now, fmt.Sprintf("%s", err))
-		go func() {
-			h.publishEventFn(connector.TopicPresence(username), true, evt)
-			h.publishEventFn(connector.TopicPresenceEvent(username), false, evt)
-		}()
+		evt.Flapping = flapping
+		h.damper.disconnected(evt)
 	}
 }
 
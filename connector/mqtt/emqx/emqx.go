@@ -99,6 +99,7 @@ type emqxAdapter struct {
 }
 
 var _ connector.Connectivity = (*emqxAdapter)(nil)
+var _ connector.PresenceReporter = (*emqxAdapter)(nil)
 
 func NewEmqxAdapter(cfg config.EmqxAdapterConfig, mqCl mq.Client) connector.Connectivity {
 	return &emqxAdapter{
@@ -246,6 +247,26 @@ func (e *emqxAdapter) updateClient(i ClientInfo) {
 	e.clients.Store(i.ClientId, client{info: i, updateAt: time.Now()})
 }
 
+func (e *emqxAdapter) ReportPresence(evt connector.PresenceEvent) error {
+	t := time.UnixMilli(evt.Timestamp)
+	i := ClientInfo{
+		ClientId:  evt.ThingId,
+		Username:  evt.ThingId,
+		Connected: evt.EventType == connector.EventConnected,
+		IpAddress: evt.RemoteAddr,
+	}
+	if i.Connected {
+		i.ConnectedAt = &t
+	} else {
+		i.DisconnectedAt = &t
+		i.DisconnectReason = evt.DisconnectReason
+	}
+	e.updateClient(i)
+	e.presenceEventBus.Publish(presenceEventName, evt)
+	notifyEvent(context.Background(), e.mqttClient, evt.ThingId, evt)
+	return nil
+}
+
 func (e *emqxAdapter) listenConnectivity(ctx context.Context) error {
 	err := e.mqttClient.Subscribe(ctx, TopicClientConnected, 1, func(c mqtt.Client, message mqtt.Message) {
 		go func() {
@@ -41,6 +41,13 @@ func (m mqttConnector) Publish(topic string, qos byte, retained bool, payload []
 	}
 }
 
+func (m mqttConnector) ReportPresence(evt connector.PresenceEvent) error {
+	if r, ok := m.Connectivity.(connector.PresenceReporter); ok {
+		return r.ReportPresence(evt)
+	}
+	return errors.New("presence reporting is not supported by the connector")
+}
+
 var onceNewConnector sync.Once
 var connectorSingleton connector.Connector
 
@@ -66,3 +73,4 @@ func InitConnector(cfg config.Connector, cl client.Client) connector.Connector {
 func Connector() connector.Connector { return connectorSingleton }
 
 var _ connector.Connector = (*mqttConnector)(nil)
+var _ connector.PresenceReporter = (*mqttConnector)(nil)
@@ -64,11 +64,12 @@ func setup() {
 
 	connector = mq.InitConnector(cfg.Connector, mqttClient)
 
-	methodHandler := shadow.NewMethodHandler(connector)
+	methodLogSvc := shadow.NewMethodLogSvc(shadow.NewMethodLogRepo(dbConn), shadow.MethodLogOptions{})
+	methodHandler := shadow.NewLoggedMethodHandler(shadow.NewMethodHandler(connector), methodLogSvc)
 	shadowStateHandler := shadow.NewShadowHandler(connector)
 
-	shadowSvc = shadowWire.InitSvc(dbConn, connector)
-	thingSvc = thingWire.InitSvc(ctx, dbConn, shadowSvc, connector)
+	shadowSvc = shadowWire.InitSvc(dbConn, connector, nil, nil)
+	thingSvc = thingWire.InitSvc(ctx, dbConn, shadowSvc, connector, methodHandler, nil)
 
 	// embedded mqtt broker
 	if cfg.Connector.Typ == config.ConnectorMqttEmbed {
@@ -88,7 +89,7 @@ func setup() {
 	container := restful.NewContainer()
 	container.ServeMux = http.NewServeMux()
 	thingWs := thingApi.Service(context.Background(), thingSvc)
-	shadowApi.Service(context.Background(), thingWs, shadowSvc, thingSvc, methodHandler)
+	shadowApi.Service(context.Background(), thingWs, shadowSvc, thingSvc, methodHandler, methodLogSvc)
 	container.Add(thingWs)
 	container.Add(restfulspec.NewOpenAPIService(api.OpenapiConfig()))
 
@@ -146,7 +147,7 @@ func startMqttBroker(ctx context.Context, cfg config.InnerMqttBroker, thingSvc t
 }
 
 func autoMigrate(conn *gorm.DB) {
-	_ = conn.AutoMigrate(&thing.Entity{}, &shadow.Entity{}, &shadow.ConnStatusEntity{})
+	_ = conn.AutoMigrate(&thing.Entity{}, &thing.TypeEntity{}, &shadow.Entity{}, &shadow.ConnStatusEntity{}, &shadow.MethodLogEntity{})
 }
 
 func newThingMqttClient(cxt context.Context, thingId string, password string) client.Client {
@@ -0,0 +1,99 @@
+package job
+
+import (
+	"fmt"
+	"time"
+
+	"ruff.io/tio/pkg/log"
+)
+
+// executed things whose tasks are done except canceled
+func (pd ProcessDetails) executed() int {
+	return pd.Succeeded + pd.Failed + pd.Rejected + pd.TimedOut
+}
+
+func (pd ProcessDetails) failures(failureType string) int {
+	switch failureType {
+	case TaskFailed.String():
+		return pd.Failed
+	case TaskRejected.String():
+		return pd.Rejected
+	case TaskTimeOut.String():
+		return pd.TimedOut
+	case AbortFailureTypeAll:
+		return pd.Failed + pd.Rejected + pd.TimedOut
+	}
+	return 0
+}
+
+// tripped returns the first criteria met
+func (c *AbortConfig) tripped(pd ProcessDetails) (AbortCriteria, bool) {
+	if c == nil {
+		return AbortCriteria{}, false
+	}
+	executed := pd.executed()
+	for _, cr := range c.CriteriaList {
+		if executed == 0 || executed < cr.MinNumberOfExecutedThings {
+			continue
+		}
+		if float64(pd.failures(cr.FailureType))*100 >= cr.ThresholdPercentage*float64(executed) {
+			return cr, true
+		}
+	}
+	return AbortCriteria{}, false
+}
+
+// checkAbortJob cancels the job when its abort criteria is met, returns whether it's aborted
+func (c *centerImpl) checkAbortJob(jobId string) bool {
+	jc := c.getJobContext(jobId)
+	if jc == nil || jc.AbortConfig == nil {
+		return false
+	}
+	tsc, err := c.repo.CountTaskStatus(c.ctx, jobId)
+	if err != nil {
+		log.Errorf("JobCenter get task status count for abort, jobId=%q, error: %v", jobId, err)
+		return false
+	}
+	pd := toProcessDetails(tsc)
+	cr, ok := jc.AbortConfig.tripped(pd)
+	if !ok {
+		return false
+	}
+	j, err := c.repo.GetJob(c.ctx, jobId)
+	if err != nil || j == nil {
+		log.Errorf("JobCenter get job for abort, jobId=%q, error: %v", jobId, err)
+		return false
+	}
+	if isJobToTerminal(j.Status) {
+		return false
+	}
+
+	force := cr.Action == AbortActionForceCancel
+	comment := fmt.Sprintf("aborted cause %s tasks reach %.1f%% of %d executed things, threshold is %.1f%%",
+		cr.FailureType, float64(pd.failures(cr.FailureType))*100/float64(pd.executed()), pd.executed(),
+		cr.ThresholdPercentage)
+	err = c.repo.ExecWithTx(func(txRepo Repo) error {
+		if err := txRepo.UpdateJob(c.ctx, jobId, map[string]any{
+			"status":         StatusCanceling,
+			"force_canceled": force,
+			"comment":        comment,
+			"reason_code":    ReasonCodeAborted,
+		}); err != nil {
+			return err
+		}
+		return txRepo.CancelTasks(c.ctx, jobId, force)
+	})
+	if err != nil {
+		log.Errorf("JobCenter abort job, jobId=%q, error: %v", jobId, err)
+		return false
+	}
+	log.Warnf("JobCenter job aborted, jobId=%q, force=%v, %s", jobId, force, comment)
+
+	// handled as canceled by management, in pool to avoid blocking the task change loop
+	msg := MgrMsg{Typ: MgrTypeCancelJob, Data: MgrMsgCancelJob{JobId: jobId, Operation: jc.Operation, Force: force}}
+	if err := c.pool.Submit(func() { c.ReceiveMgrMsg(msg) }); err != nil {
+		log.Errorf("JobCenter submit cancel of aborted job, jobId=%q, error: %v", jobId, err)
+		_ = c.repo.UpdateJob(c.ctx, jobId, map[string]any{"status": StatusCanceled, "completed_at": time.Now()})
+	}
+	return true
+}
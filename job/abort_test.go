@@ -0,0 +1,50 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/model"
+)
+
+func TestAbortConfig_Tripped(t *testing.T) {
+	c := &AbortConfig{CriteriaList: []AbortCriteria{
+		{FailureType: TaskFailed.String(), Action: AbortActionCancel, ThresholdPercentage: 10, MinNumberOfExecutedThings: 100},
+		{FailureType: AbortFailureTypeAll, Action: AbortActionForceCancel, ThresholdPercentage: 50, MinNumberOfExecutedThings: 10},
+	}}
+	require.NoError(t, c.valid())
+
+	_, ok := c.tripped(ProcessDetails{Failed: 20, Succeeded: 30, Queued: 1000})
+	require.False(t, ok, "not enough things executed")
+
+	cr, ok := c.tripped(ProcessDetails{Failed: 10, Succeeded: 90})
+	require.True(t, ok)
+	require.Equal(t, AbortActionCancel, cr.Action)
+
+	_, ok = c.tripped(ProcessDetails{Failed: 9, Succeeded: 91, Canceled: 50})
+	require.False(t, ok, "canceled tasks are not executed")
+
+	cr, ok = c.tripped(ProcessDetails{Rejected: 3, TimedOut: 2, Succeeded: 5})
+	require.True(t, ok)
+	require.Equal(t, AbortFailureTypeAll, cr.FailureType)
+
+	var nilConf *AbortConfig
+	_, ok = nilConf.tripped(ProcessDetails{Failed: 100})
+	require.False(t, ok)
+}
+
+func TestAbortConfig_Valid(t *testing.T) {
+	for _, cr := range []AbortCriteria{
+		{FailureType: "CANCELED", Action: AbortActionCancel, ThresholdPercentage: 10, MinNumberOfExecutedThings: 1},
+		{FailureType: AbortFailureTypeAll, Action: "STOP", ThresholdPercentage: 10, MinNumberOfExecutedThings: 1},
+		{FailureType: AbortFailureTypeAll, Action: AbortActionCancel, ThresholdPercentage: 0, MinNumberOfExecutedThings: 1},
+		{FailureType: AbortFailureTypeAll, Action: AbortActionCancel, ThresholdPercentage: 101, MinNumberOfExecutedThings: 1},
+		{FailureType: AbortFailureTypeAll, Action: AbortActionCancel, ThresholdPercentage: 10, MinNumberOfExecutedThings: 0},
+	} {
+		c := &AbortConfig{CriteriaList: []AbortCriteria{cr}}
+		require.ErrorIs(t, c.valid(), model.ErrInvalidParams, "criteria %v", cr)
+	}
+	dup := AbortCriteria{FailureType: AbortFailureTypeAll, Action: AbortActionCancel, ThresholdPercentage: 10, MinNumberOfExecutedThings: 1}
+	require.ErrorIs(t, (&AbortConfig{CriteriaList: []AbortCriteria{dup, dup}}).valid(), model.ErrInvalidParams)
+	require.ErrorIs(t, (&AbortConfig{}).valid(), model.ErrInvalidParams)
+}
@@ -12,6 +12,7 @@ func (r *runnerImpl) doInvokeDirectMethod(t Task, req InvokeDirectMethodReq) Tas
 		ThingId:     t.ThingId,
 		Method:      req.Method,
 		RespTimeout: req.RespTimeout,
+		Caller:      shadow.CallerJobPrefix + t.JobId,
 		Req: shadow.MethodReq{
 			ClientToken: fmt.Sprintf("job-%s-%d", t.ThingId, time.Now().Nanosecond()),
 			Data:        req.Data,
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"ruff.io/tio/job"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+)
+
+// ServiceForSchedule job schedule api
+func ServiceForSchedule(ctx context.Context, svc job.ScheduleService) *restful.WebService {
+	tags := []string{"jobSchedules"}
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/jobSchedules").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.POST("/").
+		To(createScheduleHandler(ctx, svc)).
+		Operation("create-schedule").
+		Doc("create job schedule").
+		Notes("A new job is created from the job template at every activation of the cron, "+
+			"its jobId is the scheduleId with the suffix of the activation time in UTC and a random string, "+
+			"like \"nightly-202405011800-1a2b3c\". "+
+			"The run is skipped when running jobs of the schedule reach maxConcurrency.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(job.ScheduleCreateReq{}).
+		Returns(200, "OK", rest.RespOK(job.Schedule{})))
+	ws.Route(ws.GET("/").
+		To(queryScheduleHandler(ctx, svc)).
+		Operation("query-schedules").
+		Doc("get job schedules").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(job.SchedulePage{})))
+	ws.Route(ws.GET("/{scheduleId}").
+		To(getScheduleHandler(ctx, svc)).
+		Operation("get-schedule").
+		Doc("get job schedule").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("scheduleId", "")).
+		Returns(200, "OK", rest.RespOK(job.Schedule{})))
+	ws.Route(ws.PATCH("/{scheduleId}").
+		To(updateScheduleHandler(ctx, svc)).
+		Operation("update-schedule").
+		Doc("update job schedule, changes take effect for only later runs").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("scheduleId", "")).
+		Reads(job.ScheduleUpdateReq{}).
+		Returns(200, "OK", rest.RespOK(job.Schedule{})))
+	ws.Route(ws.DELETE("/{scheduleId}").
+		To(deleteScheduleHandler(ctx, svc)).
+		Operation("delete-schedule").
+		Doc("delete job schedule and its run history, jobs created are kept").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("scheduleId", "")).
+		Returns(200, "OK", rest.RespOK("")))
+	ws.Route(ws.PUT("/{scheduleId}/pause").
+		To(pauseScheduleHandler(ctx, svc)).
+		Operation("pause-schedule").
+		Doc("pause job schedule, jobs created keep running").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("scheduleId", "")).
+		Returns(200, "OK", rest.RespOK("")))
+	ws.Route(ws.PUT("/{scheduleId}/resume").
+		To(resumeScheduleHandler(ctx, svc)).
+		Operation("resume-schedule").
+		Doc("resume job schedule, runs missed while paused are not made up").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("scheduleId", "")).
+		Returns(200, "OK", rest.RespOK("")))
+	ws.Route(ws.GET("/{scheduleId}/runs").
+		To(queryScheduleRunHandler(ctx, svc)).
+		Operation("query-schedule-runs").
+		Doc("get run history of job schedule, latest first").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("scheduleId", "")).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(job.ScheduleRunPage{})))
+
+	return ws
+}
+
+func createScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req job.ScheduleCreateReq
+		if err := r.ReadEntity(&req); err != nil {
+			log.Infof("Error decoding body for create job schedule: %v", err)
+			rest.SendResp(w, 400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		if s, err := svc.CreateSchedule(ctx, req); err != nil {
+			log.Errorf("Create job schedule error, req=%#v, error: %v", req, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, s)
+		}
+	}
+}
+
+func queryScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var pq model.PageQuery
+		var err error
+		if pq.PageIndex, pq.PageSize, err = getPageArgs(r); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		if p, err := svc.QuerySchedule(ctx, pq); err != nil {
+			log.Errorf("Query job schedule error, query=%#v, error: %v", pq, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, p)
+		}
+	}
+}
+
+func getScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("scheduleId")
+		if s, err := svc.GetSchedule(ctx, id); err != nil {
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, s)
+		}
+	}
+}
+
+func updateScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req job.ScheduleUpdateReq
+		id := r.PathParameter("scheduleId")
+		if err := r.ReadEntity(&req); err != nil {
+			log.Infof("Error decoding body for update job schedule: %v", err)
+			rest.SendResp(w, 400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		if s, err := svc.UpdateSchedule(ctx, id, req); err != nil {
+			log.Errorf("Update job schedule error, scheduleId=%q, req=%#v, error: %v", id, req, err)
+			checkErrAndSend(err, w)
+		} else {
+			log.Infof("Update job schedule success, scheduleId=%q, req=%#v", id, req)
+			rest.SendRespOK(w, s)
+		}
+	}
+}
+
+func deleteScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("scheduleId")
+		if err := svc.DeleteSchedule(ctx, id); err != nil {
+			log.Errorf("Delete job schedule error, scheduleId=%q, error: %v", id, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK[any](w, nil)
+		}
+	}
+}
+
+func pauseScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("scheduleId")
+		if err := svc.PauseSchedule(ctx, id); err != nil {
+			log.Errorf("Pause job schedule error, scheduleId=%q, error: %v", id, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK[any](w, nil)
+		}
+	}
+}
+
+func resumeScheduleHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("scheduleId")
+		if err := svc.ResumeSchedule(ctx, id); err != nil {
+			log.Errorf("Resume job schedule error, scheduleId=%q, error: %v", id, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK[any](w, nil)
+		}
+	}
+}
+
+func queryScheduleRunHandler(ctx context.Context, svc job.ScheduleService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("scheduleId")
+		var pq model.PageQuery
+		var err error
+		if pq.PageIndex, pq.PageSize, err = getPageArgs(r); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		if p, err := svc.QueryScheduleRun(ctx, id, pq); err != nil {
+			log.Errorf("Query job schedule runs error, scheduleId=%q, query=%#v, error: %v", id, pq, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, p)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"ruff.io/tio/job"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+)
+
+// ServiceForTemplate job template api
+func ServiceForTemplate(ctx context.Context, svc job.TemplateService) *restful.WebService {
+	tags := []string{"jobTemplates"}
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/jobTemplates").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.POST("/").
+		To(createTemplateHandler(ctx, svc)).
+		Operation("create-template").
+		Doc("create job template").
+		Notes("Job is created from the template by \"jobTemplateId\", fields of the job request that are set override those of the template. "+
+			"Parameters are referenced as \"${name}\" in string values of targetConfig and jobDoc, "+
+			"like {\"url\": \"${firmwareUrl}\"}, and their values are given by \"parameters\" of the job request.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(job.TemplateCreateReq{}).
+		Returns(200, "OK", rest.RespOK(job.Template{})))
+	ws.Route(ws.GET("/").
+		To(queryTemplateHandler(ctx, svc)).
+		Operation("query-templates").
+		Doc("get job templates").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(job.TemplatePage{})))
+	ws.Route(ws.GET("/{templateId}").
+		To(getTemplateHandler(ctx, svc)).
+		Operation("get-template").
+		Doc("get job template").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("templateId", "")).
+		Returns(200, "OK", rest.RespOK(job.Template{})))
+	ws.Route(ws.PUT("/{templateId}").
+		To(updateTemplateHandler(ctx, svc)).
+		Operation("update-template").
+		Doc("replace config of job template, and increase its version").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("templateId", "")).
+		Reads(job.TemplateUpdateReq{}).
+		Returns(200, "OK", rest.RespOK(job.Template{})))
+	ws.Route(ws.DELETE("/{templateId}").
+		To(deleteTemplateHandler(ctx, svc)).
+		Operation("delete-template").
+		Doc("delete job template, jobs created from it are kept").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("templateId", "")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	return ws
+}
+
+func createTemplateHandler(ctx context.Context, svc job.TemplateService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req job.TemplateCreateReq
+		if err := r.ReadEntity(&req); err != nil {
+			log.Infof("Error decoding body for create job template: %v", err)
+			rest.SendResp(w, 400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		if t, err := svc.CreateTemplate(ctx, req); err != nil {
+			log.Errorf("Create job template error, req=%#v, error: %v", req, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, t)
+		}
+	}
+}
+
+func queryTemplateHandler(ctx context.Context, svc job.TemplateService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var pq model.PageQuery
+		var err error
+		if pq.PageIndex, pq.PageSize, err = getPageArgs(r); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		if p, err := svc.QueryTemplate(ctx, pq); err != nil {
+			log.Errorf("Query job template error, query=%#v, error: %v", pq, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, p)
+		}
+	}
+}
+
+func getTemplateHandler(ctx context.Context, svc job.TemplateService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("templateId")
+		if t, err := svc.GetTemplate(ctx, id); err != nil {
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, t)
+		}
+	}
+}
+
+func updateTemplateHandler(ctx context.Context, svc job.TemplateService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req job.TemplateUpdateReq
+		id := r.PathParameter("templateId")
+		if err := r.ReadEntity(&req); err != nil {
+			log.Infof("Error decoding body for update job template: %v", err)
+			rest.SendResp(w, 400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		if t, err := svc.UpdateTemplate(ctx, id, req); err != nil {
+			log.Errorf("Update job template error, templateId=%q, req=%#v, error: %v", id, req, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK(w, t)
+		}
+	}
+}
+
+func deleteTemplateHandler(ctx context.Context, svc job.TemplateService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("templateId")
+		if err := svc.DeleteTemplate(ctx, id); err != nil {
+			log.Errorf("Delete job template error, templateId=%q, error: %v", id, err)
+			checkErrAndSend(err, w)
+		} else {
+			rest.SendRespOK[any](w, nil)
+		}
+	}
+}
@@ -18,9 +18,10 @@ func NewCenter(
 	pubSub connector.PubSub, conn connector.ConnectChecker,
 	methodHandler shadow.MethodHandler,
 	shadowSetter shadow.StateDesiredSetter,
+	thingQuerier ThingQuerier,
 ) Center {
 	jc := make(map[string]*JobContext)
-	runner := NewRunner(r, pubSub, conn, methodHandler, shadowSetter)
+	runner := NewRunner(r, pubSub, conn, methodHandler, shadowSetter, thingQuerier)
 	p, err := ants.NewPool(centerWorkerPoolSize)
 	if err != nil {
 		log.Fatalf("JobCenter init pool: %v", err)
@@ -37,14 +38,18 @@ func NewCenter(
 		getPendingReqCh:  make(chan struct{}),
 		getPendingRespCh: nil,
 
-		runner:      runner,
-		jobContexts: jc,
+		runner:       runner,
+		jobContexts:  jc,
+		thingQuerier: thingQuerier,
+		events:       newEventPublisher(pubSub),
 	}
 }
 
 type CenterOptions struct {
 	CheckJobStatusInterval time.Duration
 	ScheduleInterval       time.Duration
+	// ContinuousJobInterval interval to create tasks of continuous jobs for things newly matching the target
+	ContinuousJobInterval time.Duration
 }
 
 type PendingJobItem struct {
@@ -54,6 +59,9 @@ type PendingJobItem struct {
 		Time  time.Time
 		Count int
 	}
+	// RolloutRate current rollout rate per minute of job with exponential rate
+	RolloutRate   int
+	rateUpdatedAt time.Time
 }
 
 type removeJobMsg struct {
@@ -87,16 +95,26 @@ type centerImpl struct {
 	jcLock      sync.RWMutex
 	jobContexts map[string]*JobContext // jobId->jobContext
 	runner      Runner
+
+	thingQuerier ThingQuerier // resolves things for continuous jobs
+	events       eventPublisher
 }
 
 func (c *centerImpl) Start(ctx context.Context) error {
 	c.ctx = ctx
 	c.runner.Start(ctx, c.getJobContext)
+	c.events.start(ctx)
+	// the center is created for every term of leadership, the pool is released with it
+	go func() {
+		<-ctx.Done()
+		c.pool.Release()
+	}()
 	go c.watchTaskChangeLoop()
 
 	// preload pending jobs from db
 	l := c.preloadPendingJobs()
 	go c.rolloutLoop(l)
+	go c.continuousJobLoop()
 
 	return nil
 }
@@ -116,6 +134,7 @@ func (c *centerImpl) ReceiveMgrMsg(msg MgrMsg) {
 				log.Errorf("JobCenter create tasks: %v, jobId=%q", err, d.JobContext.JobId)
 			} else {
 				log.Infof("JobCenter created tasks, jobId=%q, count=%d", d.JobContext.JobId, len(l))
+				c.events.jobEvent(EventCreated, d.JobContext.JobId, d.JobContext.Operation, d.JobContext.Status, false)
 				c.addPendingJob(PendingJobItem{Context: d.JobContext, Tasks: l})
 			}
 		})
@@ -142,6 +161,8 @@ func (c *centerImpl) ReceiveMgrMsg(msg MgrMsg) {
 			"completed_at":   time.Now(),
 		}); err != nil {
 			log.Errorf("JobCenter update job canceled, jobId=%q, error: %v", d.JobId, err)
+		} else {
+			c.events.jobEvent(EventCanceled, d.JobId, d.Operation, StatusCanceled, d.Force)
 		}
 	case MgrTypeDeleteJob:
 		d := msg.Data.(MgrMsgDeleteJob)
@@ -153,7 +174,7 @@ func (c *centerImpl) ReceiveMgrMsg(msg MgrMsg) {
 	case MgrTypeCancelTask:
 		d := msg.Data.(MgrMsgCancelTask)
 		submit(func() {
-			c.runner.DeleteTask(d.TaskId, d.Operation, d.Force)
+			c.runner.CancelTask(d.TaskId, d.Operation, d.Force)
 		})
 	case MgrTypeDeleteTask:
 		d := msg.Data.(MgrMsgDeleteTask)
@@ -228,7 +249,18 @@ func (c *centerImpl) rolloutLoop(l []*PendingJobItem) {
 		case <-c.ctx.Done():
 			return
 		case p := <-c.pendingJobCh:
-			pendingJobs = append(pendingJobs, &p)
+			// tasks newly created for continuous job are appended to the pending one
+			merged := false
+			for _, j := range pendingJobs {
+				if j.Context.JobId == p.Context.JobId {
+					j.Tasks = append(j.Tasks, p.Tasks...)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				pendingJobs = append(pendingJobs, &p)
+			}
 		case del := <-c.pendingJobChDel:
 			for i, j := range pendingJobs {
 				if j.Context.JobId == del.jobId {
@@ -293,6 +325,14 @@ func (c *centerImpl) rolloutLoop(l []*PendingJobItem) {
 				continue
 			}
 
+			if next, remove := c.maintenanceWindowFilter(v); !next {
+				if remove {
+					clearJob(v, i)
+					l = len(pendingJobs)
+				}
+				continue
+			}
+
 			if next, remove := c.taskTimeoutFilter(v); !next {
 				if remove {
 					clearJob(v, i)
@@ -309,7 +349,7 @@ func (c *centerImpl) rolloutLoop(l []*PendingJobItem) {
 			var rolloutTasks []Task
 			rolloutCount := len(v.Tasks)
 			if jc.RolloutConfig != nil && jc.RolloutConfig.MaxPerMinute > 0 {
-				rolloutCount = jobNextRolloutCount(jc.RolloutConfig.MaxPerMinute, *v)
+				rolloutCount = jobNextRolloutCount(c.rolloutRate(v), *v)
 			}
 			rolloutTasks = v.Tasks[:rolloutCount]
 			v.Tasks = v.Tasks[rolloutCount:]
@@ -360,6 +400,8 @@ func (c *centerImpl) jobScheduleFilter(j *PendingJobItem) (next bool, remove boo
 			); err != nil {
 				log.Errorf("JobCenter update job status, job=%q, status=%q, error: %v",
 					jc.JobId, StatusInProgress, err)
+			} else {
+				c.events.jobEvent(EventStarted, jc.JobId, jc.Operation, StatusInProgress, false)
 			}
 			jc.Status = StatusInProgress
 		}
@@ -370,7 +412,10 @@ func (c *centerImpl) jobScheduleFilter(j *PendingJobItem) (next bool, remove boo
 		if len(j.Tasks) == 0 {
 			next = false
 			remove = true
-			_ = c.doFinishJob(jc.JobId, jc.Status)
+			// continuous job keeps in progress for things matching the target later
+			if !isContinuousRunning(jc.TargetSelection, jc.Status) {
+				_ = c.doFinishJob(jc.JobId, jc.Status)
+			}
 		}
 		return
 	}
@@ -425,6 +470,8 @@ func (c *centerImpl) jobScheduleFilter(j *PendingJobItem) (next bool, remove boo
 		log.Errorf("JobCenter cancel job jobId=%q, error: %v", jc.JobId, err)
 		next = false
 		remove = false
+	} else {
+		c.events.jobEvent(EventCanceled, jc.JobId, jc.Operation, StatusCanceled, force)
 	}
 	if next {
 		// get tasks ongoing for job after cancel
@@ -440,13 +487,41 @@ func (c *centerImpl) jobScheduleFilter(j *PendingJobItem) (next bool, remove boo
 	return
 }
 
+// maintenanceWindowFilter holds tasks not rolled out yet outside maintenance windows,
+// tasks already rolled out (eg: preloaded from db) are put to runner to continue anyway.
+// It runs after jobScheduleFilter, so that end time of the schedule takes precedence over windows.
+func (c *centerImpl) maintenanceWindowFilter(p *PendingJobItem) (next bool, remove bool) {
+	if isJobToTerminal(p.Context.Status) || isInMaintenanceWindow(&p.Context, time.Now()) {
+		return true, false
+	}
+	var ongoing, queued []Task
+	for _, t := range p.Tasks {
+		if isTaskOngoing(t.Status) {
+			ongoing = append(ongoing, t)
+		} else {
+			queued = append(queued, t)
+		}
+	}
+	if len(ongoing) > 0 {
+		c.setJobContext(p.Context.JobId, p.Context)
+		c.runner.PutTasks(p.Context.Operation, ongoing)
+		log.Infof("JobCenter out of maintenance window, jobId=%q, ongoing tasks continue, count=%d",
+			p.Context.JobId, len(ongoing))
+	}
+	p.Tasks = queued
+	if len(queued) == 0 {
+		return false, true
+	}
+	return false, false
+}
+
 func (c *centerImpl) preloadPendingJobs() (jobs []*PendingJobItem) {
 	l, err := c.repo.GetPendingJobs(c.ctx)
 	if err != nil {
 		log.Fatalf("JobCenter get pending jobs: %v", err)
 	}
 	for _, j := range l {
-		if len(j.Tasks) == 0 {
+		if len(j.Tasks) == 0 && !isContinuousRunning(j.TargetSelection, j.Status) {
 			log.Warnf("JobCenter job has no pending task, to terminate it, jobId=%q", j.JobId)
 			_ = c.doFinishJob(j.JobId, j.Status)
 			continue
@@ -455,13 +530,8 @@ func (c *centerImpl) preloadPendingJobs() (jobs []*PendingJobItem) {
 			log.Fatalf("JobCenter convert entity to Detail, jobId=%q, error: %v", j.JobId, err)
 		} else {
 			p := PendingJobItem{
-				Context: JobContext{
-					JobId: d.JobId, Operation: d.Operation, JobDoc: d.JobDoc,
-					SchedulingConfig: d.SchedulingConfig, RolloutConfig: d.RolloutConfig,
-					RetryConfig: d.RetryConfig, TimeoutConfig: d.TimeoutConfig,
-					Status: d.Status, StartedAt: d.StartedAt,
-				},
-				Tasks: toTasks(j.Tasks),
+				Context: toJobContext(d),
+				Tasks:   toTasks(j.Tasks),
 			}
 			jobs = append(jobs, &p)
 		}
@@ -534,6 +604,8 @@ func jobNextRolloutCount(maxCountPerMinute int, p PendingJobItem) int {
 func (c *centerImpl) watchTaskChangeLoop() {
 	tcCh := c.runner.OnTaskChange()
 	pendingCheckJobs := map[string]struct{}{}
+	// jobs with tasks done since last check, abort criteria change only then
+	abortCheckJobs := map[string]struct{}{}
 	checkJobTick := time.NewTicker(c.opt.CheckJobStatusInterval)
 	for {
 		var msg TaskChangeMsg
@@ -541,6 +613,10 @@ func (c *centerImpl) watchTaskChangeLoop() {
 		case <-c.ctx.Done():
 			return
 		case <-checkJobTick.C:
+			for k := range abortCheckJobs {
+				c.checkAbortJob(k)
+				delete(abortCheckJobs, k)
+			}
 			for k := range pendingCheckJobs {
 				if c.checkFinishJob(k) {
 					delete(pendingCheckJobs, k)
@@ -551,16 +627,22 @@ func (c *centerImpl) watchTaskChangeLoop() {
 				"status=%q, progress=%d, statusDetails=%v",
 				msg.Task.JobId, msg.Task.TaskId, msg.Task.ThingId,
 				msg.Status, msg.Progress, msg.StatusDetails)
+			if msg.Status != msg.Task.Status {
+				c.events.taskStatusChanged(msg)
+			}
 		}
 		if isTaskTerminal(msg.Status) {
 			pendingCheckJobs[msg.Task.JobId] = struct{}{}
+			abortCheckJobs[msg.Task.JobId] = struct{}{}
 		}
 
 		// TODO: more for task change
 	}
 }
 
-func (c *centerImpl) checkFinishJob(jobId string) (finished bool) {
+// checkFinishJob finishes the job whose tasks are all done, returns false if it should be checked later
+func (c *centerImpl) checkFinishJob(jobId string) (checked bool) {
+	finished := false
 	defer func() {
 		if finished {
 			c.removeJobContext(jobId)
@@ -585,9 +667,15 @@ func (c *centerImpl) checkFinishJob(jobId string) (finished bool) {
 
 	if j == nil {
 		log.Errorf("JobCenter get job nil jobId=%q", jobId)
+		finished = true
+		return true
+	}
+	if isContinuousRunning(j.TargetSelection, j.Status) {
+		// keep pending job and context, for tasks may be created for it at the same time
 		return true
 	}
 	_ = c.doFinishJob(jobId, j.Status)
+	finished = true
 	return true
 }
 
@@ -609,6 +697,7 @@ func (c *centerImpl) doFinishJob(jobId string, status Status) error {
 		// do nothing
 		// job which canceled without force may have ongoing tasks
 		// so that the job may be checked for finish more than one time
+		return nil
 	default:
 		log.Warnf("JobCenter unexpected job status when check, jobId=%q, status=%q", jobId, status)
 		return nil
@@ -622,6 +711,15 @@ func (c *centerImpl) doFinishJob(jobId string, status Status) error {
 		return err
 	} else {
 		log.Infof("JobCenter job finished, jobId=%q, status=%q", jobId, st)
+		var op string
+		if jc := c.getJobContext(jobId); jc != nil {
+			op = jc.Operation
+		}
+		evt := EventCompleted
+		if st == StatusCanceled {
+			evt = EventCanceled
+		}
+		c.events.jobEvent(evt, jobId, op, st, false)
 		return nil
 	}
 }
@@ -648,6 +746,10 @@ func (c *centerImpl) removeJobContext(jobId string) {
 func (c *centerImpl) createJob(m MgrMsgCreateJob) ([]Task, error) {
 	jobId := m.JobContext.JobId
 	l := toTaskEntities(jobId, m.JobContext.Operation, m.TargetConfig)
+	if len(l) == 0 {
+		// continuous job may match no thing when it's created, tasks are created when things match later
+		return []Task{}, nil
+	}
 	if rl, err := c.repo.CreateTasks(c.ctx, l); err != nil {
 		return []Task{}, err
 	} else {
@@ -666,6 +768,11 @@ func isJobToTerminal(s Status) bool {
 	return false
 }
 
+// isContinuousRunning whether the job is continuous and not canceled, it should not be finished
+func isContinuousRunning(targetSelection string, s Status) bool {
+	return targetSelection == TargetSelectionContinuous && !isJobToTerminal(s)
+}
+
 func isJobTerminal(s Status) bool {
 	if s == StatusCanceled || s == StatusCompleted {
 		return true
@@ -3,6 +3,8 @@ package job_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -16,6 +18,7 @@ import (
 	dbMock "ruff.io/tio/db/mock"
 	"ruff.io/tio/job"
 	"ruff.io/tio/job/test"
+	"ruff.io/tio/pkg/eventbus"
 	"ruff.io/tio/shadow"
 )
 
@@ -33,6 +36,19 @@ func prepare(t *testing.T, mockJc bool) (
 	sdSetter *sdMock.StateDesiredSetter,
 	conn *mqMock.AdapterImpl,
 	onConnCh chan connector.PresenceEvent,
+) {
+	return prepareWithThingQuerier(t, mockJc, test.NewMockThingQuerier())
+}
+
+func prepareWithThingQuerier(t *testing.T, mockJc bool, tq job.ThingQuerier) (
+	ctx context.Context,
+	repo job.Repo,
+	svc job.MgrService,
+	jc job.Center,
+	mkMethod *test.MethodHandler,
+	sdSetter *sdMock.StateDesiredSetter,
+	conn *mqMock.AdapterImpl,
+	onConnCh chan connector.PresenceEvent,
 ) {
 	ctx = context.Background()
 	db := dbMock.NewSqliteConnTest()
@@ -57,10 +73,11 @@ func prepare(t *testing.T, mockJc bool) (
 		jc = job.NewCenter(
 			job.CenterOptions{
 				CheckJobStatusInterval: time.Millisecond * 2,
-				ScheduleInterval:       time.Millisecond * 2},
-			repo, nil, conn, mkMethod, sdSetter)
+				ScheduleInterval:       time.Millisecond * 2,
+				ContinuousJobInterval:  time.Millisecond * 20},
+			repo, nil, conn, mkMethod, sdSetter, tq)
 	}
-	svc, _ = test.NewTestSvcWithDB(db, jc)
+	svc, _ = test.NewTestSvcWithDBAndThingQuerier(db, jc, tq)
 	err := jc.Start(ctx)
 	require.NoError(t, err)
 
@@ -354,6 +371,12 @@ func Test_jobCenter_DirectMethodInvoke_cancel(t *testing.T) {
 			// check job is removed from pending queue
 			require.Equal(t, 0, len(jl))
 			require.Equal(t, 0, len(tl))
+			if !st.cancelWithError {
+				// canceled job is checked for finish after its tasks are canceled, it should be kept as is
+				j, err := svc.GetJob(ctx, st.jobId)
+				require.NoError(t, err)
+				require.Equal(t, job.StatusCanceled, j.Status)
+			}
 
 			calls += st.completeTask
 			mCall.Parent.AssertNumberOfCalls(t, "InvokeMethod", calls)
@@ -506,7 +529,7 @@ func Test_jobCenter_SchedulePreloadFormDb(t *testing.T) {
 	jc := job.NewCenter(job.CenterOptions{
 		CheckJobStatusInterval: time.Millisecond * 10,
 		ScheduleInterval:       time.Millisecond * 2},
-		repo, nil, conn, mkMethod, nil)
+		repo, nil, conn, mkMethod, nil, nil)
 	conn.On("IsConnected", mock.Anything).Return(true, nil)
 	tests := []struct {
 		name              string
@@ -651,3 +674,308 @@ func Test_jobCenter_SchedulePreloadFormDb(t *testing.T) {
 	}
 
 }
+
+func Test_jobCenter_MaintenanceWindow(t *testing.T) {
+	ctx, _, svc, jc, mkMethod, _, conn, _ := prepare(t, false)
+	conn.On("IsConnected", mock.Anything).Return(true, nil)
+	mCall := mkMethod.On("InvokeMethod", ctx, mock.Anything).Return(shadow.MethodResp{}, nil)
+	mkMethod.SetReturnFunc(func() (shadow.MethodResp, error) {
+		return shadow.MethodResp{Code: 200, Message: "OK"}, nil
+	})
+	defer mCall.Unset()
+
+	closed := time.Now().UTC().Add(2 * time.Hour)
+	tests := []struct {
+		name        string
+		jobId       string
+		window      job.MaintenanceWindow
+		pendingTask int
+		completed   int
+	}{
+		{
+			name:        "out of window",
+			jobId:       "mw-out-of-window",
+			window:      job.MaintenanceWindow{StartTime: fmt.Sprintf("%d %d * * *", closed.Minute(), closed.Hour()), DurationInMinutes: 60},
+			pendingTask: 4,
+			completed:   0,
+		},
+		{
+			name:        "in window",
+			jobId:       "mw-in-window",
+			window:      job.MaintenanceWindow{StartTime: "* * * * *", DurationInMinutes: 1, TimeZone: "Asia/Shanghai"},
+			pendingTask: 0,
+			completed:   4,
+		},
+	}
+	for _, tt := range tests {
+		st := tt
+		t.Run(st.name, func(t *testing.T) {
+			_, err := svc.CreateJob(ctx, job.CreateReq{
+				JobId:     st.jobId,
+				Operation: directMethodX,
+				JobDoc:    map[string]any{"method": "testMethod"},
+				TargetConfig: job.TargetConfig{
+					Type:   job.TargetTypeThingId,
+					Things: []string{"th1", "th2", "th3", "th4"},
+				},
+				SchedulingConfig: &job.SchedulingConfig{
+					StartTime:          time.Now(),
+					EndBehavior:        job.ScheduleEndBehaviorCancel,
+					MaintenanceWindows: []job.MaintenanceWindow{st.window},
+				},
+			})
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond * 100)
+
+			require.Len(t, jc.GetPendingTasks(st.jobId), st.pendingTask)
+			j, err := svc.GetJob(ctx, st.jobId)
+			require.NoError(t, err)
+			require.Equal(t, st.completed, j.ProcessDetails.Succeeded)
+		})
+	}
+}
+
+func Test_jobCenter_Abort(t *testing.T) {
+	ctx, _, svc, _, mkMethod, _, conn, _ := prepare(t, false)
+	conn.On("IsConnected", mock.Anything).Return(true, nil)
+	mCall := mkMethod.On("InvokeMethod", ctx, mock.Anything).Return(shadow.MethodResp{}, nil)
+	mkMethod.SetReturnFunc(func() (shadow.MethodResp, error) {
+		return shadow.MethodResp{Code: 500, Message: "bad firmware"}, nil
+	})
+	defer mCall.Unset()
+
+	things := []string{"th1", "th2", "th3", "th4", "th5", "th6", "th7", "th8", "th9", "th10"}
+	_, err := svc.CreateJob(ctx, job.CreateReq{
+		JobId:         "abort-failed",
+		Operation:     directMethodX,
+		JobDoc:        map[string]any{"method": "upgrade"},
+		TargetConfig:  job.TargetConfig{Type: job.TargetTypeThingId, Things: things},
+		RolloutConfig: &job.RolloutConfig{MaxPerMinute: 5},
+		AbortConfig: &job.AbortConfig{CriteriaList: []job.AbortCriteria{{
+			FailureType:               job.TaskFailed.String(),
+			Action:                    job.AbortActionCancel,
+			ThresholdPercentage:       50,
+			MinNumberOfExecutedThings: 4,
+		}}},
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		j, err := svc.GetJob(ctx, "abort-failed")
+		return err == nil && j.Status == job.StatusCanceled
+	}, time.Second, 10*time.Millisecond, "job should be aborted")
+
+	j, err := svc.GetJob(ctx, "abort-failed")
+	require.NoError(t, err)
+	require.Equal(t, job.ReasonCodeAborted, j.ReasonCode)
+	require.Contains(t, j.Comment, "FAILED")
+	require.GreaterOrEqual(t, j.ProcessDetails.Failed, 4, "aborted once min executed things is reached")
+	require.GreaterOrEqual(t, j.ProcessDetails.Canceled, 5, "tasks not rolled out should be canceled")
+	require.Eventually(t, func() bool {
+		j, err := svc.GetJob(ctx, "abort-failed")
+		return err == nil && j.ProcessDetails.Failed+j.ProcessDetails.Canceled == len(things)
+	}, time.Second, 10*time.Millisecond, "all tasks should be done")
+	require.LessOrEqual(t, len(mCall.Parent.Calls), 5, "no more tasks rolled out after aborted")
+}
+
+func Test_jobCenter_ExponentialRollout(t *testing.T) {
+	ctx, _, svc, _, mkMethod, _, conn, _ := prepare(t, false)
+	conn.On("IsConnected", mock.Anything).Return(true, nil)
+	mCall := mkMethod.On("InvokeMethod", ctx, mock.Anything).Return(shadow.MethodResp{Code: 200}, nil)
+	defer mCall.Unset()
+
+	things := []string{"th1", "th2", "th3", "th4", "th5", "th6", "th7", "th8", "th9", "th10"}
+	_, err := svc.CreateJob(ctx, job.CreateReq{
+		JobId:        "exponential-rollout",
+		Operation:    directMethodX,
+		JobDoc:       map[string]any{"method": "upgrade"},
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: things},
+		RolloutConfig: &job.RolloutConfig{MaxPerMinute: 8, ExponentialRate: &job.ExponentialRate{
+			BaseRatePerMinute:    2,
+			IncrementFactor:      2,
+			RateIncreaseCriteria: job.RateIncreaseCriteria{NumberOfSucceededThings: 2},
+		}},
+	})
+	require.NoError(t, err)
+
+	succeeded := func() int {
+		j, err := svc.GetJob(ctx, "exponential-rollout")
+		require.NoError(t, err)
+		return j.ProcessDetails.Succeeded
+	}
+	require.Eventually(t, func() bool { return succeeded() == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, succeeded(), "base rate until the rate is refreshed")
+
+	// rate grows to 4 and then 8 in the same minute
+	require.Eventually(t, func() bool { return succeeded() == 8 }, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(1200 * time.Millisecond)
+	j, err := svc.GetJob(ctx, "exponential-rollout")
+	require.NoError(t, err)
+	require.Equal(t, 8, j.ProcessDetails.Succeeded, "up to max per minute")
+	require.Equal(t, 2, j.ProcessDetails.Queued)
+	require.Equal(t, 8, j.RolloutRatePerMinute)
+}
+
+// thingsQuerier matches things set by test for any filter, paged by thing id
+type thingsQuerier struct {
+	mu     sync.Mutex
+	things []string
+}
+
+func (q *thingsQuerier) set(things ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.things = things
+}
+
+func (q *thingsQuerier) Find(_ context.Context, f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := []shadow.ShadowWithStatus{}
+	for _, t := range q.things {
+		if t <= f.AfterId || (f.Limit > 0 && len(res) == f.Limit) {
+			continue
+		}
+		res = append(res, shadow.ShadowWithStatus{Shadow: shadow.Shadow{ThingId: t}})
+	}
+	return res, nil
+}
+
+func Test_jobCenter_ContinuousJob(t *testing.T) {
+	tq := &thingsQuerier{}
+	tq.set("th1")
+	ctx, repo, svc, _, mkMethod, _, conn, _ := prepareWithThingQuerier(t, false, tq)
+	conn.On("IsConnected", mock.Anything).Return(true, nil)
+	mCall := mkMethod.On("InvokeMethod", ctx, mock.Anything).Return(shadow.MethodResp{Code: 200}, nil)
+	defer mCall.Unset()
+
+	_, err := svc.CreateJob(ctx, job.CreateReq{
+		JobId:           "continuous",
+		Operation:       directMethodX,
+		JobDoc:          map[string]any{"method": "config"},
+		TargetConfig:    job.TargetConfig{Type: job.TargetTypeGroup, Groups: []string{"g1"}},
+		TargetSelection: job.TargetSelectionContinuous,
+	})
+	require.NoError(t, err)
+
+	getJob := func() *job.Detail {
+		j, err := svc.GetJob(ctx, "continuous")
+		require.NoError(t, err)
+		return j
+	}
+	require.Eventually(t, func() bool { return getJob().ProcessDetails.Succeeded == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, job.StatusInProgress, getJob().Status, "continuous job keeps in progress")
+	refs, err := job.GroupRefsOf(repo)(ctx, "g1")
+	require.NoError(t, err)
+	require.Equal(t, []string{`job "continuous"`}, refs, "the group is referenced by the continuous job")
+
+	// things join the group
+	tq.set("th1", "th2", "th3")
+	require.Eventually(t, func() bool { return getJob().ProcessDetails.Succeeded == 3 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	j := getJob()
+	require.Equal(t, job.StatusInProgress, j.Status)
+	require.Equal(t, job.ProcessDetails{Succeeded: 3}, j.ProcessDetails, "a thing has only one task")
+
+	require.NoError(t, svc.CancelJob(ctx, "continuous", job.CancelReq{}, false))
+	require.Eventually(t, func() bool { return getJob().Status == job.StatusCanceled }, time.Second, 10*time.Millisecond)
+	tq.set("th1", "th2", "th3", "th4")
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, job.ProcessDetails{Succeeded: 3}, getJob().ProcessDetails, "no task after canceled")
+	refs, err = job.GroupRefsOf(repo)(ctx, "g1")
+	require.NoError(t, err)
+	require.Empty(t, refs, "canceled job doesn't reference the group")
+}
+
+func Test_jobCenter_ContinuousJob_emptyTarget(t *testing.T) {
+	tq := &thingsQuerier{}
+	ctx, _, svc, _, mkMethod, _, conn, _ := prepareWithThingQuerier(t, false, tq)
+	conn.On("IsConnected", mock.Anything).Return(true, nil)
+	mCall := mkMethod.On("InvokeMethod", ctx, mock.Anything).Return(shadow.MethodResp{Code: 200}, nil)
+	defer mCall.Unset()
+
+	_, err := svc.CreateJob(ctx, job.CreateReq{
+		JobId:           "continuous-empty",
+		Operation:       directMethodX,
+		JobDoc:          map[string]any{"method": "config"},
+		TargetConfig:    job.TargetConfig{Type: job.TargetTypeGroup, Groups: []string{"g1"}},
+		TargetSelection: job.TargetSelectionContinuous,
+	})
+	require.NoError(t, err)
+
+	getJob := func() *job.Detail {
+		j, err := svc.GetJob(ctx, "continuous-empty")
+		require.NoError(t, err)
+		return j
+	}
+	require.Eventually(t, func() bool { return getJob().Status == job.StatusInProgress }, time.Second, 10*time.Millisecond,
+		"job without things matched should be in progress")
+
+	// things join the group after the job is created
+	tq.set("th1", "th2")
+	require.Eventually(t, func() bool { return getJob().ProcessDetails.Succeeded == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, job.StatusInProgress, getJob().Status)
+
+	require.NoError(t, svc.CancelJob(ctx, "continuous-empty", job.CancelReq{}, false))
+	require.Eventually(t, func() bool { return getJob().Status == job.StatusCanceled }, time.Second, 10*time.Millisecond)
+}
+
+func Test_jobCenter_Events(t *testing.T) {
+	ctx, _, svc, _, mkMethod, _, conn, _ := prepare(t, false)
+	conn.On("IsConnected", mock.Anything).Return(true, nil)
+	mCall := mkMethod.On("InvokeMethod", ctx, mock.Anything).Return(shadow.MethodResp{}, nil)
+	mkMethod.SetReturnFunc(func() (shadow.MethodResp, error) {
+		return shadow.MethodResp{Code: 200, Message: "OK"}, nil
+	})
+	defer mCall.Unset()
+
+	const jobId = "events"
+	evtCh := make(chan job.EventMsg, 100)
+	busCh := eventbus.Subscribe(job.EventBusName)
+	t.Cleanup(func() { eventbus.Unsubscribe(job.EventBusName, busCh) })
+	go func() {
+		for e := range busCh {
+			if m := e.(job.EventMsg); strings.Contains(m.Topic, "/jobs/"+jobId+"/") {
+				evtCh <- m
+			}
+		}
+	}()
+
+	_, err := svc.CreateJob(ctx, job.CreateReq{
+		JobId:        jobId,
+		Operation:    directMethodX,
+		JobDoc:       map[string]any{"method": "reboot"},
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"th1", "th2"}},
+	})
+	require.NoError(t, err)
+
+	var jobEvents []string
+	taskEvents := map[string]job.TaskStatus{}
+	timeout := time.After(2 * time.Second)
+	done := func() bool {
+		return len(jobEvents) > 0 && jobEvents[len(jobEvents)-1] == job.EventCompleted &&
+			taskEvents["th1"] == job.TaskSucceeded && taskEvents["th2"] == job.TaskSucceeded
+	}
+	// the last task event may come after the job completed, which is checked from tasks saved
+	for !done() {
+		select {
+		case m := <-evtCh:
+			if m.EventType == job.EventTaskStatusChanged {
+				var e job.TaskEvent
+				require.NoError(t, json.Unmarshal(m.Payload, &e))
+				require.Equal(t, job.TopicEventTask(jobId, e.ThingId), m.Topic)
+				taskEvents[e.ThingId] = e.Status
+			} else {
+				var e job.JobEvent
+				require.NoError(t, json.Unmarshal(m.Payload, &e))
+				require.Equal(t, job.TopicEventJob(jobId, e.EventType), m.Topic)
+				jobEvents = append(jobEvents, e.EventType)
+			}
+		case <-timeout:
+			t.Fatalf("job events not completed, got %v and %v", jobEvents, taskEvents)
+		}
+	}
+	require.Equal(t, []string{job.EventCreated, job.EventStarted, job.EventCompleted}, jobEvents)
+}
@@ -0,0 +1,142 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/shadow"
+)
+
+const defaultContinuousJobInterval = 30 * time.Second
+
+// continuousJobLoop creates tasks of continuous jobs for things newly matching their targets periodically.
+// A thing has only one task of a job, it's not targeted again after it leaves the target and comes back.
+func (c *centerImpl) continuousJobLoop() {
+	if c.thingQuerier == nil {
+		log.Warnf("JobCenter no thing querier, continuous jobs won't target things newly matched")
+		return
+	}
+	interval := c.opt.ContinuousJobInterval
+	if interval <= 0 {
+		interval = defaultContinuousJobInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-tick.C:
+			l, err := c.repo.GetContinuousJobs(c.ctx)
+			if err != nil {
+				log.Errorf("JobCenter get continuous jobs, error: %v", err)
+				continue
+			}
+			for _, e := range l {
+				c.targetNewThings(e)
+			}
+		}
+	}
+}
+
+// targetNewThings creates tasks for things matching the target of the continuous job but without tasks
+func (c *centerImpl) targetNewThings(e Entity) {
+	d, err := toDetail(e, []TaskStatusCount{})
+	if err != nil {
+		log.Errorf("JobCenter convert entity to Detail, jobId=%q, error: %v", e.JobId, err)
+		return
+	}
+	jc := toJobContext(d)
+	if isJobScheduleAfterEndTime(&jc) {
+		return
+	}
+
+	// paged by thing id, only things of each page are checked for existing tasks
+	count := 0
+	err = targetPages(c.ctx, c.thingQuerier, d.TargetConfig, func(things []string) error {
+		n, err := c.createTasksForNewThings(e, jc, things)
+		count += n
+		return err
+	})
+	if err != nil {
+		log.Errorf("JobCenter target things newly matched, jobId=%q, error: %v", e.JobId, err)
+	}
+	if count == 0 {
+		return
+	}
+	log.Infof("JobCenter created tasks for things newly matched, jobId=%q, count=%d", e.JobId, count)
+}
+
+// createTasksForNewThings creates tasks for the things without tasks of the job, returns count of tasks created
+func (c *centerImpl) createTasksForNewThings(e Entity, jc JobContext, things []string) (int, error) {
+	existing, err := c.repo.GetThingsOfJob(c.ctx, e.JobId, things)
+	if err != nil {
+		return 0, errors.WithMessage(err, "get things of job")
+	}
+	targeted := make(map[string]bool, len(existing))
+	for _, t := range existing {
+		targeted[t] = true
+	}
+	var newThings []string
+	for _, t := range things {
+		if !targeted[t] {
+			newThings = append(newThings, t)
+		}
+	}
+	if len(newThings) == 0 {
+		return 0, nil
+	}
+
+	var created []TaskEntity
+	err = c.repo.ExecWithTx(func(txRepo Repo) error {
+		// the job may be canceled meanwhile
+		j, err := txRepo.GetJob(c.ctx, e.JobId)
+		if err != nil || j == nil || j.Status != StatusInProgress {
+			return err
+		}
+		created, err = txRepo.CreateTasks(c.ctx, toTaskEntities(e.JobId, e.Operation, TargetConfig{Things: newThings}))
+		return err
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, "create tasks")
+	}
+	if len(created) > 0 {
+		c.addPendingJob(PendingJobItem{Context: jc, Tasks: toTasks(created)})
+	}
+	return len(created), nil
+}
+
+// GroupRefsOf gets continuous jobs in progress targeting the thing group, by target groups or by `thingGroup` in query.
+// It's given to the thing group service, so that groups can't be deleted while jobs still target things of them.
+func GroupRefsOf(r Repo) func(ctx context.Context, group string) ([]string, error) {
+	return func(ctx context.Context, group string) ([]string, error) {
+		l, err := r.GetContinuousJobs(ctx)
+		if err != nil {
+			return nil, errors.WithMessage(err, "get continuous jobs")
+		}
+		var res []string
+		for _, e := range l {
+			var tc TargetConfig
+			if err := json.Unmarshal(e.TargetConfig, &tc); err != nil {
+				log.Warnf("Unmarshal target config of job %q error: %v", e.JobId, err)
+				continue
+			}
+			groups := tc.Groups
+			if tc.Type == TargetTypeQuery {
+				if groups, err = shadow.GroupsInCondition(tc.Query); err != nil {
+					log.Warnf("Parse target query of job %q error: %v", e.JobId, err)
+					continue
+				}
+			}
+			if slices.Contains(groups, group) {
+				res = append(res, fmt.Sprintf("job %q", e.JobId))
+			}
+		}
+		return res, nil
+	}
+}
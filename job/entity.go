@@ -17,6 +17,7 @@ import (
 type Entity struct {
 	JobId            string         `gorm:"primaryKey;size:64"`
 	TargetConfig     datatypes.JSON `gorm:"NOT NULL;"`
+	TargetSelection  string         `gorm:"size:16; NOT NULL; default:SNAPSHOT"`
 	JobDoc           datatypes.JSON
 	Description      string `gorm:"size:256"`
 	Operation        string `gorm:"NOT NULL;"`
@@ -24,6 +25,10 @@ type Entity struct {
 	RolloutConfig    datatypes.JSON
 	RetryConfig      datatypes.JSON
 	TimeoutConfig    datatypes.JSON
+	AbortConfig      datatypes.JSON
+
+	JobTemplateId      string `gorm:"size:64; NOT NULL; default: ''"`
+	JobTemplateVersion int    `gorm:"NOT NULL; default: 0"`
 
 	Status         Status `gorm:"NOT NULL;default:WAITING;"`
 	ForceCanceled  bool   `gorm:"NOT NULL; DEFAULT: 0"`
@@ -98,6 +103,7 @@ func toEntity(r CreateReq) (Entity, error) {
 	var roConfig []byte = nil
 	var retryConf []byte = nil
 	var timeoutConf []byte = nil
+	var abortConf []byte = nil
 	if r.SchedulingConfig != nil {
 		schConfig, err = json.Marshal(*r.SchedulingConfig)
 		if err != nil {
@@ -120,6 +126,11 @@ func toEntity(r CreateReq) (Entity, error) {
 			return Entity{}, errors.WithMessage(model.ErrInvalidParams, "field timeoutConfig: "+err.Error())
 		}
 	}
+	if r.AbortConfig != nil {
+		if abortConf, err = json.Marshal(*r.AbortConfig); err != nil {
+			return Entity{}, errors.WithMessage(model.ErrInvalidParams, "field abortConfig: "+err.Error())
+		}
+	}
 
 	var jd []byte
 	if r.JobDoc != nil {
@@ -129,16 +140,23 @@ func toEntity(r CreateReq) (Entity, error) {
 	}
 
 	e := Entity{
-		JobId:        r.JobId,
-		TargetConfig: targetConf,
-		Operation:    r.Operation,
-		Description:  r.Description,
-		JobDoc:       jd,
+		JobId:           r.JobId,
+		TargetConfig:    targetConf,
+		TargetSelection: TargetSelectionSnapshot,
+		Operation:       r.Operation,
+		Description:     r.Description,
+		JobDoc:          jd,
 
 		SchedulingConfig: schConfig,
 		RolloutConfig:    roConfig,
 		RetryConfig:      retryConf,
 		TimeoutConfig:    timeoutConf,
+		AbortConfig:      abortConf,
+
+		JobTemplateId: r.JobTemplateId,
+	}
+	if r.TargetSelection != "" {
+		e.TargetSelection = r.TargetSelection
 	}
 
 	return e, nil
@@ -165,30 +183,9 @@ func toDetail(e Entity, tsc []TaskStatusCount) (Detail, error) {
 	var roConf RolloutConfig
 	var retryConf RetryConfig
 	var timeoutConf TimeoutConfig
+	var abortConf AbortConfig
 
-	pd := ProcessDetails{}
-	for _, sc := range tsc {
-		switch sc.Status {
-		case TaskQueued:
-			pd.Queued = sc.Count
-		case TaskSent:
-			pd.Sent = sc.Count
-		case TaskInProgress:
-			pd.InProgress = sc.Count
-		case TaskFailed:
-			pd.Failed = sc.Count
-		case TaskSucceeded:
-			pd.Succeeded = sc.Count
-		case TaskCanceled:
-			pd.Canceled = sc.Count
-		case TaskTimeOut:
-			pd.TimedOut = sc.Count
-		case TaskRejected:
-			pd.Rejected = sc.Count
-		default:
-			log.Errorf("unexpected task status %q", sc.Status)
-		}
-	}
+	pd := toProcessDetails(tsc)
 
 	var jd map[string]any
 	if len(e.JobDoc) != 0 {
@@ -198,18 +195,21 @@ func toDetail(e Entity, tsc []TaskStatusCount) (Detail, error) {
 	}
 
 	d := Detail{
-		JobId:          e.JobId,
-		Operation:      e.Operation,
-		Description:    e.Description,
-		JobDoc:         jd,
-		Status:         e.Status,
-		ForceCanceled:  e.ForceCanceled,
-		ProcessDetails: pd,
-		Comment:        e.Comment,
-		ReasonCode:     e.ReasonCode,
-		UpdatedAt:      e.UpdatedAt.UnixMilli(),
-		CreatedAt:      e.CreatedAt.UnixMilli(),
-		Version:        e.Version,
+		JobId:              e.JobId,
+		TargetSelection:    e.TargetSelection,
+		Operation:          e.Operation,
+		Description:        e.Description,
+		JobDoc:             jd,
+		Status:             e.Status,
+		ForceCanceled:      e.ForceCanceled,
+		ProcessDetails:     pd,
+		Comment:            e.Comment,
+		ReasonCode:         e.ReasonCode,
+		JobTemplateId:      e.JobTemplateId,
+		JobTemplateVersion: e.JobTemplateVersion,
+		UpdatedAt:          e.UpdatedAt.UnixMilli(),
+		CreatedAt:          e.CreatedAt.UnixMilli(),
+		Version:            e.Version,
 	}
 
 	d.StartedAt = timeToMs(e.StartedAt)
@@ -232,6 +232,9 @@ func toDetail(e Entity, tsc []TaskStatusCount) (Detail, error) {
 			return Detail{}, errors.WithMessage(model.ErrInternal, "field rolloutConfig in db")
 		}
 		d.RolloutConfig = &roConf
+		if roConf.ExponentialRate != nil && e.Status == StatusInProgress {
+			d.RolloutRatePerMinute = roConf.ratePerMinute(pd)
+		}
 	}
 	if e.RetryConfig != nil {
 		if err := json.Unmarshal(e.RetryConfig, &retryConf); err != nil {
@@ -245,10 +248,52 @@ func toDetail(e Entity, tsc []TaskStatusCount) (Detail, error) {
 		}
 		d.TimeoutConfig = &timeoutConf
 	}
+	if e.AbortConfig != nil {
+		if err := json.Unmarshal(e.AbortConfig, &abortConf); err != nil {
+			return Detail{}, errors.WithMessage(model.ErrInternal, "field abortConfig in db")
+		}
+		d.AbortConfig = &abortConf
+	}
 
 	return d, nil
 }
 
+func toJobContext(d Detail) JobContext {
+	return JobContext{
+		JobId: d.JobId, Operation: d.Operation, JobDoc: d.JobDoc, TargetSelection: d.TargetSelection,
+		SchedulingConfig: d.SchedulingConfig, RolloutConfig: d.RolloutConfig,
+		RetryConfig: d.RetryConfig, TimeoutConfig: d.TimeoutConfig, AbortConfig: d.AbortConfig,
+		Status: d.Status, StartedAt: d.StartedAt,
+	}
+}
+
+func toProcessDetails(tsc []TaskStatusCount) ProcessDetails {
+	pd := ProcessDetails{}
+	for _, sc := range tsc {
+		switch sc.Status {
+		case TaskQueued:
+			pd.Queued = sc.Count
+		case TaskSent:
+			pd.Sent = sc.Count
+		case TaskInProgress:
+			pd.InProgress = sc.Count
+		case TaskFailed:
+			pd.Failed = sc.Count
+		case TaskSucceeded:
+			pd.Succeeded = sc.Count
+		case TaskCanceled:
+			pd.Canceled = sc.Count
+		case TaskTimeOut:
+			pd.TimedOut = sc.Count
+		case TaskRejected:
+			pd.Rejected = sc.Count
+		default:
+			log.Errorf("unexpected task status %q", sc.Status)
+		}
+	}
+	return pd
+}
+
 func toSummary(e Entity) Summary {
 	s := Summary{
 		JobId:     e.JobId,
@@ -282,6 +327,7 @@ func toTask(e TaskEntity) Task {
 		Status:        e.Status,
 		Progress:      e.Progress,
 		UpdatedAt:     e.UpdatedAt.UnixMilli(),
+		CreatedAt:     e.CreatedAt.UnixMilli(),
 		Version:       e.Version,
 	}
 
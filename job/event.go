@@ -0,0 +1,131 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/eventbus"
+	"ruff.io/tio/pkg/log"
+)
+
+// Job lifecycle events are published to topics TopicEventJobTmpl and TopicEventTaskTmpl,
+// and to the event bus in process for data integration rules.
+
+// event types
+const (
+	EventCreated           = "created"
+	EventStarted           = "started"
+	EventCompleted         = "completed"
+	EventCanceled          = "canceled"
+	EventTaskStatusChanged = "taskStatusChanged"
+)
+
+// EventBusName name of job events on the default event bus, the messages are EventMsg
+const EventBusName = "job.events"
+
+type JobEvent struct {
+	EventType     string `json:"eventType"`
+	JobId         string `json:"jobId"`
+	Operation     string `json:"operation,omitempty"`
+	Status        Status `json:"status"`
+	ForceCanceled bool   `json:"forceCanceled,omitempty"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+type TaskEvent struct {
+	EventType     string        `json:"eventType"`
+	JobId         string        `json:"jobId"`
+	TaskId        int64         `json:"taskId"`
+	ThingId       string        `json:"thingId"`
+	Operation     string        `json:"operation"`
+	Status        TaskStatus    `json:"status"`
+	StatusDetails StatusDetails `json:"statusDetails,omitempty"`
+	Progress      uint8         `json:"progress"`
+	Timestamp     int64         `json:"timestamp"`
+}
+
+// EventMsg event on the event bus, payload is the JSON of JobEvent or TaskEvent
+type EventMsg struct {
+	EventType string
+	ThingId   string // thing of the task event, empty for job events
+	Topic     string
+	Payload   []byte
+}
+
+// eventQueueSize events are published in order by a worker, they are dropped if the queue is full,
+// so that the job center is not blocked by slow subscribers
+const eventQueueSize = 1024
+
+type eventPublisher struct {
+	pub   connector.Publisher
+	queue chan EventMsg
+}
+
+func newEventPublisher(pub connector.Publisher) eventPublisher {
+	return eventPublisher{pub: pub, queue: make(chan EventMsg, eventQueueSize)}
+}
+
+// start publishes events queued until the context is done
+func (p eventPublisher) start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-p.queue:
+				p.send(e)
+			}
+		}
+	}()
+}
+
+func (p eventPublisher) jobEvent(eventType, jobId, operation string, status Status, force bool) {
+	p.publish(TopicEventJob(jobId, eventType), "", eventType, JobEvent{
+		EventType:     eventType,
+		JobId:         jobId,
+		Operation:     operation,
+		Status:        status,
+		ForceCanceled: force,
+		Timestamp:     time.Now().UnixMilli(),
+	})
+}
+
+func (p eventPublisher) taskStatusChanged(msg TaskChangeMsg) {
+	t := msg.Task
+	p.publish(TopicEventTask(t.JobId, t.ThingId), t.ThingId, EventTaskStatusChanged, TaskEvent{
+		EventType:     EventTaskStatusChanged,
+		JobId:         t.JobId,
+		TaskId:        t.TaskId,
+		ThingId:       t.ThingId,
+		Operation:     t.Operation,
+		Status:        msg.Status,
+		StatusDetails: msg.StatusDetails,
+		Progress:      msg.Progress,
+		Timestamp:     time.Now().UnixMilli(),
+	})
+}
+
+func (p eventPublisher) publish(topic, thingId, eventType string, evt any) {
+	buf, err := json.Marshal(evt)
+	if err != nil {
+		log.Errorf("JobEvent marshal event %#v, error: %v", evt, err)
+		return
+	}
+	select {
+	case p.queue <- EventMsg{EventType: eventType, ThingId: thingId, Topic: topic, Payload: buf}:
+	default:
+		log.Warnf("JobEvent queue is full, event is dropped, topic=%q", topic)
+	}
+}
+
+func (p eventPublisher) send(e EventMsg) {
+	eventbus.Publish(EventBusName, e)
+	if p.pub == nil {
+		return
+	}
+	if err := p.pub.Publish(e.Topic, DefaultQos, false, e.Payload); err != nil {
+		log.Errorf("JobEvent publish topic=%q, error: %v", e.Topic, err)
+	}
+}
@@ -0,0 +1,35 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/eventbus"
+)
+
+func TestEventPublisher_NotBlocking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// the subscriber doesn't receive until all events are published
+	ch := eventbus.Subscribe(EventBusName)
+	t.Cleanup(func() { eventbus.Unsubscribe(EventBusName, ch) })
+
+	p := newEventPublisher(nil)
+	p.start(ctx)
+	begin := time.Now()
+	for _, evt := range []string{EventCreated, EventStarted, EventCompleted} {
+		p.jobEvent(evt, "job-evt", "op", StatusInProgress, false)
+	}
+	require.Less(t, time.Since(begin), 100*time.Millisecond, "publishing is not blocked by subscribers")
+
+	for _, evt := range []string{EventCreated, EventStarted, EventCompleted} {
+		select {
+		case e := <-ch:
+			require.Equal(t, evt, e.(EventMsg).EventType, "events are in order")
+		case <-time.After(time.Second):
+			t.Fatalf("event %q is not published", evt)
+		}
+	}
+}
@@ -42,6 +42,7 @@ type Center interface {
 	// ReceiveMgrMsg Receive management message from MgrService, and return immediately.
 	ReceiveMgrMsg(msg MgrMsg)
 
+	// GetPendingJobs pending jobs in memory, it's empty on instances which are not the leader, see NewLeaderCenter
 	GetPendingJobs() []PendingJobItem
 
 	GetPendingTasks(jobId string) []Task
@@ -127,13 +128,15 @@ type ctxGetter func(jobId string) *JobContext
 type JobContext struct {
 	JobId string
 
-	JobDoc    map[string]any
-	Operation string
+	JobDoc          map[string]any
+	Operation       string
+	TargetSelection string
 
 	SchedulingConfig *SchedulingConfig
 	RolloutConfig    *RolloutConfig
 	RetryConfig      *RetryConfig
 	TimeoutConfig    *TimeoutConfig
+	AbortConfig      *AbortConfig
 
 	Status        Status
 	ForceCanceled bool
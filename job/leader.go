@@ -0,0 +1,334 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/datatypes"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+)
+
+// Leader election for multiple instances sharing the database.
+// The job center keeps pending jobs, job contexts and task queues in memory,
+// so only the instance holding the lease runs it. Management messages received by
+// other instances are forwarded to the leader through the database.
+// The MQTT broker should be shared by the instances too, eg: EMQX, for things to reach the leader.
+// Pending jobs and tasks are in memory of the leader, other instances get none of them.
+
+const leaseNameJobCenter = "job-center"
+
+const (
+	defaultLeaseTTL       = 10 * time.Second
+	defaultRenewInterval  = 2 * time.Second
+	defaultMgrMsgInterval = time.Second
+	mgrMsgBatchSize       = 100
+)
+
+type LeaderOptions struct {
+	// InstanceId identity of the instance, it should be unique among instances
+	InstanceId string
+	// LeaseTTL the lease expires if the leader doesn't renew it in the duration, then other instance takes over
+	LeaseTTL time.Duration
+	// RenewInterval interval to renew or acquire the lease
+	RenewInterval time.Duration
+	// MgrMsgInterval interval for the leader to fetch management messages forwarded by other instances
+	MgrMsgInterval time.Duration
+}
+
+type LeaderRepo interface {
+	// AcquireLease acquires or renews the lease, returns false if it's held by another holder
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+
+	CreateMgrMsg(ctx context.Context, e MgrMsgEntity) error
+	// TakeMgrMsgs gets and deletes management messages in order of creation,
+	// the messages are delivered at most once, they are lost if the taker fails before handling them
+	TakeMgrMsgs(ctx context.Context, limit int) ([]MgrMsgEntity, error)
+}
+
+type LeaseEntity struct {
+	Name      string    `gorm:"primaryKey;size:64"`
+	Holder    string    `gorm:"size:128; NOT NULL"`
+	ExpiresAt time.Time `gorm:"NOT NULL"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime; NOT NULL;"`
+}
+
+func (LeaseEntity) TableName() string {
+	return "job_lease"
+}
+
+// MgrMsgEntity management message forwarded to the leader
+type MgrMsgEntity struct {
+	Id        int64      `gorm:"primaryKey;autoIncrement"`
+	Typ       MgrMsgType `gorm:"size:32; NOT NULL"`
+	Data      datatypes.JSON
+	CreatedAt time.Time `gorm:"autoCreateTime; NOT NULL;"`
+}
+
+func (MgrMsgEntity) TableName() string {
+	return "job_mgr_msg"
+}
+
+// NewLeaderCenter job center runs on the leader only, newCenter is called for every term of leadership,
+// for the state in memory of last term may be stale.
+func NewLeaderCenter(opt LeaderOptions, r LeaderRepo, newCenter func() Center) Center {
+	if opt.LeaseTTL == 0 {
+		opt.LeaseTTL = defaultLeaseTTL
+	}
+	if opt.RenewInterval == 0 {
+		opt.RenewInterval = defaultRenewInterval
+	}
+	if opt.MgrMsgInterval == 0 {
+		opt.MgrMsgInterval = defaultMgrMsgInterval
+	}
+	return &leaderCenter{opt: opt, repo: r, newCenter: newCenter}
+}
+
+type leaderCenter struct {
+	ctx       context.Context
+	opt       LeaderOptions
+	repo      LeaderRepo
+	newCenter func() Center
+
+	mu        sync.RWMutex
+	center    Center // center of current term, nil if the instance is not the leader
+	cancel    context.CancelFunc
+	renewedAt time.Time
+	// dispatchMu keeps messages in order, forwarded ones are dispatched before those received by the leader
+	dispatchMu sync.Mutex
+}
+
+func (l *leaderCenter) Start(ctx context.Context) error {
+	l.ctx = ctx
+	// elect at once, so that a single instance starts the center without delay
+	l.elect()
+	go l.loop()
+	return nil
+}
+
+func (l *leaderCenter) ReceiveMgrMsg(msg MgrMsg) {
+	if c := l.leading(); c != nil {
+		// messages forwarded before are dispatched first, eg: the job is created on another instance then canceled here
+		l.dispatchMu.Lock()
+		defer l.dispatchMu.Unlock()
+		l.dispatchMgrMsgsLocked(c)
+		c.ReceiveMgrMsg(msg)
+		return
+	}
+	buf, err := json.Marshal(msg.Data)
+	if err != nil {
+		log.Errorf("JobLeader marshal manager message, msg=%#v, error: %v", msg, err)
+		return
+	}
+	if err := l.repo.CreateMgrMsg(l.ctx, MgrMsgEntity{Typ: msg.Typ, Data: buf}); err != nil {
+		log.Errorf("JobLeader forward manager message, msg=%#v, error: %v", msg, err)
+	}
+}
+
+// GetPendingJobs pending jobs are only known by the leader, it's empty on other instances
+func (l *leaderCenter) GetPendingJobs() []PendingJobItem {
+	if c := l.leading(); c != nil {
+		return c.GetPendingJobs()
+	}
+	return []PendingJobItem{}
+}
+
+// GetPendingTasks pending tasks are only known by the leader, it's empty on other instances
+func (l *leaderCenter) GetPendingTasks(jobId string) []Task {
+	if c := l.leading(); c != nil {
+		return c.GetPendingTasks(jobId)
+	}
+	return []Task{}
+}
+
+func (l *leaderCenter) loop() {
+	renewTick := time.NewTicker(l.opt.RenewInterval)
+	msgTick := time.NewTicker(l.opt.MgrMsgInterval)
+	defer renewTick.Stop()
+	defer msgTick.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			l.resign()
+			return
+		case <-renewTick.C:
+			l.elect()
+		case <-msgTick.C:
+			if c := l.leading(); c != nil {
+				l.dispatchMgrMsgs(c)
+			}
+		}
+	}
+}
+
+func (l *leaderCenter) elect() {
+	ok, err := l.repo.AcquireLease(l.ctx, leaseNameJobCenter, l.opt.InstanceId, l.opt.LeaseTTL)
+	if err != nil {
+		log.Errorf("JobLeader acquire lease, instanceId=%q, error: %v", l.opt.InstanceId, err)
+		// keep leading until the lease may be taken over by others
+		if l.leading() != nil && time.Since(l.renewedAt) < l.opt.LeaseTTL-l.opt.RenewInterval {
+			return
+		}
+	}
+	if !ok {
+		l.stepDown()
+		return
+	}
+	l.renewedAt = time.Now()
+	if l.leading() == nil {
+		l.takeOver()
+	}
+}
+
+func (l *leaderCenter) takeOver() {
+	ctx, cancel := context.WithCancel(l.ctx)
+	c := l.newCenter()
+	if err := c.Start(ctx); err != nil {
+		log.Errorf("JobLeader start job center, instanceId=%q, error: %v", l.opt.InstanceId, err)
+		cancel()
+		return
+	}
+	l.mu.Lock()
+	l.center, l.cancel = c, cancel
+	l.mu.Unlock()
+	log.Infof("JobLeader became the leader, instanceId=%q", l.opt.InstanceId)
+	l.dispatchMgrMsgs(c)
+}
+
+func (l *leaderCenter) stepDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.center == nil {
+		return
+	}
+	l.cancel()
+	l.center, l.cancel = nil, nil
+	log.Warnf("JobLeader lost the leadership, instanceId=%q", l.opt.InstanceId)
+}
+
+// resign releases the lease on exit, so that other instance takes over without waiting for it expired
+func (l *leaderCenter) resign() {
+	if l.leading() == nil {
+		return
+	}
+	l.stepDown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.repo.ReleaseLease(ctx, leaseNameJobCenter, l.opt.InstanceId); err != nil {
+		log.Errorf("JobLeader release lease, instanceId=%q, error: %v", l.opt.InstanceId, err)
+	}
+}
+
+func (l *leaderCenter) leading() Center {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.center
+}
+
+// dispatchMgrMsgs messages are deleted before dispatched, a message is lost rather than done twice on failure,
+// for handling of them is not idempotent, e.g. creating a job twice.
+// If the leader crashes or steps down in the middle of a batch, the rest of the batch is lost,
+func (l *leaderCenter) dispatchMgrMsgs(c Center) {
+	l.dispatchMu.Lock()
+	defer l.dispatchMu.Unlock()
+	l.dispatchMgrMsgsLocked(c)
+}
+
+func (l *leaderCenter) dispatchMgrMsgsLocked(c Center) {
+	for {
+		msgs, err := l.repo.TakeMgrMsgs(l.ctx, mgrMsgBatchSize)
+		if err != nil {
+			log.Errorf("JobLeader take manager messages, error: %v", err)
+			return
+		}
+		for _, e := range msgs {
+			msg, err := toMgrMsg(e)
+			if err != nil {
+				log.Errorf("JobLeader convert manager message, id=%d, typ=%q, error: %v", e.Id, e.Typ, err)
+				continue
+			}
+			c.ReceiveMgrMsg(msg)
+		}
+		if len(msgs) < mgrMsgBatchSize {
+			return
+		}
+	}
+}
+
+// NewTermPubSub wraps the pub sub for centers created for every term of leadership.
+// A topic is subscribed only once, its messages are sent to the callback of the latest term until the term's context done,
+// so that subscriptions don't pile up with terms, and a term stepped down receives no more messages.
+func NewTermPubSub(ps connector.PubSub) connector.PubSub {
+	return &termPubSub{PubSub: ps, subs: make(map[string]termSub)}
+}
+
+type termPubSub struct {
+	connector.PubSub
+
+	mu   sync.RWMutex
+	subs map[string]termSub // topic -> subscription of the latest term
+}
+
+type termSub struct {
+	ctx      context.Context
+	callback func(msg connector.Message)
+}
+
+func (p *termPubSub) Subscribe(ctx context.Context, topic string, qos byte, callback func(msg connector.Message)) error {
+	p.mu.Lock()
+	_, subscribed := p.subs[topic]
+	p.subs[topic] = termSub{ctx: ctx, callback: callback}
+	p.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	// the subscription outlives the term
+	err := p.PubSub.Subscribe(context.WithoutCancel(ctx), topic, qos, func(msg connector.Message) {
+		p.mu.RLock()
+		s := p.subs[topic]
+		p.mu.RUnlock()
+		if s.ctx.Err() == nil {
+			s.callback(msg)
+		}
+	})
+	if err != nil {
+		p.mu.Lock()
+		delete(p.subs, topic)
+		p.mu.Unlock()
+	}
+	return err
+}
+
+func toMgrMsg(e MgrMsgEntity) (MgrMsg, error) {
+	var d any
+	var err error
+	switch e.Typ {
+	case MgrTypeCreateJob:
+		d, err = unmarshalMgrMsgData[MgrMsgCreateJob](e.Data)
+	case MgrTypeUpdateJob:
+		d, err = unmarshalMgrMsgData[MgrMsgUpdateJob](e.Data)
+	case MgrTypeCancelJob:
+		d, err = unmarshalMgrMsgData[MgrMsgCancelJob](e.Data)
+	case MgrTypeDeleteJob:
+		d, err = unmarshalMgrMsgData[MgrMsgDeleteJob](e.Data)
+	case MgrTypeCancelTask:
+		d, err = unmarshalMgrMsgData[MgrMsgCancelTask](e.Data)
+	case MgrTypeDeleteTask:
+		d, err = unmarshalMgrMsgData[MgrMsgDeleteTask](e.Data)
+	default:
+		return MgrMsg{}, errors.Errorf("unknown job manage message type: %q", e.Typ)
+	}
+	return MgrMsg{Typ: e.Typ, Data: d}, err
+}
+
+func unmarshalMgrMsgData[T any](data []byte) (T, error) {
+	var d T
+	err := json.Unmarshal(data, &d)
+	return d, errors.Wrap(err, "unmarshal manager message")
+}
+
+var _ Center = &leaderCenter{}
@@ -0,0 +1,77 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type leaderRepo struct {
+	db *gorm.DB
+}
+
+func NewLeaderRepo(db *gorm.DB) LeaderRepo {
+	return leaderRepo{db}
+}
+
+// AcquireLease the lease time is by the clock of the database, for clocks of instances may be skewed
+func (r leaderRepo) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now, expiresAt := r.leaseTime(ttl)
+	res := r.db.WithContext(ctx).Model(&LeaseEntity{}).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(map[string]any{"name": name, "holder": holder, "expires_at": expiresAt, "updated_at": now})
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "create job lease")
+	}
+	if res.RowsAffected > 0 {
+		return true, nil
+	}
+	// renew the lease held, or take over the expired one
+	res = r.db.WithContext(ctx).Model(&LeaseEntity{}).
+		Where("name=? AND (holder=? OR expires_at<?)", name, holder, now).
+		Updates(map[string]any{"holder": holder, "expires_at": expiresAt, "updated_at": now})
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "update job lease")
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// leaseTime expressions of the current time of the database, and the time the lease expires after ttl
+func (r leaderRepo) leaseTime(ttl time.Duration) (now clause.Expr, expiresAt clause.Expr) {
+	if r.db.Dialector.Name() == "mysql" {
+		return gorm.Expr("CURRENT_TIMESTAMP(3)"), gorm.Expr("CURRENT_TIMESTAMP(3) + INTERVAL ? MICROSECOND", ttl.Microseconds())
+	}
+	return gorm.Expr("strftime('%Y-%m-%d %H:%M:%f', 'now')"),
+		gorm.Expr("strftime('%Y-%m-%d %H:%M:%f', 'now', ?)", fmt.Sprintf("%+.3f seconds", ttl.Seconds()))
+}
+
+func (r leaderRepo) ReleaseLease(ctx context.Context, name, holder string) error {
+	err := r.db.WithContext(ctx).Where("name=? AND holder=?", name, holder).Delete(&LeaseEntity{}).Error
+	return errors.Wrap(err, "delete job lease")
+}
+
+func (r leaderRepo) CreateMgrMsg(ctx context.Context, e MgrMsgEntity) error {
+	return errors.Wrap(r.db.WithContext(ctx).Create(&e).Error, "create job manager message")
+}
+
+func (r leaderRepo) TakeMgrMsgs(ctx context.Context, limit int) ([]MgrMsgEntity, error) {
+	var l []MgrMsgEntity
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Order("id").Limit(limit).Find(&l).Error; err != nil {
+			return err
+		}
+		if len(l) == 0 {
+			return nil
+		}
+		// only the read ones, a message of a lower id may be committed after reading
+		ids := make([]int64, len(l))
+		for i, e := range l {
+			ids[i] = e.Id
+		}
+		return tx.Where("id IN ?", ids).Delete(&MgrMsgEntity{}).Error
+	})
+	return l, errors.Wrap(err, "take job manager messages")
+}
@@ -0,0 +1,176 @@
+package job_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/connector"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/job"
+	"ruff.io/tio/job/test"
+)
+
+func TestLeaderRepo_AcquireLease(t *testing.T) {
+	ctx := context.Background()
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(job.LeaseEntity{}, job.MgrMsgEntity{}))
+	r := job.NewLeaderRepo(db)
+
+	ok, err := r.AcquireLease(ctx, "center", "a", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = r.AcquireLease(ctx, "center", "a", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok, "renew")
+	ok, err = r.AcquireLease(ctx, "center", "b", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, ok, "held by a")
+
+	time.Sleep(60 * time.Millisecond)
+	ok, err = r.AcquireLease(ctx, "center", "b", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok, "take over the expired")
+	ok, err = r.AcquireLease(ctx, "center", "a", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, r.ReleaseLease(ctx, "center", "b"))
+	ok, err = r.AcquireLease(ctx, "center", "a", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok, "released")
+}
+
+func TestLeaderRepo_TakeMgrMsgs(t *testing.T) {
+	ctx := context.Background()
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(job.LeaseEntity{}, job.MgrMsgEntity{}))
+	r := job.NewLeaderRepo(db)
+
+	for _, id := range []int64{2, 5, 7} {
+		require.NoError(t, r.CreateMgrMsg(ctx, job.MgrMsgEntity{Id: id, Typ: job.MgrTypeCancelJob}))
+	}
+	l, err := r.TakeMgrMsgs(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, l, 2)
+	require.Equal(t, []int64{2, 5}, []int64{l[0].Id, l[1].Id})
+
+	// committed later with a lower id, it's not deleted without read
+	require.NoError(t, r.CreateMgrMsg(ctx, job.MgrMsgEntity{Id: 3, Typ: job.MgrTypeCancelJob}))
+	l, err = r.TakeMgrMsgs(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, l, 2)
+	require.Equal(t, []int64{3, 7}, []int64{l[0].Id, l[1].Id})
+
+	l, err = r.TakeMgrMsgs(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, l)
+}
+
+func TestLeaderCenter(t *testing.T) {
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(job.LeaseEntity{}, job.MgrMsgEntity{}))
+	repo := job.NewLeaderRepo(db)
+	opt := job.LeaderOptions{
+		LeaseTTL:       500 * time.Millisecond,
+		RenewInterval:  20 * time.Millisecond,
+		MgrMsgInterval: 10 * time.Millisecond,
+	}
+	received := make(chan job.MgrMsg, 10)
+	newInstance := func(id string, terms *atomic.Int32) job.Center {
+		o := opt
+		o.InstanceId = id
+		return job.NewLeaderCenter(o, repo, func() job.Center {
+			terms.Add(1)
+			c := test.NewMockJobCenter()
+			c.On("ReceiveMgrMsg", mock.Anything).Run(func(args mock.Arguments) {
+				received <- args.Get(0).(job.MgrMsg)
+			}).Return()
+			return c
+		})
+	}
+	var termsA, termsB atomic.Int32
+	a := newInstance("a", &termsA)
+	b := newInstance("b", &termsB)
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	t.Cleanup(cancelB)
+
+	require.NoError(t, a.Start(ctxA))
+	require.NoError(t, b.Start(ctxB))
+	require.Equal(t, int32(1), termsA.Load(), "a is the leader")
+	require.Equal(t, int32(0), termsB.Load())
+
+	msg := job.MgrMsg{Typ: job.MgrTypeCancelJob, Data: job.MgrMsgCancelJob{JobId: "j1", Operation: "op", Force: true}}
+	b.ReceiveMgrMsg(msg)
+	select {
+	case got := <-received:
+		require.Equal(t, msg, got, "forwarded to the leader")
+	case <-time.After(time.Second):
+		t.Fatal("message is not forwarded to the leader")
+	}
+	require.Empty(t, b.GetPendingJobs(), "pending jobs are in memory of the leader only")
+
+	// created on b then canceled on a
+	create := job.MgrMsg{Typ: job.MgrTypeCreateJob, Data: job.MgrMsgCreateJob{JobContext: job.JobContext{JobId: "j2", Operation: "op"}}}
+	cancel := job.MgrMsg{Typ: job.MgrTypeCancelJob, Data: job.MgrMsgCancelJob{JobId: "j2", Operation: "op"}}
+	b.ReceiveMgrMsg(create)
+	a.ReceiveMgrMsg(cancel)
+	for _, want := range []job.MgrMsg{create, cancel} {
+		select {
+		case got := <-received:
+			require.Equal(t, want, got, "messages are in order")
+		case <-time.After(time.Second):
+			t.Fatal("message is not received by the leader")
+		}
+	}
+
+	cancelA()
+	require.Eventually(t, func() bool {
+		return termsB.Load() == 1
+	}, time.Second, 10*time.Millisecond, "b takes over after a exits")
+	require.Equal(t, int32(1), termsA.Load())
+}
+
+type fakePubSub struct {
+	connector.PubSub
+	subs map[string][]func(msg connector.Message)
+}
+
+func (p *fakePubSub) Subscribe(_ context.Context, topic string, _ byte, callback func(msg connector.Message)) error {
+	p.subs[topic] = append(p.subs[topic], callback)
+	return nil
+}
+
+func (p *fakePubSub) deliver(topic string) {
+	for _, cb := range p.subs[topic] {
+		cb(nil)
+	}
+}
+
+func TestTermPubSub(t *testing.T) {
+	ps := &fakePubSub{subs: map[string][]func(msg connector.Message){}}
+	termPs := job.NewTermPubSub(ps)
+
+	var got1, got2 atomic.Int32
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	require.NoError(t, termPs.Subscribe(ctx1, job.TopicAllGet, job.DefaultQos, func(connector.Message) { got1.Add(1) }))
+	ps.deliver(job.TopicAllGet)
+	require.Equal(t, int32(1), got1.Load())
+
+	// the term steps down
+	cancel1()
+	ps.deliver(job.TopicAllGet)
+	require.Equal(t, int32(1), got1.Load(), "term stepped down receives no message")
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	require.NoError(t, termPs.Subscribe(ctx2, job.TopicAllGet, job.DefaultQos, func(connector.Message) { got2.Add(1) }))
+	require.Len(t, ps.subs[job.TopicAllGet], 1, "topic is subscribed only once")
+	ps.deliver(job.TopicAllGet)
+	require.Equal(t, int32(1), got1.Load())
+	require.Equal(t, int32(1), got2.Load(), "message is sent to the latest term")
+}
@@ -0,0 +1,77 @@
+package job
+
+import (
+	"time"
+	// time zones of maintenance windows and schedules work without tzdata on the host
+	_ "time/tzdata"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"ruff.io/tio/pkg/model"
+)
+
+const (
+	maxMaintenanceWindows       = 10
+	maxMaintenanceWindowMinutes = 24 * 60
+)
+
+// cronParser parses startTime of maintenance windows and cron of job schedules, both are 5-field cron expressions
+// without seconds, or descriptors like "@daily"
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+func (w MaintenanceWindow) valid() error {
+	if _, _, err := w.schedule(); err != nil {
+		return errors.WithMessage(model.ErrInvalidParams, "maintenanceWindow "+err.Error())
+	}
+	if w.DurationInMinutes < 1 || w.DurationInMinutes > maxMaintenanceWindowMinutes {
+		return errors.WithMessagef(model.ErrInvalidParams,
+			"maintenanceWindow durationInMinutes should between 1 and %d", maxMaintenanceWindowMinutes)
+	}
+	return nil
+}
+
+func (w MaintenanceWindow) schedule() (cron.Schedule, *time.Location, error) {
+	return parseCron("startTime", w.StartTime, w.TimeZone)
+}
+
+// parseCron parses the 5-field cron expression of the field and the IANA time zone, which is UTC when empty
+func parseCron(field, expr, timeZone string) (cron.Schedule, *time.Location, error) {
+	loc := time.UTC
+	if timeZone != "" {
+		l, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, nil, errors.Errorf("timeZone %q is invalid", timeZone)
+		}
+		loc = l
+	}
+	s, err := cronParser.Parse(expr)
+	if err != nil {
+		return nil, nil, errors.Errorf("%s %q is invalid: %v", field, expr, err)
+	}
+	return s, loc, nil
+}
+
+// contains whether t is in the window, which starts at any activation of the cron and lasts for the duration
+func (w MaintenanceWindow) contains(t time.Time) bool {
+	s, loc, err := w.schedule()
+	if err != nil {
+		return false
+	}
+	d := time.Duration(w.DurationInMinutes) * time.Minute
+	// the first start after (t - duration) is the only start whose window may contain t
+	start := s.Next(t.Add(-d).In(loc))
+	return !start.IsZero() && !start.After(t)
+}
+
+// isInMaintenanceWindow whether tasks of the job can be rolled out now, it's true when no window is configured
+func isInMaintenanceWindow(jc *JobContext, now time.Time) bool {
+	if jc.SchedulingConfig == nil || len(jc.SchedulingConfig.MaintenanceWindows) == 0 {
+		return true
+	}
+	for _, w := range jc.SchedulingConfig.MaintenanceWindows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/model"
+)
+
+func TestMaintenanceWindow_Contains(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+	// every day at 22:00 for 3 hours, in Shanghai
+	w := MaintenanceWindow{StartTime: "0 22 * * *", DurationInMinutes: 180, TimeZone: "Asia/Shanghai"}
+
+	cases := []struct {
+		at     time.Time
+		inside bool
+	}{
+		{time.Date(2024, 5, 1, 21, 59, 0, 0, shanghai), false},
+		{time.Date(2024, 5, 1, 22, 0, 0, 0, shanghai), true},
+		{time.Date(2024, 5, 2, 0, 30, 0, 0, shanghai), true},
+		{time.Date(2024, 5, 2, 0, 59, 59, 0, shanghai), true},
+		{time.Date(2024, 5, 2, 1, 0, 0, 0, shanghai), false},
+		{time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC), true}, // 22:30 in Shanghai
+		{time.Date(2024, 5, 1, 22, 30, 0, 0, time.UTC), false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.inside, w.contains(c.at), "at %v", c.at)
+	}
+
+	weekly := MaintenanceWindow{StartTime: "0 18 * * MON", DurationInMinutes: 60}
+	require.True(t, weekly.contains(time.Date(2024, 5, 6, 18, 30, 0, 0, time.UTC)), "monday")
+	require.False(t, weekly.contains(time.Date(2024, 5, 7, 18, 30, 0, 0, time.UTC)), "tuesday")
+
+	jc := &JobContext{SchedulingConfig: &SchedulingConfig{MaintenanceWindows: []MaintenanceWindow{w, weekly}}}
+	require.True(t, isInMaintenanceWindow(jc, time.Date(2024, 5, 6, 18, 30, 0, 0, time.UTC)))
+	require.False(t, isInMaintenanceWindow(jc, time.Date(2024, 5, 7, 18, 30, 0, 0, time.UTC)))
+	require.True(t, isInMaintenanceWindow(&JobContext{}, time.Now()), "no window means always")
+}
+
+func TestMaintenanceWindow_Valid(t *testing.T) {
+	require.NoError(t, MaintenanceWindow{StartTime: "30 1 * * 1-5", DurationInMinutes: 60, TimeZone: "Europe/Berlin"}.valid())
+	for _, w := range []MaintenanceWindow{
+		{StartTime: "0 0 18 ? * MON *", DurationInMinutes: 60},
+		{StartTime: "0 18 * * *", DurationInMinutes: 0},
+		{StartTime: "0 18 * * *", DurationInMinutes: 24*60 + 1},
+		{StartTime: "0 18 * * *", DurationInMinutes: 60, TimeZone: "Mars/Olympus"},
+	} {
+		require.ErrorIs(t, w.valid(), model.ErrInvalidParams, "window %v", w)
+	}
+}
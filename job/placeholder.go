@@ -0,0 +1,232 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+// Placeholders in job doc are resolved with values of the thing when its task is sent or fetched:
+//   - ${thing.id}, ${thing.type}
+//   - ${shadow.reported.<path>}, ${shadow.desired.<path>}, path is split by ".", like ${shadow.reported.fw.version}
+//   - ${tags.<path>}
+//
+// A string which is a single placeholder takes the value as is, otherwise values are formatted into the string.
+
+var thingPlaceholderRegexp = regexp.MustCompile(`\$\{((?:thing|shadow|tags)\.[^}]*)}`)
+
+// resolve failure is reported in task statusDetails with the key
+const statusDetailsKeyJobDocError = "jobDocError"
+
+// thingPlaceholderValid whether the placeholder is one of the supported forms
+func thingPlaceholderValid(p string) bool {
+	seg := strings.Split(p, ".")
+	for _, s := range seg {
+		if s == "" {
+			return false
+		}
+	}
+	switch seg[0] {
+	case "thing":
+		return len(seg) == 2 && (seg[1] == "id" || seg[1] == "type")
+	case "shadow":
+		return len(seg) > 2 && (seg[1] == "reported" || seg[1] == "desired")
+	case "tags":
+		return len(seg) > 1
+	}
+	return false
+}
+
+// thingPlaceholders placeholders of thing in string values of the job doc
+func thingPlaceholders(jobDoc map[string]any) []string {
+	if len(jobDoc) == 0 {
+		return nil
+	}
+	var l []string
+	walkStrings(copyJobDoc(jobDoc), func(s string) string {
+		for _, m := range thingPlaceholderRegexp.FindAllStringSubmatch(s, -1) {
+			l = append(l, m[1])
+		}
+		return s
+	})
+	return l
+}
+
+func validJobDocPlaceholders(jobDoc map[string]any) error {
+	for _, p := range thingPlaceholders(jobDoc) {
+		if !thingPlaceholderValid(p) {
+			return errors.WithMessagef(model.ErrInvalidParams, "jobDoc placeholder ${%s} is not supported", p)
+		}
+	}
+	return nil
+}
+
+// resolveJobDoc returns a copy of the job doc whose placeholders are resolved with the thing,
+// which is a row of "select * from shadow"
+func resolveJobDoc(jobDoc map[string]any, thing map[string]any) (map[string]any, error) {
+	var resolveErr error
+	resolve := func(v any) any {
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		// single placeholder takes the value of its type
+		if m := thingPlaceholderRegexp.FindStringSubmatch(s); m != nil && m[0] == s {
+			val, err := thingValue(thing, m[1])
+			if err != nil {
+				resolveErr = err
+				return v
+			}
+			return val
+		}
+		return thingPlaceholderRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+			val, err := thingValue(thing, ref[2:len(ref)-1])
+			if err != nil {
+				resolveErr = err
+				return ref
+			}
+			if str, ok := val.(string); ok {
+				return str
+			}
+			buf, _ := json.Marshal(val)
+			return string(buf)
+		})
+	}
+	res := walkValues(copyJobDoc(jobDoc), resolve)
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	return res.(map[string]any), nil
+}
+
+func thingValue(thing map[string]any, placeholder string) (any, error) {
+	seg := strings.Split(placeholder, ".")
+	var v any
+	switch {
+	case placeholder == "thing.id":
+		v = thing["thingId"]
+	case placeholder == "thing.type":
+		v = thing["thingType"]
+	case seg[0] == "shadow" && len(seg) > 2:
+		state, _ := thing["state"].(map[string]any)
+		v = lookupPath(state, seg[1:])
+	case seg[0] == "tags" && len(seg) > 1:
+		tags, _ := thing["tags"].(map[string]any)
+		v = lookupPath(tags, seg[1:])
+	default:
+		return nil, errors.Errorf("placeholder ${%s} is not supported", placeholder)
+	}
+	if v == nil || v == "" {
+		return nil, errors.Errorf("placeholder ${%s} has no value", placeholder)
+	}
+	return v, nil
+}
+
+func lookupPath(m map[string]any, path []string) any {
+	var v any = m
+	for _, k := range path {
+		o, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = o[k]
+	}
+	return v
+}
+
+// jobDocOfThing resolves placeholders of the job doc for the thing, the job doc is returned as is if it has none
+func jobDocOfThing(ctx context.Context, tq ThingQuerier, jobDoc map[string]any, thingId string) (map[string]any, error) {
+	if len(thingPlaceholders(jobDoc)) == 0 {
+		return jobDoc, nil
+	}
+	things, err := jobDocThings(ctx, tq, []string{thingId})
+	if err != nil {
+		return nil, err
+	}
+	return resolveJobDocOfThing(jobDoc, things, thingId)
+}
+
+// jobDocThings finds the things for resolving job docs, keyed by thing id
+func jobDocThings(ctx context.Context, tq ThingQuerier, thingIds []string) (map[string]map[string]any, error) {
+	if tq == nil {
+		return nil, errors.New("placeholders of job doc are not supported")
+	}
+	res := make(map[string]map[string]any, len(thingIds))
+	for i := 0; i < len(thingIds); i += resolveTargetBatch {
+		ids := thingIds[i:min(i+resolveTargetBatch, len(thingIds))]
+		l, err := tq.Find(ctx, shadow.ThingFilter{ThingIds: ids, Limit: len(ids)})
+		if err != nil {
+			return nil, errors.WithMessage(err, "find things for job doc")
+		}
+		for _, s := range l {
+			m, err := shadowToMap(s)
+			if err != nil {
+				return nil, err
+			}
+			res[s.ThingId] = m
+		}
+	}
+	return res, nil
+}
+
+// resolveJobDocOfThing resolves placeholders of the job doc with the thing found by jobDocThings
+func resolveJobDocOfThing(jobDoc map[string]any, things map[string]map[string]any, thingId string) (map[string]any, error) {
+	thing, ok := things[thingId]
+	if !ok {
+		return nil, errors.Errorf("thing %q not found for job doc", thingId)
+	}
+	return resolveJobDoc(jobDoc, thing)
+}
+
+// withJobDocError returns a copy of the status details with the failure of resolving job doc
+func withJobDocError(sd StatusDetails, err error) StatusDetails {
+	res := make(StatusDetails, len(sd)+1)
+	maps.Copy(res, sd)
+	res[statusDetailsKeyJobDocError] = err.Error()
+	return res
+}
+
+// shadowToMap converts the shadow to map, the same as a row of "select * from shadow"
+func shadowToMap(s shadow.ShadowWithStatus) (map[string]any, error) {
+	var m map[string]any
+	buf, err := json.Marshal(s)
+	if err == nil {
+		err = json.Unmarshal(buf, &m)
+	}
+	return m, errors.Wrapf(err, "convert shadow of thing %q", s.ThingId)
+}
+
+func copyJobDoc(jobDoc map[string]any) map[string]any {
+	if jobDoc == nil {
+		return nil
+	}
+	var res map[string]any
+	buf, err := json.Marshal(jobDoc)
+	if err != nil || json.Unmarshal(buf, &res) != nil {
+		return map[string]any{}
+	}
+	return res
+}
+
+// walkValues replaces leaf values of v by f
+func walkValues(v any, f func(any) any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, e := range x {
+			x[k] = walkValues(e, f)
+		}
+		return x
+	case []any:
+		for i, e := range x {
+			x[i] = walkValues(e, f)
+		}
+		return x
+	}
+	return f(v)
+}
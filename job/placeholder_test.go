@@ -0,0 +1,98 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+type thingFinderFunc func(f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error)
+
+func (f thingFinderFunc) Find(_ context.Context, filter shadow.ThingFilter) ([]shadow.ShadowWithStatus, error) {
+	return f(filter)
+}
+
+func lampShadow() map[string]any {
+	return map[string]any{
+		"thingId":   "lamp-1",
+		"thingType": "lamp",
+		"state": map[string]any{
+			"reported": map[string]any{"fw": map[string]any{"version": "1.2.0"}, "port": 8080.0},
+			"desired":  map[string]any{},
+		},
+		"tags": map[string]any{"region": "eu-west"},
+	}
+}
+
+func TestValidJobDocPlaceholders(t *testing.T) {
+	valid := []string{"${thing.id}", "${thing.type}", "${shadow.reported.fw.version}", "${shadow.desired.x}", "${tags.region}"}
+	for _, p := range valid {
+		require.NoError(t, validJobDocPlaceholders(map[string]any{"k": "a-" + p}), p)
+	}
+	invalid := []string{"${thing.foo}", "${thing.id.x}", "${shadow.reported}", "${shadow.meta.x}", "${tags.}", "${tags.a..b}"}
+	for _, p := range invalid {
+		err := validJobDocPlaceholders(map[string]any{"l": []any{map[string]any{"k": p}}})
+		require.ErrorIs(t, err, model.ErrInvalidParams, p)
+	}
+}
+
+func TestResolveJobDoc(t *testing.T) {
+	jobDoc := map[string]any{
+		"url":     "https://x.io/${tags.region}/${thing.type}-${shadow.reported.fw.version}.bin",
+		"port":    "${shadow.reported.port}",
+		"from":    "${shadow.reported.fw}",
+		"targets": []any{"${thing.id}", 1.0},
+		"raw":     "${firmwareUrl}",
+	}
+	res, err := resolveJobDoc(jobDoc, lampShadow())
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"url":     "https://x.io/eu-west/lamp-1.2.0.bin",
+		"port":    8080.0,
+		"from":    map[string]any{"version": "1.2.0"},
+		"targets": []any{"lamp-1", 1.0},
+		"raw":     "${firmwareUrl}",
+	}, res)
+	require.Equal(t, "${thing.id}", jobDoc["targets"].([]any)[0], "job doc is not changed")
+
+	_, err = resolveJobDoc(map[string]any{"a": "${tags.zone}"}, lampShadow())
+	require.ErrorContains(t, err, "${tags.zone}")
+}
+
+func TestJobDocOfThing(t *testing.T) {
+	ctx := context.Background()
+	var filters []shadow.ThingFilter
+	tq := thingFinderFunc(func(f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error) {
+		filters = append(filters, f)
+		if len(f.ThingIds) == 1 && f.ThingIds[0] == "lamp-1" {
+			return []shadow.ShadowWithStatus{{
+				ThingType: "lamp",
+				Shadow: shadow.Shadow{
+					ThingId: "lamp-1",
+					State:   shadow.StateDR{Reported: shadow.StateValue{"port": 8080}},
+					Tags:    shadow.TagsValue{"region": "eu-west"},
+				},
+			}}, nil
+		}
+		return nil, nil
+	})
+
+	plain := map[string]any{"a": "b"}
+	res, err := jobDocOfThing(ctx, tq, plain, "lamp-1")
+	require.NoError(t, err)
+	require.Equal(t, plain, res)
+	require.Empty(t, filters, "no query without placeholders")
+
+	res, err = jobDocOfThing(ctx, tq, map[string]any{"id": "${thing.id}", "t": "${thing.type}-${tags.region}",
+		"port": "${shadow.reported.port}"}, "lamp-1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"id": "lamp-1", "t": "lamp-eu-west", "port": 8080.0}, res)
+
+	_, err = jobDocOfThing(ctx, tq, map[string]any{"id": "${thing.id}"}, "lamp-2")
+	require.Error(t, err)
+	_, err = jobDocOfThing(ctx, nil, map[string]any{"id": "${thing.id}"}, "lamp-1")
+	require.Error(t, err)
+}
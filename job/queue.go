@@ -22,6 +22,9 @@ func (tq taskPriorityQueue) Len() int {
 func taskLess(i, j *Task) bool {
 	p1, p2 := taskStatusPriority[i.Status], taskStatusPriority[j.Status]
 	if p1 == p2 {
+		if i.CreatedAt == j.CreatedAt {
+			return i.TaskId < j.TaskId
+		}
 		return i.CreatedAt < j.CreatedAt
 	}
 	return p1 > p2
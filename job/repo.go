@@ -165,12 +165,8 @@ func (r jobRepo) CancelTasks(ctx context.Context, jobId string, force bool) erro
 }
 
 func (r jobRepo) UpdateTask(ctx context.Context, taskId int64, m map[string]any) error {
-	for k := range m {
-		lk := strings.ToLower(k)
-		if lk == "taskid" || lk == "task_id" || lk == "jobid" || lk == "job_id" ||
-			lk == "thingid" || lk == "thing_id" {
-			return errors.New("can't update taskId, jobId, thingId")
-		}
+	if err := checkTaskUpdate(m); err != nil {
+		return err
 	}
 	if err := r.db.WithContext(ctx).Model(TaskEntity{TaskId: taskId}).Updates(m).Error; err != nil {
 		return err
@@ -178,6 +174,43 @@ func (r jobRepo) UpdateTask(ctx context.Context, taskId int64, m map[string]any)
 	return nil
 }
 
+func (r jobRepo) UpdateTaskOfVersion(ctx context.Context, taskId int64, version int, m map[string]any) error {
+	if err := checkTaskUpdate(m); err != nil {
+		return err
+	}
+	up := map[string]any{"version": gorm.Expr("version + 1")}
+	for k, v := range m {
+		up[k] = v
+	}
+	res := r.db.WithContext(ctx).Model(TaskEntity{}).
+		Where("task_id = ? AND version = ?", taskId, version).
+		Where("status in ?", []TaskStatus{TaskQueued, TaskSent, TaskInProgress}).
+		Updates(up)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.WithMessagef(model.ErrVersionConflict, "task %d is not ongoing at version %d", taskId, version)
+	}
+	return nil
+}
+
+func (r jobRepo) UpdateTasksOfStatus(ctx context.Context, taskIds []int64, status []TaskStatus, m map[string]any) error {
+	if len(taskIds) == 0 {
+		return nil
+	}
+	if err := checkTaskUpdate(m); err != nil {
+		return err
+	}
+	up := map[string]any{"version": gorm.Expr("version + 1")}
+	for k, v := range m {
+		up[k] = v
+	}
+	return r.db.WithContext(ctx).Model(TaskEntity{}).
+		Where("task_id in ? AND status in ?", taskIds, status).
+		Updates(up).Error
+}
+
 func (r jobRepo) DeleteTask(ctx context.Context, taskId int64) error {
 	if err := r.db.WithContext(ctx).Delete(TaskEntity{TaskId: taskId}).Error; err != nil {
 		return err
@@ -261,6 +294,25 @@ func (r jobRepo) GetTasksOfJob(ctx context.Context, jobId string, status []TaskS
 	return l, nil
 }
 
+func (r jobRepo) GetThingsOfJob(ctx context.Context, jobId string, things []string) ([]string, error) {
+	var l []string
+	if len(things) == 0 {
+		return l, nil
+	}
+	err := r.db.WithContext(ctx).Model(TaskEntity{}).
+		Where("job_id=? AND thing_id IN ?", jobId, things).
+		Distinct().Pluck("thing_id", &l).Error
+	return l, err
+}
+
+func (r jobRepo) GetContinuousJobs(ctx context.Context) ([]Entity, error) {
+	var l []Entity
+	err := r.db.WithContext(ctx).Model(Entity{}).
+		Where("target_selection = ? AND status = ?", TargetSelectionContinuous, StatusInProgress).
+		Find(&l).Error
+	return l, err
+}
+
 func (r jobRepo) GetPendingJobs(ctx context.Context) ([]Entity, error) {
 	var l []Entity
 	q := r.db.WithContext(ctx).Model(Entity{}).
@@ -277,3 +329,14 @@ func (r jobRepo) GetPendingJobs(ctx context.Context) ([]Entity, error) {
 	}
 	return l, nil
 }
+
+func checkTaskUpdate(m map[string]any) error {
+	for k := range m {
+		lk := strings.ToLower(k)
+		if lk == "taskid" || lk == "task_id" || lk == "jobid" || lk == "job_id" ||
+			lk == "thingid" || lk == "thing_id" {
+			return errors.New("can't update taskId, jobId, thingId")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package job
+
+import (
+	"time"
+
+	"ruff.io/tio/pkg/log"
+)
+
+const (
+	minIncrementFactor = 1.1
+	maxIncrementFactor = 5
+
+	// rolloutRateRefreshInterval how often task status is counted to grow the exponential rate
+	rolloutRateRefreshInterval = time.Second
+)
+
+// notified things whose tasks are rolled out except canceled
+func (pd ProcessDetails) notified() int {
+	return pd.Sent + pd.InProgress + pd.executed()
+}
+
+// ratePerMinute current rollout rate, which is multiplied by the increment factor
+// every time the number of notified or succeeded things reaches the criteria, up to MaxPerMinute
+func (c *RolloutConfig) ratePerMinute(pd ProcessDetails) int {
+	er := c.ExponentialRate
+	if er == nil {
+		return c.MaxPerMinute
+	}
+	var times int
+	if n := er.RateIncreaseCriteria.NumberOfNotifiedThings; n > 0 {
+		times = pd.notified() / n
+	} else if n := er.RateIncreaseCriteria.NumberOfSucceededThings; n > 0 {
+		times = pd.Succeeded / n
+	}
+	rate := float64(er.BaseRatePerMinute)
+	for i := 0; i < times && rate < float64(c.MaxPerMinute); i++ {
+		rate *= er.IncrementFactor
+	}
+	if rate >= float64(c.MaxPerMinute) {
+		return c.MaxPerMinute
+	}
+	return int(rate)
+}
+
+// rolloutRate rollout rate per minute of the pending job,
+// the exponential rate is refreshed from task status count periodically and never decreases
+func (c *centerImpl) rolloutRate(p *PendingJobItem) int {
+	rc := p.Context.RolloutConfig
+	if rc.ExponentialRate == nil {
+		return rc.MaxPerMinute
+	}
+	if p.RolloutRate > 0 && time.Since(p.rateUpdatedAt) < rolloutRateRefreshInterval {
+		return p.RolloutRate
+	}
+	p.rateUpdatedAt = time.Now()
+	tsc, err := c.repo.CountTaskStatus(c.ctx, p.Context.JobId)
+	if err != nil {
+		log.Errorf("JobCenter get task status count for rollout rate, jobId=%q, error: %v", p.Context.JobId, err)
+		return max(p.RolloutRate, rc.ExponentialRate.BaseRatePerMinute)
+	}
+	if r := rc.ratePerMinute(toProcessDetails(tsc)); r > p.RolloutRate {
+		if p.RolloutRate > 0 {
+			log.Infof("JobCenter rollout rate increased, jobId=%q, ratePerMinute=%d", p.Context.JobId, r)
+		}
+		p.RolloutRate = r
+	}
+	return p.RolloutRate
+}
@@ -0,0 +1,46 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/model"
+)
+
+func TestRolloutConfig_RatePerMinute(t *testing.T) {
+	notified := &RolloutConfig{MaxPerMinute: 100, ExponentialRate: &ExponentialRate{
+		BaseRatePerMinute: 10, IncrementFactor: 2,
+		RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: 10},
+	}}
+	require.NoError(t, notified.valid())
+	require.Equal(t, 10, notified.ratePerMinute(ProcessDetails{Queued: 100}))
+	require.Equal(t, 10, notified.ratePerMinute(ProcessDetails{Sent: 9, Canceled: 10}))
+	require.Equal(t, 20, notified.ratePerMinute(ProcessDetails{Sent: 5, Failed: 5}))
+	require.Equal(t, 80, notified.ratePerMinute(ProcessDetails{InProgress: 20, Succeeded: 15}))
+	require.Equal(t, 100, notified.ratePerMinute(ProcessDetails{Succeeded: 40}), "up to max")
+
+	succeeded := &RolloutConfig{MaxPerMinute: 50, ExponentialRate: &ExponentialRate{
+		BaseRatePerMinute: 2, IncrementFactor: 1.5,
+		RateIncreaseCriteria: RateIncreaseCriteria{NumberOfSucceededThings: 2},
+	}}
+	require.NoError(t, succeeded.valid())
+	require.Equal(t, 2, succeeded.ratePerMinute(ProcessDetails{Sent: 10, Failed: 10, Succeeded: 1}))
+	require.Equal(t, 4, succeeded.ratePerMinute(ProcessDetails{Succeeded: 4}))
+
+	require.Equal(t, 7, (&RolloutConfig{MaxPerMinute: 7}).ratePerMinute(ProcessDetails{}))
+}
+
+func TestRolloutConfig_Valid(t *testing.T) {
+	for _, er := range []ExponentialRate{
+		{BaseRatePerMinute: 0, IncrementFactor: 2, RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: 1}},
+		{BaseRatePerMinute: 11, IncrementFactor: 2, RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: 1}},
+		{BaseRatePerMinute: 1, IncrementFactor: 1, RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: 1}},
+		{BaseRatePerMinute: 1, IncrementFactor: 5.1, RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: 1}},
+		{BaseRatePerMinute: 1, IncrementFactor: 2},
+		{BaseRatePerMinute: 1, IncrementFactor: 2, RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: 1, NumberOfSucceededThings: 1}},
+		{BaseRatePerMinute: 1, IncrementFactor: 2, RateIncreaseCriteria: RateIncreaseCriteria{NumberOfNotifiedThings: -1, NumberOfSucceededThings: 1}},
+	} {
+		c := &RolloutConfig{MaxPerMinute: 10, ExponentialRate: &er}
+		require.ErrorIs(t, c.valid(), model.ErrInvalidParams, "exponentialRate %+v", er)
+	}
+}
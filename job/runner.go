@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/panjf2000/ants/v2"
@@ -18,8 +19,9 @@ func NewRunner(
 	pubSub connector.PubSub, conn connector.ConnectChecker,
 	methodHandler shadow.MethodHandler,
 	shadowSetter shadow.StateDesiredSetter,
+	thingQuerier ThingQuerier,
 ) Runner {
-	ttq := make(map[string]TaskQueue)
+	ttq := make(map[string]*TaskQueue)
 	p, err := ants.NewPool(runnerWorkerPoolSize, ants.WithNonblocking(true))
 	if err != nil {
 		log.Fatalf("JobRunner init pool: %v", err)
@@ -36,15 +38,23 @@ func NewRunner(
 		conn:          conn,
 		methodHandler: methodHandler,
 		shadowSetter:  shadowSetter,
+		thingQuerier:  thingQuerier,
 
 		// channels for task change
 		innerTaskChangeCh: make(chan TaskChangeMsg),
 		outTaskChangeCh:   make(chan TaskChangeMsg),
 
+		customTaskChangeSignal: make(chan struct{}, 1),
+
 		// channels for direct method task
 		sysOpTaskCh:    make(chan []Task),
 		sysOpTaskDelCh: make(chan deleteTaskMsg),
 
+		// channels for custom operation task
+		customOpTaskCh:    make(chan []Task),
+		customOpTaskDelCh: make(chan deleteTaskMsg),
+		thingReqCh:        make(chan thingReq, thingReqQueueSize),
+
 		// channels for get tasks
 		getPendingTasksOfCustomReqCh:  make(chan struct{}),
 		getPendingTasksOfCustomRespCh: nil,
@@ -56,6 +66,9 @@ func NewRunner(
 type deleteTaskMsg struct {
 	jobId string
 	tasks []int64
+	// cancel tasks rather than delete, only for custom operation
+	cancel bool
+	force  bool
 }
 
 type runnerImpl struct {
@@ -70,20 +83,29 @@ type runnerImpl struct {
 	conn          connector.ConnectChecker
 	methodHandler shadow.MethodHandler
 	shadowSetter  shadow.StateDesiredSetter
+	// thingQuerier for resolving placeholders of job doc
+	thingQuerier ThingQuerier
 
 	innerTaskChangeCh chan TaskChangeMsg
 	outTaskChangeCh   chan TaskChangeMsg
+	// changes of custom operation tasks to be sent to outTaskChangeCh in order
+	customTaskChanges      []TaskChangeMsg
+	customTaskChangeMu     sync.Mutex
+	customTaskChangeSignal chan struct{}
 
 	sysOpTaskCh    chan []Task
 	sysOpTaskDelCh chan deleteTaskMsg
+
+	customOpTaskCh    chan []Task
+	customOpTaskDelCh chan deleteTaskMsg
+	thingReqCh        chan thingReq
 	// channels for get tasks
 	getPendingTasksOfCustomReqCh  chan struct{}
 	getPendingTasksOfCustomRespCh chan []Task
 	getPendingTasksOfSysReqCh     chan struct{}
 	getPendingTasksOfSysRespCh    chan []Task
 
-	thingTaskQueues map[string]TaskQueue // thingId->[]Task, for general task
-
+	thingTaskQueues map[string]*TaskQueue // thingId->[]Task, for custom operation task, only used in customOpTaskLoop
 }
 
 var _ Runner = &runnerImpl{}
@@ -91,8 +113,17 @@ var _ Runner = &runnerImpl{}
 func (r *runnerImpl) Start(ctx context.Context, jcGetter ctxGetter) {
 	r.ctx = ctx
 	r.jcGetter = jcGetter
+	go func() {
+		<-ctx.Done()
+		r.pool.Release()
+	}()
 	go r.watchTaskChangeLoop()
 	go r.sysOpTaskLoop(r.sysOpTaskCh, r.sysOpTaskDelCh)
+	go r.customOpTaskLoop(r.customOpTaskCh, r.customOpTaskDelCh, r.thingReqCh)
+	go r.customTaskChangeLoop()
+	if r.pubSub != nil {
+		r.subscribeThingReq()
+	}
 }
 
 func (r *runnerImpl) OnTaskChange() <-chan TaskChangeMsg {
@@ -103,7 +134,7 @@ func (r *runnerImpl) PutTasks(operation string, l []Task) {
 	if IsSysOp(operation) {
 		r.sysOpTaskCh <- l
 	} else {
-		// TODO custom
+		r.customOpTaskCh <- l
 	}
 }
 
@@ -132,7 +163,7 @@ func (r *runnerImpl) DeleteTaskOfJob(jobId, operation string, force bool) {
 	if IsSysOp(operation) {
 		r.sysOpTaskDelCh <- deleteTaskMsg{jobId: jobId}
 	} else {
-		// TODO: custom
+		r.customOpTaskDelCh <- deleteTaskMsg{jobId: jobId, force: force}
 	}
 }
 
@@ -141,7 +172,7 @@ func (r *runnerImpl) CancelTaskOfJob(jobId, operation string, force bool) {
 		r.sysOpTaskDelCh <- deleteTaskMsg{jobId: jobId}
 		log.Debugf("JobRunner sent msg for delete tasks of system operation, jobId=%q", jobId)
 	} else {
-		// TODO: custom
+		r.customOpTaskDelCh <- deleteTaskMsg{jobId: jobId, cancel: true, force: force}
 	}
 }
 
@@ -149,7 +180,7 @@ func (r *runnerImpl) DeleteTask(taskId int64, operation string, force bool) {
 	if IsSysOp(operation) {
 		r.sysOpTaskDelCh <- deleteTaskMsg{tasks: []int64{taskId}}
 	} else {
-		// TODO: custom
+		r.customOpTaskDelCh <- deleteTaskMsg{tasks: []int64{taskId}, force: force}
 	}
 }
 
@@ -157,7 +188,7 @@ func (r *runnerImpl) CancelTask(taskId int64, operation string, force bool) {
 	if IsSysOp(operation) {
 		r.sysOpTaskDelCh <- deleteTaskMsg{tasks: []int64{taskId}}
 	} else {
-		// TODO: custom
+		r.customOpTaskDelCh <- deleteTaskMsg{tasks: []int64{taskId}, cancel: true, force: force}
 	}
 }
 
@@ -168,19 +199,18 @@ func (r *runnerImpl) watchTaskChangeLoop() {
 			log.Debug("JobRunner task change watcher exit cause context closed")
 			return
 		case chMsg := <-r.innerTaskChangeCh:
-			r.updateTaskStatus(chMsg)
-			r.outTaskChangeCh <- chMsg
-
-			if isTaskTerminal(chMsg.Status) {
-				if IsCustomOp(chMsg.Task.Operation) {
-					// TODO: update thing task queue
-				}
+			// stale change of task done, eg: sent after succeeded, is not forwarded
+			if err := r.updateTaskStatus(chMsg); errors.Is(err, errTaskTerminal) {
+				continue
 			}
+			r.outTaskChangeCh <- chMsg
 		}
 	}
 }
 
-func (r *runnerImpl) updateTaskStatus(msg TaskChangeMsg) {
+var errTaskTerminal = errors.New("task is terminal")
+
+func (r *runnerImpl) updateTaskStatus(msg TaskChangeMsg) error {
 	sdBuf, err := json.Marshal(msg.StatusDetails)
 	if err != nil {
 		log.Errorf("JobRunner update task status, unexpected marshal statusDetails=%v, jobId=%q, taskId=%d, error: %v",
@@ -189,27 +219,23 @@ func (r *runnerImpl) updateTaskStatus(msg TaskChangeMsg) {
 	err = r.repo.ExecWithTx(func(txRepo Repo) error {
 		t, er := txRepo.GetTask(r.ctx, msg.Task.TaskId)
 		if er != nil {
-			return err
+			return er
 		}
 		if t == nil {
 			return errors.New("task not found")
 		}
 		if isTaskTerminal(t.Status) {
-			return fmt.Errorf("task is terminal at status=%q", t.Status)
+			return fmt.Errorf("%w at status=%q", errTaskTerminal, t.Status)
 		}
 		toUpdate := map[string]any{
 			"status":         msg.Status,
 			"progress":       msg.Progress,
 			"status_details": sdBuf,
 		}
-		if isTaskTerminal(t.Status) {
+		if isTaskTerminal(msg.Status) {
 			toUpdate["completed_at"] = time.Now()
 		}
-		er = txRepo.UpdateTask(r.ctx, msg.Task.TaskId, toUpdate)
-		if er != nil {
-			return err
-		}
-		return nil
+		return txRepo.UpdateTask(r.ctx, msg.Task.TaskId, toUpdate)
 	})
 	if err != nil {
 		log.Errorf("JobRunner update task status, jobId=%q, taskId=%d, status=%q, error: %v",
@@ -217,6 +243,7 @@ func (r *runnerImpl) updateTaskStatus(msg TaskChangeMsg) {
 	}
 	log.Debugf("JobRunner update task status, jobId=%q, taskId=%d, status=%q, progress=%v",
 		msg.Task.JobId, msg.Task.TaskId, msg.Status, msg.Progress)
+	return err
 }
 
 func (r *runnerImpl) sysOpTaskLoop(addCh <-chan []Task, delCh <-chan deleteTaskMsg) {
@@ -357,22 +384,9 @@ func (r *runnerImpl) sysOpTaskLoop(addCh <-chan []Task, delCh <-chan deleteTaskM
 func (r *runnerImpl) submitDirectMethodTaskToPool(jc *JobContext, t *Task) error {
 	return r.pool.Submit(func() {
 		var req InvokeDirectMethodReq
-		if jc.JobDoc == nil {
-			log.Errorf("JobRunner unexpected job doc is nil for invoke direct method! jobId=%q, jobDoc=%v",
-				jc.JobId, jc.JobDoc)
-		}
-
-		jBuf, err := json.Marshal(jc.JobDoc)
-		if err != nil {
-			log.Errorf("JobRunner unexpected job doc is nil for invoke direct method! jobId=%q, jobDoc=%v",
-				jc.JobId, jc.JobDoc)
-		}
-		if err := json.Unmarshal(jBuf, &req); err != nil {
-			// job doc should be checked before job created
-			log.Errorf("JobRunner unexpected job doc for invoke direct method! jobId=%q, jobDoc=%v",
-				jc.JobId, jc.JobDoc)
+		if !r.decodeJobDoc(jc, t, &req) {
+			return
 		}
-
 		re := r.doInvokeDirectMethod(*t, req)
 		if re.Err != nil {
 			log.Errorf("JobRunner do invoke direct method, jobId=%q taskId=%d thingId=%s : %v",
@@ -386,22 +400,9 @@ func (r *runnerImpl) submitDirectMethodTaskToPool(jc *JobContext, t *Task) error
 func (r *runnerImpl) submitUpdateShadowTaskToPool(jc *JobContext, t *Task) error {
 	return r.pool.Submit(func() {
 		var req UpdateShadowReq
-		if jc.JobDoc == nil {
-			log.Errorf("JobRunner unexpected job doc is nil for update shadow! jobId=%q, jobDoc=%v",
-				jc.JobId, jc.JobDoc)
-		}
-
-		jBuf, err := json.Marshal(jc.JobDoc)
-		if err != nil {
-			log.Errorf("JobRunner unexpected job doc is nil for update shadow! jobId=%q, jobDoc=%v",
-				jc.JobId, jc.JobDoc)
-		}
-		if err := json.Unmarshal(jBuf, &req); err != nil {
-			// job doc should be checked before job created
-			log.Errorf("JobRunner unexpected job doc for update shadow! jobId=%q, jobDoc=%v",
-				jc.JobId, jc.JobDoc)
+		if !r.decodeJobDoc(jc, t, &req) {
+			return
 		}
-
 		re := r.doUpdateShadow(*t, req)
 		if re.Err != nil {
 			log.Errorf("JobRunner do update shadow, jobId=%q taskId=%d thingId=%s : %v",
@@ -411,3 +412,29 @@ func (r *runnerImpl) submitUpdateShadowTaskToPool(jc *JobContext, t *Task) error
 		r.innerTaskChangeCh <- re
 	})
 }
+
+// decodeJobDoc decodes the job doc resolved for the thing of the task into req,
+// the task fails if placeholders of the job doc can't be resolved
+func (r *runnerImpl) decodeJobDoc(jc *JobContext, t *Task, req any) bool {
+	if jc.JobDoc == nil {
+		log.Errorf("JobRunner unexpected job doc is nil for operation %q! jobId=%q", t.Operation, jc.JobId)
+	}
+	jd, err := jobDocOfThing(r.ctx, r.thingQuerier, jc.JobDoc, t.ThingId)
+	if err != nil {
+		log.Warnf("JobRunner resolve job doc, jobId=%q, taskId=%d, thingId=%q, error: %v",
+			jc.JobId, t.TaskId, t.ThingId, err)
+		r.innerTaskChangeCh <- TaskChangeMsg{
+			Task: *t, Status: TaskFailed, StatusDetails: StatusDetails{statusDetailsKeyJobDocError: err.Error()},
+		}
+		return false
+	}
+	jBuf, err := json.Marshal(jd)
+	if err != nil {
+		log.Errorf("JobRunner unexpected job doc for operation %q! jobId=%q, jobDoc=%v", t.Operation, jc.JobId, jd)
+	}
+	if err := json.Unmarshal(jBuf, req); err != nil {
+		// job doc should be checked before job created
+		log.Errorf("JobRunner unexpected job doc for operation %q! jobId=%q, jobDoc=%v", t.Operation, jc.JobId, jd)
+	}
+	return true
+}
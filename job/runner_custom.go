@@ -0,0 +1,657 @@
+package job
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+)
+
+// Tasks of custom operations are executed by things themselves over the jobs MQTT protocol:
+//   - tio publishes "notify" when pending tasks of a thing change, and "notify-next" when the next one changes
+//   - thing gets pending tasks by "get", or a task by "{jobId}/get", "$next" for the next pending task
+//   - thing starts the next pending task by "start-next", it's IN_PROGRESS then
+//   - thing reports progress and result by "{jobId}/update", with the version it knows for concurrency control
+//
+// Notifications are not retained, things should get pending tasks after connecting.
+
+const (
+	DefaultQos = 1
+
+	customOpCheckInterval = time.Second
+	// thingReqQueueSize requests of things are handled in order by the custom operation loop,
+	// they are dropped if the queue is full, things may retry with "get" later
+	thingReqQueueSize = 1024
+)
+
+type thingReqType int
+
+const (
+	thingReqGetList thingReqType = iota
+	thingReqGet
+	thingReqStartNext
+	thingReqUpdate
+)
+
+type thingReq struct {
+	typ     thingReqType
+	thingId string
+	jobId   string
+	payload []byte
+}
+
+func (r *runnerImpl) subscribeThingReq() {
+	sub := func(topic string, typ thingReqType) {
+		err := r.pubSub.Subscribe(r.ctx, topic, DefaultQos, func(msg connector.Message) {
+			thingId, jobId, ok := parseTopic(msg.Topic())
+			if !ok {
+				log.Errorf("JobRunner got wrong topic for thing request, topic=%q", msg.Topic())
+				return
+			}
+			req := thingReq{typ: typ, thingId: thingId, jobId: jobId, payload: msg.Payload()}
+			select {
+			case r.thingReqCh <- req:
+			default:
+				log.Warnf("JobRunner thing request queue is full, dropped request of topic %q", msg.Topic())
+			}
+		})
+		if err != nil {
+			log.Errorf("JobRunner subscribe topic=%q error: %v", topic, err)
+		}
+	}
+	sub(TopicAllGetList, thingReqGetList)
+	sub(TopicAllGet, thingReqGet)
+	sub(TopicAllStartNext, thingReqStartNext)
+	sub(TopicAllUpdate, thingReqUpdate)
+}
+
+// customOpTaskLoop The task queues of things are only used in this go routine for lock-free
+func (r *runnerImpl) customOpTaskLoop(addCh <-chan []Task, delCh <-chan deleteTaskMsg, reqCh <-chan thingReq) {
+	defer func() {
+		log.Info("JobRunner custom operation loop exit")
+	}()
+	tick := time.NewTicker(customOpCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			log.Debugf("JobRunner custom operation exit cause context closed")
+			return
+		case tl := <-addCh:
+			r.addCustomTasks(tl)
+		case dl := <-delCh:
+			r.removeCustomTasks(dl)
+		case req := <-reqCh:
+			r.handleThingReq(req)
+		case <-r.getPendingTasksOfCustomReqCh:
+			l := []Task{}
+			for _, q := range r.thingTaskQueues {
+				l = append(l, q.GetTasks()...)
+			}
+			r.getPendingTasksOfCustomRespCh <- l
+		case now := <-tick.C:
+			r.checkCustomTaskTimeout(now)
+		}
+	}
+}
+
+func (r *runnerImpl) addCustomTasks(tl []Task) {
+	tl, failed := r.failUnresolvedCustomTasks(tl)
+	r.notifyTaskChange(failed...)
+
+	var queued []int64
+	for _, t := range tl {
+		if t.Status == TaskQueued {
+			queued = append(queued, t.TaskId)
+		}
+	}
+	err := r.repo.UpdateTasksOfStatus(r.ctx, queued, []TaskStatus{TaskQueued}, map[string]any{"status": TaskSent})
+	if err != nil {
+		log.Errorf("JobRunner update custom operation tasks to %q, error: %v", TaskSent, err)
+	}
+
+	prevNext := map[string]int64{}
+	var changes []TaskChangeMsg
+	for _, t := range tl {
+		st := t
+		if _, ok := prevNext[st.ThingId]; !ok {
+			prevNext[st.ThingId] = r.nextCustomTaskId(st.ThingId)
+		}
+		if st.Status == TaskQueued && err == nil {
+			changes = append(changes, TaskChangeMsg{Task: t, Status: TaskSent})
+			st.Status = TaskSent
+			st.Version++
+		}
+		q, ok := r.thingTaskQueues[st.ThingId]
+		if !ok {
+			nq := NewTaskQueue()
+			q = &nq
+			r.thingTaskQueues[st.ThingId] = q
+		}
+		q.RemoveById(st.TaskId)
+		q.Push(&st)
+		log.Debugf("JobRunner push custom operation task %d for thing %q", st.TaskId, st.ThingId)
+	}
+	r.notifyTaskChange(changes...)
+	for thingId, prev := range prevNext {
+		r.notifyThing(thingId, prev)
+	}
+}
+
+// failUnresolvedCustomTasks fails tasks to be sent whose job doc can't be resolved for the thing,
+// and returns the other tasks. Things of the tasks are found in batches.
+func (r *runnerImpl) failUnresolvedCustomTasks(tl []Task) ([]Task, []TaskChangeMsg) {
+	var ok, toResolve []Task
+	var thingIds []string
+	for _, t := range tl {
+		jc := r.jcGetter(t.JobId)
+		if jc == nil || (t.Status != TaskQueued && t.Status != TaskSent) || len(thingPlaceholders(jc.JobDoc)) == 0 {
+			ok = append(ok, t)
+			continue
+		}
+		toResolve = append(toResolve, t)
+		if !slices.Contains(thingIds, t.ThingId) {
+			thingIds = append(thingIds, t.ThingId)
+		}
+	}
+	if len(toResolve) == 0 {
+		return ok, nil
+	}
+
+	things, findErr := jobDocThings(r.ctx, r.thingQuerier, thingIds)
+	var changes []TaskChangeMsg
+	for _, t := range toResolve {
+		err := findErr
+		if err == nil {
+			_, err = resolveJobDocOfThing(r.jcGetter(t.JobId).JobDoc, things, t.ThingId)
+		}
+		if err == nil {
+			ok = append(ok, t)
+			continue
+		}
+		log.Warnf("JobRunner resolve job doc, jobId=%q, taskId=%d, thingId=%q, error: %v", t.JobId, t.TaskId, t.ThingId, err)
+		sd := StatusDetails{statusDetailsKeyJobDocError: err.Error()}
+		sdBuf, _ := json.Marshal(sd)
+		if err := r.repo.UpdateTasksOfStatus(r.ctx, []int64{t.TaskId}, []TaskStatus{TaskQueued, TaskSent}, map[string]any{
+			"status": TaskFailed, "status_details": sdBuf, "completed_at": time.Now(),
+		}); err != nil {
+			log.Errorf("JobRunner fail custom operation task %d of unresolved job doc, error: %v", t.TaskId, err)
+			continue
+		}
+		changes = append(changes, TaskChangeMsg{Task: t, Status: TaskFailed, StatusDetails: sd})
+	}
+	return ok, changes
+}
+
+func (r *runnerImpl) removeCustomTasks(dl deleteTaskMsg) {
+	var changes []TaskChangeMsg
+	for thingId, q := range r.thingTaskQueues {
+		prev := r.nextCustomTaskId(thingId)
+		var removed []int64
+		for _, t := range q.GetTasks() {
+			if t.JobId != dl.jobId && !slices.Contains(dl.tasks, t.TaskId) {
+				continue
+			}
+			// task in progress keeps running unless it's canceled by force
+			if dl.cancel && !dl.force && t.Status == TaskInProgress {
+				continue
+			}
+			q.RemoveById(t.TaskId)
+			removed = append(removed, t.TaskId)
+			if dl.cancel {
+				changes = append(changes, TaskChangeMsg{Task: t, Status: TaskCanceled})
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		if dl.cancel {
+			status := []TaskStatus{TaskQueued, TaskSent}
+			if dl.force {
+				status = append(status, TaskInProgress)
+			}
+			err := r.repo.UpdateTasksOfStatus(r.ctx, removed, status, map[string]any{
+				"status":         TaskCanceled,
+				"force_canceled": dl.force,
+				"completed_at":   time.Now(),
+			})
+			if err != nil {
+				log.Errorf("JobRunner cancel custom operation tasks of thing %q, error: %v", thingId, err)
+			}
+		}
+		if q.Size() == 0 {
+			delete(r.thingTaskQueues, thingId)
+		}
+		log.Debugf("JobRunner removed custom operation tasks of thing %q, count=%d, cancel=%v",
+			thingId, len(removed), dl.cancel)
+		r.notifyThing(thingId, prev)
+	}
+	r.notifyTaskChange(changes...)
+}
+
+func (r *runnerImpl) checkCustomTaskTimeout(now time.Time) {
+	for thingId, q := range r.thingTaskQueues {
+		prev := r.nextCustomTaskId(thingId)
+		changed := false
+		for _, t := range q.GetTasks() {
+			if t.Status != TaskInProgress || t.StartedAt == nil {
+				continue
+			}
+			jc := r.jcGetter(t.JobId)
+			if jc == nil || jc.TimeoutConfig == nil || jc.TimeoutConfig.InProgressMinutes <= 0 {
+				continue
+			}
+			timeout := time.Duration(jc.TimeoutConfig.InProgressMinutes) * time.Minute
+			if now.Sub(time.UnixMilli(*t.StartedAt)) < timeout {
+				continue
+			}
+			st := t
+			err := r.updateCustomTask(&st, map[string]any{"status": TaskTimeOut, "completed_at": now})
+			if err != nil {
+				log.Errorf("JobRunner update custom operation task %d timed out, error: %v", t.TaskId, err)
+				continue
+			}
+			log.Infof("JobRunner custom operation task timed out, jobId=%q, taskId=%d, thingId=%q",
+				t.JobId, t.TaskId, t.ThingId)
+			changed = true
+		}
+		if changed {
+			r.notifyThing(thingId, prev)
+		}
+	}
+}
+
+func (r *runnerImpl) handleThingReq(req thingReq) {
+	switch req.typ {
+	case thingReqGetList:
+		var p TGetPendingTasksReq
+		_ = json.Unmarshal(req.payload, &p)
+		resp := TPendingTasksResp{
+			InProgressTasks: []TTaskSummary{},
+			QueuedTasks:     []TTaskSummary{},
+			Timestamp:       time.Now().UnixMilli(),
+			ClientToken:     p.ClientToken,
+		}
+		for _, t := range r.customTasksOf(req.thingId) {
+			if t.Status == TaskInProgress {
+				resp.InProgressTasks = append(resp.InProgressTasks, toTTaskSummary(t))
+			} else {
+				resp.QueuedTasks = append(resp.QueuedTasks, toTTaskSummary(t))
+			}
+		}
+		r.publish(TopicGetListAccepted(req.thingId), resp)
+	case thingReqGet:
+		var p TGetTaskReq
+		_ = json.Unmarshal(req.payload, &p)
+		t, err := r.getCustomTask(req.thingId, req.jobId)
+		if err != nil {
+			r.publish(TopicGetRejected(req.thingId, req.jobId), errResp(err, p.ClientToken))
+			return
+		}
+		resp := TGetTaskResp{Task: r.toTTask(t, p.IncludeJobDoc), Timestamp: time.Now().UnixMilli(), ClientToken: p.ClientToken}
+		r.publish(TopicGetAccepted(req.thingId, req.jobId), resp)
+	case thingReqStartNext:
+		var p TStartNextPendingTaskReq
+		_ = json.Unmarshal(req.payload, &p)
+		t, err := r.startNextCustomTask(req.thingId, p)
+		if err != nil {
+			r.publish(TopicStartNextRejected(req.thingId), errResp(err, p.ClientToken))
+			return
+		}
+		resp := TGetTaskResp{Task: r.toTTask(t, true), Timestamp: time.Now().UnixMilli(), ClientToken: p.ClientToken}
+		r.publish(TopicStartNextAccepted(req.thingId), resp)
+	case thingReqUpdate:
+		var p TUpdateTaskReq
+		if err := json.Unmarshal(req.payload, &p); err != nil {
+			err = errors.WithMessage(model.ErrInvalidParams, "invalid json")
+			r.publish(TopicUpdateRejected(req.thingId, req.jobId), errResp(err, ""))
+			return
+		}
+		t, err := r.updateCustomTaskByThing(req.thingId, req.jobId, p)
+		if err != nil {
+			r.publish(TopicUpdateRejected(req.thingId, req.jobId), errResp(err, p.ClientToken))
+			return
+		}
+		resp := TUpdateTaskResp{Timestamp: time.Now().UnixMilli(), ClientToken: p.ClientToken}
+		if p.IncludeTaskState {
+			resp.TaskState = TTaskState{
+				Status: t.Status, StatusDetails: t.StatusDetails, Progress: int(t.Progress), Version: t.Version,
+			}
+		}
+		if p.IncludeJobDoc {
+			var err error
+			if resp.JobDoc, err = r.jobDocOf(t); err != nil {
+				resp.TaskState.StatusDetails = withJobDocError(resp.TaskState.StatusDetails, err)
+			}
+		}
+		r.publish(TopicUpdateAccepted(req.thingId, req.jobId), resp)
+	}
+}
+
+// getCustomTask gets pending task of the job, or the next one for jobId "$next".
+// Task not pending is got from db, so that thing can get the result of a task which is done.
+func (r *runnerImpl) getCustomTask(thingId, jobId string) (Task, error) {
+	if jobId == NextJobId {
+		if t := r.nextCustomTask(thingId); t != nil {
+			return *t, nil
+		}
+		return Task{}, errors.WithMessage(model.ErrNotFound, "no pending task")
+	}
+	if t := r.findCustomTask(thingId, jobId); t != nil {
+		return *t, nil
+	}
+	p, err := r.repo.QueryTask(r.ctx, thingId, jobId, TaskPageQuery{PageQuery: model.PageQuery{PageIndex: 1, PageSize: 1}})
+	if err != nil {
+		return Task{}, errors.WithMessage(model.ErrInternal, err.Error())
+	}
+	if len(p.Content) == 0 {
+		return Task{}, errors.WithMessagef(model.ErrNotFound, "task of job %q", jobId)
+	}
+	return toTask(p.Content[0]), nil
+}
+
+// startNextCustomTask gets the next pending task and makes it IN_PROGRESS, the task is returned as is if it's already
+func (r *runnerImpl) startNextCustomTask(thingId string, p TStartNextPendingTaskReq) (Task, error) {
+	t := r.nextCustomTask(thingId)
+	if t == nil {
+		return Task{}, errors.WithMessage(model.ErrNotFound, "no pending task")
+	}
+	if t.Status == TaskInProgress {
+		return *t, nil
+	}
+	st := *t
+	toUpdate := map[string]any{"status": TaskInProgress, "started_at": time.Now()}
+	if p.StatusDetails != nil {
+		buf, err := json.Marshal(p.StatusDetails)
+		if err != nil {
+			return Task{}, errors.WithMessage(model.ErrInvalidParams, "statusDetails: "+err.Error())
+		}
+		toUpdate["status_details"] = buf
+	}
+	if err := r.updateCustomTask(&st, toUpdate); err != nil {
+		return Task{}, err
+	}
+	log.Infof("JobRunner custom operation task started, jobId=%q, taskId=%d, thingId=%q", st.JobId, st.TaskId, thingId)
+	r.notifyThing(thingId, st.TaskId)
+	return st, nil
+}
+
+func (r *runnerImpl) updateCustomTaskByThing(thingId, jobId string, p TUpdateTaskReq) (Task, error) {
+	switch p.Status {
+	case TaskInProgress, TaskSucceeded, TaskFailed, TaskRejected:
+	default:
+		return Task{}, errors.WithMessagef(model.ErrInvalidParams, "status %q", p.Status)
+	}
+	if p.Progress < 0 || p.Progress > 100 {
+		return Task{}, errors.WithMessage(model.ErrInvalidParams, "progress should be in 0-100")
+	}
+	t := r.findCustomTask(thingId, jobId)
+	if t == nil || (p.TaskId != 0 && p.TaskId != t.TaskId) {
+		old, err := r.getCustomTask(thingId, jobId)
+		if err != nil {
+			return Task{}, err
+		}
+		if isTaskTerminal(old.Status) {
+			return Task{}, errors.WithMessagef(model.ErrInvalidStateTransition, "task is terminal at status %q", old.Status)
+		}
+		return Task{}, errors.WithMessagef(model.ErrNotFound, "task %d", p.TaskId)
+	}
+	if p.Version > 0 && p.Version != t.Version {
+		return Task{}, errors.WithMessagef(model.ErrVersionConflict,
+			"current version %d, expect version %d", t.Version, p.Version)
+	}
+
+	prev := r.nextCustomTaskId(thingId)
+	st := *t
+	now := time.Now()
+	toUpdate := map[string]any{"status": p.Status, "progress": uint8(p.Progress)}
+	if p.StatusDetails != nil {
+		buf, err := json.Marshal(p.StatusDetails)
+		if err != nil {
+			return Task{}, errors.WithMessage(model.ErrInvalidParams, "statusDetails: "+err.Error())
+		}
+		toUpdate["status_details"] = buf
+	}
+	if st.StartedAt == nil {
+		toUpdate["started_at"] = now
+	}
+	if isTaskTerminal(p.Status) {
+		toUpdate["completed_at"] = now
+	}
+	if err := r.updateCustomTask(&st, toUpdate); err != nil {
+		return Task{}, err
+	}
+	log.Infof("JobRunner custom operation task updated by thing, jobId=%q, taskId=%d, thingId=%q, status=%q, progress=%d",
+		st.JobId, st.TaskId, thingId, st.Status, st.Progress)
+	if st.Status != t.Status {
+		r.notifyThing(thingId, prev)
+	}
+	return st, nil
+}
+
+// updateCustomTask updates the task with its version, refreshes it in the queue, and notifies center of the change.
+// The task is refreshed from db when the version conflicts, eg. it's canceled by management meanwhile.
+func (r *runnerImpl) updateCustomTask(t *Task, m map[string]any) error {
+	err := r.repo.UpdateTaskOfVersion(r.ctx, t.TaskId, t.Version, m)
+	if err != nil && !errors.Is(err, model.ErrVersionConflict) {
+		return errors.WithMessage(model.ErrInternal, err.Error())
+	}
+	e, gErr := r.repo.GetTask(r.ctx, t.TaskId)
+	if gErr != nil {
+		return errors.WithMessage(model.ErrInternal, gErr.Error())
+	}
+	q := r.thingTaskQueues[t.ThingId]
+	if q != nil {
+		q.RemoveById(t.TaskId)
+	}
+	if e == nil {
+		return errors.WithMessagef(model.ErrNotFound, "task %d", t.TaskId)
+	}
+	nt := toTask(*e)
+	if q != nil && !isTaskTerminal(nt.Status) {
+		q.Push(&nt)
+	} else if q != nil && q.Size() == 0 {
+		delete(r.thingTaskQueues, t.ThingId)
+	}
+	if err != nil {
+		*t = nt
+		return err
+	}
+	r.notifyTaskChange(TaskChangeMsg{Task: *t, Status: nt.Status, StatusDetails: nt.StatusDetails, Progress: nt.Progress})
+	*t = nt
+	return nil
+}
+
+func (r *runnerImpl) customTasksOf(thingId string) []Task {
+	q, ok := r.thingTaskQueues[thingId]
+	if !ok {
+		return nil
+	}
+	l := q.GetTasks()
+	slices.SortFunc(l, func(a, b Task) int {
+		if taskLess(&a, &b) {
+			return -1
+		}
+		return 1
+	})
+	return l
+}
+
+func (r *runnerImpl) findCustomTask(thingId, jobId string) *Task {
+	for _, t := range r.customTasksOf(thingId) {
+		if t.JobId == jobId {
+			return &t
+		}
+	}
+	return nil
+}
+
+func (r *runnerImpl) nextCustomTask(thingId string) *Task {
+	q, ok := r.thingTaskQueues[thingId]
+	if !ok || q.Size() == 0 {
+		return nil
+	}
+	t := *q.Peek()
+	return &t
+}
+
+func (r *runnerImpl) nextCustomTaskId(thingId string) int64 {
+	if t := r.nextCustomTask(thingId); t != nil {
+		return t.TaskId
+	}
+	return 0
+}
+
+// notifyThing publishes pending tasks of the thing, and the next task if it's changed from prevNext,
+// the next task is absent if there is no pending task any more
+func (r *runnerImpl) notifyThing(thingId string, prevNext int64) {
+	l := r.customTasksOf(thingId)
+	n := TTasksNotify{Tasks: make([]TTaskSummary, len(l)), Timestamp: time.Now().UnixMilli()}
+	for i, t := range l {
+		n.Tasks[i] = toTTaskSummary(t)
+	}
+	r.publish(TopicNotify(thingId), n)
+
+	next := r.nextCustomTask(thingId)
+	if next == nil && prevNext != 0 {
+		r.publish(TopicNotifyNext(thingId), TTaskNotifyNext{Timestamp: n.Timestamp})
+	} else if next != nil && next.TaskId != prevNext {
+		tt := r.toTTask(*next, true)
+		r.publish(TopicNotifyNext(thingId), TTaskNotifyNext{Task: &tt, Timestamp: n.Timestamp})
+	}
+}
+
+// notifyTaskChange queues changes of custom operation tasks for center without blocking,
+// since center may call the runner while it's busy, they are sent in order by customTaskChangeLoop
+func (r *runnerImpl) notifyTaskChange(l ...TaskChangeMsg) {
+	if len(l) == 0 {
+		return
+	}
+	r.customTaskChangeMu.Lock()
+	r.customTaskChanges = append(r.customTaskChanges, l...)
+	r.customTaskChangeMu.Unlock()
+	select {
+	case r.customTaskChangeSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (r *runnerImpl) customTaskChangeLoop() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.customTaskChangeSignal:
+		}
+		r.customTaskChangeMu.Lock()
+		l := r.customTaskChanges
+		r.customTaskChanges = nil
+		r.customTaskChangeMu.Unlock()
+		for _, msg := range l {
+			select {
+			case <-r.ctx.Done():
+				return
+			case r.outTaskChangeCh <- msg:
+			}
+		}
+	}
+}
+
+func (r *runnerImpl) publish(topic string, v any) {
+	if r.pubSub == nil {
+		return
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("JobRunner marshal message for topic=%q, error: %v", topic, err)
+		return
+	}
+	if err := r.pubSub.Publish(topic, DefaultQos, false, buf); err != nil {
+		log.Errorf("JobRunner publish topic=%q, error: %v", topic, err)
+	}
+}
+
+// jobDocOf job doc of the task, whose placeholders are resolved for the thing.
+// Placeholders are kept if they can't be resolved now, eg. the tag is removed after the task is sent,
+// and the failure is returned to be reported in statusDetails.
+func (r *runnerImpl) jobDocOf(t Task) (string, error) {
+	var jd map[string]any
+	if jc := r.jcGetter(t.JobId); jc != nil {
+		jd = jc.JobDoc
+	} else {
+		j, err := r.repo.GetJob(r.ctx, t.JobId)
+		if err != nil || j == nil {
+			log.Errorf("JobRunner get job doc, jobId=%q, error: %v", t.JobId, err)
+			return "", nil
+		}
+		if len(j.JobDoc) == 0 {
+			return "", nil
+		}
+		if err := json.Unmarshal(j.JobDoc, &jd); err != nil {
+			log.Errorf("JobRunner unmarshal job doc, jobId=%q, error: %v", t.JobId, err)
+			return string(j.JobDoc), nil
+		}
+	}
+	if jd == nil {
+		return "", nil
+	}
+	res, resolveErr := jobDocOfThing(r.ctx, r.thingQuerier, jd, t.ThingId)
+	if resolveErr != nil {
+		log.Warnf("JobRunner resolve job doc, jobId=%q, thingId=%q, error: %v", t.JobId, t.ThingId, resolveErr)
+	} else {
+		jd = res
+	}
+	buf, err := json.Marshal(jd)
+	if err != nil {
+		log.Errorf("JobRunner marshal job doc, jobId=%q, error: %v", t.JobId, err)
+	}
+	return string(buf), resolveErr
+}
+
+func (r *runnerImpl) toTTask(t Task, withJobDoc bool) TTask {
+	tt := TTask{
+		JobId:         t.JobId,
+		ThingId:       t.ThingId,
+		TaskId:        t.TaskId,
+		Operation:     t.Operation,
+		Status:        t.Status,
+		StatusDetails: t.StatusDetails,
+		Progress:      int(t.Progress),
+		QueuedAt:      t.QueuedAt,
+		StartedAt:     t.StartedAt,
+		UpdatedAt:     t.UpdatedAt,
+		Version:       t.Version,
+	}
+	if withJobDoc {
+		var err error
+		if tt.JobDoc, err = r.jobDocOf(t); err != nil {
+			tt.StatusDetails = withJobDocError(tt.StatusDetails, err)
+		}
+	}
+	return tt
+}
+
+func toTTaskSummary(t Task) TTaskSummary {
+	return TTaskSummary{
+		JobId:     t.JobId,
+		TaskId:    t.TaskId,
+		Operation: t.Operation,
+		QueuedAt:  t.QueuedAt,
+		StartedAt: t.StartedAt,
+		UpdatedAt: t.UpdatedAt,
+		Version:   t.Version,
+	}
+}
+
+func errResp(err error, clientToken string) TErrResp {
+	res := TErrResp{Code: 500, Message: err.Error(), ClientToken: clientToken, Timestamp: time.Now().UnixMilli()}
+	var httpErr model.HttpErr
+	if errors.As(err, &httpErr) {
+		res.Code = httpErr.Code
+	}
+	return res
+}
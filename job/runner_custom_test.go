@@ -0,0 +1,295 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+	mqMock "ruff.io/tio/connector/mqtt/mock"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/shadow"
+)
+
+const customOpX = "upgradeFirmware"
+
+type thingMsgs struct {
+	mu sync.Mutex
+	m  map[string][][]byte // topic => payloads
+}
+
+func (t *thingMsgs) last(topic string, v any) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.m[topic]
+	if len(l) == 0 {
+		return false
+	}
+	return json.Unmarshal(l[len(l)-1], v) == nil
+}
+
+func (t *thingMsgs) count(topic string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.m[topic])
+}
+
+type taskChanges struct {
+	mu sync.Mutex
+	l  []TaskChangeMsg
+}
+
+func (c *taskChanges) statusOf(taskId int64) []TaskStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []TaskStatus
+	for _, m := range c.l {
+		if m.Task.TaskId == taskId {
+			res = append(res, m.Status)
+		}
+	}
+	return res
+}
+
+func prepareCustom(t *testing.T, things []string, timeout *TimeoutConfig) (
+	ctx context.Context, repo Repo, r *runnerImpl, conn *mqMock.AdapterImpl, msgs *thingMsgs, tasks []Task,
+) {
+	ctx, repo, r, conn, msgs, tasks, _ = prepareCustomWithChanges(t, things, timeout)
+	return
+}
+
+func prepareCustomWithChanges(t *testing.T, things []string, timeout *TimeoutConfig) (
+	ctx context.Context, repo Repo, r *runnerImpl, conn *mqMock.AdapterImpl, msgs *thingMsgs, tasks []Task,
+	changes *taskChanges,
+) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(Entity{}, TaskEntity{}))
+	repo = NewRepo(db)
+
+	_, err := repo.CreateJob(ctx, Entity{
+		JobId: "job-custom", Operation: customOpX,
+		TargetConfig: datatypes.JSON(`{}`), JobDoc: datatypes.JSON(`{"version":"1.2.0"}`),
+	})
+	require.NoError(t, err)
+	el, err := repo.CreateTasks(ctx, toTaskEntities("job-custom", customOpX, TargetConfig{Things: things}))
+	require.NoError(t, err)
+	tasks = toTasks(el)
+
+	msgs = &thingMsgs{m: map[string][][]byte{}}
+	mockMqtt := mqMock.NewMqttClient("", nil, nil)
+	mockMqtt.On("Subscribe", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMqtt.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mqMock.NewMockToken())
+	c := mqMock.NewAdapter(mockMqtt)
+	conn = &c
+	_ = mockMqtt.Subscribe(ctx, "$iothub/things/+/jobs/#", 1, func(_ mqtt.Client, m mqtt.Message) {
+		msgs.mu.Lock()
+		defer msgs.mu.Unlock()
+		msgs.m[m.Topic()] = append(msgs.m[m.Topic()], m.Payload())
+	})
+
+	r = NewRunner(repo, conn, conn, nil, nil, nil).(*runnerImpl)
+	r.ctx = ctx
+	r.jcGetter = func(jobId string) *JobContext {
+		return &JobContext{JobId: jobId, Operation: customOpX, JobDoc: map[string]any{"version": "1.2.0"},
+			Status: StatusInProgress, TimeoutConfig: timeout}
+	}
+	// drain task changes for center
+	changes = &taskChanges{}
+	go r.customTaskChangeLoop()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-r.outTaskChangeCh:
+				changes.mu.Lock()
+				changes.l = append(changes.l, m)
+				changes.mu.Unlock()
+			}
+		}
+	}()
+	return
+}
+
+func TestRunner_CustomOperation(t *testing.T) {
+	ctx, repo, r, conn, msgs, tasks, changes := prepareCustomWithChanges(t, []string{"th1", "th2"}, nil)
+	r.subscribeThingReq()
+	go r.customOpTaskLoop(r.customOpTaskCh, r.customOpTaskDelCh, r.thingReqCh)
+	r.PutTasks(customOpX, tasks)
+
+	var next TTaskNotifyNext
+	require.Eventually(t, func() bool {
+		return msgs.last(TopicNotifyNext("th1"), &next)
+	}, time.Second, 10*time.Millisecond, "thing should be notified of the new task")
+	require.Equal(t, TaskSent, next.Task.Status)
+	require.JSONEq(t, `{"version":"1.2.0"}`, next.Task.JobDoc)
+
+	pub := func(topic string, v any) {
+		buf, _ := json.Marshal(v)
+		require.NoError(t, conn.Publish(topic, 1, false, buf))
+	}
+
+	t.Run("get pending tasks", func(t *testing.T) {
+		pub(TopicGetList("th1"), TGetPendingTasksReq{ClientToken: "t1"})
+		var resp TPendingTasksResp
+		require.Eventually(t, func() bool { return msgs.last(TopicGetListAccepted("th1"), &resp) }, time.Second, 10*time.Millisecond)
+		require.Equal(t, "t1", resp.ClientToken)
+		require.Len(t, resp.QueuedTasks, 1)
+		require.Empty(t, resp.InProgressTasks)
+	})
+
+	var started TGetTaskResp
+	t.Run("start next", func(t *testing.T) {
+		pub(TopicStartNext("th1"), TStartNextPendingTaskReq{StatusDetails: StatusDetails{"step": "download"}})
+		require.Eventually(t, func() bool { return msgs.last(TopicStartNextAccepted("th1"), &started) }, time.Second, 10*time.Millisecond)
+		require.Equal(t, TaskInProgress, started.Task.Status)
+		require.NotNil(t, started.Task.StartedAt)
+		require.Equal(t, next.Task.Version+1, started.Task.Version)
+	})
+
+	t.Run("update with version", func(t *testing.T) {
+		pub(TopicUpdate("th1", "job-custom"), TUpdateTaskReq{Status: TaskInProgress, Progress: 50, Version: started.Task.Version - 1})
+		var rej TErrResp
+		require.Eventually(t, func() bool { return msgs.last(TopicUpdateRejected("th1", "job-custom"), &rej) }, time.Second, 10*time.Millisecond)
+		require.Equal(t, 409, rej.Code)
+
+		pub(TopicUpdate("th1", "job-custom"), TUpdateTaskReq{Status: TaskInProgress, Progress: 50,
+			Version: started.Task.Version, IncludeTaskState: true})
+		var acc TUpdateTaskResp
+		require.Eventually(t, func() bool { return msgs.last(TopicUpdateAccepted("th1", "job-custom"), &acc) }, time.Second, 10*time.Millisecond)
+		require.Equal(t, 50, acc.TaskState.Progress)
+		require.Equal(t, started.Task.Version+1, acc.TaskState.Version)
+
+		pub(TopicUpdate("th1", "job-custom"), TUpdateTaskReq{Status: TaskSucceeded, Progress: 100, IncludeJobDoc: true})
+		require.Eventually(t, func() bool { return msgs.count(TopicUpdateAccepted("th1", "job-custom")) == 2 }, time.Second, 10*time.Millisecond)
+		require.True(t, msgs.last(TopicUpdateAccepted("th1", "job-custom"), &acc))
+		require.JSONEq(t, `{"version":"1.2.0"}`, acc.JobDoc)
+	})
+
+	t.Run("update terminal task", func(t *testing.T) {
+		pub(TopicUpdate("th1", "job-custom"), TUpdateTaskReq{Status: TaskFailed})
+		var rej TErrResp
+		require.Eventually(t, func() bool {
+			return msgs.count(TopicUpdateRejected("th1", "job-custom")) == 2 && msgs.last(TopicUpdateRejected("th1", "job-custom"), &rej)
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, 409, rej.Code)
+
+		pub(TopicGet("th1", "job-custom"), TGetTaskReq{ClientToken: "t2"})
+		var resp TGetTaskResp
+		require.Eventually(t, func() bool { return msgs.last(TopicGetAccepted("th1", "job-custom"), &resp) }, time.Second, 10*time.Millisecond)
+		require.Equal(t, TaskSucceeded, resp.Task.Status, "result of done task can be got")
+
+		var drained TTaskNotifyNext
+		require.True(t, msgs.last(TopicNotifyNext("th1"), &drained))
+		require.Nil(t, drained.Task, "thing should be notified that no task is pending")
+	})
+
+	t.Run("task changes in order", func(t *testing.T) {
+		want := []TaskStatus{TaskSent, TaskInProgress, TaskInProgress, TaskSucceeded}
+		require.Eventually(t, func() bool { return len(changes.statusOf(tasks[0].TaskId)) == len(want) },
+			time.Second, 10*time.Millisecond)
+		require.Equal(t, want, changes.statusOf(tasks[0].TaskId))
+	})
+
+	t.Run("cancel job", func(t *testing.T) {
+		r.CancelTaskOfJob("job-custom", customOpX, false)
+		require.Eventually(t, func() bool { return len(r.GetPendingTasksOfCustom()) == 0 }, time.Second, 10*time.Millisecond)
+		tsc, err := repo.CountTaskStatus(ctx, "job-custom")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []TaskStatusCount{{TaskSucceeded, 1}, {TaskCanceled, 1}}, tsc)
+	})
+}
+
+func TestRunner_CustomOperationTimeout(t *testing.T) {
+	ctx, repo, r, _, _, tasks := prepareCustom(t, []string{"th1"}, &TimeoutConfig{InProgressMinutes: 1})
+	r.addCustomTasks(tasks)
+	st, err := r.startNextCustomTask("th1", TStartNextPendingTaskReq{})
+	require.NoError(t, err)
+
+	r.checkCustomTaskTimeout(time.Now().Add(30 * time.Second))
+	require.NotNil(t, r.nextCustomTask("th1"))
+
+	r.checkCustomTaskTimeout(time.Now().Add(time.Minute))
+	require.Nil(t, r.nextCustomTask("th1"), "timed out task should be removed")
+	e, err := repo.GetTask(ctx, st.TaskId)
+	require.NoError(t, err)
+	require.Equal(t, TaskTimeOut, e.Status)
+	require.NotNil(t, e.CompletedAt)
+}
+
+func TestRunner_CustomOperationJobDocPlaceholders(t *testing.T) {
+	_, _, r, conn, msgs, tasks, changes := prepareCustomWithChanges(t, []string{"th1", "th2", "th3"}, nil)
+	r.jcGetter = func(jobId string) *JobContext {
+		return &JobContext{JobId: jobId, Operation: customOpX, JobDoc: map[string]any{"url": "${tags.region}"},
+			Status: StatusInProgress}
+	}
+	var finds atomic.Int32
+	var tagRemoved atomic.Bool
+	r.thingQuerier = thingFinderFunc(func(f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error) {
+		finds.Add(1)
+		var l []shadow.ShadowWithStatus
+		for _, id := range f.ThingIds {
+			s := shadow.ShadowWithStatus{Shadow: shadow.Shadow{ThingId: id}}
+			if id == "th1" && !tagRemoved.Load() {
+				s.Tags = shadow.TagsValue{"region": "eu-west"}
+			}
+			if id != "th3" {
+				l = append(l, s)
+			}
+		}
+		return l, nil
+	})
+	r.subscribeThingReq()
+	go r.customOpTaskLoop(r.customOpTaskCh, r.customOpTaskDelCh, r.thingReqCh)
+	r.PutTasks(customOpX, tasks)
+
+	var next TTaskNotifyNext
+	require.Eventually(t, func() bool {
+		return msgs.last(TopicNotifyNext("th1"), &next)
+	}, time.Second, 10*time.Millisecond)
+	require.JSONEq(t, `{"url":"eu-west"}`, next.Task.JobDoc)
+	for _, tk := range tasks[1:] {
+		require.Eventually(t, func() bool {
+			return slices.Contains(changes.statusOf(tk.TaskId), TaskFailed)
+		}, time.Second, 10*time.Millisecond, "task of thing %q fails", tk.ThingId)
+	}
+	require.Equal(t, int32(2), finds.Load(), "things are found in one batch, and once for notifying th1")
+
+	t.Run("report failure of fetching", func(t *testing.T) {
+		tagRemoved.Store(true)
+		buf, _ := json.Marshal(TGetTaskReq{IncludeJobDoc: true, ClientToken: "t1"})
+		require.NoError(t, conn.Publish(TopicGet("th1", NextJobId), 1, false, buf))
+		var resp TGetTaskResp
+		require.Eventually(t, func() bool {
+			return msgs.last(TopicGetAccepted("th1", NextJobId), &resp)
+		}, time.Second, 10*time.Millisecond)
+		require.JSONEq(t, `{"url":"${tags.region}"}`, resp.Task.JobDoc)
+		require.Contains(t, resp.Task.StatusDetails[statusDetailsKeyJobDocError], "${tags.region}")
+	})
+}
+
+func TestRunner_UpdateTaskStatusCompletedAt(t *testing.T) {
+	ctx, repo, r, _, _, tasks := prepareCustom(t, []string{"th1"}, nil)
+	require.NoError(t, r.updateTaskStatus(TaskChangeMsg{Task: tasks[0], Status: TaskSucceeded, Progress: 100}))
+	e, err := repo.GetTask(ctx, tasks[0].TaskId)
+	require.NoError(t, err)
+	require.Equal(t, TaskSucceeded, e.Status)
+	require.NotNil(t, e.CompletedAt, "completedAt is set when the task becomes terminal")
+}
+
+func TestRunner_UpdateTaskStatusGetTaskError(t *testing.T) {
+	ctx, _, r, _, _, tasks := prepareCustom(t, []string{"th1"}, nil)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	r.ctx = canceled
+	require.ErrorIs(t, r.updateTaskStatus(TaskChangeMsg{Task: tasks[0], Status: TaskSucceeded}), context.Canceled)
+}
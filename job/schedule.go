@@ -0,0 +1,566 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"gorm.io/datatypes"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/pkg/strutil"
+)
+
+// Job schedule creates a new job from its job template at every activation of the cron,
+// the jobs created are recorded as runs of the schedule.
+
+type ScheduleStatus string
+
+const (
+	ScheduleEnabled ScheduleStatus = "ENABLED"
+	SchedulePaused  ScheduleStatus = "PAUSED"
+)
+
+type ScheduleRunResult string
+
+const (
+	ScheduleRunCreated ScheduleRunResult = "CREATED" // job is created
+	ScheduleRunSkipped ScheduleRunResult = "SKIPPED" // running jobs of the schedule reach the max concurrency
+	ScheduleRunFailed  ScheduleRunResult = "FAILED"  // failed to create the job
+)
+
+const (
+	defaultScheduleCheckInterval = time.Second * 10
+	maxScheduleConcurrency       = 100
+	// scheduleJobIdTimeLayout jobId of a run is the scheduleId with the suffix of the activation time and a random string
+	scheduleJobIdTimeLayout = "200601021504"
+	scheduleJobIdRandLen    = 6
+	maxScheduleIdLen        = 40
+)
+
+// scheduleId is part of the jobId of runs, so its length leaves room for the suffix
+var scheduleIdRegexp = regexp.MustCompile(fmt.Sprintf("^[0-9a-zA-Z_-]{1,%d}$", maxScheduleIdLen))
+
+type ScheduleCreateReq struct {
+	ScheduleId  string `json:"scheduleId"`
+	Description string `json:"description" optional:"true"`
+	// Cron 5-field cron expression or descriptor, e.g. "0 2 * * *" for 02:00 every day, or "@daily"
+	Cron     string `json:"cron"`
+	TimeZone string `json:"timeZone" optional:"true"` // optional, IANA time zone of the cron, UTC by default
+	// JobTemplate jobs are created from, jobId and schedulingConfig are not allowed
+	JobTemplate CreateReq `json:"jobTemplate"`
+	// MaxConcurrency optional, max number of running jobs created by the schedule, 1 by default,
+	// the run is skipped when it's reached
+	MaxConcurrency int            `json:"maxConcurrency" optional:"true"`
+	Status         ScheduleStatus `json:"status" optional:"true" enum:"ENABLED|PAUSED"` // optional, "ENABLED" by default
+}
+
+type ScheduleUpdateReq struct {
+	Description    *string    `json:"description" optional:"true"`
+	Cron           *string    `json:"cron" optional:"true"`
+	TimeZone       *string    `json:"timeZone" optional:"true"`
+	JobTemplate    *CreateReq `json:"jobTemplate" optional:"true"`
+	MaxConcurrency *int       `json:"maxConcurrency" optional:"true"`
+}
+
+type Schedule struct {
+	ScheduleId     string         `json:"scheduleId"`
+	Description    string         `json:"description"`
+	Cron           string         `json:"cron"`
+	TimeZone       string         `json:"timeZone"`
+	JobTemplate    CreateReq      `json:"jobTemplate"`
+	MaxConcurrency int            `json:"maxConcurrency"`
+	Status         ScheduleStatus `json:"status" enum:"ENABLED|PAUSED"`
+	NextRunAt      *int64         `json:"nextRunAt"` // activation time of the next run, empty when paused
+	LastRun        *ScheduleRun   `json:"lastRun"`
+	UpdatedAt      int64          `json:"updatedAt"`
+	CreatedAt      int64          `json:"createdAt"`
+}
+
+type ScheduleRun struct {
+	ScheduleId  string            `json:"scheduleId"`
+	ScheduledAt int64             `json:"scheduledAt"` // activation time of the cron
+	Result      ScheduleRunResult `json:"result" enum:"CREATED|SKIPPED|FAILED"`
+	JobId       string            `json:"jobId"` // created job, empty when skipped or failed
+	Message     string            `json:"message"`
+	CreatedAt   int64             `json:"createdAt"`
+}
+
+type SchedulePage model.PageData[Schedule]
+type ScheduleRunPage model.PageData[ScheduleRun]
+
+type ScheduleService interface {
+	// Start runs schedules in the background until ctx is done
+	Start(ctx context.Context)
+
+	CreateSchedule(ctx context.Context, r ScheduleCreateReq) (Schedule, error)
+	// UpdateSchedule the next run is rescheduled when cron or time zone changes,
+	// the updated job template takes effect for only later runs
+	UpdateSchedule(ctx context.Context, scheduleId string, r ScheduleUpdateReq) (Schedule, error)
+	// DeleteSchedule deletes the schedule and its run history, jobs created are kept
+	DeleteSchedule(ctx context.Context, scheduleId string) error
+	GetSchedule(ctx context.Context, scheduleId string) (Schedule, error)
+	QuerySchedule(ctx context.Context, pq model.PageQuery) (SchedulePage, error)
+
+	// PauseSchedule stops creating jobs, jobs created keep running
+	PauseSchedule(ctx context.Context, scheduleId string) error
+	// ResumeSchedule creates jobs from the next activation after now, runs missed while paused are not made up
+	ResumeSchedule(ctx context.Context, scheduleId string) error
+
+	// QueryScheduleRun history of runs, latest first
+	QueryScheduleRun(ctx context.Context, scheduleId string, pq model.PageQuery) (ScheduleRunPage, error)
+}
+
+type ScheduleRepo interface {
+	CreateSchedule(ctx context.Context, e ScheduleEntity) error
+	UpdateSchedule(ctx context.Context, scheduleId string, m map[string]any) error
+	// UpdateScheduleNextRun sets the next run time if it's still at the previous value,
+	// returns false if it has been changed by others
+	UpdateScheduleNextRun(ctx context.Context, scheduleId string, prev time.Time, next *time.Time) (bool, error)
+	DeleteSchedule(ctx context.Context, scheduleId string) error
+	GetSchedule(ctx context.Context, scheduleId string) (*ScheduleEntity, error)
+	QuerySchedule(ctx context.Context, pq model.PageQuery) (model.PageData[ScheduleEntity], error)
+	// GetDueSchedules returns enabled schedules whose next run time is not after now
+	GetDueSchedules(ctx context.Context, now time.Time) ([]ScheduleEntity, error)
+
+	CreateScheduleRun(ctx context.Context, e ScheduleRunEntity) error
+	GetLastScheduleRun(ctx context.Context, scheduleId string) (*ScheduleRunEntity, error)
+	QueryScheduleRun(ctx context.Context, scheduleId string, pq model.PageQuery) (model.PageData[ScheduleRunEntity], error)
+	// CountRunningJobs counts jobs created by the schedule which are not terminal yet
+	CountRunningJobs(ctx context.Context, scheduleId string) (int64, error)
+}
+
+type ScheduleEntity struct {
+	ScheduleId     string         `gorm:"primaryKey;size:64"`
+	Description    string         `gorm:"size:256; NOT NULL; default: ''"`
+	Cron           string         `gorm:"size:128; NOT NULL"`
+	TimeZone       string         `gorm:"size:64; NOT NULL; default: ''"`
+	JobTemplate    datatypes.JSON `gorm:"NOT NULL"`
+	MaxConcurrency int            `gorm:"NOT NULL; default: 1"`
+	Status         ScheduleStatus `gorm:"size:16; NOT NULL; default: ENABLED"`
+	NextRunAt      *time.Time     `gorm:"index"`
+	UpdatedAt      time.Time      `gorm:"autoUpdateTime; NOT NULL"`
+	CreatedAt      time.Time      `gorm:"autoCreateTime; NOT NULL"`
+}
+
+func (ScheduleEntity) TableName() string {
+	return "job_schedule"
+}
+
+type ScheduleRunEntity struct {
+	Id          int64             `gorm:"primaryKey;autoIncrement"`
+	ScheduleId  string            `gorm:"size:64; NOT NULL; index"`
+	ScheduledAt time.Time         `gorm:"NOT NULL"`
+	Result      ScheduleRunResult `gorm:"size:16; NOT NULL"`
+	JobId       string            `gorm:"size:64; NOT NULL; default: ''"`
+	Message     string            `gorm:"size:256; NOT NULL; default: ''"`
+	CreatedAt   time.Time         `gorm:"autoCreateTime; NOT NULL"`
+}
+
+func (ScheduleRunEntity) TableName() string {
+	return "job_schedule_run"
+}
+
+type ScheduleOptions struct {
+	CheckInterval time.Duration // how often due schedules are checked, 10s by default
+}
+
+func NewScheduleSvc(r ScheduleRepo, mgrSvc MgrService, opt ScheduleOptions) ScheduleService {
+	if opt.CheckInterval <= 0 {
+		opt.CheckInterval = defaultScheduleCheckInterval
+	}
+	return &scheduleSvc{repo: r, mgrSvc: mgrSvc, opt: opt}
+}
+
+var _ ScheduleService = &scheduleSvc{}
+
+type scheduleSvc struct {
+	repo   ScheduleRepo
+	mgrSvc MgrService
+	opt    ScheduleOptions
+}
+
+func (s *scheduleSvc) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.opt.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Infof("Job schedule loop exit")
+				return
+			case now := <-ticker.C:
+				s.runDueSchedules(ctx, now)
+			}
+		}
+	}()
+}
+
+func (s *scheduleSvc) CreateSchedule(ctx context.Context, r ScheduleCreateReq) (Schedule, error) {
+	if r.MaxConcurrency == 0 {
+		r.MaxConcurrency = 1
+	}
+	if r.Status == "" {
+		r.Status = ScheduleEnabled
+	}
+	if err := r.valid(); err != nil {
+		return Schedule{}, err
+	}
+	tpl, err := json.Marshal(r.JobTemplate)
+	if err != nil {
+		return Schedule{}, errors.WithMessage(model.ErrInvalidParams, "field jobTemplate: "+err.Error())
+	}
+	e := ScheduleEntity{
+		ScheduleId:     r.ScheduleId,
+		Description:    r.Description,
+		Cron:           r.Cron,
+		TimeZone:       r.TimeZone,
+		JobTemplate:    tpl,
+		MaxConcurrency: r.MaxConcurrency,
+		Status:         r.Status,
+	}
+	if e.Status == ScheduleEnabled {
+		e.NextRunAt = nextRunAt(e.Cron, e.TimeZone, time.Now())
+	}
+	if err := s.repo.CreateSchedule(ctx, e); err != nil {
+		return Schedule{}, err
+	}
+	log.Infof("Created job schedule %q, cron=%q", r.ScheduleId, r.Cron)
+	return s.GetSchedule(ctx, r.ScheduleId)
+}
+
+func (s *scheduleSvc) UpdateSchedule(ctx context.Context, scheduleId string, r ScheduleUpdateReq) (Schedule, error) {
+	e, err := s.getEntity(ctx, scheduleId)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if err := r.valid(e); err != nil {
+		return Schedule{}, err
+	}
+	toUpdate := map[string]any{}
+	if r.Description != nil {
+		toUpdate["description"] = *r.Description
+	}
+	if r.Cron != nil {
+		toUpdate["cron"] = *r.Cron
+		e.Cron = *r.Cron
+	}
+	if r.TimeZone != nil {
+		toUpdate["time_zone"] = *r.TimeZone
+		e.TimeZone = *r.TimeZone
+	}
+	if r.JobTemplate != nil {
+		if buf, err := json.Marshal(*r.JobTemplate); err == nil {
+			toUpdate["job_template"] = datatypes.JSON(buf)
+		} else {
+			return Schedule{}, errors.WithMessage(model.ErrInvalidParams, "field jobTemplate: "+err.Error())
+		}
+	}
+	if r.MaxConcurrency != nil {
+		toUpdate["max_concurrency"] = *r.MaxConcurrency
+	}
+	if (r.Cron != nil || r.TimeZone != nil) && e.Status == ScheduleEnabled {
+		toUpdate["next_run_at"] = nextRunAt(e.Cron, e.TimeZone, time.Now())
+	}
+	if len(toUpdate) > 0 {
+		if err := s.repo.UpdateSchedule(ctx, scheduleId, toUpdate); err != nil {
+			return Schedule{}, err
+		}
+	}
+	return s.GetSchedule(ctx, scheduleId)
+}
+
+func (s *scheduleSvc) DeleteSchedule(ctx context.Context, scheduleId string) error {
+	if _, err := s.getEntity(ctx, scheduleId); err != nil {
+		return err
+	}
+	if err := s.repo.DeleteSchedule(ctx, scheduleId); err != nil {
+		return err
+	}
+	log.Infof("Deleted job schedule %q", scheduleId)
+	return nil
+}
+
+func (s *scheduleSvc) GetSchedule(ctx context.Context, scheduleId string) (Schedule, error) {
+	e, err := s.getEntity(ctx, scheduleId)
+	if err != nil {
+		return Schedule{}, err
+	}
+	last, err := s.repo.GetLastScheduleRun(ctx, scheduleId)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return toSchedule(*e, last)
+}
+
+func (s *scheduleSvc) QuerySchedule(ctx context.Context, pq model.PageQuery) (SchedulePage, error) {
+	p, err := s.repo.QuerySchedule(ctx, pq)
+	if err != nil {
+		return SchedulePage{}, err
+	}
+	l := make([]Schedule, 0, len(p.Content))
+	for _, e := range p.Content {
+		sc, err := toSchedule(e, nil)
+		if err != nil {
+			return SchedulePage{}, err
+		}
+		l = append(l, sc)
+	}
+	return SchedulePage{Total: p.Total, Content: l}, nil
+}
+
+func (s *scheduleSvc) PauseSchedule(ctx context.Context, scheduleId string) error {
+	e, err := s.getEntity(ctx, scheduleId)
+	if err != nil {
+		return err
+	}
+	if e.Status == SchedulePaused {
+		return nil
+	}
+	if err := s.repo.UpdateSchedule(ctx, scheduleId, map[string]any{
+		"status": SchedulePaused, "next_run_at": nil,
+	}); err != nil {
+		return err
+	}
+	log.Infof("Paused job schedule %q", scheduleId)
+	return nil
+}
+
+func (s *scheduleSvc) ResumeSchedule(ctx context.Context, scheduleId string) error {
+	e, err := s.getEntity(ctx, scheduleId)
+	if err != nil {
+		return err
+	}
+	if e.Status == ScheduleEnabled {
+		return nil
+	}
+	if err := s.repo.UpdateSchedule(ctx, scheduleId, map[string]any{
+		"status": ScheduleEnabled, "next_run_at": nextRunAt(e.Cron, e.TimeZone, time.Now()),
+	}); err != nil {
+		return err
+	}
+	log.Infof("Resumed job schedule %q", scheduleId)
+	return nil
+}
+
+func (s *scheduleSvc) QueryScheduleRun(ctx context.Context, scheduleId string, pq model.PageQuery) (ScheduleRunPage, error) {
+	if _, err := s.getEntity(ctx, scheduleId); err != nil {
+		return ScheduleRunPage{}, err
+	}
+	p, err := s.repo.QueryScheduleRun(ctx, scheduleId, pq)
+	if err != nil {
+		return ScheduleRunPage{}, err
+	}
+	l := make([]ScheduleRun, 0, len(p.Content))
+	for _, e := range p.Content {
+		l = append(l, toScheduleRun(e))
+	}
+	return ScheduleRunPage{Total: p.Total, Content: l}, nil
+}
+
+func (s *scheduleSvc) getEntity(ctx context.Context, scheduleId string) (*ScheduleEntity, error) {
+	e, err := s.repo.GetSchedule(ctx, scheduleId)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, errors.WithMessagef(model.ErrNotFound, "job schedule %q", scheduleId)
+	}
+	return e, nil
+}
+
+func (s *scheduleSvc) runDueSchedules(ctx context.Context, now time.Time) {
+	l, err := s.repo.GetDueSchedules(ctx, now)
+	if err != nil {
+		log.Errorf("Job schedule get due schedules error: %v", err)
+		return
+	}
+	for _, e := range l {
+		s.run(ctx, e, now)
+	}
+}
+
+// run creates a job for the due schedule, runs missed while the service is down are merged into one
+func (s *scheduleSvc) run(ctx context.Context, e ScheduleEntity, now time.Time) {
+	scheduledAt := *e.NextRunAt
+	// claim the run by moving the next run time forward, so that it's run only once among instances
+	if ok, err := s.repo.UpdateScheduleNextRun(ctx, e.ScheduleId, scheduledAt, nextRunAt(e.Cron, e.TimeZone, now)); err != nil {
+		log.Errorf("Job schedule update next run time, scheduleId=%q, error: %v", e.ScheduleId, err)
+		return
+	} else if !ok {
+		return
+	}
+
+	r := ScheduleRunEntity{ScheduleId: e.ScheduleId, ScheduledAt: scheduledAt}
+	if n, err := s.repo.CountRunningJobs(ctx, e.ScheduleId); err != nil {
+		r.Result = ScheduleRunFailed
+		r.Message = "count running jobs: " + err.Error()
+	} else if n >= int64(e.MaxConcurrency) {
+		r.Result = ScheduleRunSkipped
+		r.Message = fmt.Sprintf("%d running jobs reach the max concurrency", n)
+	} else {
+		var tpl CreateReq
+		if err := json.Unmarshal(e.JobTemplate, &tpl); err != nil {
+			r.Result = ScheduleRunFailed
+			r.Message = "invalid job template: " + err.Error()
+		} else {
+			tpl.JobId = scheduleJobId(e.ScheduleId, scheduledAt)
+			if d, err := s.mgrSvc.CreateJob(ctx, tpl); err != nil {
+				r.Result = ScheduleRunFailed
+				r.Message = err.Error()
+			} else {
+				r.Result = ScheduleRunCreated
+				r.JobId = d.JobId
+			}
+		}
+	}
+	r.Message = strutil.Cut(r.Message, 250)
+	if r.Result == ScheduleRunCreated {
+		log.Infof("Job schedule created job, scheduleId=%q, jobId=%q", e.ScheduleId, r.JobId)
+	} else {
+		log.Warnf("Job schedule run %s, scheduleId=%q, scheduledAt=%v, %s", r.Result, e.ScheduleId, scheduledAt, r.Message)
+	}
+	if err := s.repo.CreateScheduleRun(ctx, r); err != nil {
+		log.Errorf("Job schedule save run, scheduleId=%q, error: %v", e.ScheduleId, err)
+	}
+}
+
+// scheduleJobId jobId of the run, like "nightly-202405011800-1a2b3c".
+// The random suffix keeps it unique when the schedule is recreated, or a job of the id is created by others.
+func scheduleJobId(scheduleId string, scheduledAt time.Time) string {
+	rnd := strings.ReplaceAll(uuid.Must(uuid.NewV4()).String(), "-", "")[:scheduleJobIdRandLen]
+	return scheduleId + "-" + scheduledAt.UTC().Format(scheduleJobIdTimeLayout) + "-" + rnd
+}
+
+// nextRunAt the first activation of the cron after t, nil if the cron is invalid or never activates
+func nextRunAt(expr, timeZone string, t time.Time) *time.Time {
+	sc, loc, err := parseCron("cron", expr, timeZone)
+	if err != nil {
+		return nil
+	}
+	n := sc.Next(t.In(loc))
+	if n.IsZero() {
+		return nil
+	}
+	return &n
+}
+
+func (r ScheduleCreateReq) valid() error {
+	if !scheduleIdRegexp.MatchString(r.ScheduleId) {
+		return errors.WithMessage(model.ErrInvalidParams, "field `scheduleId` should match regex: "+scheduleIdRegexp.String())
+	}
+	if len(r.Description) > 250 {
+		return errors.WithMessage(model.ErrInvalidParams, "field `description` length should be less than 250")
+	}
+	if r.Status != ScheduleEnabled && r.Status != SchedulePaused {
+		return errors.WithMessagef(model.ErrInvalidParams, "field `status` should be %q or %q", ScheduleEnabled, SchedulePaused)
+	}
+	if err := validCron(r.Cron, r.TimeZone); err != nil {
+		return err
+	}
+	if err := validMaxConcurrency(r.MaxConcurrency); err != nil {
+		return err
+	}
+	return validJobTemplate(r.JobTemplate)
+}
+
+func (r ScheduleUpdateReq) valid(e *ScheduleEntity) error {
+	if r.Description != nil && len(*r.Description) > 250 {
+		return errors.WithMessage(model.ErrInvalidParams, "field `description` length should be less than 250")
+	}
+	cr, tz := e.Cron, e.TimeZone
+	if r.Cron != nil {
+		cr = *r.Cron
+	}
+	if r.TimeZone != nil {
+		tz = *r.TimeZone
+	}
+	if err := validCron(cr, tz); err != nil {
+		return err
+	}
+	if r.MaxConcurrency != nil {
+		if err := validMaxConcurrency(*r.MaxConcurrency); err != nil {
+			return err
+		}
+	}
+	if r.JobTemplate != nil {
+		return validJobTemplate(*r.JobTemplate)
+	}
+	return nil
+}
+
+func validCron(cr, timeZone string) error {
+	sc, loc, err := parseCron("cron", cr, timeZone)
+	if err != nil {
+		return errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	if sc.Next(time.Now().In(loc)).IsZero() {
+		return errors.WithMessagef(model.ErrInvalidParams, "cron %q never activates", cr)
+	}
+	return nil
+}
+
+func validMaxConcurrency(n int) error {
+	if n < 1 || n > maxScheduleConcurrency {
+		return errors.WithMessagef(model.ErrInvalidParams,
+			"field `maxConcurrency` should between 1 and %d", maxScheduleConcurrency)
+	}
+	return nil
+}
+
+func validJobTemplate(tpl CreateReq) error {
+	if tpl.JobId != "" {
+		return errors.WithMessage(model.ErrInvalidParams, "jobTemplate jobId is generated for each run, it should be empty")
+	}
+	if tpl.SchedulingConfig != nil {
+		return errors.WithMessage(model.ErrInvalidParams, "jobTemplate schedulingConfig is not supported")
+	}
+	// fields given by the referenced job template are validated when jobs are created
+	if tpl.JobTemplateId != "" {
+		return nil
+	}
+	if err := tpl.valid(); err != nil {
+		return errors.WithMessage(err, "jobTemplate")
+	}
+	return nil
+}
+
+func toSchedule(e ScheduleEntity, last *ScheduleRunEntity) (Schedule, error) {
+	var tpl CreateReq
+	if err := json.Unmarshal(e.JobTemplate, &tpl); err != nil {
+		return Schedule{}, errors.WithMessage(model.ErrInternal, "field jobTemplate in db")
+	}
+	s := Schedule{
+		ScheduleId:     e.ScheduleId,
+		Description:    e.Description,
+		Cron:           e.Cron,
+		TimeZone:       e.TimeZone,
+		JobTemplate:    tpl,
+		MaxConcurrency: e.MaxConcurrency,
+		Status:         e.Status,
+		NextRunAt:      timeToMs(e.NextRunAt),
+		UpdatedAt:      e.UpdatedAt.UnixMilli(),
+		CreatedAt:      e.CreatedAt.UnixMilli(),
+	}
+	if last != nil {
+		r := toScheduleRun(*last)
+		s.LastRun = &r
+	}
+	return s, nil
+}
+
+func toScheduleRun(e ScheduleRunEntity) ScheduleRun {
+	return ScheduleRun{
+		ScheduleId:  e.ScheduleId,
+		ScheduledAt: e.ScheduledAt.UnixMilli(),
+		Result:      e.Result,
+		JobId:       e.JobId,
+		Message:     e.Message,
+		CreatedAt:   e.CreatedAt.UnixMilli(),
+	}
+}
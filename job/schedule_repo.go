@@ -0,0 +1,129 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type scheduleRepo struct {
+	db *gorm.DB
+}
+
+func NewScheduleRepo(db *gorm.DB) ScheduleRepo {
+	return scheduleRepo{db}
+}
+
+func (r scheduleRepo) CreateSchedule(ctx context.Context, e ScheduleEntity) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&ScheduleEntity{}).Where("schedule_id=?", e.ScheduleId).Count(&n).Error; err != nil {
+			return errors.Wrap(err, "count job schedule")
+		}
+		if n > 0 {
+			return errors.WithMessagef(model.ErrDuplicated, "job schedule %q", e.ScheduleId)
+		}
+		return errors.Wrap(tx.Create(&e).Error, "create job schedule")
+	})
+}
+
+func (r scheduleRepo) UpdateSchedule(ctx context.Context, scheduleId string, m map[string]any) error {
+	err := r.db.WithContext(ctx).Model(&ScheduleEntity{}).Where("schedule_id=?", scheduleId).Updates(m).Error
+	return errors.Wrap(err, "update job schedule")
+}
+
+func (r scheduleRepo) UpdateScheduleNextRun(ctx context.Context, scheduleId string, prev time.Time, next *time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&ScheduleEntity{}).
+		Where("schedule_id=? AND status=? AND next_run_at=?", scheduleId, ScheduleEnabled, prev).
+		Update("next_run_at", next)
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "update job schedule next run")
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (r scheduleRepo) DeleteSchedule(ctx context.Context, scheduleId string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("schedule_id=?", scheduleId).Delete(&ScheduleRunEntity{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("schedule_id=?", scheduleId).Delete(&ScheduleEntity{}).Error
+	})
+	return errors.Wrap(err, "delete job schedule")
+}
+
+func (r scheduleRepo) GetSchedule(ctx context.Context, scheduleId string) (*ScheduleEntity, error) {
+	var e ScheduleEntity
+	if err := r.db.WithContext(ctx).Where("schedule_id=?", scheduleId).First(&e).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get job schedule")
+	}
+	return &e, nil
+}
+
+func (r scheduleRepo) QuerySchedule(ctx context.Context, pq model.PageQuery) (model.PageData[ScheduleEntity], error) {
+	var page model.PageData[ScheduleEntity]
+	q := r.db.WithContext(ctx).Model(&ScheduleEntity{})
+	if err := q.Count(&page.Total).Error; err != nil {
+		return page, errors.Wrap(err, "count job schedule")
+	}
+	if page.Total == 0 {
+		page.Content = []ScheduleEntity{}
+		return page, nil
+	}
+	err := q.Order("created_at ASC").Offset(pq.Offset()).Limit(pq.Limit()).Find(&page.Content).Error
+	return page, errors.Wrap(err, "query job schedule")
+}
+
+func (r scheduleRepo) GetDueSchedules(ctx context.Context, now time.Time) ([]ScheduleEntity, error) {
+	var l []ScheduleEntity
+	err := r.db.WithContext(ctx).
+		Where("status=? AND next_run_at IS NOT NULL AND next_run_at<=?", ScheduleEnabled, now).
+		Find(&l).Error
+	return l, errors.Wrap(err, "get due job schedules")
+}
+
+func (r scheduleRepo) CreateScheduleRun(ctx context.Context, e ScheduleRunEntity) error {
+	return errors.Wrap(r.db.WithContext(ctx).Create(&e).Error, "create job schedule run")
+}
+
+func (r scheduleRepo) GetLastScheduleRun(ctx context.Context, scheduleId string) (*ScheduleRunEntity, error) {
+	var l []ScheduleRunEntity
+	if err := r.db.WithContext(ctx).Where("schedule_id=?", scheduleId).
+		Order("id DESC").Limit(1).Find(&l).Error; err != nil {
+		return nil, errors.Wrap(err, "get last job schedule run")
+	}
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return &l[0], nil
+}
+
+func (r scheduleRepo) QueryScheduleRun(ctx context.Context, scheduleId string, pq model.PageQuery) (model.PageData[ScheduleRunEntity], error) {
+	var page model.PageData[ScheduleRunEntity]
+	q := r.db.WithContext(ctx).Model(&ScheduleRunEntity{}).Where("schedule_id=?", scheduleId)
+	if err := q.Count(&page.Total).Error; err != nil {
+		return page, errors.Wrap(err, "count job schedule run")
+	}
+	if page.Total == 0 {
+		page.Content = []ScheduleRunEntity{}
+		return page, nil
+	}
+	err := q.Order("id DESC").Offset(pq.Offset()).Limit(pq.Limit()).Find(&page.Content).Error
+	return page, errors.Wrap(err, "query job schedule run")
+}
+
+func (r scheduleRepo) CountRunningJobs(ctx context.Context, scheduleId string) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&Entity{}).
+		Joins("JOIN "+ScheduleRunEntity{}.TableName()+" r ON r.job_id = "+Entity{}.TableName()+".job_id").
+		Where("r.schedule_id=? AND r.result=?", scheduleId, ScheduleRunCreated).
+		Where(Entity{}.TableName()+".status IN ?", []Status{StatusWaiting, StatusInProgress, StatusCanceling}).
+		Count(&n).Error
+	return n, errors.Wrap(err, "count running jobs of schedule")
+}
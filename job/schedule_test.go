@@ -0,0 +1,165 @@
+package job_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/job"
+	"ruff.io/tio/job/test"
+	"ruff.io/tio/pkg/model"
+)
+
+func prepareSchedule(t *testing.T) (context.Context, job.ScheduleService, job.ScheduleRepo, job.Repo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(job.ScheduleEntity{}, job.ScheduleRunEntity{}))
+	mockJc := test.NewMockJobCenter()
+	mockJc.On("ReceiveMgrMsg", mock.Anything).Return()
+	mgrSvc, jobRepo := test.NewTestSvcWithDB(db, mockJc)
+	repo := job.NewScheduleRepo(db)
+	svc := job.NewScheduleSvc(repo, mgrSvc, job.ScheduleOptions{CheckInterval: 5 * time.Millisecond})
+	return ctx, svc, repo, jobRepo
+}
+
+func scheduleReq(id string) job.ScheduleCreateReq {
+	return job.ScheduleCreateReq{
+		ScheduleId: id,
+		Cron:       "0 2 * * *",
+		TimeZone:   "Asia/Shanghai",
+		JobTemplate: job.CreateReq{
+			Operation:    "collectLogs",
+			TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"th1"}},
+		},
+	}
+}
+
+func Test_scheduleSvc_Create(t *testing.T) {
+	ctx, svc, _, _ := prepareSchedule(t)
+
+	s, err := svc.CreateSchedule(ctx, scheduleReq("nightly"))
+	require.NoError(t, err)
+	require.Equal(t, job.ScheduleEnabled, s.Status)
+	require.Equal(t, 1, s.MaxConcurrency)
+	require.NotNil(t, s.NextRunAt)
+	next := time.UnixMilli(*s.NextRunAt).UTC()
+	require.Equal(t, 18, next.Hour(), "02:00 in Shanghai")
+	require.True(t, next.After(time.Now()))
+
+	_, err = svc.CreateSchedule(ctx, scheduleReq("nightly"))
+	require.ErrorIs(t, err, model.ErrDuplicated)
+
+	invalid := []func(r *job.ScheduleCreateReq){
+		func(r *job.ScheduleCreateReq) { r.ScheduleId = strings.Repeat("a", 51) },
+		func(r *job.ScheduleCreateReq) { r.Cron = "0 0 2 * * *" },
+		func(r *job.ScheduleCreateReq) { r.TimeZone = "Mars/Olympus" },
+		func(r *job.ScheduleCreateReq) { r.MaxConcurrency = 101 },
+		func(r *job.ScheduleCreateReq) { r.Status = "STOPPED" },
+		func(r *job.ScheduleCreateReq) { r.JobTemplate.JobId = "job-1" },
+		func(r *job.ScheduleCreateReq) { r.JobTemplate.SchedulingConfig = &job.SchedulingConfig{} },
+		func(r *job.ScheduleCreateReq) { r.JobTemplate.Operation = "" },
+	}
+	for i, f := range invalid {
+		r := scheduleReq("invalid")
+		f(&r)
+		_, err := svc.CreateSchedule(ctx, r)
+		require.ErrorIs(t, err, model.ErrInvalidParams, "case %d", i)
+	}
+
+	cr := "*/5 * * * *"
+	s, err = svc.UpdateSchedule(ctx, "nightly", job.ScheduleUpdateReq{Cron: &cr})
+	require.NoError(t, err)
+	require.Equal(t, cr, s.Cron)
+	require.True(t, time.UnixMilli(*s.NextRunAt).Before(next))
+
+	_, err = svc.GetSchedule(ctx, "absent")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	p, err := svc.QuerySchedule(ctx, model.PageQuery{PageIndex: 1, PageSize: 10})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), p.Total)
+
+	require.NoError(t, svc.DeleteSchedule(ctx, "nightly"))
+	require.ErrorIs(t, svc.DeleteSchedule(ctx, "nightly"), model.ErrNotFound)
+}
+
+func Test_scheduleSvc_Run(t *testing.T) {
+	ctx, svc, repo, jobRepo := prepareSchedule(t)
+	_, err := svc.CreateSchedule(ctx, scheduleReq("nightly"))
+	require.NoError(t, err)
+	svc.Start(ctx)
+
+	// makes the schedule due at the time
+	dueAt := func(t *testing.T, at time.Time) {
+		require.NoError(t, repo.UpdateSchedule(ctx, "nightly", map[string]any{"next_run_at": at}))
+	}
+	lastRun := func(t *testing.T, at time.Time) job.ScheduleRun {
+		var s job.Schedule
+		require.Eventually(t, func() bool {
+			var err error
+			s, err = svc.GetSchedule(ctx, "nightly")
+			return err == nil && s.LastRun != nil && s.LastRun.ScheduledAt == at.UnixMilli()
+		}, time.Second, 5*time.Millisecond)
+		require.True(t, time.UnixMilli(*s.NextRunAt).After(time.Now()), "next run is after now")
+		return *s.LastRun
+	}
+
+	first := time.Now().Add(-3 * time.Hour).Truncate(time.Minute)
+	dueAt(t, first)
+	r := lastRun(t, first)
+	require.Equal(t, job.ScheduleRunCreated, r.Result)
+	require.Regexp(t, "^nightly-"+first.UTC().Format("200601021504")+"-[0-9a-f]{6}$", r.JobId)
+	j, err := jobRepo.GetJob(ctx, r.JobId)
+	require.NoError(t, err)
+	require.Equal(t, "collectLogs", j.Operation)
+
+	second := first.Add(time.Hour)
+	dueAt(t, second)
+	r = lastRun(t, second)
+	require.Equal(t, job.ScheduleRunSkipped, r.Result, "the first job is still running")
+
+	require.NoError(t, jobRepo.UpdateJob(ctx, j.JobId, map[string]any{"status": job.StatusCompleted}))
+	third := second.Add(time.Hour)
+	dueAt(t, third)
+	r = lastRun(t, third)
+	require.Equal(t, job.ScheduleRunCreated, r.Result)
+
+	p, err := svc.QueryScheduleRun(ctx, "nightly", model.PageQuery{PageIndex: 1, PageSize: 10})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), p.Total)
+	require.Equal(t, third.UnixMilli(), p.Content[0].ScheduledAt, "latest first")
+
+	t.Run("pause and resume", func(t *testing.T) {
+		require.NoError(t, svc.PauseSchedule(ctx, "nightly"))
+		s, err := svc.GetSchedule(ctx, "nightly")
+		require.NoError(t, err)
+		require.Equal(t, job.SchedulePaused, s.Status)
+		require.Nil(t, s.NextRunAt)
+
+		require.NoError(t, repo.UpdateSchedule(ctx, "nightly", map[string]any{"next_run_at": third.Add(time.Hour)}))
+		time.Sleep(30 * time.Millisecond)
+		p, err := svc.QueryScheduleRun(ctx, "nightly", model.PageQuery{PageIndex: 1, PageSize: 10})
+		require.NoError(t, err)
+		require.Equal(t, int64(3), p.Total, "paused schedule doesn't run")
+
+		require.NoError(t, svc.ResumeSchedule(ctx, "nightly"))
+		s, err = svc.GetSchedule(ctx, "nightly")
+		require.NoError(t, err)
+		require.Equal(t, job.ScheduleEnabled, s.Status)
+		require.True(t, time.UnixMilli(*s.NextRunAt).After(time.Now()), "missed runs are not made up")
+	})
+
+	t.Run("recreate and run at the same time", func(t *testing.T) {
+		require.NoError(t, svc.DeleteSchedule(ctx, "nightly"))
+		_, err := svc.CreateSchedule(ctx, scheduleReq("nightly"))
+		require.NoError(t, err)
+		dueAt(t, third)
+		r := lastRun(t, third)
+		require.Equal(t, job.ScheduleRunCreated, r.Result, r.Message)
+		require.NotEqual(t, p.Content[0].JobId, r.JobId)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"ruff.io/tio"
 	"ruff.io/tio/pkg/model"
 	"ruff.io/tio/pkg/uuid"
+	"ruff.io/tio/shadow"
 )
 
 var idProvider = uuid.New()
@@ -74,6 +75,10 @@ type MgrService interface {
 	QueryTaskForJob(ctx context.Context, jobId string, q TaskPageQuery) (TaskPage, error)
 }
 
+// MethodDefFunc finds definition of the method for things of the type, nil if the method is free to invoke,
+// see shadow.FindMethodDef. It validates job doc of direct method.
+type MethodDefFunc func(ctx context.Context, thingType, method string) (*shadow.MethodDef, error)
+
 type Repo interface {
 	ExecWithTx(func(txRepo Repo) error) error
 
@@ -88,11 +93,18 @@ type Repo interface {
 	QueryJob(ctx context.Context, q PageQuery) (model.PageData[Entity], error)
 
 	GetPendingJobs(ctx context.Context) ([]Entity, error)
+	// GetContinuousJobs returns in progress jobs of target selection "CONTINUOUS"
+	GetContinuousJobs(ctx context.Context) ([]Entity, error)
 
 	// Task API
 
 	CreateTasks(ctx context.Context, l []TaskEntity) ([]TaskEntity, error)
 	UpdateTask(ctx context.Context, taskId int64, m map[string]any) error
+	// UpdateTaskOfVersion updates the task if it's ongoing and at the version, then increases the version,
+	// returns ErrVersionConflict if not
+	UpdateTaskOfVersion(ctx context.Context, taskId int64, version int, m map[string]any) error
+	// UpdateTasksOfStatus updates the tasks which are at one of the status, and increases their versions
+	UpdateTasksOfStatus(ctx context.Context, taskIds []int64, status []TaskStatus, m map[string]any) error
 	CancelTasks(ctx context.Context, jobId string, force bool) error
 	DeleteTask(ctx context.Context, taskId int64) error
 	GetTask(ctx context.Context, taskId int64) (*TaskEntity, error)
@@ -100,29 +112,83 @@ type Repo interface {
 
 	CountTaskStatus(ctx context.Context, jobId string) ([]TaskStatusCount, error)
 	GetTasksOfJob(ctx context.Context, jobId string, status []TaskStatus) ([]TaskEntity, error)
+	// GetThingsOfJob returns id of the things which have tasks of the job
+	GetThingsOfJob(ctx context.Context, jobId string, things []string) ([]string, error)
+}
+
+// ThingQuerier finds things of targets and for placeholders of job doc, it's implemented by shadow.CrudService
+type ThingQuerier interface {
+	// Find finds things with values of the filter bound as parameters
+	Find(ctx context.Context, f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error)
 }
 
-func NewMgrService(repo Repo, idProvider tio.IdProvider, jc Center) MgrService {
-	return &mgrSvcImpl{repo, idProvider, jc}
+// NewMgrService md may be nil if methods are not defined, then direct methods are not validated
+func NewMgrService(
+	repo Repo, tplRepo TemplateRepo, idProvider tio.IdProvider, jc Center, tq ThingQuerier, md MethodDefFunc,
+) MgrService {
+	return &mgrSvcImpl{repo: repo, tplRepo: tplRepo, idProvider: idProvider, jobCenter: jc, thingQuerier: tq, methodDefs: md}
 }
 
 var _ MgrService = &mgrSvcImpl{}
 
 type mgrSvcImpl struct {
-	repo       Repo
-	idProvider tio.IdProvider
-	jobCenter  Center
+	repo         Repo
+	tplRepo      TemplateRepo
+	idProvider   tio.IdProvider
+	jobCenter    Center
+	thingQuerier ThingQuerier
+	methodDefs   MethodDefFunc
 }
 
 func (s *mgrSvcImpl) CreateJob(ctx context.Context, p CreateReq) (Detail, error) {
 	// TODO check thingId is exist
+	var tplVersion int
+	if p.JobTemplateId != "" {
+		t, err := s.getTemplate(ctx, p.JobTemplateId)
+		if err != nil {
+			return Detail{}, err
+		}
+		if p, err = applyTemplate(p, t); err != nil {
+			return Detail{}, err
+		}
+		tplVersion = t.Version
+	} else if len(p.Parameters) > 0 {
+		return Detail{}, errors.WithMessage(model.ErrInvalidParams, "parameters are only for job created from template")
+	}
 	if err := p.valid(); err != nil {
 		return Detail{}, err
 	}
+	if p.TargetConfig.Type == TargetTypeGroup || p.TargetConfig.Type == TargetTypeQuery {
+		things, err := resolveTarget(ctx, s.thingQuerier, p.TargetConfig)
+		if err != nil {
+			return Detail{}, err
+		}
+		// continuous job may target things that don't match yet
+		if len(things) == 0 && p.TargetSelection != TargetSelectionContinuous {
+			return Detail{}, errors.WithMessage(model.ErrInvalidParams, "no thing matches the target")
+		}
+		p.TargetConfig.Things = things
+	} else if p.TargetConfig.ThingType != "" {
+		things, err := s.filterThingsByType(ctx, p.TargetConfig.ThingType, p.TargetConfig.Things)
+		if err != nil {
+			return Detail{}, err
+		}
+		if len(things) == 0 {
+			return Detail{}, errors.WithMessagef(model.ErrInvalidParams,
+				"none of the target things is of type %q", p.TargetConfig.ThingType)
+		}
+		p.TargetConfig.Things = things
+	}
+	if IsDirectMethodOp(p.Operation) {
+		if err := s.validDirectMethod(ctx, p); err != nil {
+			return Detail{}, err
+		}
+	}
 	e, err := toEntity(p)
 	if err != nil {
 		return Detail{}, err
 	}
+	e.JobTemplateVersion = tplVersion
 	if e.JobId == "" {
 		e.JobId, err = idProvider.ID()
 		if err != nil {
@@ -140,18 +206,103 @@ func (s *mgrSvcImpl) CreateJob(ctx context.Context, p CreateReq) (Detail, error)
 			Typ: MgrTypeCreateJob,
 			Data: MgrMsgCreateJob{
 				TargetConfig: d.TargetConfig,
-				JobContext: JobContext{
-					JobId: d.JobId, Operation: d.Operation, JobDoc: d.JobDoc,
-					SchedulingConfig: d.SchedulingConfig, RolloutConfig: d.RolloutConfig,
-					RetryConfig: d.RetryConfig, TimeoutConfig: d.TimeoutConfig,
-					Status: d.Status, StartedAt: d.StartedAt,
-				},
+				JobContext:   toJobContext(d),
 			},
 		})
 		return d, nil
 	}
 }
 
+func (s *mgrSvcImpl) getTemplate(ctx context.Context, templateId string) (Template, error) {
+	e, err := s.tplRepo.GetTemplate(ctx, templateId)
+	if err != nil {
+		return Template{}, err
+	}
+	if e == nil {
+		return Template{}, errors.WithMessagef(model.ErrInvalidParams, "job template %q not found", templateId)
+	}
+	return toTemplate(*e)
+}
+
+// filterThingsByType returns the things of the type, in the order of the given things
+func (s *mgrSvcImpl) filterThingsByType(ctx context.Context, thingType string, things []string) ([]string, error) {
+	const batch = 500
+	res := make([]string, 0, len(things))
+	for from := 0; from < len(things); from += batch {
+		l := things[from:min(from+batch, len(things))]
+		found, err := s.thingQuerier.Find(ctx, shadow.ThingFilter{ThingIds: l, ThingType: thingType})
+		if err != nil {
+			return nil, errors.WithMessage(err, "find things of type")
+		}
+		matched := make(map[string]bool, len(found))
+		for _, f := range found {
+			matched[f.ThingId] = true
+		}
+		for _, t := range l {
+			if matched[t] {
+				res = append(res, t)
+			}
+		}
+	}
+	return res, nil
+}
+
+// validDirectMethod validates the job doc of direct method against method definitions of types of the target things.
+// Request data with placeholders is validated when the task is invoked, for it differs between things.
+func (s *mgrSvcImpl) validDirectMethod(ctx context.Context, p CreateReq) error {
+	if s.methodDefs == nil {
+		return nil
+	}
+	var req InvokeDirectMethodReq
+	if buf, err := json.Marshal(p.JobDoc); err != nil || json.Unmarshal(buf, &req) != nil {
+		return errors.WithMessage(model.ErrInvalidParams, "jobDoc of direct method is invalid")
+	}
+	if req.Method == "" {
+		return errors.WithMessage(model.ErrInvalidParams, "jobDoc of direct method should have field `method`")
+	}
+	types, err := s.typesOfThings(ctx, p.TargetConfig)
+	if err != nil {
+		return err
+	}
+	withPlaceholders := len(thingPlaceholders(p.JobDoc)) > 0
+	for _, tp := range types {
+		def, err := s.methodDefs(ctx, tp, req.Method)
+		if err != nil {
+			return err
+		}
+		if def == nil || withPlaceholders {
+			continue
+		}
+		if err := def.ValidateReq(req.Data); err != nil {
+			return errors.WithMessagef(err, "request data of method %q for thing type %q", req.Method, tp)
+		}
+	}
+	return nil
+}
+
+// typesOfThings returns the distinct types of the target things
+func (s *mgrSvcImpl) typesOfThings(ctx context.Context, tc TargetConfig) ([]string, error) {
+	if tc.ThingType != "" {
+		return []string{tc.ThingType}, nil
+	}
+	const batch = 500
+	var res []string
+	found := map[string]bool{}
+	for from := 0; from < len(tc.Things); from += batch {
+		l, err := s.thingQuerier.Find(ctx, shadow.ThingFilter{ThingIds: tc.Things[from:min(from+batch, len(tc.Things))]})
+		if err != nil {
+			return nil, errors.WithMessage(err, "find types of target things")
+		}
+		for _, t := range l {
+			if t.ThingType != "" && !found[t.ThingType] {
+				found[t.ThingType] = true
+				res = append(res, t.ThingType)
+			}
+		}
+	}
+	return res, nil
+}
+
 // UpdateJob Updated values for timeoutConfig take effect for only newly in-progress tasks.
 // Currently, in-progress tasks continue to launch with the previous timeout configuration.
 func (s *mgrSvcImpl) UpdateJob(ctx context.Context, jobId string, r UpdateReq) error {
@@ -236,7 +387,8 @@ func (s *mgrSvcImpl) CancelJob(ctx context.Context, jobId string, r CancelReq, f
 			return errors.WithMessagef(model.ErrInvalidStateTransition,
 				"can'be canceled cause job is at status %q", j.Status)
 		}
-		if !force && j.Status == StatusInProgress {
+		// continuous job keeps in progress, it can be canceled without force to stop targeting new things
+		if !force && j.Status == StatusInProgress && j.TargetSelection != TargetSelectionContinuous {
 			return errors.WithMessage(model.ErrInvalidParams, "can't cancel job which is in progress")
 		}
 		if err := txRepo.UpdateJob(ctx, jobId, toUpdate); err != nil {
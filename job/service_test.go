@@ -12,6 +12,7 @@ import (
 	"ruff.io/tio/job"
 	"ruff.io/tio/job/test"
 	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
 )
 
 func Test_mgrSvcImpl_CreateJob(t *testing.T) {
@@ -118,6 +119,7 @@ func Test_mgrSvcImpl_CreateJob(t *testing.T) {
 					TargetConfig: subT.req.TargetConfig,
 					JobContext: job.JobContext{JobId: got.JobId, JobDoc: subT.req.JobDoc,
 						Operation:        subT.req.Operation,
+						TargetSelection:  job.TargetSelectionSnapshot,
 						SchedulingConfig: subT.req.SchedulingConfig,
 						RolloutConfig:    subT.req.RolloutConfig,
 						RetryConfig:      subT.req.RetryConfig,
@@ -228,11 +230,149 @@ func preCreateJobs(ctx context.Context, t *testing.T, svc job.MgrService, mockJc
 		require.NoError(t, err)
 	}
 }
+func Test_mgrSvcImpl_CreateJobWithThingType(t *testing.T) {
+	ctx := context.Background()
+	mockJc := test.NewMockJobCenter()
+	mockJc.On("ReceiveMgrMsg", mock.AnythingOfType("job.MgrMsg")).Return(nil)
+	tq := test.NewMockThingQuerier()
+	svc, _ := test.NewTestSvcWithThingQuerier(mockJc, tq)
+
+	tq.On("Find", mock.Anything, shadow.ThingFilter{ThingIds: []string{"a", "b", "c"}, ThingType: "lamp"}).
+		Return([]shadow.ShadowWithStatus{
+			{Shadow: shadow.Shadow{ThingId: "c"}}, {Shadow: shadow.Shadow{ThingId: "a"}},
+		}, nil)
+	got, err := svc.CreateJob(ctx, job.CreateReq{
+		Operation:    "test",
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a", "b", "c"}, ThingType: "lamp"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, got.TargetConfig.Things)
+	require.Equal(t, "lamp", got.TargetConfig.ThingType)
+
+	tq.On("Find", mock.Anything, shadow.ThingFilter{ThingIds: []string{"a"}, ThingType: "switch"}).
+		Return([]shadow.ShadowWithStatus{}, nil)
+	_, err = svc.CreateJob(ctx, job.CreateReq{
+		Operation:    "test",
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a"}, ThingType: "switch"},
+	})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+}
+
+// resolveTargetBatch page size of finding things of targets
+const resolveTargetBatch = 500
+
+func Test_mgrSvcImpl_CreateJobWithGroup(t *testing.T) {
+	ctx := context.Background()
+	mockJc := test.NewMockJobCenter()
+	mockJc.On("ReceiveMgrMsg", mock.AnythingOfType("job.MgrMsg")).Return(nil)
+	tq := test.NewMockThingQuerier()
+	svc, _ := test.NewTestSvcWithThingQuerier(mockJc, tq)
+
+	tq.On("Find", mock.Anything, shadow.ThingFilter{Groups: []string{"g1", "g2"}, ThingType: "lamp", Limit: resolveTargetBatch}).
+		Return([]shadow.ShadowWithStatus{{Shadow: shadow.Shadow{ThingId: "a"}}, {Shadow: shadow.Shadow{ThingId: "b"}}}, nil)
+	got, err := svc.CreateJob(ctx, job.CreateReq{
+		Operation:    "test",
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeGroup, Groups: []string{"g1", "g2"}, ThingType: "lamp"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, got.TargetConfig.Things)
+	require.Equal(t, []string{"g1", "g2"}, got.TargetConfig.Groups)
+
+	_, err = svc.CreateJob(ctx, job.CreateReq{
+		Operation:    "test",
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeGroup},
+	})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+}
+
+func Test_mgrSvcImpl_CreateJobWithQuery(t *testing.T) {
+	ctx := context.Background()
+	mockJc := test.NewMockJobCenter()
+	mockJc.On("ReceiveMgrMsg", mock.AnythingOfType("job.MgrMsg")).Return(nil)
+	tq := test.NewMockThingQuerier()
+	svc, _ := test.NewTestSvcWithThingQuerier(mockJc, tq)
+
+	tq.On("Find", mock.Anything, shadow.ThingFilter{Where: "`state.reported.version` < '1.2'", Limit: resolveTargetBatch}).
+		Return([]shadow.ShadowWithStatus{{Shadow: shadow.Shadow{ThingId: "a"}}}, nil)
+	got, err := svc.CreateJob(ctx, job.CreateReq{
+		Operation:    "test",
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeQuery, Query: "`state.reported.version` < '1.2'"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, got.TargetConfig.Things)
+	require.Equal(t, job.TargetSelectionSnapshot, got.TargetSelection)
+
+	tq.On("Find", mock.Anything, shadow.ThingFilter{Where: "connected = true", ThingType: "lamp", Limit: resolveTargetBatch}).
+		Return([]shadow.ShadowWithStatus{}, nil)
+	req := job.CreateReq{
+		Operation:    "test",
+		TargetConfig: job.TargetConfig{Type: job.TargetTypeQuery, Query: "connected = true", ThingType: "lamp"},
+	}
+	_, err = svc.CreateJob(ctx, req)
+	require.ErrorIs(t, err, model.ErrInvalidParams, "no thing matches snapshot job")
+
+	req.TargetSelection = job.TargetSelectionContinuous
+	got, err = svc.CreateJob(ctx, req)
+	require.NoError(t, err, "continuous job targets things matching later")
+	require.Empty(t, got.TargetConfig.Things)
+	require.Equal(t, job.TargetSelectionContinuous, got.TargetSelection)
+
+	for _, r := range []job.CreateReq{
+		{Operation: "test", TargetConfig: job.TargetConfig{Type: job.TargetTypeQuery}},
+		{Operation: "test", TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a"}, Query: "connected = true"}},
+		{Operation: "test", TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a"}},
+			TargetSelection: job.TargetSelectionContinuous},
+		{Operation: "test", TargetConfig: job.TargetConfig{Type: job.TargetTypeQuery, Query: "connected = true"},
+			TargetSelection: "DYNAMIC"},
+		{Operation: "test", TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a"}},
+			JobDoc: map[string]any{"id": "${thing.foo}"}},
+	} {
+		_, err = svc.CreateJob(ctx, r)
+		require.ErrorIs(t, err, model.ErrInvalidParams, "req %+v", r)
+	}
+}
+
 func preCreateTasks(ctx context.Context, t *testing.T, repo job.Repo) {
 	_, err := repo.CreateTasks(ctx, tasksTest)
 	require.NoError(t, err)
 }
 
+func Test_mgrSvcImpl_CreateJobOfDirectMethod(t *testing.T) {
+	ctx := context.Background()
+	mockJc := test.NewMockJobCenter()
+	mockJc.On("ReceiveMgrMsg", mock.AnythingOfType("job.MgrMsg")).Return(nil)
+	tq := test.NewMockThingQuerier()
+	svc, _ := test.NewTestSvcWithMethodDefs(mockJc, tq, func(_ context.Context, thingType, method string) (*shadow.MethodDef, error) {
+		if thingType != "lamp" {
+			return nil, nil
+		}
+		if method != "flash" {
+			return nil, model.ErrInvalidParams
+		}
+		return &shadow.MethodDef{ThingType: "lamp", Name: "flash", ReqSchema: map[string]any{
+			"type": "object", "required": []any{"times"},
+		}}, nil
+	})
+	tq.On("Find", mock.Anything, shadow.ThingFilter{ThingIds: []string{"a", "b"}}).
+		Return([]shadow.ShadowWithStatus{{ThingType: "lamp", Shadow: shadow.Shadow{ThingId: "a"}}, {Shadow: shadow.Shadow{ThingId: "b"}}}, nil)
+
+	create := func(jobDoc map[string]any) error {
+		_, err := svc.CreateJob(ctx, job.CreateReq{
+			Operation:    job.SysOpDirectMethodPrefix + "flash",
+			JobDoc:       jobDoc,
+			TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a", "b"}},
+		})
+		return err
+	}
+	require.NoError(t, create(map[string]any{"method": "flash", "data": map[string]any{"times": 3}}))
+	require.ErrorIs(t, create(map[string]any{"method": "blink"}), model.ErrInvalidParams, "method not defined")
+	require.ErrorIs(t, create(map[string]any{"method": "flash", "data": map[string]any{}}), model.ErrInvalidParams,
+		"data does not match the schema")
+	require.NoError(t, create(map[string]any{"method": "flash", "data": "${shadow.desired.flash}"}),
+		"data with placeholders is validated on invoking")
+	require.ErrorIs(t, create(map[string]any{"data": map[string]any{}}), model.ErrInvalidParams, "no method")
+}
+
 func Test_mgrSvcImpl_QueryJob(t *testing.T) {
 	ctx := context.Background()
 	mockJc := test.NewMockJobCenter()
@@ -0,0 +1,55 @@
+package job
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+const resolveTargetBatch = 500
+
+// resolveTarget returns id of things matching the target of type "GROUP" or "QUERY", ordered by thing id
+func resolveTarget(ctx context.Context, tq ThingQuerier, tc TargetConfig) ([]string, error) {
+	var res []string
+	err := targetPages(ctx, tq, tc, func(things []string) error {
+		res = append(res, things...)
+		return nil
+	})
+	return res, err
+}
+
+// targetPages calls fn with pages of things matching the target of type "GROUP" or "QUERY", ordered by thing id.
+// Values of the target are bound as parameters, and the query must be a single where condition.
+func targetPages(ctx context.Context, tq ThingQuerier, tc TargetConfig, fn func(things []string) error) error {
+	f := shadow.ThingFilter{ThingType: tc.ThingType, Limit: resolveTargetBatch}
+	switch tc.Type {
+	case TargetTypeGroup:
+		f.Groups = tc.Groups
+	case TargetTypeQuery:
+		f.Where = tc.Query
+	default:
+		return errors.WithMessagef(model.ErrInvalidParams, "resolve things of target type %q", tc.Type)
+	}
+	for {
+		l, err := tq.Find(ctx, f)
+		if err != nil {
+			return errors.WithMessage(err, "find things of target")
+		}
+		if len(l) == 0 {
+			return nil
+		}
+		things := make([]string, len(l))
+		for i, s := range l {
+			things[i] = s.ThingId
+		}
+		if err := fn(things); err != nil {
+			return err
+		}
+		if len(l) < resolveTargetBatch {
+			return nil
+		}
+		f.AfterId = things[len(things)-1]
+	}
+}
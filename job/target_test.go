@@ -0,0 +1,45 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/shadow"
+)
+
+func TestTargetPages(t *testing.T) {
+	var all []string
+	for i := 0; i < resolveTargetBatch*2+1; i++ {
+		all = append(all, fmt.Sprintf("th-%04d", i))
+	}
+	var filters []shadow.ThingFilter
+	tq := thingFinderFunc(func(f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error) {
+		filters = append(filters, f)
+		var res []shadow.ShadowWithStatus
+		for _, id := range all {
+			if id > f.AfterId && len(res) < f.Limit {
+				res = append(res, shadow.ShadowWithStatus{Shadow: shadow.Shadow{ThingId: id}})
+			}
+		}
+		return res, nil
+	})
+
+	var pages []int
+	err := targetPages(context.Background(), tq, TargetConfig{Type: TargetTypeQuery, Query: "connected = true", ThingType: "lamp"},
+		func(things []string) error {
+			pages = append(pages, len(things))
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, []int{resolveTargetBatch, resolveTargetBatch, 1}, pages)
+	require.Len(t, filters, 3)
+	require.Equal(t, shadow.ThingFilter{Where: "connected = true", ThingType: "lamp", Limit: resolveTargetBatch}, filters[0])
+	require.Equal(t, all[resolveTargetBatch*2-1], filters[2].AfterId, "paged by the last thing id")
+
+	res, err := resolveTarget(context.Background(), tq, TargetConfig{Type: TargetTypeGroup, Groups: []string{"g1"}})
+	require.NoError(t, err)
+	require.Equal(t, all, res)
+	require.Equal(t, []string{"g1"}, filters[len(filters)-1].Groups)
+}
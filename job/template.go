@@ -0,0 +1,399 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/datatypes"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+)
+
+// Job template holds the reusable config of jobs, a job created from it may override any field
+// and give values of template parameters, which are referenced as "${name}" in targetConfig and jobDoc.
+
+const maxTemplateParameters = 50
+
+var templateIdRegexp = regexp.MustCompile("^[0-9a-zA-Z_-]{1,64}$")
+var templateParamNameRegexp = regexp.MustCompile("^[0-9a-zA-Z_]{1,64}$")
+
+// placeholders of thing like "${thing.id}" are not template parameters, they're resolved for each thing
+var templateParamRefRegexp = regexp.MustCompile(`\$\{([^}.]*)}`)
+
+type TemplateParameter struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description" optional:"true"`
+	Default     *string `json:"default" optional:"true"` // optional, the parameter is required when no default
+}
+
+type TemplateConfig struct {
+	Operation       string              `json:"operation"`
+	JobDoc          map[string]any      `json:"jobDoc" optional:"true"`
+	TargetConfig    *TargetConfig       `json:"targetConfig" optional:"true"` // optional, jobs created should give it if empty
+	TargetSelection string              `json:"targetSelection" optional:"true" enum:"SNAPSHOT|CONTINUOUS"`
+	RolloutConfig   *RolloutConfig      `json:"rolloutConfig" optional:"true"`
+	RetryConfig     *RetryConfig        `json:"retryConfig" optional:"true"`
+	TimeoutConfig   *TimeoutConfig      `json:"timeoutConfig" optional:"true"`
+	AbortConfig     *AbortConfig        `json:"abortConfig" optional:"true"`
+	Parameters      []TemplateParameter `json:"parameters" optional:"true"`
+}
+
+type TemplateCreateReq struct {
+	TemplateId  string `json:"templateId"`
+	Description string `json:"description" optional:"true"`
+	TemplateConfig
+}
+
+// TemplateUpdateReq replaces the config of the template and increases its version
+type TemplateUpdateReq struct {
+	Description string `json:"description" optional:"true"`
+	TemplateConfig
+}
+
+type Template struct {
+	TemplateId  string `json:"templateId"`
+	Description string `json:"description"`
+	TemplateConfig
+	Version   int   `json:"version"`
+	UpdatedAt int64 `json:"updatedAt"`
+	CreatedAt int64 `json:"createdAt"`
+}
+
+type TemplateSummary struct {
+	TemplateId  string `json:"templateId"`
+	Description string `json:"description"`
+	Operation   string `json:"operation"`
+	Version     int    `json:"version"`
+	UpdatedAt   int64  `json:"updatedAt"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+type TemplatePage model.PageData[TemplateSummary]
+
+type TemplateService interface {
+	CreateTemplate(ctx context.Context, r TemplateCreateReq) (Template, error)
+	// UpdateTemplate jobs created before keep the config of the previous version
+	UpdateTemplate(ctx context.Context, templateId string, r TemplateUpdateReq) (Template, error)
+	DeleteTemplate(ctx context.Context, templateId string) error
+	GetTemplate(ctx context.Context, templateId string) (Template, error)
+	QueryTemplate(ctx context.Context, pq model.PageQuery) (TemplatePage, error)
+}
+
+type TemplateRepo interface {
+	CreateTemplate(ctx context.Context, e TemplateEntity) error
+	// UpdateTemplate updates the template and increases its version
+	UpdateTemplate(ctx context.Context, templateId string, m map[string]any) error
+	DeleteTemplate(ctx context.Context, templateId string) error
+	GetTemplate(ctx context.Context, templateId string) (*TemplateEntity, error)
+	QueryTemplate(ctx context.Context, pq model.PageQuery) (model.PageData[TemplateEntity], error)
+}
+
+type TemplateEntity struct {
+	TemplateId  string         `gorm:"primaryKey;size:64"`
+	Description string         `gorm:"size:256; NOT NULL; default: ''"`
+	Operation   string         `gorm:"size:64; NOT NULL"`
+	Config      datatypes.JSON `gorm:"NOT NULL"`
+	Version     int            `gorm:"NOT NULL; DEFAULT: 1"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime; NOT NULL"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime; NOT NULL"`
+}
+
+func (TemplateEntity) TableName() string {
+	return "job_template"
+}
+
+func NewTemplateSvc(r TemplateRepo) TemplateService {
+	return &templateSvc{repo: r}
+}
+
+var _ TemplateService = &templateSvc{}
+
+type templateSvc struct {
+	repo TemplateRepo
+}
+
+func (s *templateSvc) CreateTemplate(ctx context.Context, r TemplateCreateReq) (Template, error) {
+	if !templateIdRegexp.MatchString(r.TemplateId) {
+		return Template{}, errors.WithMessage(model.ErrInvalidParams,
+			"field `templateId` should match regex: "+templateIdRegexp.String())
+	}
+	if err := validTemplate(r.Description, r.TemplateConfig); err != nil {
+		return Template{}, err
+	}
+	buf, err := json.Marshal(r.TemplateConfig)
+	if err != nil {
+		return Template{}, errors.WithMessage(model.ErrInvalidParams, "template config: "+err.Error())
+	}
+	e := TemplateEntity{TemplateId: r.TemplateId, Description: r.Description, Operation: r.Operation, Config: buf}
+	if err := s.repo.CreateTemplate(ctx, e); err != nil {
+		return Template{}, err
+	}
+	log.Infof("Created job template %q", r.TemplateId)
+	return s.GetTemplate(ctx, r.TemplateId)
+}
+
+func (s *templateSvc) UpdateTemplate(ctx context.Context, templateId string, r TemplateUpdateReq) (Template, error) {
+	if _, err := s.getEntity(ctx, templateId); err != nil {
+		return Template{}, err
+	}
+	if err := validTemplate(r.Description, r.TemplateConfig); err != nil {
+		return Template{}, err
+	}
+	buf, err := json.Marshal(r.TemplateConfig)
+	if err != nil {
+		return Template{}, errors.WithMessage(model.ErrInvalidParams, "template config: "+err.Error())
+	}
+	if err := s.repo.UpdateTemplate(ctx, templateId, map[string]any{
+		"description": r.Description, "operation": r.Operation, "config": datatypes.JSON(buf),
+	}); err != nil {
+		return Template{}, err
+	}
+	log.Infof("Updated job template %q", templateId)
+	return s.GetTemplate(ctx, templateId)
+}
+
+func (s *templateSvc) DeleteTemplate(ctx context.Context, templateId string) error {
+	if _, err := s.getEntity(ctx, templateId); err != nil {
+		return err
+	}
+	if err := s.repo.DeleteTemplate(ctx, templateId); err != nil {
+		return err
+	}
+	log.Infof("Deleted job template %q", templateId)
+	return nil
+}
+
+func (s *templateSvc) GetTemplate(ctx context.Context, templateId string) (Template, error) {
+	e, err := s.getEntity(ctx, templateId)
+	if err != nil {
+		return Template{}, err
+	}
+	return toTemplate(*e)
+}
+
+func (s *templateSvc) QueryTemplate(ctx context.Context, pq model.PageQuery) (TemplatePage, error) {
+	p, err := s.repo.QueryTemplate(ctx, pq)
+	if err != nil {
+		return TemplatePage{}, err
+	}
+	l := make([]TemplateSummary, 0, len(p.Content))
+	for _, e := range p.Content {
+		l = append(l, TemplateSummary{
+			TemplateId:  e.TemplateId,
+			Description: e.Description,
+			Operation:   e.Operation,
+			Version:     e.Version,
+			UpdatedAt:   e.UpdatedAt.UnixMilli(),
+			CreatedAt:   e.CreatedAt.UnixMilli(),
+		})
+	}
+	return TemplatePage{Total: p.Total, Content: l}, nil
+}
+
+func (s *templateSvc) getEntity(ctx context.Context, templateId string) (*TemplateEntity, error) {
+	e, err := s.repo.GetTemplate(ctx, templateId)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, errors.WithMessagef(model.ErrNotFound, "job template %q", templateId)
+	}
+	return e, nil
+}
+
+func toTemplate(e TemplateEntity) (Template, error) {
+	t := Template{
+		TemplateId:  e.TemplateId,
+		Description: e.Description,
+		Version:     e.Version,
+		UpdatedAt:   e.UpdatedAt.UnixMilli(),
+		CreatedAt:   e.CreatedAt.UnixMilli(),
+	}
+	if err := json.Unmarshal(e.Config, &t.TemplateConfig); err != nil {
+		return Template{}, errors.WithMessage(model.ErrInternal, "field config of job template in db")
+	}
+	return t, nil
+}
+
+// validTemplate validates fields of the template which don't reference parameters,
+// the whole config is validated when a job is created from it
+func validTemplate(description string, c TemplateConfig) error {
+	if len(description) > 250 {
+		return errors.WithMessage(model.ErrInvalidParams, "field `description` length should be less than 250")
+	}
+	if c.Operation == "" {
+		return errors.WithMessage(model.ErrInvalidParams, "field `operation` can't be empty")
+	}
+	if !IsSysOp(c.Operation) && !operationValid(c.Operation) {
+		return errors.WithMessage(model.ErrInvalidParams, "`operation` should match regex: "+operationRegexp.String())
+	}
+	if jd, err := json.Marshal(c.JobDoc); err != nil {
+		return errors.WithMessage(model.ErrInvalidParams, "field `jobDoc` is not a json object")
+	} else if len(jd) > 60000 {
+		return errors.WithMessage(model.ErrInvalidParams, "field `jobDoc` size should be less than 60000 byte")
+	}
+	switch c.TargetSelection {
+	case "", TargetSelectionSnapshot, TargetSelectionContinuous:
+	default:
+		return errors.WithMessage(model.ErrInvalidParams,
+			"targetSelection should be \""+TargetSelectionSnapshot+"\" or \""+TargetSelectionContinuous+"\"")
+	}
+	if err := c.RolloutConfig.valid(); err != nil {
+		return err
+	}
+	if err := c.RetryConfig.valid(); err != nil {
+		return err
+	}
+	if err := c.TimeoutConfig.valid(); err != nil {
+		return err
+	}
+	if err := c.AbortConfig.valid(); err != nil {
+		return err
+	}
+
+	if len(c.Parameters) > maxTemplateParameters {
+		return errors.WithMessagef(model.ErrInvalidParams, "parameters count should be less than %d", maxTemplateParameters)
+	}
+	declared := map[string]bool{}
+	for _, p := range c.Parameters {
+		if !templateParamNameRegexp.MatchString(p.Name) {
+			return errors.WithMessage(model.ErrInvalidParams,
+				"parameter name should match regex: "+templateParamNameRegexp.String())
+		}
+		if declared[p.Name] {
+			return errors.WithMessagef(model.ErrInvalidParams, "parameter %q is duplicated", p.Name)
+		}
+		declared[p.Name] = true
+	}
+	refs := paramRefs(c.JobDoc)
+	if c.TargetConfig != nil {
+		refs = append(refs, paramRefs(c.TargetConfig)...)
+	}
+	for _, n := range refs {
+		if !declared[n] {
+			return errors.WithMessagef(model.ErrInvalidParams, "parameter %q is referenced but not declared", n)
+		}
+	}
+	return nil
+}
+
+// applyTemplate fills fields of the request which are not set from the template,
+// then substitutes parameters in targetConfig and jobDoc
+func applyTemplate(r CreateReq, t Template) (CreateReq, error) {
+	if r.Operation == "" {
+		r.Operation = t.Operation
+	}
+	if r.TargetConfig.Type == "" && t.TargetConfig != nil {
+		r.TargetConfig = *t.TargetConfig
+	}
+	if r.TargetSelection == "" {
+		r.TargetSelection = t.TargetSelection
+	}
+	if r.JobDoc == nil {
+		r.JobDoc = t.JobDoc
+	}
+	if r.RolloutConfig == nil {
+		r.RolloutConfig = t.RolloutConfig
+	}
+	if r.RetryConfig == nil {
+		r.RetryConfig = t.RetryConfig
+	}
+	if r.TimeoutConfig == nil {
+		r.TimeoutConfig = t.TimeoutConfig
+	}
+	if r.AbortConfig == nil {
+		r.AbortConfig = t.AbortConfig
+	}
+
+	values := map[string]string{}
+	for _, p := range t.Parameters {
+		if v, ok := r.Parameters[p.Name]; ok {
+			values[p.Name] = v
+		} else if p.Default != nil {
+			values[p.Name] = *p.Default
+		} else {
+			return CreateReq{}, errors.WithMessagef(model.ErrInvalidParams, "template parameter %q is required", p.Name)
+		}
+	}
+	for n := range r.Parameters {
+		if _, ok := values[n]; !ok {
+			return CreateReq{}, errors.WithMessagef(model.ErrInvalidParams, "template parameter %q is not declared", n)
+		}
+	}
+
+	var err error
+	if r.JobDoc != nil {
+		var jd map[string]any
+		if jd, err = substituteParams(r.JobDoc, values); err != nil {
+			return CreateReq{}, errors.WithMessage(err, "jobDoc")
+		}
+		r.JobDoc = jd
+	}
+	if r.TargetConfig, err = substituteParams(r.TargetConfig, values); err != nil {
+		return CreateReq{}, errors.WithMessage(err, "targetConfig")
+	}
+	return r, nil
+}
+
+// substituteParams replaces "${name}" in string values of v, v is copied through json
+func substituteParams[T any](v T, values map[string]string) (T, error) {
+	var res T
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return res, errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	var raw any
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return res, errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	var missing string
+	raw = walkStrings(raw, func(s string) string {
+		return templateParamRefRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+			n := ref[2 : len(ref)-1]
+			val, ok := values[n]
+			if !ok {
+				missing = n
+			}
+			return val
+		})
+	})
+	if missing != "" {
+		return res, errors.WithMessagef(model.ErrInvalidParams, "template parameter %q is not declared", missing)
+	}
+	if buf, err = json.Marshal(raw); err != nil {
+		return res, errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	if err := json.Unmarshal(buf, &res); err != nil {
+		return res, errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	return res, nil
+}
+
+// paramRefs names of parameters referenced in string values of v
+func paramRefs(v any) []string {
+	var raw any
+	if buf, err := json.Marshal(v); err != nil || json.Unmarshal(buf, &raw) != nil {
+		return nil
+	}
+	var refs []string
+	walkStrings(raw, func(s string) string {
+		for _, m := range templateParamRefRegexp.FindAllStringSubmatch(s, -1) {
+			refs = append(refs, m[1])
+		}
+		return s
+	})
+	return refs
+}
+
+func walkStrings(v any, f func(string) string) any {
+	return walkValues(v, func(e any) any {
+		if s, ok := e.(string); ok && strings.Contains(s, "${") {
+			return f(s)
+		}
+		return e
+	})
+}
@@ -0,0 +1,66 @@
+package job
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type templateRepo struct {
+	db *gorm.DB
+}
+
+func NewTemplateRepo(db *gorm.DB) TemplateRepo {
+	return templateRepo{db}
+}
+
+func (r templateRepo) CreateTemplate(ctx context.Context, e TemplateEntity) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&TemplateEntity{}).Where("template_id=?", e.TemplateId).Count(&n).Error; err != nil {
+			return errors.Wrap(err, "count job template")
+		}
+		if n > 0 {
+			return errors.WithMessagef(model.ErrDuplicated, "job template %q", e.TemplateId)
+		}
+		return errors.Wrap(tx.Create(&e).Error, "create job template")
+	})
+}
+
+func (r templateRepo) UpdateTemplate(ctx context.Context, templateId string, m map[string]any) error {
+	m["version"] = gorm.Expr("version + 1")
+	err := r.db.WithContext(ctx).Model(&TemplateEntity{}).Where("template_id=?", templateId).Updates(m).Error
+	return errors.Wrap(err, "update job template")
+}
+
+func (r templateRepo) DeleteTemplate(ctx context.Context, templateId string) error {
+	err := r.db.WithContext(ctx).Where("template_id=?", templateId).Delete(&TemplateEntity{}).Error
+	return errors.Wrap(err, "delete job template")
+}
+
+func (r templateRepo) GetTemplate(ctx context.Context, templateId string) (*TemplateEntity, error) {
+	var e TemplateEntity
+	if err := r.db.WithContext(ctx).Where("template_id=?", templateId).First(&e).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get job template")
+	}
+	return &e, nil
+}
+
+func (r templateRepo) QueryTemplate(ctx context.Context, pq model.PageQuery) (model.PageData[TemplateEntity], error) {
+	var page model.PageData[TemplateEntity]
+	q := r.db.WithContext(ctx).Model(&TemplateEntity{})
+	if err := q.Count(&page.Total).Error; err != nil {
+		return page, errors.Wrap(err, "count job template")
+	}
+	if page.Total == 0 {
+		page.Content = []TemplateEntity{}
+		return page, nil
+	}
+	err := q.Order("created_at ASC").Offset(pq.Offset()).Limit(pq.Limit()).Find(&page.Content).Error
+	return page, errors.Wrap(err, "query job template")
+}
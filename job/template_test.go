@@ -0,0 +1,134 @@
+package job_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/job"
+	"ruff.io/tio/job/test"
+	"ruff.io/tio/pkg/model"
+)
+
+func firmwareTemplate() job.TemplateCreateReq {
+	dft := "stable"
+	return job.TemplateCreateReq{
+		TemplateId:  "upgrade-firmware",
+		Description: "upgrade firmware of lamps",
+		TemplateConfig: job.TemplateConfig{
+			Operation: "upgradeFirmware",
+			JobDoc:    map[string]any{"url": "${firmwareUrl}", "channel": "${channel}", "retries": 3.0},
+			TargetConfig: &job.TargetConfig{
+				Type: job.TargetTypeThingId, Things: []string{"lamp-${batch}-1", "lamp-${batch}-2"},
+			},
+			RetryConfig: &job.RetryConfig{CriteriaList: []job.RetryConfigItem{{FailureType: "FAILED", NumberOfRetries: 2}}},
+			Parameters: []job.TemplateParameter{
+				{Name: "firmwareUrl"},
+				{Name: "batch"},
+				{Name: "channel", Default: &dft},
+			},
+		},
+	}
+}
+
+func Test_templateSvc(t *testing.T) {
+	ctx := context.Background()
+	db := dbMock.NewSqliteConnTest()
+	mockJc := test.NewMockJobCenter()
+	mockJc.On("ReceiveMgrMsg", mock.Anything).Return()
+	mgrSvc, _ := test.NewTestSvcWithDB(db, mockJc)
+	svc := job.NewTemplateSvc(job.NewTemplateRepo(db))
+
+	tpl, err := svc.CreateTemplate(ctx, firmwareTemplate())
+	require.NoError(t, err)
+	require.Equal(t, 1, tpl.Version)
+	_, err = svc.CreateTemplate(ctx, firmwareTemplate())
+	require.ErrorIs(t, err, model.ErrDuplicated)
+
+	t.Run("invalid template", func(t *testing.T) {
+		invalid := []func(r *job.TemplateCreateReq){
+			func(r *job.TemplateCreateReq) { r.TemplateId = "a b" },
+			func(r *job.TemplateCreateReq) { r.Operation = "" },
+			func(r *job.TemplateCreateReq) { r.JobDoc["sha"] = "${sha256}" },
+			func(r *job.TemplateCreateReq) {
+				r.Parameters = append(r.Parameters, job.TemplateParameter{Name: "batch"})
+			},
+			func(r *job.TemplateCreateReq) {
+				r.Parameters = append(r.Parameters, job.TemplateParameter{Name: "a-b"})
+			},
+			func(r *job.TemplateCreateReq) { r.RolloutConfig = &job.RolloutConfig{MaxPerMinute: 0} },
+		}
+		for i, f := range invalid {
+			r := firmwareTemplate()
+			r.TemplateId = "invalid"
+			f(&r)
+			_, err := svc.CreateTemplate(ctx, r)
+			require.ErrorIs(t, err, model.ErrInvalidParams, "case %d", i)
+		}
+	})
+
+	t.Run("create job from template", func(t *testing.T) {
+		d, err := mgrSvc.CreateJob(ctx, job.CreateReq{
+			JobTemplateId: "upgrade-firmware",
+			Parameters:    map[string]string{"firmwareUrl": "https://x.io/fw-1.2.bin", "batch": "b7"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "upgradeFirmware", d.Operation)
+		require.Equal(t, []string{"lamp-b7-1", "lamp-b7-2"}, d.TargetConfig.Things)
+		require.Equal(t, map[string]any{"url": "https://x.io/fw-1.2.bin", "channel": "stable", "retries": 3.0}, d.JobDoc)
+		require.NotNil(t, d.RetryConfig)
+		require.Equal(t, "upgrade-firmware", d.JobTemplateId)
+		require.Equal(t, 1, d.JobTemplateVersion)
+
+		// overrides
+		d, err = mgrSvc.CreateJob(ctx, job.CreateReq{
+			JobTemplateId: "upgrade-firmware",
+			TargetConfig:  job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"lamp-${batch}"}},
+			JobDoc:        map[string]any{"url": "${firmwareUrl}"},
+			Parameters:    map[string]string{"firmwareUrl": "u", "batch": "b8", "channel": "beta"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"lamp-b8"}, d.TargetConfig.Things)
+		require.Equal(t, map[string]any{"url": "u"}, d.JobDoc)
+
+		for _, r := range []job.CreateReq{
+			{JobTemplateId: "upgrade-firmware", Parameters: map[string]string{"batch": "b7"}},
+			{JobTemplateId: "upgrade-firmware", Parameters: map[string]string{"firmwareUrl": "u", "batch": "b7", "sha": "x"}},
+			{JobTemplateId: "absent"},
+			{Operation: "upgradeFirmware", TargetConfig: job.TargetConfig{Type: job.TargetTypeThingId, Things: []string{"a"}},
+				Parameters: map[string]string{"batch": "b7"}},
+		} {
+			_, err := mgrSvc.CreateJob(ctx, r)
+			require.ErrorIs(t, err, model.ErrInvalidParams, "req %#v", r)
+		}
+	})
+
+	t.Run("update template", func(t *testing.T) {
+		u := firmwareTemplate()
+		u.JobDoc = map[string]any{"url": "${firmwareUrl}", "force": true}
+		tpl, err := svc.UpdateTemplate(ctx, "upgrade-firmware", job.TemplateUpdateReq{
+			Description: u.Description, TemplateConfig: u.TemplateConfig,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, tpl.Version)
+		require.Equal(t, true, tpl.JobDoc["force"])
+
+		d, err := mgrSvc.CreateJob(ctx, job.CreateReq{
+			JobTemplateId: "upgrade-firmware",
+			Parameters:    map[string]string{"firmwareUrl": "u", "batch": "b9"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, d.JobTemplateVersion)
+
+		p, err := svc.QueryTemplate(ctx, model.PageQuery{PageIndex: 1, PageSize: 10})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), p.Total)
+		require.Equal(t, 2, p.Content[0].Version)
+	})
+
+	require.NoError(t, svc.DeleteTemplate(ctx, "upgrade-firmware"))
+	_, err = svc.GetTemplate(ctx, "upgrade-firmware")
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
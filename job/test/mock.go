@@ -80,3 +80,20 @@ func (m *MethodHandler) InitMethodHandler(ctx context.Context) error {
 func (m *MethodHandler) SetReturnFunc(f func() (shadow.MethodResp, error)) {
 	m.returnFc = f
 }
+
+// --------------------------------mock thing querier--------------------------------
+
+type ThingQuerier struct {
+	mock.Mock
+}
+
+func NewMockThingQuerier() *ThingQuerier {
+	return &ThingQuerier{}
+}
+
+var _ job.ThingQuerier = (*ThingQuerier)(nil)
+
+func (q *ThingQuerier) Find(ctx context.Context, f shadow.ThingFilter) ([]shadow.ShadowWithStatus, error) {
+	args := q.Called(ctx, f)
+	return args.Get(0).([]shadow.ShadowWithStatus), args.Error(1)
+}
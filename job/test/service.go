@@ -13,13 +13,29 @@ func NewTestSvc(jc job.Center) (job.MgrService, job.Repo) {
 	return NewTestSvcWithDB(db, jc)
 }
 
+func NewTestSvcWithThingQuerier(jc job.Center, tq job.ThingQuerier) (job.MgrService, job.Repo) {
+	return newTestSvc(mock.NewSqliteConnTest(), jc, tq, nil)
+}
+
+func NewTestSvcWithMethodDefs(jc job.Center, tq job.ThingQuerier, md job.MethodDefFunc) (job.MgrService, job.Repo) {
+	return newTestSvc(mock.NewSqliteConnTest(), jc, tq, md)
+}
+
 func NewTestSvcWithDB(db *gorm.DB, jc job.Center) (job.MgrService, job.Repo) {
-	err := db.AutoMigrate(job.Entity{}, job.TaskEntity{})
+	return newTestSvc(db, jc, NewMockThingQuerier(), nil)
+}
+
+func NewTestSvcWithDBAndThingQuerier(db *gorm.DB, jc job.Center, tq job.ThingQuerier) (job.MgrService, job.Repo) {
+	return newTestSvc(db, jc, tq, nil)
+}
+
+func newTestSvc(db *gorm.DB, jc job.Center, tq job.ThingQuerier, md job.MethodDefFunc) (job.MgrService, job.Repo) {
+	err := db.AutoMigrate(job.Entity{}, job.TaskEntity{}, job.TemplateEntity{})
 	if err != nil {
 		log.Fatalf("job auto migrate error: %v", err)
 	}
 	r := job.NewRepo(db)
 
-	s := wire.InitSvc(db, jc)
+	s := wire.InitSvc(db, jc, tq, md)
 	return s, r
 }
@@ -17,13 +17,39 @@ const (
 	TopicGetAcceptedTmpl = TopicPrefixTmpl + "/{jobId}/get/accepted"
 	TopicGetRejectedTmpl = TopicPrefixTmpl + "/{jobId}/get/rejected"
 
-	TopicStartNextTmpl = TopicPrefixTmpl + "/start-next"
+	TopicStartNextTmpl         = TopicPrefixTmpl + "/start-next"
+	TopicStartNextAcceptedTmpl = TopicPrefixTmpl + "/start-next/accepted"
+	TopicStartNextRejectedTmpl = TopicPrefixTmpl + "/start-next/rejected"
 
 	TopicUpdateTmpl         = TopicPrefixTmpl + "/{jobId}/update"
 	TopicUpdateAcceptedTmpl = TopicPrefixTmpl + "/{jobId}/update/accepted"
 	TopicUpdateRejectedTmpl = TopicPrefixTmpl + "/{jobId}/update/rejected"
 )
 
+// Topics subscribed for requests of all things
+const (
+	TopicAllGetList   = "$iothub/things/+/jobs/get"
+	TopicAllGet       = "$iothub/things/+/jobs/+/get"
+	TopicAllStartNext = "$iothub/things/+/jobs/start-next"
+	TopicAllUpdate    = "$iothub/things/+/jobs/+/update"
+)
+
+// Topics of job lifecycle events, for subscribers like $biz
+const (
+	TopicEventJobTmpl  = "$iothub/events/jobs/{jobId}/{eventType}"
+	TopicEventTaskTmpl = "$iothub/events/jobs/{jobId}/tasks/{thingId}"
+
+	TopicEventJobAll  = "$iothub/events/jobs/+/+"
+	TopicEventTaskAll = "$iothub/events/jobs/+/tasks/+"
+)
+
+func TopicEventJob(jobId, eventType string) string {
+	return strings.ReplaceAll(replaceJobId(TopicEventJobTmpl, jobId), "{eventType}", eventType)
+}
+func TopicEventTask(jobId, thingId string) string {
+	return replaceThingId(replaceJobId(TopicEventTaskTmpl, jobId), thingId)
+}
+
 func replaceThingId(s, thingId string) string {
 	return strings.ReplaceAll(s, "{thingId}", thingId)
 }
@@ -52,6 +78,12 @@ func TopicGetListRejected(thingId string) string {
 func TopicStartNext(thingId string) string {
 	return replaceThingId(TopicStartNextTmpl, thingId)
 }
+func TopicStartNextAccepted(thingId string) string {
+	return replaceThingId(TopicStartNextAcceptedTmpl, thingId)
+}
+func TopicStartNextRejected(thingId string) string {
+	return replaceThingId(TopicStartNextRejectedTmpl, thingId)
+}
 
 func TopicGet(thingId, jobId string) string {
 	return replaceJobId(replaceThingId(TopicGetTmpl, thingId), jobId)
@@ -72,3 +104,17 @@ func TopicUpdateAccepted(thingId, jobId string) string {
 func TopicUpdateRejected(thingId, jobId string) string {
 	return replaceJobId(replaceThingId(TopicUpdateRejectedTmpl, thingId), jobId)
 }
+
+// parseTopic gets thingId and jobId from topic like "$iothub/things/{thingId}/jobs/{jobId}/update",
+// jobId is empty for topics without it
+func parseTopic(topic string) (thingId, jobId string, ok bool) {
+	arr := strings.Split(topic, "/")
+	if len(arr) < 5 || arr[0] != "$iothub" || arr[1] != "things" || arr[3] != "jobs" {
+		return "", "", false
+	}
+	thingId = arr[2]
+	if len(arr) == 6 {
+		jobId = arr[4]
+	}
+	return thingId, jobId, true
+}
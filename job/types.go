@@ -23,6 +23,13 @@ const (
 
 	TargetTypeThingId = "THING_ID"
 	TargetTypeGroup   = "GROUP"
+	TargetTypeQuery   = "QUERY"
+
+	// TargetSelectionSnapshot tasks are created for the target things when the job is created
+	TargetSelectionSnapshot = "SNAPSHOT"
+	// TargetSelectionContinuous tasks are also created for things newly matching the target,
+	// the job keeps in progress until it's canceled
+	TargetSelectionContinuous = "CONTINUOUS"
 )
 
 type StatusDetails map[string]any
@@ -122,8 +129,9 @@ type TTasksNotify struct {
 }
 
 type TTaskNotifyNext struct {
-	Task      TTask `json:"task"`
-	Timestamp int64 `json:"timestamp"`
+	// Task is absent when there is no pending task
+	Task      *TTask `json:"task,omitempty"`
+	Timestamp int64  `json:"timestamp"`
 }
 
 type TPendingTasksResp struct {
@@ -133,6 +141,10 @@ type TPendingTasksResp struct {
 	ClientToken     string         `json:"clientToken"`
 }
 
+type TGetPendingTasksReq struct {
+	ClientToken string `json:"clientToken"`
+}
+
 type TStartNextPendingTaskReq struct {
 	StatusDetails StatusDetails `json:"statusDetails"`
 	ClientToken   string        `json:"clientToken"`
@@ -238,22 +250,40 @@ func (b ScheduleEndBehavior) Of(value string) (ScheduleEndBehavior, error) {
 }
 
 type MaintenanceWindow struct {
-	StartTime         string `json:"startTime"` //  cron, eg: "cron(0 0 18 ? * MON *)" means "every monday at 18:00"
+	// StartTime cron of 5 fields or a descriptor, eg: "0 18 * * MON" means "every monday at 18:00", "@daily" means "every day at 00:00"
+	StartTime         string `json:"startTime"`
 	DurationInMinutes int    `json:"durationInMinutes"`
+	// TimeZone IANA time zone name of the start time, eg: "Asia/Shanghai", UTC by default
+	TimeZone string `json:"timeZone,omitempty" optional:"true"`
 }
 type SchedulingConfig struct {
 	StartTime time.Time `json:"startTime"` // ISO-8601 date time
 
-	// To be implemented later
-
 	EndTime     *time.Time          `json:"endTime"` // optional, ISO8601 date time
 	EndBehavior ScheduleEndBehavior `json:"endBehavior" enum:"STOP_ROLLOUT | CANCEL | FORCE_CANCEL"`
 
-	//MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows"`
+	// MaintenanceWindows optional, tasks are only rolled out in any of the windows,
+	// while tasks rolled out continue outside windows
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows" optional:"true"`
 }
 
 type RolloutConfig struct {
 	MaxPerMinute int
+	// ExponentialRate optional, rollout starts at a base rate and grows to MaxPerMinute
+	ExponentialRate *ExponentialRate `json:"exponentialRate,omitempty" optional:"true"`
+}
+
+// ExponentialRate the rollout rate is multiplied by IncrementFactor each time the criteria is met
+type ExponentialRate struct {
+	BaseRatePerMinute    int                  `json:"baseRatePerMinute"`
+	IncrementFactor      float64              `json:"incrementFactor"` // 1.1 ~ 5
+	RateIncreaseCriteria RateIncreaseCriteria `json:"rateIncreaseCriteria"`
+}
+
+// RateIncreaseCriteria only one of the fields can be set
+type RateIncreaseCriteria struct {
+	NumberOfNotifiedThings  int `json:"numberOfNotifiedThings,omitempty" optional:"true"`
+	NumberOfSucceededThings int `json:"numberOfSucceededThings,omitempty" optional:"true"`
 }
 
 type RetryConfig struct {
@@ -264,6 +294,34 @@ type RetryConfigItem struct {
 	NumberOfRetries int    `json:"numberOfRetries"`
 }
 
+type AbortAction string
+
+const (
+	AbortActionCancel      AbortAction = "CANCEL"
+	AbortActionForceCancel AbortAction = "FORCE_CANCEL"
+
+	// AbortFailureTypeAll counts all of FAILED, REJECTED and TIMED_OUT tasks
+	AbortFailureTypeAll = "ALL"
+
+	// ReasonCodeAborted reason code of jobs canceled by abort criteria
+	ReasonCodeAborted = "ABORTED"
+)
+
+// AbortConfig the job is canceled when any of the criteria is met
+type AbortConfig struct {
+	CriteriaList []AbortCriteria `json:"criteriaList"`
+}
+
+// AbortCriteria eg: abort when FAILED tasks reach 10% after at least 100 things executed
+type AbortCriteria struct {
+	FailureType string      `json:"failureType" enum:"FAILED | REJECTED | TIMED_OUT | ALL"`
+	Action      AbortAction `json:"action" enum:"CANCEL | FORCE_CANCEL"`
+	// ThresholdPercentage percentage of failed tasks of the type in executed tasks, 0 - 100
+	ThresholdPercentage float64 `json:"thresholdPercentage"`
+	// MinNumberOfExecutedThings things whose tasks are in terminal status except CANCELED
+	MinNumberOfExecutedThings int `json:"minNumberOfExecutedThings"`
+}
+
 type TimeoutConfig struct {
 	InProgressMinutes int `json:"inProgressMinutes"` // max time for task stay in "IN_PROGRESS" status
 }
@@ -284,14 +342,22 @@ type ProcessDetails struct {
 }
 
 type TargetConfig struct {
-	Type   string   `json:"type" enum:"THING_ID"` // "THING_ID". Or can be "GROUP" in future ?
+	Type string `json:"type" enum:"THING_ID|GROUP|QUERY"`
+	// Things target things for type "THING_ID", or things resolved when the job is created for type "GROUP" and "QUERY"
 	Things []string `json:"things"`
+	// Groups target thing groups for type "GROUP", they're resolved to things when the job is created
+	Groups []string `json:"groups,omitempty" optional:"true"`
+	// Query where condition of shadow query for type "QUERY", eg: `state.reported.version` < '1.2.0'
+	Query string `json:"query,omitempty" optional:"true"`
+	// ThingType only things of the type are targeted, others are excluded when the job is created
+	ThingType string `json:"thingType,omitempty" optional:"true"`
 }
 
 type Detail struct {
 	JobId string `json:"jobId"`
 
 	TargetConfig     TargetConfig      `json:"targetConfig"`
+	TargetSelection  string            `json:"targetSelection" enum:"SNAPSHOT|CONTINUOUS"`
 	JobDoc           map[string]any    `json:"jobDoc"`
 	Description      string            `json:"description"`
 	Operation        string            `json:"operation"`
@@ -299,6 +365,7 @@ type Detail struct {
 	RolloutConfig    *RolloutConfig    `json:"rolloutConfig"`
 	RetryConfig      *RetryConfig      `json:"retryConfig"`
 	TimeoutConfig    *TimeoutConfig    `json:"timeoutConfig"`
+	AbortConfig      *AbortConfig      `json:"abortConfig"`
 
 	Status         Status         `json:"status" enum:"WAITING|IN_PROGRESS|CANCELING|CANCELED|COMPLETED|REMOVING"`
 	ForceCanceled  bool           `json:"forceCanceled"`
@@ -306,6 +373,13 @@ type Detail struct {
 	Comment        string         `json:"comment"`
 	ReasonCode     string         `json:"reasonCode"`
 
+	// RolloutRatePerMinute current rollout rate of job with exponential rate which is rolling out
+	RolloutRatePerMinute int `json:"rolloutRatePerMinute,omitempty" optional:"true"`
+
+	// JobTemplateId and JobTemplateVersion of the template the job is created from
+	JobTemplateId      string `json:"jobTemplateId,omitempty" optional:"true"`
+	JobTemplateVersion int    `json:"jobTemplateVersion,omitempty" optional:"true"`
+
 	StartedAt   *int64 `json:"startedAt"`
 	CompletedAt *int64 `json:"completedAt"`
 	UpdatedAt   int64  `json:"updatedAt"`
@@ -367,8 +441,10 @@ type TaskSummary struct {
 type CreateReq struct {
 	JobId        string       `json:"jobId" optional:"true"` // optional
 	TargetConfig TargetConfig `json:"targetConfig"`
-	Operation    string       `json:"operation" description:"system operation: \"$directMethod\" or \"$updateShadow\", and custom operation without \"$\" prefix"`
-	Description  string       `json:"description" optional:"true"` // optional
+	// TargetSelection optional, "SNAPSHOT" by default, "CONTINUOUS" is only for target type "GROUP" and "QUERY"
+	TargetSelection string `json:"targetSelection" optional:"true" enum:"SNAPSHOT|CONTINUOUS"`
+	Operation       string `json:"operation" description:"system operation: \"$directMethod\" or \"$updateShadow\", and custom operation without \"$\" prefix"`
+	Description     string `json:"description" optional:"true"` // optional
 
 	// JobDoc optional, when operation is "$updateShadow" or "$updateShadow",
 	// job doc should be json string of UpdateShadowReq or InvokeDirectMethodReq
@@ -378,6 +454,13 @@ type CreateReq struct {
 	RolloutConfig    *RolloutConfig    `json:"rolloutConfig" optional:"true"`
 	RetryConfig      *RetryConfig      `json:"retryConfig" optional:"true"`   // optional, tasks retry config
 	TimeoutConfig    *TimeoutConfig    `json:"timeoutConfig" optional:"true"` // optional
+	AbortConfig      *AbortConfig      `json:"abortConfig" optional:"true"`   // optional
+
+	// JobTemplateId optional, the job is created from the template,
+	// fields of the request that are set override those of the template
+	JobTemplateId string `json:"jobTemplateId" optional:"true"`
+	// Parameters optional, values of template parameters, which substitute "${name}" in targetConfig and jobDoc
+	Parameters map[string]string `json:"parameters" optional:"true"`
 }
 type UpdateShadowReq struct {
 	State struct {
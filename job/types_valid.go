@@ -12,11 +12,23 @@ import (
 
 var jobIdRegexp = regexp.MustCompile("^[0-9a-zA-Z_-]{1,64}$")
 var operationRegexp = regexp.MustCompile("^[0-9a-zA-Z_-]{1,64}$")
+var thingTypeRegexp = regexp.MustCompile("^[0-9a-zA-Z_-]{1,64}$")
+var groupNameRegexp = regexp.MustCompile("^[0-9a-zA-Z_-]{1,64}$")
+
+const maxTargetQueryLen = 2048
 
 func operationValid(op string) bool {
 	return operationRegexp.MatchString(op)
 }
 
+func thingTypeValid(t string) bool {
+	return thingTypeRegexp.MatchString(t)
+}
+
+func groupNameValid(g string) bool {
+	return groupNameRegexp.MatchString(g)
+}
+
 func jobIdValid(op string) bool {
 	return jobIdRegexp.MatchString(op)
 }
@@ -47,16 +59,58 @@ func (r CreateReq) valid() error {
 	} else if len(jd) > 60000 {
 		return errors.WithMessage(model.ErrInvalidParams, "field `jobDoc` size should be less than 60000 byte")
 	}
+	if err := validJobDocPlaceholders(r.JobDoc); err != nil {
+		return err
+	}
 
 	if r.TargetConfig.Type == "" {
 		return errors.WithMessage(model.ErrInvalidParams, "targetConfig type can't be empty")
 	}
-	if r.TargetConfig.Type != TargetTypeThingId {
+	switch r.TargetConfig.Type {
+	case TargetTypeThingId:
+		if len(r.TargetConfig.Things) == 0 {
+			return errors.WithMessage(model.ErrInvalidParams, "targetConfig things can't be empty")
+		}
+	case TargetTypeGroup:
+		if len(r.TargetConfig.Groups) == 0 {
+			return errors.WithMessage(model.ErrInvalidParams, "targetConfig groups can't be empty")
+		}
+		for _, g := range r.TargetConfig.Groups {
+			if !groupNameValid(g) {
+				return errors.WithMessage(model.ErrInvalidParams,
+					"targetConfig group should match regex: "+groupNameRegexp.String())
+			}
+		}
+	case TargetTypeQuery:
+		if strings.TrimSpace(r.TargetConfig.Query) == "" {
+			return errors.WithMessage(model.ErrInvalidParams, "targetConfig query can't be empty")
+		}
+		if len(r.TargetConfig.Query) > maxTargetQueryLen {
+			return errors.WithMessagef(model.ErrInvalidParams,
+				"targetConfig query length should be less than %d", maxTargetQueryLen)
+		}
+	default:
 		return errors.WithMessage(model.ErrInvalidParams,
-			"targetConfig type can only be \""+TargetTypeThingId+"\" at present")
+			"targetConfig type should be \""+TargetTypeThingId+"\", \""+TargetTypeGroup+"\" or \""+TargetTypeQuery+"\"")
 	}
-	if len(r.TargetConfig.Things) == 0 {
-		return errors.WithMessage(model.ErrInvalidParams, "targetConfig things can't be empty")
+	if r.TargetConfig.Type != TargetTypeQuery && r.TargetConfig.Query != "" {
+		return errors.WithMessage(model.ErrInvalidParams, "targetConfig query is only for type \""+TargetTypeQuery+"\"")
+	}
+	if r.TargetConfig.ThingType != "" && !thingTypeValid(r.TargetConfig.ThingType) {
+		return errors.WithMessage(model.ErrInvalidParams,
+			"targetConfig thingType should match regex: "+thingTypeRegexp.String())
+	}
+	switch r.TargetSelection {
+	case "", TargetSelectionSnapshot:
+	case TargetSelectionContinuous:
+		if r.TargetConfig.Type == TargetTypeThingId {
+			return errors.WithMessage(model.ErrInvalidParams,
+				"targetSelection \""+TargetSelectionContinuous+"\" is only for targetConfig type \""+
+					TargetTypeGroup+"\" and \""+TargetTypeQuery+"\"")
+		}
+	default:
+		return errors.WithMessage(model.ErrInvalidParams,
+			"targetSelection should be \""+TargetSelectionSnapshot+"\" or \""+TargetSelectionContinuous+"\"")
 	}
 
 	if err := r.SchedulingConfig.valid(); err != nil {
@@ -72,6 +126,9 @@ func (r CreateReq) valid() error {
 	if err := r.RetryConfig.valid(); err != nil {
 		return err
 	}
+	if err := r.AbortConfig.valid(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -116,6 +173,15 @@ func (s *SchedulingConfig) valid() error {
 	if s.EndTime != nil && s.StartTime.After(*s.EndTime) {
 		return errors.WithMessage(model.ErrInvalidParams, "scheduleConfig startTime should before endTime")
 	}
+	if len(s.MaintenanceWindows) > maxMaintenanceWindows {
+		return errors.WithMessagef(model.ErrInvalidParams,
+			"scheduleConfig maintenanceWindows count should be less than %d", maxMaintenanceWindows)
+	}
+	for _, w := range s.MaintenanceWindows {
+		if err := w.valid(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -127,6 +193,22 @@ func (c *RolloutConfig) valid() error {
 	if c.MaxPerMinute <= 0 {
 		return errors.WithMessage(model.ErrInvalidParams, "rolloutConfig maxPerMinute must be positive")
 	}
+	if er := c.ExponentialRate; er != nil {
+		if er.BaseRatePerMinute < 1 || er.BaseRatePerMinute > c.MaxPerMinute {
+			return errors.WithMessage(model.ErrInvalidParams,
+				"rolloutConfig exponentialRate baseRatePerMinute should between 1 and maxPerMinute")
+		}
+		if er.IncrementFactor < minIncrementFactor || er.IncrementFactor > maxIncrementFactor {
+			return errors.WithMessagef(model.ErrInvalidParams,
+				"rolloutConfig exponentialRate incrementFactor should between %v and %v", minIncrementFactor, maxIncrementFactor)
+		}
+		cr := er.RateIncreaseCriteria
+		if cr.NumberOfNotifiedThings < 0 || cr.NumberOfSucceededThings < 0 ||
+			(cr.NumberOfNotifiedThings > 0) == (cr.NumberOfSucceededThings > 0) {
+			return errors.WithMessage(model.ErrInvalidParams,
+				"rolloutConfig exponentialRate rateIncreaseCriteria should have one positive of numberOfNotifiedThings and numberOfSucceededThings")
+		}
+	}
 	return nil
 }
 
@@ -176,3 +258,40 @@ func (c *RetryConfig) valid() error {
 	}
 	return nil
 }
+
+func (c *AbortConfig) valid() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.CriteriaList) == 0 || len(c.CriteriaList) > 4 {
+		return errors.WithMessage(model.ErrInvalidParams,
+			fmt.Sprintf("abortConfig wrong criteria count %d", len(c.CriteriaList)))
+	}
+	types := map[string]bool{}
+	for _, cr := range c.CriteriaList {
+		switch cr.FailureType {
+		case TaskFailed.String(), TaskRejected.String(), TaskTimeOut.String(), AbortFailureTypeAll:
+		default:
+			return errors.WithMessage(model.ErrInvalidParams,
+				fmt.Sprintf("abortConfig failureType should be %s, %s, %s or %s",
+					AbortFailureTypeAll, TaskFailed, TaskRejected, TaskTimeOut))
+		}
+		if types[cr.FailureType] {
+			return errors.WithMessage(model.ErrInvalidParams, "abortConfig duplicated failure type "+cr.FailureType)
+		}
+		types[cr.FailureType] = true
+		if cr.Action != AbortActionCancel && cr.Action != AbortActionForceCancel {
+			return errors.WithMessage(model.ErrInvalidParams,
+				fmt.Sprintf("abortConfig action should be %s or %s", AbortActionCancel, AbortActionForceCancel))
+		}
+		if cr.ThresholdPercentage <= 0 || cr.ThresholdPercentage > 100 {
+			return errors.WithMessage(model.ErrInvalidParams,
+				"abortConfig thresholdPercentage should be greater than 0 and at most 100")
+		}
+		if cr.MinNumberOfExecutedThings < 1 {
+			return errors.WithMessage(model.ErrInvalidParams,
+				"abortConfig minNumberOfExecutedThings should be positive")
+		}
+	}
+	return nil
+}
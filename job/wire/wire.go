@@ -10,10 +10,11 @@ import (
 	"ruff.io/tio/pkg/uuid"
 )
 
-func InitSvc(dbConn *gorm.DB, jc job.Center) job.MgrService {
+func InitSvc(dbConn *gorm.DB, jc job.Center, tq job.ThingQuerier, md job.MethodDefFunc) job.MgrService {
 	wire.Build(
 		uuid.New,
 		job.NewRepo,
+		job.NewTemplateRepo,
 		job.NewMgrService,
 	)
 	return nil
@@ -14,9 +14,10 @@ import (
 
 // Injectors from wire.go:
 
-func InitSvc(dbConn *gorm.DB, jc job.Center) job.MgrService {
+func InitSvc(dbConn *gorm.DB, jc job.Center, tq job.ThingQuerier, md job.MethodDefFunc) job.MgrService {
 	repo := job.NewRepo(dbConn)
+	templateRepo := job.NewTemplateRepo(dbConn)
 	idProvider := uuid.New()
-	mgrService := job.NewMgrService(repo, idProvider, jc)
+	mgrService := job.NewMgrService(repo, templateRepo, idProvider, jc, tq, md)
 	return mgrService
 }
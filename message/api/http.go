@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	"ruff.io/tio/message"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+	"ruff.io/tio/thing"
+)
+
+type PurgeResp struct {
+	Count int64 `json:"count"`
+}
+
+func Service(ctx context.Context, svc message.Service, thingSvc thing.Service, thingWs *restful.WebService) *restful.WebService {
+	ws := thingWs
+
+	tags := []string{"messages"}
+	statusValues := []string{
+		string(message.StatusQueued), string(message.StatusDelivered), string(message.StatusCompleted),
+		string(message.StatusRejected), string(message.StatusExpired), string(message.StatusDeadLettered),
+	}
+
+	ws.Route(ws.POST("/{id}/messages").
+		To(EnqueueHandler(ctx, svc, thingSvc)).
+		Operation("enqueue-message").
+		Doc("send a cloud-to-device message to the thing").
+		Notes("The message is queued and delivered to the thing via topic `"+message.TopicDeliverTmpl+"` once it's online.\n"+
+			"\nThe thing should acknowledge it via topic `"+message.TopicAckTmpl+"` with payload like: "+
+			`{"messageId": "xxx", "result": "complete"}`+", result can be complete, reject or abandon.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Reads(message.EnqueueReq{}).
+		Returns(200, "OK", rest.RespOK(message.Message{})))
+
+	ws.Route(ws.GET("/{id}/messages").
+		To(QueryHandler(ctx, svc)).
+		Operation("query-messages").
+		Doc("get cloud-to-device messages of the thing").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.QueryParameter("status", "").PossibleValues(statusValues)).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(message.Page{})))
+
+	ws.Route(ws.GET("/{id}/messages/{messageId}").
+		To(GetHandler(ctx, svc)).
+		Operation("get-message").
+		Doc("get cloud-to-device message").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.PathParameter("messageId", "")).
+		Returns(200, "OK", rest.RespOK(message.Message{})))
+
+	ws.Route(ws.DELETE("/{id}/messages/{messageId}").
+		To(DeleteHandler(ctx, svc)).
+		Operation("delete-message").
+		Doc("delete cloud-to-device message").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.PathParameter("messageId", "")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	ws.Route(ws.DELETE("/{id}/messages").
+		To(PurgeHandler(ctx, svc)).
+		Operation("purge-messages").
+		Doc("delete cloud-to-device messages of the thing, all messages are deleted if no status specified").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.QueryParameter("status", "").PossibleValues(statusValues)).
+		Returns(200, "OK", rest.RespOK(PurgeResp{})))
+
+	return ws
+}
+
+func EnqueueHandler(ctx context.Context, svc message.Service, thingSvc thing.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		thingId := r.PathParameter("id")
+		var req message.EnqueueReq
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		exist, err := thingSvc.Exist(ctx, thingId)
+		if err != nil {
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			return
+		}
+		if !exist {
+			rest.SendResp(w, 404, rest.Resp[any]{Code: 404, Message: "thing not found"})
+			return
+		}
+		m, err := svc.Enqueue(ctx, thingId, req)
+		if err != nil {
+			log.Errorf("Enqueue message error: %v, thingId=%q", err, thingId)
+			checkErrAndSend(err, w)
+			return
+		}
+		rest.SendRespOK(w, m)
+	}
+}
+
+func QueryHandler(ctx context.Context, svc message.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		q := message.PageQuery{
+			Status:    message.Status(r.QueryParameter("status")),
+			PageQuery: getPageQuery(r),
+		}
+		p, err := svc.Query(ctx, r.PathParameter("id"), q)
+		if err != nil {
+			checkErrAndSend(err, w)
+			return
+		}
+		rest.SendRespOK(w, p)
+	}
+}
+
+func GetHandler(ctx context.Context, svc message.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		m, err := svc.Get(ctx, r.PathParameter("id"), r.PathParameter("messageId"))
+		if err != nil {
+			checkErrAndSend(err, w)
+			return
+		}
+		rest.SendRespOK(w, m)
+	}
+}
+
+func DeleteHandler(ctx context.Context, svc message.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		if err := svc.Delete(ctx, r.PathParameter("id"), r.PathParameter("messageId")); err != nil {
+			checkErrAndSend(err, w)
+			return
+		}
+		rest.SendRespOK(w, "")
+	}
+}
+
+func PurgeHandler(ctx context.Context, svc message.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		thingId := r.PathParameter("id")
+		n, err := svc.Purge(ctx, thingId, message.Status(r.QueryParameter("status")))
+		if err != nil {
+			checkErrAndSend(err, w)
+			return
+		}
+		log.Infof("Purged %d messages of thing %q", n, thingId)
+		rest.SendRespOK(w, PurgeResp{Count: n})
+	}
+}
+
+func getPageQuery(r *restful.Request) model.PageQuery {
+	var err error
+	q := model.PageQuery{}
+	if q.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex")); err != nil || q.PageIndex < 1 {
+		q.PageIndex = 1
+	}
+	if q.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize")); err != nil || q.PageSize < 1 {
+		q.PageSize = 10
+	}
+	return q
+}
+
+func checkErrAndSend(err error, w http.ResponseWriter) {
+	var he model.HttpErr
+	if ok := errors.As(err, &he); ok {
+		rest.SendResp(w, he.HttpCode, rest.Resp[string]{Code: he.Code, Message: err.Error()})
+	} else {
+		rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+	}
+}
@@ -0,0 +1,57 @@
+package message
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/datatypes"
+)
+
+type Entity struct {
+	Seq           int64          `gorm:"primaryKey;autoIncrement"`
+	Id            string         `gorm:"size:64;NOT NULL;uniqueIndex"`
+	ThingId       string         `gorm:"size:64;NOT NULL;index:idx_c2d_thing_status"`
+	Status        Status         `gorm:"size:32;NOT NULL;index:idx_c2d_thing_status"`
+	Data          datatypes.JSON `gorm:"NOT NULL"`
+	DeliveryCount int            `gorm:"NOT NULL;default:0"`
+	ExpiresAt     time.Time      `gorm:"NOT NULL;index"`
+	LockedUntil   *time.Time
+	DeliveredAt   *time.Time
+	FinishedAt    *time.Time
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (Entity) TableName() string {
+	return "c2d_message"
+}
+
+func toMessage(e Entity) (Message, error) {
+	m := Message{
+		Id:            e.Id,
+		ThingId:       e.ThingId,
+		Status:        e.Status,
+		DeliveryCount: e.DeliveryCount,
+		ExpiresAt:     e.ExpiresAt,
+		DeliveredAt:   e.DeliveredAt,
+		FinishedAt:    e.FinishedAt,
+		UpdatedAt:     e.UpdatedAt,
+		CreatedAt:     e.CreatedAt,
+	}
+	if err := json.Unmarshal(e.Data, &m.Data); err != nil {
+		return m, errors.Wrapf(err, "unmarshal data of message %s", e.Id)
+	}
+	return m, nil
+}
+
+func toDeliverMsg(e Entity) DeliverMsg {
+	return DeliverMsg{
+		MessageId:     e.Id,
+		Data:          json.RawMessage(e.Data),
+		DeliveryCount: e.DeliveryCount,
+		ExpiresAt:     e.ExpiresAt,
+		CreatedAt:     e.CreatedAt,
+	}
+}
@@ -0,0 +1,125 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"ruff.io/tio/pkg/model"
+)
+
+// Cloud-to-device messages.
+// Messages are queued per thing and delivered to the thing once it's online,
+// the thing should acknowledge every message it received with complete, reject or abandon.
+// A delivered message which is not acknowledged in time, or abandoned, will be delivered again.
+
+type Status string
+
+const (
+	// StatusQueued waiting to deliver
+	StatusQueued Status = "QUEUED"
+	// StatusDelivered sent to the thing, waiting for its acknowledgement
+	StatusDelivered Status = "DELIVERED"
+	// StatusCompleted the thing has processed the message
+	StatusCompleted Status = "COMPLETED"
+	// StatusRejected the thing refused to process the message
+	StatusRejected Status = "REJECTED"
+	// StatusExpired the message is not completed before its expiry time
+	StatusExpired Status = "EXPIRED"
+	// StatusDeadLettered the message has been delivered too many times without completion
+	StatusDeadLettered Status = "DEAD_LETTERED"
+)
+
+func (s Status) Pending() bool {
+	return s == StatusQueued || s == StatusDelivered
+}
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusQueued, StatusDelivered, StatusCompleted, StatusRejected, StatusExpired, StatusDeadLettered:
+		return true
+	}
+	return false
+}
+
+type AckResult string
+
+const (
+	AckComplete AckResult = "complete"
+	AckReject   AckResult = "reject"
+	AckAbandon  AckResult = "abandon"
+)
+
+type Message struct {
+	Id            string     `json:"messageId"`
+	ThingId       string     `json:"thingId"`
+	Data          any        `json:"data"`
+	Status        Status     `json:"status"`
+	DeliveryCount int        `json:"deliveryCount"`
+	ExpiresAt     time.Time  `json:"expiresAt"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty" description:"time of completed, rejected, expired or dead lettered"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+type EnqueueReq struct {
+	Data any `json:"data" description:"Any legal json data, including basic types, array, object, etc."`
+	TTL  int `json:"ttl" description:"time to live in seconds, the message expires if it's not completed in time"`
+}
+
+type PageQuery struct {
+	Status Status
+	model.PageQuery
+}
+
+type Page = model.PageData[Message]
+
+// DeliverMsg is published to the thing
+type DeliverMsg struct {
+	MessageId     string    `json:"messageId"`
+	Data          any       `json:"data"`
+	DeliveryCount int       `json:"deliveryCount"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// AckMsg is published by the thing
+type AckMsg struct {
+	MessageId string    `json:"messageId"`
+	Result    AckResult `json:"result"`
+}
+
+type Service interface {
+	Enqueue(ctx context.Context, thingId string, req EnqueueReq) (Message, error)
+	Get(ctx context.Context, thingId, messageId string) (Message, error)
+	Query(ctx context.Context, thingId string, q PageQuery) (Page, error)
+	Delete(ctx context.Context, thingId, messageId string) error
+	// Purge deletes messages of the thing with the status, or all messages when status is empty
+	Purge(ctx context.Context, thingId string, status Status) (int64, error)
+
+	// Start receiving acknowledgements and delivering messages until context done
+	Start(ctx context.Context) error
+}
+
+type Repo interface {
+	Create(ctx context.Context, e *Entity) error
+	Get(ctx context.Context, thingId, messageId string) (*Entity, error)
+	Query(ctx context.Context, thingId string, q PageQuery) (model.PageData[Entity], error)
+	CountByStatus(ctx context.Context, thingId string, status ...Status) (int64, error)
+	Delete(ctx context.Context, thingId, messageId string) error
+	Purge(ctx context.Context, thingId string, status Status) (int64, error)
+	// DeleteByThing deletes all messages of the thing, it's a thing.DeleteHook,
+	// so that messages of a deleted thing aren't delivered to a new one with the same id
+	DeleteByThing(ctx context.Context, thingId string) error
+
+	// ListQueued returns queued messages not expired in order
+	ListQueued(ctx context.Context, thingId string, now time.Time, limit int) ([]Entity, error)
+	// ThingsWithQueued returns things having queued messages not expired
+	ThingsWithQueued(ctx context.Context, now time.Time) ([]string, error)
+	// ListDelivered returns delivered messages whose lock expired before the time, or all if thingId is specified
+	ListDelivered(ctx context.Context, thingId string, lockedBefore time.Time) ([]Entity, error)
+	// UpdateStatus updates the message only when its status is the `from` one, returns whether it's updated
+	UpdateStatus(ctx context.Context, messageId string, from Status, to Status, fields map[string]any) (bool, error)
+	Expire(ctx context.Context, now time.Time) (int64, error)
+	DeleteFinishedBefore(ctx context.Context, t time.Time) (int64, error)
+}
@@ -0,0 +1,141 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type repo struct {
+	db *gorm.DB
+}
+
+var _ Repo = (*repo)(nil)
+
+func NewRepo(db *gorm.DB) Repo {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, e *Entity) error {
+	if err := r.db.WithContext(ctx).Create(e).Error; err != nil {
+		return errors.Wrap(err, "create message")
+	}
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, thingId, messageId string) (*Entity, error) {
+	var e Entity
+	err := r.db.WithContext(ctx).Where("thing_id = ? AND id = ?", thingId, messageId).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get message")
+	}
+	return &e, nil
+}
+
+func (r *repo) Query(ctx context.Context, thingId string, q PageQuery) (model.PageData[Entity], error) {
+	tx := r.db.WithContext(ctx).Model(&Entity{}).Where("thing_id = ?", thingId)
+	if q.Status != "" {
+		tx = tx.Where("status = ?", q.Status)
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[Entity]{}, errors.Wrap(err, "count message")
+	}
+	var l []Entity
+	if err := tx.Order("seq").Offset(q.Offset()).Limit(q.Limit()).Find(&l).Error; err != nil {
+		return model.PageData[Entity]{}, errors.Wrap(err, "query message")
+	}
+	return model.PageData[Entity]{Total: total, Content: l}, nil
+}
+
+func (r *repo) CountByStatus(ctx context.Context, thingId string, status ...Status) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&Entity{}).
+		Where("thing_id = ? AND status IN ?", thingId, status).
+		Count(&n).Error
+	return n, errors.Wrap(err, "count message")
+}
+
+func (r *repo) Delete(ctx context.Context, thingId, messageId string) error {
+	err := r.db.WithContext(ctx).Where("thing_id = ? AND id = ?", thingId, messageId).Delete(&Entity{}).Error
+	return errors.Wrap(err, "delete message")
+}
+
+func (r *repo) Purge(ctx context.Context, thingId string, status Status) (int64, error) {
+	tx := r.db.WithContext(ctx).Where("thing_id = ?", thingId)
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	res := tx.Delete(&Entity{})
+	return res.RowsAffected, errors.Wrap(res.Error, "purge message")
+}
+
+func (r *repo) DeleteByThing(ctx context.Context, thingId string) error {
+	_, err := r.Purge(ctx, thingId, "")
+	return err
+}
+
+func (r *repo) ListQueued(ctx context.Context, thingId string, now time.Time, limit int) ([]Entity, error) {
+	var l []Entity
+	err := r.db.WithContext(ctx).
+		Where("thing_id = ? AND status = ? AND expires_at > ?", thingId, StatusQueued, now).
+		Order("seq").
+		Limit(limit).
+		Find(&l).Error
+	return l, errors.Wrap(err, "list queued message")
+}
+
+func (r *repo) ThingsWithQueued(ctx context.Context, now time.Time) ([]string, error) {
+	var l []string
+	err := r.db.WithContext(ctx).Model(&Entity{}).
+		Distinct("thing_id").
+		Where("status = ? AND expires_at > ?", StatusQueued, now).
+		Pluck("thing_id", &l).Error
+	return l, errors.Wrap(err, "list things with queued message")
+}
+
+func (r *repo) ListDelivered(ctx context.Context, thingId string, lockedBefore time.Time) ([]Entity, error) {
+	tx := r.db.WithContext(ctx).Where("status = ?", StatusDelivered)
+	if thingId != "" {
+		tx = tx.Where("thing_id = ?", thingId)
+	} else {
+		tx = tx.Where("locked_until < ?", lockedBefore)
+	}
+	var l []Entity
+	err := tx.Order("seq").Find(&l).Error
+	return l, errors.Wrap(err, "list delivered message")
+}
+
+func (r *repo) UpdateStatus(ctx context.Context, messageId string, from Status, to Status, fields map[string]any) (bool, error) {
+	up := map[string]any{"status": to}
+	for k, v := range fields {
+		up[k] = v
+	}
+	res := r.db.WithContext(ctx).Model(&Entity{}).
+		Where("id = ? AND status = ?", messageId, from).
+		Updates(up)
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "update message status")
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *repo) Expire(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Model(&Entity{}).
+		Where("status IN ? AND expires_at <= ?", []Status{StatusQueued, StatusDelivered}, now).
+		Updates(map[string]any{"status": StatusExpired, "finished_at": now, "locked_until": nil})
+	return res.RowsAffected, errors.Wrap(res.Error, "expire message")
+}
+
+func (r *repo) DeleteFinishedBefore(ctx context.Context, t time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("status NOT IN ? AND finished_at < ?", []Status{StatusQueued, StatusDelivered}, t).
+		Delete(&Entity{})
+	return res.RowsAffected, errors.Wrap(res.Error, "delete finished message")
+}
@@ -0,0 +1,384 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+const (
+	MaxDataSize = 64 * 1024
+
+	defaultTTL              = time.Hour
+	defaultMaxTTL           = time.Hour * 24 * 7
+	defaultLockTimeout      = time.Minute
+	defaultMaxDeliveryCount = 10
+	defaultMaxQueueSize     = 50
+	defaultMaxInFlight      = 10
+	defaultRetention        = time.Hour * 24 * 7
+	defaultCheckInterval    = time.Second * 5
+
+	presenceQueueSize = 1024
+)
+
+type Options struct {
+	// DefaultTTL for messages enqueued without ttl
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	// LockTimeout how long to wait for the acknowledgement of a delivered message before delivering it again
+	LockTimeout time.Duration
+	// MaxDeliveryCount a message is dead lettered after delivered so many times without completion
+	MaxDeliveryCount int
+	// MaxQueueSize max count of pending (queued and delivered) messages of a thing
+	MaxQueueSize int
+	// MaxInFlight max count of delivered messages waiting for acknowledgement of a thing
+	MaxInFlight int
+	// Retention finished messages are removed after this time
+	Retention time.Duration
+	// CheckInterval for expiring messages and redelivering unacknowledged messages
+	CheckInterval time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.DefaultTTL <= 0 {
+		o.DefaultTTL = defaultTTL
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = defaultMaxTTL
+	}
+	if o.LockTimeout <= 0 {
+		o.LockTimeout = defaultLockTimeout
+	}
+	if o.MaxDeliveryCount <= 0 {
+		o.MaxDeliveryCount = defaultMaxDeliveryCount
+	}
+	if o.MaxQueueSize <= 0 {
+		o.MaxQueueSize = defaultMaxQueueSize
+	}
+	if o.MaxInFlight <= 0 {
+		o.MaxInFlight = defaultMaxInFlight
+	}
+	if o.Retention <= 0 {
+		o.Retention = defaultRetention
+	}
+	if o.CheckInterval <= 0 {
+		o.CheckInterval = defaultCheckInterval
+	}
+}
+
+type svcImpl struct {
+	opt        Options
+	repo       Repo
+	conn       connector.Connector
+	idProvider tio.IdProvider
+
+	// serialize deliveries so that a message is not sent twice concurrently
+	deliverMu sync.Mutex
+}
+
+var _ Service = (*svcImpl)(nil)
+
+func NewSvc(opt Options, r Repo, conn connector.Connector, idProvider tio.IdProvider) Service {
+	opt.setDefaults()
+	return &svcImpl{opt: opt, repo: r, conn: conn, idProvider: idProvider}
+}
+
+func (s *svcImpl) Enqueue(ctx context.Context, thingId string, req EnqueueReq) (Message, error) {
+	ttl := time.Duration(req.TTL) * time.Second
+	if req.TTL < 0 || ttl > s.opt.MaxTTL {
+		return Message{}, errors.WithMessagef(model.ErrInvalidParams, "ttl should between 0 and %d seconds", int(s.opt.MaxTTL.Seconds()))
+	}
+	if ttl == 0 {
+		ttl = s.opt.DefaultTTL
+	}
+	data, err := json.Marshal(req.Data)
+	if err != nil {
+		return Message{}, errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	if len(data) > MaxDataSize {
+		return Message{}, errors.WithMessagef(model.ErrPayloadTooLarge, "message data should be less than %d bytes", MaxDataSize)
+	}
+	n, err := s.repo.CountByStatus(ctx, thingId, StatusQueued, StatusDelivered)
+	if err != nil {
+		return Message{}, err
+	}
+	if n >= int64(s.opt.MaxQueueSize) {
+		return Message{}, errors.WithMessagef(model.ErrInvalidParams,
+			"thing %q has %d pending messages, exceeds the limit", thingId, n)
+	}
+	id, err := s.idProvider.ID()
+	if err != nil {
+		return Message{}, errors.Wrap(err, "message id generate")
+	}
+	e := Entity{
+		Id:        id,
+		ThingId:   thingId,
+		Status:    StatusQueued,
+		Data:      data,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.repo.Create(ctx, &e); err != nil {
+		return Message{}, err
+	}
+
+	go s.deliver(ctx, thingId)
+
+	return toMessage(e)
+}
+
+func (s *svcImpl) Get(ctx context.Context, thingId, messageId string) (Message, error) {
+	e, err := s.repo.Get(ctx, thingId, messageId)
+	if err != nil {
+		return Message{}, err
+	}
+	if e == nil {
+		return Message{}, errors.WithMessagef(model.ErrNotFound, "message %q of thing %q", messageId, thingId)
+	}
+	return toMessage(*e)
+}
+
+func (s *svcImpl) Query(ctx context.Context, thingId string, q PageQuery) (Page, error) {
+	if q.Status != "" && !q.Status.Valid() {
+		return Page{}, errors.WithMessagef(model.ErrInvalidParams, "status %q", q.Status)
+	}
+	p, err := s.repo.Query(ctx, thingId, q)
+	if err != nil {
+		return Page{}, err
+	}
+	res := Page{Total: p.Total, Content: make([]Message, len(p.Content))}
+	for i, e := range p.Content {
+		if res.Content[i], err = toMessage(e); err != nil {
+			return Page{}, err
+		}
+	}
+	return res, nil
+}
+
+func (s *svcImpl) Delete(ctx context.Context, thingId, messageId string) error {
+	return s.repo.Delete(ctx, thingId, messageId)
+}
+
+func (s *svcImpl) Purge(ctx context.Context, thingId string, status Status) (int64, error) {
+	if status != "" && !status.Valid() {
+		return 0, errors.WithMessagef(model.ErrInvalidParams, "status %q", status)
+	}
+	return s.repo.Purge(ctx, thingId, status)
+}
+
+func (s *svcImpl) Start(ctx context.Context) error {
+	err := s.conn.Subscribe(ctx, TopicAckAll, 1, func(msg connector.Message) {
+		go s.onAck(ctx, msg)
+	})
+	if err != nil {
+		return errors.Wrap(err, "subscribe message ack topic")
+	}
+
+	go s.presenceLoop(ctx)
+	go s.checkLoop(ctx)
+	log.Info("Cloud-to-device message service started")
+	return nil
+}
+
+func (s *svcImpl) onAck(ctx context.Context, msg connector.Message) {
+	thingId, err := shadow.GetThingIdFromTopic(msg.Topic())
+	if err != nil {
+		log.Errorf("Got wrong topic for message ack: %v, topic=%q", err, msg.Topic())
+		return
+	}
+	var ack AckMsg
+	if err := json.Unmarshal(msg.Payload(), &ack); err != nil {
+		log.Errorf("Invalid payload for message ack: %s, topic=%q", msg.Payload(), msg.Topic())
+		return
+	}
+	e, err := s.repo.Get(ctx, thingId, ack.MessageId)
+	if err != nil {
+		log.Errorf("Get message for ack error: %v, thingId=%q messageId=%q", err, thingId, ack.MessageId)
+		return
+	}
+	if e == nil {
+		log.Warnf("Got ack of unknown message, thingId=%q messageId=%q", thingId, ack.MessageId)
+		return
+	}
+
+	now := time.Now()
+	var ok bool
+	switch ack.Result {
+	case AckComplete:
+		ok, err = s.repo.UpdateStatus(ctx, e.Id, StatusDelivered, StatusCompleted,
+			map[string]any{"finished_at": now, "locked_until": nil})
+	case AckReject:
+		ok, err = s.repo.UpdateStatus(ctx, e.Id, StatusDelivered, StatusRejected,
+			map[string]any{"finished_at": now, "locked_until": nil})
+	case AckAbandon:
+		ok, err = s.release(ctx, *e)
+	default:
+		log.Warnf("Got invalid message ack result %q, thingId=%q messageId=%q", ack.Result, thingId, ack.MessageId)
+		return
+	}
+	if err != nil {
+		log.Errorf("Update message status for ack error: %v, thingId=%q messageId=%q", err, thingId, ack.MessageId)
+		return
+	}
+	if !ok {
+		log.Warnf("Ignored message ack %q, current status is %q, thingId=%q messageId=%q",
+			ack.Result, e.Status, thingId, ack.MessageId)
+		return
+	}
+	log.Debugf("Message acknowledged %q, thingId=%q messageId=%q", ack.Result, thingId, ack.MessageId)
+
+	s.deliver(ctx, thingId)
+}
+
+// release a delivered message to be delivered again, or dead lettered if delivered too many times
+func (s *svcImpl) release(ctx context.Context, e Entity) (bool, error) {
+	if e.DeliveryCount >= s.opt.MaxDeliveryCount {
+		return s.repo.UpdateStatus(ctx, e.Id, StatusDelivered, StatusDeadLettered,
+			map[string]any{"finished_at": time.Now(), "locked_until": nil})
+	}
+	return s.repo.UpdateStatus(ctx, e.Id, StatusDelivered, StatusQueued, map[string]any{"locked_until": nil})
+}
+
+// presenceLoop takes presence events off the event bus without blocking it,
+// they are handled in order by presenceWorker
+func (s *svcImpl) presenceLoop(ctx context.Context) {
+	ch := s.conn.OnConnect()
+	queue := make(chan connector.PresenceEvent, presenceQueueSize)
+	go s.presenceWorker(ctx, queue)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			select {
+			case queue <- e:
+			default:
+				// messages are delivered or released by the check loop later
+				log.Warnf("Presence event queue of message service is full, dropped event %q of thing %q",
+					e.EventType, e.ThingId)
+			}
+		}
+	}
+}
+
+func (s *svcImpl) presenceWorker(ctx context.Context, queue <-chan connector.PresenceEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-queue:
+			// handled in order, messages delivered after reconnecting should not be released
+			if e.EventType == connector.EventConnected {
+				s.deliver(ctx, e.ThingId)
+			} else if e.EventType == connector.EventDisconnected {
+				// messages in flight are lost with the clean session
+				s.releaseDelivered(ctx, e.ThingId, time.Time{})
+			}
+		}
+	}
+}
+
+func (s *svcImpl) checkLoop(ctx context.Context) {
+	tick := time.NewTicker(s.opt.CheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Cloud-to-device message check loop exit")
+			return
+		case <-tick.C:
+			s.check(ctx)
+		}
+	}
+}
+
+func (s *svcImpl) check(ctx context.Context) {
+	now := time.Now()
+	if n, err := s.repo.Expire(ctx, now); err != nil {
+		log.Errorf("Expire messages error: %v", err)
+	} else if n > 0 {
+		log.Infof("Expired %d messages", n)
+	}
+
+	s.releaseDelivered(ctx, "", now)
+
+	things, err := s.repo.ThingsWithQueued(ctx, now)
+	if err != nil {
+		log.Errorf("Get things with queued messages error: %v", err)
+	}
+	for _, th := range things {
+		s.deliver(ctx, th)
+	}
+
+	if _, err := s.repo.DeleteFinishedBefore(ctx, now.Add(-s.opt.Retention)); err != nil {
+		log.Errorf("Delete finished messages error: %v", err)
+	}
+}
+
+// releaseDelivered releases delivered messages of the thing, or all messages whose lock expired if thingId is empty
+func (s *svcImpl) releaseDelivered(ctx context.Context, thingId string, lockedBefore time.Time) {
+	l, err := s.repo.ListDelivered(ctx, thingId, lockedBefore)
+	if err != nil {
+		log.Errorf("List delivered messages error: %v", err)
+		return
+	}
+	for _, e := range l {
+		if _, err := s.release(ctx, e); err != nil {
+			log.Errorf("Release delivered message error: %v, thingId=%q messageId=%q", err, e.ThingId, e.Id)
+		}
+	}
+}
+
+// deliver queued messages to the thing if it's online
+func (s *svcImpl) deliver(ctx context.Context, thingId string) {
+	s.deliverMu.Lock()
+	defer s.deliverMu.Unlock()
+
+	if online, err := s.conn.IsConnected(thingId); err != nil || !online {
+		return
+	}
+	inFlight, err := s.repo.CountByStatus(ctx, thingId, StatusDelivered)
+	if err != nil {
+		log.Errorf("Count delivered messages error: %v, thingId=%q", err, thingId)
+		return
+	}
+	limit := s.opt.MaxInFlight - int(inFlight)
+	if limit <= 0 {
+		return
+	}
+	now := time.Now()
+	l, err := s.repo.ListQueued(ctx, thingId, now, limit)
+	if err != nil {
+		log.Errorf("List queued messages error: %v, thingId=%q", err, thingId)
+		return
+	}
+	for _, e := range l {
+		e.DeliveryCount++
+		ok, err := s.repo.UpdateStatus(ctx, e.Id, StatusQueued, StatusDelivered, map[string]any{
+			"delivery_count": e.DeliveryCount,
+			"delivered_at":   now,
+			"locked_until":   now.Add(s.opt.LockTimeout),
+		})
+		if err != nil || !ok {
+			log.Warnf("Skip delivering message, thingId=%q messageId=%q updated=%v error: %v", thingId, e.Id, ok, err)
+			continue
+		}
+		payload, _ := json.Marshal(toDeliverMsg(e))
+		if err := s.conn.Publish(TopicDeliver(thingId), 1, false, payload); err != nil {
+			log.Errorf("Publish message error: %v, thingId=%q messageId=%q", err, thingId, e.Id)
+			_, _ = s.repo.UpdateStatus(ctx, e.Id, StatusDelivered, StatusQueued, map[string]any{"locked_until": nil})
+			return
+		}
+		log.Debugf("Message delivered, thingId=%q messageId=%q deliveryCount=%d", thingId, e.Id, e.DeliveryCount)
+	}
+}
@@ -0,0 +1,173 @@
+package message_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/connector"
+	mqMock "ruff.io/tio/connector/mqtt/mock"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/message"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/pkg/uuid"
+)
+
+type device struct {
+	mu       sync.Mutex
+	received []message.DeliverMsg
+}
+
+func (d *device) messages() []message.DeliverMsg {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]message.DeliverMsg{}, d.received...)
+}
+
+func prepare(t *testing.T, ackResult message.AckResult) (context.Context, message.Service, *device, chan connector.PresenceEvent) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(&message.Entity{}))
+
+	mockMqtt := mqMock.NewMqttClient("", nil, nil)
+	mockMqtt.On("Subscribe", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMqtt.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mqMock.NewMockToken())
+	c := mqMock.NewAdapter(mockMqtt)
+	conn := &c
+	onConnCh := make(chan connector.PresenceEvent)
+	var outCh <-chan connector.PresenceEvent = onConnCh
+	conn.On("OnConnect").Return(outCh)
+	conn.On("IsConnected", "online").Return(true, nil)
+	conn.On("IsConnected", "offline").Return(false, nil)
+
+	svc := message.NewSvc(message.Options{CheckInterval: time.Millisecond * 20, MaxDeliveryCount: 2},
+		message.NewRepo(db), conn, uuid.New())
+	require.NoError(t, svc.Start(ctx))
+
+	dev := &device{}
+	err := conn.Subscribe(ctx, message.TopicDeliver("online"), 1, func(msg connector.Message) {
+		var m message.DeliverMsg
+		_ = json.Unmarshal(msg.Payload(), &m)
+		dev.mu.Lock()
+		dev.received = append(dev.received, m)
+		dev.mu.Unlock()
+		ack, _ := json.Marshal(message.AckMsg{MessageId: m.MessageId, Result: ackResult})
+		go func() { _ = conn.Publish(message.TopicAck("online"), 1, false, ack) }()
+	})
+	require.NoError(t, err)
+	return ctx, svc, dev, onConnCh
+}
+
+func waitStatus(t *testing.T, ctx context.Context, svc message.Service, thingId, id string, status message.Status) message.Message {
+	var m message.Message
+	require.Eventually(t, func() bool {
+		var err error
+		m, err = svc.Get(ctx, thingId, id)
+		require.NoError(t, err)
+		return m.Status == status
+	}, time.Second, time.Millisecond*10, "message should be %s", status)
+	return m
+}
+
+func TestSvc_Enqueue(t *testing.T) {
+	ctx, svc, _, _ := prepare(t, message.AckComplete)
+
+	t.Run("invalid ttl", func(t *testing.T) {
+		_, err := svc.Enqueue(ctx, "offline", message.EnqueueReq{Data: "x", TTL: -1})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, err := svc.Enqueue(ctx, "offline", message.EnqueueReq{Data: string(make([]byte, message.MaxDataSize))})
+		require.ErrorIs(t, err, model.ErrPayloadTooLarge)
+	})
+
+	t.Run("queued for offline thing", func(t *testing.T) {
+		m, err := svc.Enqueue(ctx, "offline", message.EnqueueReq{Data: map[string]any{"text": "hello"}, TTL: 60})
+		require.NoError(t, err)
+		require.Equal(t, message.StatusQueued, m.Status)
+		time.Sleep(time.Millisecond * 50)
+		m, err = svc.Get(ctx, "offline", m.Id)
+		require.NoError(t, err)
+		require.Equal(t, message.StatusQueued, m.Status)
+		require.Equal(t, map[string]any{"text": "hello"}, m.Data)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		m, err := svc.Enqueue(ctx, "offline", message.EnqueueReq{Data: "x", TTL: 1})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			m, _ = svc.Get(ctx, "offline", m.Id)
+			return m.Status == message.StatusExpired
+		}, time.Second*3, time.Millisecond*50)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		n, err := svc.Purge(ctx, "offline", message.StatusQueued)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		p, err := svc.Query(ctx, "offline", message.PageQuery{PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), p.Total)
+		require.Equal(t, message.StatusExpired, p.Content[0].Status)
+	})
+}
+
+func TestSvc_Deliver(t *testing.T) {
+	t.Run("complete", func(t *testing.T) {
+		ctx, svc, dev, _ := prepare(t, message.AckComplete)
+		m, err := svc.Enqueue(ctx, "online", message.EnqueueReq{Data: "hello"})
+		require.NoError(t, err)
+		m = waitStatus(t, ctx, svc, "online", m.Id, message.StatusCompleted)
+		require.Equal(t, 1, m.DeliveryCount)
+		require.NotNil(t, m.FinishedAt)
+		require.Len(t, dev.messages(), 1)
+		require.Equal(t, m.Id, dev.messages()[0].MessageId)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		ctx, svc, _, _ := prepare(t, message.AckReject)
+		m, err := svc.Enqueue(ctx, "online", message.EnqueueReq{Data: "hello"})
+		require.NoError(t, err)
+		waitStatus(t, ctx, svc, "online", m.Id, message.StatusRejected)
+	})
+
+	t.Run("abandon until dead lettered", func(t *testing.T) {
+		ctx, svc, dev, _ := prepare(t, message.AckAbandon)
+		m, err := svc.Enqueue(ctx, "online", message.EnqueueReq{Data: "hello"})
+		require.NoError(t, err)
+		m = waitStatus(t, ctx, svc, "online", m.Id, message.StatusDeadLettered)
+		require.Equal(t, 2, m.DeliveryCount)
+		require.Len(t, dev.messages(), 2)
+	})
+}
+
+func TestRepo_DeleteByThing(t *testing.T) {
+	ctx := context.Background()
+	db := dbMock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(&message.Entity{}))
+	repo := message.NewRepo(db)
+	for i, e := range []message.Entity{
+		{Id: "m1", ThingId: "deleted", Status: message.StatusQueued},
+		{Id: "m2", ThingId: "deleted", Status: message.StatusDelivered},
+		{Id: "m3", ThingId: "other", Status: message.StatusQueued},
+	} {
+		e.Data = []byte(`"x"`)
+		e.ExpiresAt = time.Now().Add(time.Hour)
+		require.NoError(t, repo.Create(ctx, &e), i)
+	}
+
+	require.NoError(t, repo.DeleteByThing(ctx, "deleted"))
+	n, err := repo.CountByStatus(ctx, "deleted", message.StatusQueued, message.StatusDelivered)
+	require.NoError(t, err)
+	require.Zero(t, n, "queued and delivered messages of the deleted thing should be removed")
+	n, err = repo.CountByStatus(ctx, "other", message.StatusQueued)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+}
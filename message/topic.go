@@ -0,0 +1,22 @@
+package message
+
+import "strings"
+
+const (
+	TopicPrefixTmpl = "$iothub/things/{thingId}/messages"
+
+	// TopicDeliverTmpl messages are delivered to the thing via this topic
+	TopicDeliverTmpl = TopicPrefixTmpl + "/deliver"
+	// TopicAckTmpl the thing acknowledges messages via this topic
+	TopicAckTmpl = TopicPrefixTmpl + "/ack"
+
+	TopicAckAll = "$iothub/things/+/messages/ack"
+)
+
+func TopicDeliver(thingId string) string {
+	return strings.ReplaceAll(TopicDeliverTmpl, "{thingId}", thingId)
+}
+
+func TopicAck(thingId string) string {
+	return strings.ReplaceAll(TopicAckTmpl, "{thingId}", thingId)
+}
@@ -26,6 +26,7 @@ var (
 	ErrDuplicated             = MkHttpErr("entity already exists", 400, 400)
 	ErrVersionConflict        = MkHttpErr("version conflict", 409, 409)
 	ErrInvalidStateTransition = MkHttpErr("an invalid state transition was attempted", 409, 409)
+	ErrInUse                  = MkHttpErr("entity is in use", 409, 409)
 	ErrPayloadTooLarge        = MkHttpErr("payload too large", 413, 413)
 
 	ErrInternal = MkHttpErr("server internal error", 500, 500)
@@ -0,0 +1,14 @@
+package strutil
+
+import "unicode/utf8"
+
+// Cut cuts the string to at most max bytes without splitting a multi-byte rune
+func Cut(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+	"ruff.io/tio/provision"
+)
+
+func Service(ctx context.Context, svc provision.Service) *restful.WebService {
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/provisioningTemplates").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	tags := []string{"provisioning"}
+
+	ws.Route(ws.GET("/").
+		To(func(r *restful.Request, w *restful.Response) {
+			p, err := svc.Query(ctx, getPageQuery(r))
+			sendResp(w, p, err)
+		}).
+		Operation("query-provisioning-templates").
+		Doc("get provisioning templates").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(provision.TemplatePage{})))
+
+	ws.Route(ws.POST("/").
+		To(func(r *restful.Request, w *restful.Response) {
+			var t provision.Template
+			if err := r.ReadEntity(&t); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			res, err := svc.Create(ctx, t)
+			sendResp(w, res, err)
+		}).
+		Operation("create-provisioning-template").
+		Doc("create provisioning template").
+		Notes("Devices connect to the embedded broker with username `$provision/{name}`, client id `$provision/{thingId}`, "+
+			"and the claim secret as password or the claim certificate. "+
+			"They publish to `$iothub/provisioning/{name}/{thingId}/register` "+
+			"and get the permanent password from `$iothub/provisioning/{name}/{thingId}/register/accepted`. "+
+			"The claim secret is returned only in this response.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(provision.Template{}).
+		Returns(200, "OK", rest.RespOK(provision.Template{})))
+
+	ws.Route(ws.GET("/{name}").
+		To(func(r *restful.Request, w *restful.Response) {
+			res, err := svc.Get(ctx, r.PathParameter("name"))
+			sendResp(w, res, err)
+		}).
+		Operation("get-provisioning-template").
+		Doc("get provisioning template").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "template name")).
+		Returns(200, "OK", rest.RespOK(provision.Template{})))
+
+	ws.Route(ws.PUT("/{name}").
+		To(func(r *restful.Request, w *restful.Response) {
+			var t provision.Template
+			if err := r.ReadEntity(&t); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+				return
+			}
+			t.Name = r.PathParameter("name")
+			res, err := svc.Update(ctx, t)
+			sendResp(w, res, err)
+		}).
+		Operation("update-provisioning-template").
+		Doc("update provisioning template").
+		Notes("The claim secret is kept if it's empty.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "template name")).
+		Reads(provision.Template{}).
+		Returns(200, "OK", rest.RespOK(provision.Template{})))
+
+	ws.Route(ws.DELETE("/{name}").
+		To(func(r *restful.Request, w *restful.Response) {
+			err := svc.Delete(ctx, r.PathParameter("name"))
+			sendResp(w, "", err)
+		}).
+		Operation("delete-provisioning-template").
+		Doc("delete provisioning template").
+		Notes("Things provisioned by the template are kept.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("name", "template name")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	return ws
+}
+
+func sendResp[T any](w *restful.Response, data T, err error) {
+	if err != nil {
+		log.Infof("Provisioning api error: %v", err)
+		checkErrAndSend(err, w)
+		return
+	}
+	rest.SendRespOK(w, data)
+}
+
+func getPageQuery(r *restful.Request) model.PageQuery {
+	var err error
+	q := model.PageQuery{}
+	if q.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex")); err != nil || q.PageIndex < 1 {
+		q.PageIndex = 1
+	}
+	if q.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize")); err != nil || q.PageSize < 1 {
+		q.PageSize = 10
+	}
+	return q
+}
+
+func checkErrAndSend(err error, w http.ResponseWriter) {
+	var he model.HttpErr
+	if ok := errors.As(err, &he); ok {
+		rest.SendResp(w, he.HttpCode, rest.Resp[string]{Code: he.Code, Message: err.Error()})
+	} else {
+		rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+	}
+}
@@ -0,0 +1,33 @@
+package provision
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	"ruff.io/tio/connector/mqtt/embed"
+	"ruff.io/tio/pkg/log"
+)
+
+// AuthzMqttClient authenticates claim clients, whose username is `$provision/{template}` and client id is `$provision/{thingId}`
+func AuthzMqttClient(ctx context.Context, svc Service) embed.UserAuthzFn {
+	return func(connParams embed.ConnectParams, chain []*x509.Certificate) (string, bool) {
+		user, clientId := string(connParams.Username), connParams.ClientIdentifier
+		template := strings.TrimPrefix(user, UserPrefix)
+		thingId, ok := strings.CutPrefix(clientId, ClientIdPrefix)
+		if !ok {
+			log.Infof("Mqtt claim client user %s client %s is not authorized: client id should have prefix %q",
+				user, clientId, ClientIdPrefix)
+			return "", false
+		}
+		if err := svc.Claim(ctx, template, thingId, string(connParams.Password), chain); err != nil {
+			log.Infof("Mqtt claim client user %s client %s is not authorized: %v", user, thingId, err)
+			return "", false
+		}
+		if !connParams.Clean {
+			log.Warnf("Mqtt claim client user %s client %s authz error: cleanSession false is not allowed", user, thingId)
+			return "", false
+		}
+		return ClaimUser(template, thingId), true
+	}
+}
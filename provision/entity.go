@@ -0,0 +1,96 @@
+package provision
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/datatypes"
+)
+
+type TemplateEntity struct {
+	Name                 string `gorm:"primaryKey;size:64"`
+	Description          string `gorm:"size:512;NOT NULL;default:''"`
+	Enabled              bool
+	ClaimSecret          string `gorm:"size:100;NOT NULL;default:''"` // hash of the secret
+	ClaimCertPem         string `gorm:"type:text"`
+	ClaimCertFingerprint string `gorm:"size:64;NOT NULL;default:''"`
+	ThingIdPattern       string `gorm:"size:256;NOT NULL"`
+	ThingType            string `gorm:"size:64;NOT NULL;default:''"`
+	Tags                 datatypes.JSON
+	Desired              datatypes.JSON
+	AllowReprovision     bool
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (TemplateEntity) TableName() string {
+	return "provision_template"
+}
+
+// ProvisionedEntity thing provisioned by a template, only such things can be reprovisioned
+type ProvisionedEntity struct {
+	ThingId   string    `gorm:"primaryKey;size:64"`
+	Template  string    `gorm:"size:64;NOT NULL"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (ProvisionedEntity) TableName() string {
+	return "provision_thing"
+}
+
+func toEntity(t Template) (TemplateEntity, error) {
+	e := TemplateEntity{
+		Name:                 t.Name,
+		Description:          t.Description,
+		Enabled:              t.Enabled,
+		ClaimCertPem:         t.ClaimCertPem,
+		ClaimCertFingerprint: t.ClaimCertFingerprint,
+		ThingIdPattern:       t.ThingIdPattern,
+		ThingType:            t.ThingType,
+		AllowReprovision:     t.AllowReprovision,
+		CreatedAt:            t.CreatedAt,
+	}
+	var err error
+	if e.Tags, err = marshalNullable(t.Tags); err != nil {
+		return e, errors.Wrap(err, "marshal tags")
+	}
+	if e.Desired, err = marshalNullable(t.Desired); err != nil {
+		return e, errors.Wrap(err, "marshal desired")
+	}
+	return e, nil
+}
+
+func toTemplate(e TemplateEntity) (Template, error) {
+	t := Template{
+		Name:                 e.Name,
+		Description:          e.Description,
+		Enabled:              e.Enabled,
+		ClaimCertPem:         e.ClaimCertPem,
+		ClaimCertFingerprint: e.ClaimCertFingerprint,
+		ThingIdPattern:       e.ThingIdPattern,
+		ThingType:            e.ThingType,
+		AllowReprovision:     e.AllowReprovision,
+		UpdatedAt:            e.UpdatedAt,
+		CreatedAt:            e.CreatedAt,
+	}
+	if len(e.Tags) > 0 {
+		if err := json.Unmarshal(e.Tags, &t.Tags); err != nil {
+			return t, errors.Wrap(err, "unmarshal tags")
+		}
+	}
+	if len(e.Desired) > 0 {
+		if err := json.Unmarshal(e.Desired, &t.Desired); err != nil {
+			return t, errors.Wrap(err, "unmarshal desired")
+		}
+	}
+	return t, nil
+}
+
+func marshalNullable(m map[string]any) (datatypes.JSON, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
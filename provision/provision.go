@@ -0,0 +1,180 @@
+package provision
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/model"
+)
+
+// Just-in-time provisioning of things.
+// A provisioning template defines the claim credential shared by devices, a shared secret or a claim certificate,
+// and the pattern of thing ids allowed to register.
+//
+// A device connects with username `$provision/{template}`, client id `$provision/{thingId}` of the thing id it requests,
+// and the claim secret as password or the claim certificate on tls listeners.
+// Client ids of claim clients never collide with thing ids, so the session of a connected thing is not taken over.
+// Then it publishes a RegisterReq to topic `TopicRegisterTmpl`, and receives its permanent credential
+// from topic `TopicAcceptedTmpl`, or an error from topic `TopicRejectedTmpl`.
+// The thing is created with the type, tags and desired state of the template.
+
+const (
+	// UserPrefix username prefix of claim clients, followed by the template name
+	UserPrefix = "$provision/"
+	// ClientIdPrefix client id prefix of claim clients, followed by the thing id
+	ClientIdPrefix = "$provision/"
+
+	TopicPrefixTmpl = "$iothub/provisioning/{template}/{thingId}"
+
+	// TopicRegisterTmpl the device requests registration via this topic
+	TopicRegisterTmpl = TopicPrefixTmpl + "/register"
+	// TopicAcceptedTmpl RegisterResp is published to this topic
+	TopicAcceptedTmpl = TopicRegisterTmpl + "/accepted"
+	// TopicRejectedTmpl ErrResp is published to this topic
+	TopicRejectedTmpl = TopicRegisterTmpl + "/rejected"
+
+	TopicRegisterAll = "$iothub/provisioning/+/+/register"
+)
+
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+
+	ClaimSecret          string `json:"claimSecret,omitempty" optional:"true" description:"secret shared by devices for claim, it's only returned at creation, and kept if it's empty at update. A random one is generated if neither secret nor certificate is specified"`
+	ClaimCertPem         string `json:"claimCertPem,omitempty" optional:"true" description:"PEM encoded certificate shared by devices for claim"`
+	ClaimCertFingerprint string `json:"claimCertFingerprint,omitempty" optional:"true" description:"readonly, SHA-256 fingerprint of the claim certificate"`
+
+	ThingIdPattern   string         `json:"thingIdPattern" description:"regular expression which ids of things registering should fully match"`
+	ThingType        string         `json:"thingType,omitempty" optional:"true"`
+	Tags             map[string]any `json:"tags,omitempty" optional:"true" description:"initial tags of shadow"`
+	Desired          map[string]any `json:"desired,omitempty" optional:"true" description:"initial desired state of shadow"`
+	AllowReprovision bool           `json:"allowReprovision" description:"whether a thing provisioned by the template can register again, its password is reset"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TemplatePage = model.PageData[Template]
+
+type RegisterReq struct {
+	ClientToken string `json:"clientToken"`
+}
+
+type RegisterResp struct {
+	ClientToken string `json:"clientToken"`
+	ThingId     string `json:"thingId"`
+	Password    string `json:"password"`
+}
+
+type ErrResp struct {
+	ClientToken string `json:"clientToken"`
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+}
+
+type Service interface {
+	Create(ctx context.Context, t Template) (Template, error)
+	// Update replaces the template, the claim secret is kept if it's empty
+	Update(ctx context.Context, t Template) (Template, error)
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (Template, error)
+	Query(ctx context.Context, pq model.PageQuery) (TemplatePage, error)
+
+	// Claim authenticates the claim client, chain is certificates of tls connection if any.
+	// Things existing can be claimed only if they were provisioned by the template and the template allows reprovisioning
+	Claim(ctx context.Context, template, thingId, secret string, chain []*x509.Certificate) error
+	// Register creates the thing, or resets its password if it was provisioned by the template and the template allows
+	Register(ctx context.Context, template, thingId string) (RegisterResp, error)
+
+	// Start handling registration requests
+	Start(ctx context.Context) error
+}
+
+type Repo interface {
+	Create(ctx context.Context, e *TemplateEntity) error
+	Save(ctx context.Context, e *TemplateEntity) error
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (*TemplateEntity, error)
+	Query(ctx context.Context, pq model.PageQuery) (model.PageData[TemplateEntity], error)
+
+	SaveProvisioned(ctx context.Context, e *ProvisionedEntity) error
+	// GetProvisioned returns nil if the thing is not provisioned by any template
+	GetProvisioned(ctx context.Context, thingId string) (*ProvisionedEntity, error)
+	// DeleteProvisioned is a thing.DeleteHook
+	DeleteProvisioned(ctx context.Context, thingId string) error
+}
+
+func TopicAccepted(template, thingId string) string {
+	return topicOf(TopicAcceptedTmpl, template, thingId)
+}
+
+func TopicRejected(template, thingId string) string {
+	return topicOf(TopicRejectedTmpl, template, thingId)
+}
+
+func TopicRegister(template, thingId string) string {
+	return topicOf(TopicRegisterTmpl, template, thingId)
+}
+
+func topicOf(tmpl, template, thingId string) string {
+	return strings.NewReplacer("{template}", template, "{thingId}", thingId).Replace(tmpl)
+}
+
+// parseTopic gets template and thing id from topic
+func parseTopic(topic string) (template, thingId string, err error) {
+	l := strings.Split(topic, "/")
+	if len(l) < 4 || l[0] != "$iothub" || l[1] != "provisioning" {
+		return "", "", errors.Errorf("wrong provisioning topic %q", topic)
+	}
+	return l[2], l[3], nil
+}
+
+// ClaimUser is the identity of the claim client after authenticated
+func ClaimUser(template, thingId string) string {
+	return UserPrefix + template + "/" + thingId
+}
+
+func IsClaimUser(user string) bool {
+	return strings.HasPrefix(user, UserPrefix)
+}
+
+// TopicAcl claim clients can only publish to their own register topic,
+// and subscribe to the accepted and rejected topics of their own registration
+func TopicAcl(user string, topic string, write bool) bool {
+	l := strings.Split(strings.TrimPrefix(user, UserPrefix), "/")
+	if len(l) != 2 {
+		return false
+	}
+	if write {
+		return topic == TopicRegister(l[0], l[1])
+	}
+	return topic == TopicAccepted(l[0], l[1]) || topic == TopicRejected(l[0], l[1]) ||
+		topic == TopicRegister(l[0], l[1])+"/+"
+}
+
+// Acl wraps the topic acl of other users, claim clients are checked by TopicAcl,
+// and provisioning topics are denied to users other than claim clients and super users
+func Acl(isSuperUser func(user string) bool, acl func(user string, topic string, write bool) bool) func(user string, topic string, write bool) bool {
+	return func(user string, topic string, write bool) bool {
+		if IsClaimUser(user) {
+			return TopicAcl(user, topic, write)
+		}
+		if isProvisioningTopic(topic) && !isSuperUser(user) {
+			return false
+		}
+		return acl(user, topic, write)
+	}
+}
+
+// isProvisioningTopic reports whether the topic or topic filter may match provisioning topics
+func isProvisioningTopic(topic string) bool {
+	l := strings.Split(topic, "/")
+	if len(l) < 2 || l[0] != "$iothub" {
+		return false
+	}
+	return l[1] == "provisioning" || l[1] == "+" || l[1] == "#"
+}
@@ -0,0 +1,81 @@
+package provision
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type repo struct {
+	db *gorm.DB
+}
+
+var _ Repo = (*repo)(nil)
+
+func NewRepo(db *gorm.DB) Repo {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, e *TemplateEntity) error {
+	err := r.db.WithContext(ctx).Create(e).Error
+	return errors.Wrap(err, "create provisioning template")
+}
+
+func (r *repo) Save(ctx context.Context, e *TemplateEntity) error {
+	err := r.db.WithContext(ctx).Save(e).Error
+	return errors.Wrap(err, "save provisioning template")
+}
+
+func (r *repo) Delete(ctx context.Context, name string) error {
+	err := r.db.WithContext(ctx).Where("name = ?", name).Delete(&TemplateEntity{}).Error
+	return errors.Wrap(err, "delete provisioning template")
+}
+
+func (r *repo) Get(ctx context.Context, name string) (*TemplateEntity, error) {
+	var e TemplateEntity
+	err := r.db.WithContext(ctx).Where("name = ?", name).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get provisioning template")
+	}
+	return &e, nil
+}
+
+func (r *repo) SaveProvisioned(ctx context.Context, e *ProvisionedEntity) error {
+	err := r.db.WithContext(ctx).Save(e).Error
+	return errors.Wrap(err, "save provisioned thing")
+}
+
+func (r *repo) GetProvisioned(ctx context.Context, thingId string) (*ProvisionedEntity, error) {
+	var e ProvisionedEntity
+	err := r.db.WithContext(ctx).Where("thing_id = ?", thingId).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get provisioned thing")
+	}
+	return &e, nil
+}
+
+func (r *repo) DeleteProvisioned(ctx context.Context, thingId string) error {
+	err := r.db.WithContext(ctx).Where("thing_id = ?", thingId).Delete(&ProvisionedEntity{}).Error
+	return errors.Wrap(err, "delete provisioned thing")
+}
+
+func (r *repo) Query(ctx context.Context, pq model.PageQuery) (model.PageData[TemplateEntity], error) {
+	tx := r.db.WithContext(ctx).Model(&TemplateEntity{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[TemplateEntity]{}, errors.Wrap(err, "count provisioning template")
+	}
+	l := make([]TemplateEntity, 0)
+	if err := tx.Order("name").Offset(pq.Offset()).Limit(pq.Limit()).Find(&l).Error; err != nil {
+		return model.PageData[TemplateEntity]{}, errors.Wrap(err, "query provisioning template")
+	}
+	return model.PageData[TemplateEntity]{Total: total, Content: l}, nil
+}
@@ -0,0 +1,369 @@
+package provision
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"ruff.io/tio"
+	"ruff.io/tio/auth/certs"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+	"ruff.io/tio/thing"
+)
+
+const callerProvisioning = "provisioning"
+
+type svcImpl struct {
+	repo       Repo
+	thingSvc   thing.Service
+	shadowSvc  shadow.Service
+	conn       connector.PubSub
+	idProvider tio.IdProvider
+}
+
+var _ Service = (*svcImpl)(nil)
+
+// NewSvc Repo.DeleteProvisioned of r should be a delete hook of thingSvc,
+// for a thing created again with the id of a deleted one is not the provisioned one
+func NewSvc(r Repo, thingSvc thing.Service, shadowSvc shadow.Service, conn connector.PubSub, idProvider tio.IdProvider) Service {
+	return &svcImpl{repo: r, thingSvc: thingSvc, shadowSvc: shadowSvc, conn: conn, idProvider: idProvider}
+}
+
+func (s *svcImpl) Create(ctx context.Context, t Template) (Template, error) {
+	if old, err := s.repo.Get(ctx, t.Name); err != nil {
+		return Template{}, err
+	} else if old != nil {
+		return Template{}, errors.WithMessagef(model.ErrDuplicated, "provisioning template %q", t.Name)
+	}
+	if t.ClaimSecret == "" && t.ClaimCertPem == "" {
+		id, err := s.idProvider.ID()
+		if err != nil {
+			return Template{}, errors.Wrap(err, "claim secret generate")
+		}
+		t.ClaimSecret = id
+	}
+	e, err := s.toValidEntity(t)
+	if err != nil {
+		return Template{}, err
+	}
+	if err := s.repo.Create(ctx, &e); err != nil {
+		return Template{}, err
+	}
+	log.Infof("Created provisioning template %q", t.Name)
+	res, err := s.Get(ctx, t.Name)
+	// the plaintext secret is returned only this time
+	res.ClaimSecret = t.ClaimSecret
+	return res, err
+}
+
+func (s *svcImpl) Update(ctx context.Context, t Template) (Template, error) {
+	old, err := s.repo.Get(ctx, t.Name)
+	if err != nil {
+		return Template{}, err
+	}
+	if old == nil {
+		return Template{}, errors.WithMessagef(model.ErrNotFound, "provisioning template %q", t.Name)
+	}
+	e, err := s.toValidEntity(t)
+	if err != nil {
+		return Template{}, err
+	}
+	if t.ClaimSecret == "" {
+		e.ClaimSecret = old.ClaimSecret
+	}
+	if e.ClaimSecret == "" && e.ClaimCertPem == "" {
+		return Template{}, errors.WithMessage(model.ErrInvalidParams, "claim secret or certificate is required")
+	}
+	e.CreatedAt = old.CreatedAt
+	if err := s.repo.Save(ctx, &e); err != nil {
+		return Template{}, err
+	}
+	log.Infof("Updated provisioning template %q", t.Name)
+	return s.Get(ctx, t.Name)
+}
+
+// toValidEntity validates the template, hashes the claim secret and parses the claim certificate
+func (s *svcImpl) toValidEntity(t Template) (TemplateEntity, error) {
+	if !thing.IdValid(t.Name) {
+		return TemplateEntity{}, errors.WithMessagef(model.ErrInvalidParams, "name %q", t.Name)
+	}
+	if _, err := compilePattern(t.ThingIdPattern); err != nil {
+		return TemplateEntity{}, err
+	}
+	if t.ThingType != "" && !thing.IdValid(t.ThingType) {
+		return TemplateEntity{}, errors.WithMessagef(model.ErrInvalidParams, "thing type %q", t.ThingType)
+	}
+	t.ClaimCertFingerprint = ""
+	if t.ClaimCertPem != "" {
+		c, err := certs.ParsePem(t.ClaimCertPem)
+		if err != nil {
+			return TemplateEntity{}, err
+		}
+		t.ClaimCertFingerprint = certs.Fingerprint(c)
+	}
+	e, err := toEntity(t)
+	if err != nil {
+		return TemplateEntity{}, errors.WithMessage(model.ErrInvalidParams, err.Error())
+	}
+	if t.ClaimSecret != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(t.ClaimSecret), bcrypt.DefaultCost)
+		if err != nil {
+			return TemplateEntity{}, errors.Wrap(err, "hash claim secret")
+		}
+		e.ClaimSecret = string(h)
+	}
+	return e, nil
+}
+
+func compilePattern(p string) (*regexp.Regexp, error) {
+	if p == "" {
+		return nil, errors.WithMessage(model.ErrInvalidParams, "thing id pattern is required")
+	}
+	r, err := regexp.Compile("^(?:" + p + ")$")
+	if err != nil {
+		return nil, errors.WithMessagef(model.ErrInvalidParams, "thing id pattern: %v", err)
+	}
+	return r, nil
+}
+
+func (s *svcImpl) Delete(ctx context.Context, name string) error {
+	err := s.repo.Delete(ctx, name)
+	if err == nil {
+		log.Infof("Deleted provisioning template %q", name)
+	}
+	return err
+}
+
+func (s *svcImpl) Get(ctx context.Context, name string) (Template, error) {
+	e, err := s.getEntity(ctx, name)
+	if err != nil {
+		return Template{}, err
+	}
+	return toTemplate(*e)
+}
+
+func (s *svcImpl) getEntity(ctx context.Context, name string) (*TemplateEntity, error) {
+	e, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, errors.WithMessagef(model.ErrNotFound, "provisioning template %q", name)
+	}
+	return e, nil
+}
+
+func (s *svcImpl) Query(ctx context.Context, pq model.PageQuery) (TemplatePage, error) {
+	p, err := s.repo.Query(ctx, pq)
+	if err != nil {
+		return TemplatePage{}, err
+	}
+	res := TemplatePage{Total: p.Total, Content: make([]Template, len(p.Content))}
+	for i, e := range p.Content {
+		if res.Content[i], err = toTemplate(e); err != nil {
+			return TemplatePage{}, err
+		}
+	}
+	return res, nil
+}
+
+func (s *svcImpl) Claim(ctx context.Context, template, thingId, secret string, chain []*x509.Certificate) error {
+	e, err := s.enabledTemplate(ctx, template, thingId)
+	if err != nil {
+		return err
+	}
+	if _, err := s.provisionable(ctx, e, thingId); err != nil {
+		return err
+	}
+	if len(chain) > 0 && e.ClaimCertFingerprint != "" {
+		now := time.Now()
+		if now.Before(chain[0].NotBefore) || now.After(chain[0].NotAfter) {
+			return errors.WithMessage(model.ErrAuthentication, "claim certificate is not in validity period")
+		}
+		fp := certs.Fingerprint(chain[0])
+		if subtle.ConstantTimeCompare([]byte(fp), []byte(e.ClaimCertFingerprint)) == 1 {
+			return nil
+		}
+		return errors.WithMessage(model.ErrAuthentication, "wrong claim certificate")
+	}
+	if e.ClaimSecret == "" || bcrypt.CompareHashAndPassword([]byte(e.ClaimSecret), []byte(secret)) != nil {
+		return errors.WithMessage(model.ErrAuthentication, "wrong claim secret")
+	}
+	return nil
+}
+
+// enabledTemplate gets the template which allows the thing id
+func (s *svcImpl) enabledTemplate(ctx context.Context, template, thingId string) (*TemplateEntity, error) {
+	e, err := s.getEntity(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Enabled {
+		return nil, errors.WithMessagef(model.ErrAuthorization, "provisioning template %q is disabled", template)
+	}
+	if !thing.IdValid(thingId) {
+		return nil, errors.WithMessagef(model.ErrInvalidParams, "thing id %q", thingId)
+	}
+	r, err := compilePattern(e.ThingIdPattern)
+	if err != nil {
+		return nil, err
+	}
+	if !r.MatchString(thingId) {
+		return nil, errors.WithMessagef(model.ErrAuthorization,
+			"thing id %q is not allowed by provisioning template %q", thingId, template)
+	}
+	return e, nil
+}
+
+// provisionable checks whether the thing can be provisioned by the template, and reports whether it exists.
+// Things existing can be reprovisioned only if they were provisioned by the template and the template allows,
+// things created otherwise or by other templates keep their secrets.
+func (s *svcImpl) provisionable(ctx context.Context, e *TemplateEntity, thingId string) (exists bool, err error) {
+	old, err := s.thingSvc.Get(ctx, thingId)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		return false, err
+	}
+	if old == nil {
+		return false, nil
+	}
+	if !e.AllowReprovision {
+		return true, errors.WithMessagef(model.ErrDuplicated, "thing %q exists", thingId)
+	}
+	p, err := s.repo.GetProvisioned(ctx, thingId)
+	if err != nil {
+		return true, err
+	}
+	if p == nil || p.Template != e.Name {
+		return true, errors.WithMessagef(model.ErrDuplicated,
+			"thing %q exists and is not provisioned by template %q", thingId, e.Name)
+	}
+	return true, nil
+}
+
+func (s *svcImpl) Register(ctx context.Context, template, thingId string) (RegisterResp, error) {
+	e, err := s.enabledTemplate(ctx, template, thingId)
+	if err != nil {
+		return RegisterResp{}, err
+	}
+	tmpl, err := toTemplate(*e)
+	if err != nil {
+		return RegisterResp{}, err
+	}
+	exists, err := s.provisionable(ctx, e, thingId)
+	if err != nil {
+		return RegisterResp{}, err
+	}
+	if exists {
+		th, err := s.thingSvc.ResetSecret(ctx, thingId, "")
+		if err != nil {
+			return RegisterResp{}, err
+		}
+		log.Infof("Reprovisioned thing %q by template %q", thingId, template)
+		return RegisterResp{ThingId: thingId, Password: th.AuthValue}, nil
+	}
+
+	th, err := s.thingSvc.Create(ctx, thing.Thing{
+		Id:        thingId,
+		ThingType: tmpl.ThingType,
+		Enabled:   true,
+		AuthType:  thing.AuthTypePassword,
+	})
+	if err != nil {
+		return RegisterResp{}, err
+	}
+	err = s.repo.SaveProvisioned(ctx, &ProvisionedEntity{ThingId: thingId, Template: template})
+	if err == nil {
+		err = s.initShadow(ctx, thingId, tmpl)
+	}
+	if err != nil {
+		// the device can register again after the failure
+		if e := s.thingSvc.Delete(ctx, thingId); e != nil {
+			log.Errorf("Delete thing %q after provisioning failure error: %v", thingId, e)
+		}
+		return RegisterResp{}, err
+	}
+	log.Infof("Provisioned thing %q by template %q", thingId, template)
+	return RegisterResp{ThingId: thingId, Password: th.AuthValue}, nil
+}
+
+func (s *svcImpl) initShadow(ctx context.Context, thingId string, t Template) error {
+	if len(t.Tags) > 0 {
+		if err := s.shadowSvc.SetTag(ctx, thingId, shadow.TagsReq{Tags: t.Tags}); err != nil {
+			return errors.WithMessage(err, "set initial tags")
+		}
+	}
+	if len(t.Desired) > 0 {
+		_, err := s.shadowSvc.SetDesired(ctx, thingId, shadow.StateReq{
+			ClientToken: fmt.Sprintf("%s-%d", callerProvisioning, time.Now().UnixNano()),
+			State:       shadow.StateDR{Desired: t.Desired},
+		})
+		if err != nil {
+			return errors.WithMessage(err, "set initial desired state")
+		}
+	}
+	return nil
+}
+
+func (s *svcImpl) Start(ctx context.Context) error {
+	err := s.conn.Subscribe(ctx, TopicRegisterAll, 1, func(msg connector.Message) {
+		go s.onRegister(ctx, msg)
+	})
+	if err != nil {
+		return errors.Wrap(err, "subscribe provisioning register topic")
+	}
+	log.Info("Provisioning service started")
+	return nil
+}
+
+func (s *svcImpl) onRegister(ctx context.Context, msg connector.Message) {
+	template, thingId, err := parseTopic(msg.Topic())
+	if err != nil {
+		log.Errorf("Got wrong topic for provisioning: %v", err)
+		return
+	}
+	var req RegisterReq
+	if len(msg.Payload()) > 0 {
+		if err := json.Unmarshal(msg.Payload(), &req); err != nil {
+			s.reject(template, thingId, req, errors.WithMessage(model.ErrInvalidParams, "invalid payload"))
+			return
+		}
+	}
+	resp, err := s.Register(ctx, template, thingId)
+	if err != nil {
+		log.Infof("Provisioning thing %q by template %q error: %v", thingId, template, err)
+		s.reject(template, thingId, req, err)
+		return
+	}
+	resp.ClientToken = req.ClientToken
+	b, _ := json.Marshal(resp)
+	if err := s.conn.Publish(TopicAccepted(template, thingId), 1, false, b); err != nil {
+		log.Errorf("Publish provisioning accepted response of thing %q error: %v", thingId, err)
+	}
+}
+
+func (s *svcImpl) reject(template, thingId string, req RegisterReq, err error) {
+	code := 500
+	switch {
+	case errors.Is(err, model.ErrInvalidParams):
+		code = 400
+	case errors.Is(err, model.ErrAuthorization):
+		code = 403
+	case errors.Is(err, model.ErrNotFound):
+		code = 404
+	case errors.Is(err, model.ErrDuplicated):
+		code = 409
+	}
+	b, _ := json.Marshal(ErrResp{ClientToken: req.ClientToken, Code: code, Message: err.Error()})
+	if e := s.conn.Publish(TopicRejected(template, thingId), 1, false, b); e != nil {
+		log.Errorf("Publish provisioning rejected response of thing %q error: %v", thingId, e)
+	}
+}
@@ -0,0 +1,266 @@
+package provision_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/connector/mqtt/embed"
+	mqMock "ruff.io/tio/connector/mqtt/mock"
+	dbMock "ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/pkg/uuid"
+	"ruff.io/tio/provision"
+	"ruff.io/tio/shadow"
+	shadowMock "ruff.io/tio/shadow/mock"
+	shadowWire "ruff.io/tio/shadow/wire"
+	"ruff.io/tio/thing"
+	thingWire "ruff.io/tio/thing/wire"
+)
+
+var (
+	ctx = context.Background()
+
+	// shadow service is a singleton, so the database is shared by tests
+	dbOnce sync.Once
+	db     *gorm.DB
+)
+
+type testEnv struct {
+	thingSvc  thing.Service
+	shadowSvc shadow.Service
+	pubSub    connector.PubSub
+}
+
+func newTestSvc(t *testing.T) (provision.Service, testEnv) {
+	dbOnce.Do(func() {
+		db = dbMock.NewSqliteConnTest()
+		err := db.AutoMigrate(thing.Entity{}, thing.GroupMemberEntity{}, shadow.Entity{}, shadow.ConnStatusEntity{},
+			provision.TemplateEntity{}, provision.ProvisionedEntity{})
+		require.NoError(t, err)
+	})
+	conn := shadowMock.NewConnectivity()
+	conn.On("Close", mock.Anything).Return(nil)
+	conn.On("Remove", mock.Anything).Return(nil)
+	shadowSvc := shadowWire.InitSvc(db, conn, nil, nil)
+	repo := provision.NewRepo(db)
+	thingSvc := thingWire.InitSvc(ctx, db, shadowSvc, conn, nil, []thing.DeleteHook{repo.DeleteProvisioned})
+
+	mockMqtt := mqMock.NewMqttClient("", nil, nil)
+	mockMqtt.On("Subscribe", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMqtt.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mqMock.NewMockToken())
+	pubSub := mqMock.NewAdapter(mockMqtt)
+	svc := provision.NewSvc(repo, thingSvc, shadowSvc, &pubSub, uuid.New())
+	return svc, testEnv{thingSvc: thingSvc, shadowSvc: shadowSvc, pubSub: &pubSub}
+}
+
+func TestProvisionSvc_Template(t *testing.T) {
+	svc, _ := newTestSvc(t)
+
+	_, err := svc.Create(ctx, provision.Template{Name: "bad-pattern", ThingIdPattern: "sensor-("})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+	_, err = svc.Create(ctx, provision.Template{Name: "bad-cert", ThingIdPattern: ".*", ClaimCertPem: "xx"})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+
+	tmpl, err := svc.Create(ctx, provision.Template{Name: "tmpl-gen", Enabled: true, ThingIdPattern: "gen-.*"})
+	require.NoError(t, err)
+	require.NotEmpty(t, tmpl.ClaimSecret, "claim secret should be generated")
+	_, err = svc.Create(ctx, provision.Template{Name: "tmpl-gen", ThingIdPattern: ".*"})
+	require.ErrorIs(t, err, model.ErrDuplicated)
+
+	got, err := svc.Get(ctx, tmpl.Name)
+	require.NoError(t, err)
+	require.Empty(t, got.ClaimSecret, "claim secret should not be returned")
+
+	got.Description = "updated"
+	_, err = svc.Update(ctx, got)
+	require.NoError(t, err)
+	require.NoError(t, svc.Claim(ctx, tmpl.Name, "gen-1", tmpl.ClaimSecret, nil), "claim secret should be kept")
+
+	require.NoError(t, svc.Delete(ctx, tmpl.Name))
+	_, err = svc.Get(ctx, tmpl.Name)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestProvisionSvc_Claim(t *testing.T) {
+	svc, env := newTestSvc(t)
+	_, err := svc.Create(ctx, provision.Template{
+		Name: "tmpl-claim", Enabled: true, ClaimSecret: "claim-secret", ThingIdPattern: "sensor-[0-9]+",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.Claim(ctx, "tmpl-claim", "sensor-1", "claim-secret", nil))
+	err = svc.Claim(ctx, "tmpl-claim", "sensor-1", "wrong", nil)
+	require.ErrorIs(t, err, model.ErrAuthentication)
+	err = svc.Claim(ctx, "tmpl-claim", "sensor-x", "claim-secret", nil)
+	require.ErrorIs(t, err, model.ErrAuthorization, "thing id should fully match the pattern")
+	err = svc.Claim(ctx, "tmpl-claim", "my-sensor-1", "claim-secret", nil)
+	require.ErrorIs(t, err, model.ErrAuthorization, "thing id should fully match the pattern")
+	err = svc.Claim(ctx, "not-exist", "sensor-1", "claim-secret", nil)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	_, err = env.thingSvc.Create(ctx, thing.Thing{Id: "sensor-2", AuthValue: "admin-secret"})
+	require.NoError(t, err)
+	err = svc.Claim(ctx, "tmpl-claim", "sensor-2", "claim-secret", nil)
+	require.ErrorIs(t, err, model.ErrDuplicated, "existing things should not be claimed")
+
+	_, err = svc.Update(ctx, provision.Template{Name: "tmpl-claim", ThingIdPattern: "sensor-[0-9]+"})
+	require.NoError(t, err)
+	err = svc.Claim(ctx, "tmpl-claim", "sensor-1", "claim-secret", nil)
+	require.ErrorIs(t, err, model.ErrAuthorization, "template disabled")
+}
+
+func TestAuthzMqttClient(t *testing.T) {
+	svc, _ := newTestSvc(t)
+	_, err := svc.Create(ctx, provision.Template{
+		Name: "tmpl-authz", Enabled: true, ClaimSecret: "claim-secret", ThingIdPattern: "authz-.*",
+	})
+	require.NoError(t, err)
+
+	authz := provision.AuthzMqttClient(ctx, svc)
+	params := func(clientId string) embed.ConnectParams {
+		return embed.ConnectParams{
+			Username: []byte("$provision/tmpl-authz"), Password: []byte("claim-secret"),
+			ClientIdentifier: clientId, Clean: true,
+		}
+	}
+	user, ok := authz(params("$provision/authz-1"), nil)
+	require.True(t, ok)
+	require.Equal(t, provision.ClaimUser("tmpl-authz", "authz-1"), user)
+	_, ok = authz(params("authz-1"), nil)
+	require.False(t, ok, "client id of claim clients should not be the thing id")
+}
+
+func TestProvisionSvc_Register(t *testing.T) {
+	svc, env := newTestSvc(t)
+	_, err := svc.Create(ctx, provision.Template{
+		Name:           "tmpl-reg",
+		Enabled:        true,
+		ClaimSecret:    "claim-secret",
+		ThingIdPattern: "reg-.*",
+		Tags:           map[string]any{"site": "factory-1"},
+		Desired:        map[string]any{"interval": float64(60)},
+	})
+	require.NoError(t, err)
+
+	resp, err := svc.Register(ctx, "tmpl-reg", "reg-1")
+	require.NoError(t, err)
+	require.Equal(t, "reg-1", resp.ThingId)
+	require.NotEmpty(t, resp.Password)
+	_, err = env.thingSvc.Authenticate(ctx, "reg-1", resp.Password)
+	require.NoError(t, err, "the permanent password should be valid")
+
+	sd, err := env.shadowSvc.Get(ctx, "reg-1", shadow.GetOption{})
+	require.NoError(t, err)
+	require.Equal(t, "factory-1", sd.Tags["site"])
+	require.Equal(t, float64(60), sd.State.Desired["interval"])
+
+	_, err = svc.Register(ctx, "tmpl-reg", "reg-1")
+	require.ErrorIs(t, err, model.ErrDuplicated)
+
+	t.Run("reprovision", func(t *testing.T) {
+		tmpl, err := svc.Get(ctx, "tmpl-reg")
+		require.NoError(t, err)
+		tmpl.AllowReprovision = true
+		_, err = svc.Update(ctx, tmpl)
+		require.NoError(t, err)
+
+		require.NoError(t, svc.Claim(ctx, "tmpl-reg", "reg-1", "claim-secret", nil), "reprovision is allowed")
+		again, err := svc.Register(ctx, "tmpl-reg", "reg-1")
+		require.NoError(t, err)
+		require.NotEqual(t, resp.Password, again.Password)
+		_, err = env.thingSvc.Authenticate(ctx, "reg-1", resp.Password)
+		require.ErrorIs(t, err, model.ErrAuthentication, "the previous password should be reset")
+
+		_, err = env.thingSvc.Create(ctx, thing.Thing{Id: "reg-admin", AuthValue: "admin-secret"})
+		require.NoError(t, err)
+		err = svc.Claim(ctx, "tmpl-reg", "reg-admin", "claim-secret", nil)
+		require.ErrorIs(t, err, model.ErrDuplicated)
+		_, err = svc.Register(ctx, "tmpl-reg", "reg-admin")
+		require.ErrorIs(t, err, model.ErrDuplicated, "things not provisioned by the template should not be reprovisioned")
+		_, err = env.thingSvc.Authenticate(ctx, "reg-admin", "admin-secret")
+		require.NoError(t, err)
+
+		require.NoError(t, env.thingSvc.Delete(ctx, "reg-1"))
+		_, err = env.thingSvc.Create(ctx, thing.Thing{Id: "reg-1", AuthValue: "admin-secret"})
+		require.NoError(t, err)
+		_, err = svc.Register(ctx, "tmpl-reg", "reg-1")
+		require.ErrorIs(t, err, model.ErrDuplicated, "a thing created again after deletion is not the provisioned one")
+	})
+}
+
+func TestProvisionSvc_RegisterByMqtt(t *testing.T) {
+	svc, env := newTestSvc(t)
+	_, err := svc.Create(ctx, provision.Template{
+		Name: "tmpl-mqtt", Enabled: true, ClaimSecret: "claim-secret", ThingIdPattern: "mqtt-.*",
+	})
+	require.NoError(t, err)
+	require.NoError(t, svc.Start(ctx))
+
+	accepted := make(chan provision.RegisterResp, 1)
+	err = env.pubSub.Subscribe(ctx, provision.TopicAccepted("tmpl-mqtt", "mqtt-1"), 1, func(msg connector.Message) {
+		var resp provision.RegisterResp
+		_ = json.Unmarshal(msg.Payload(), &resp)
+		accepted <- resp
+	})
+	require.NoError(t, err)
+	rejected := make(chan provision.ErrResp, 1)
+	err = env.pubSub.Subscribe(ctx, provision.TopicRejected("tmpl-mqtt", "other-1"), 1, func(msg connector.Message) {
+		var resp provision.ErrResp
+		_ = json.Unmarshal(msg.Payload(), &resp)
+		rejected <- resp
+	})
+	require.NoError(t, err)
+
+	req, _ := json.Marshal(provision.RegisterReq{ClientToken: "token-1"})
+	require.NoError(t, env.pubSub.Publish(provision.TopicRegister("tmpl-mqtt", "mqtt-1"), 1, false, req))
+	select {
+	case resp := <-accepted:
+		require.Equal(t, "token-1", resp.ClientToken)
+		require.Equal(t, "mqtt-1", resp.ThingId)
+		require.NotEmpty(t, resp.Password)
+	case <-time.After(time.Second):
+		require.Fail(t, "should get accepted response")
+	}
+
+	require.NoError(t, env.pubSub.Publish(provision.TopicRegister("tmpl-mqtt", "other-1"), 1, false, req))
+	select {
+	case resp := <-rejected:
+		require.Equal(t, 403, resp.Code)
+	case <-time.After(time.Second):
+		require.Fail(t, "should get rejected response")
+	}
+}
+
+func TestTopicAcl(t *testing.T) {
+	user := provision.ClaimUser("tmpl", "sensor-1")
+	require.True(t, provision.IsClaimUser(user))
+	require.True(t, provision.TopicAcl(user, provision.TopicRegister("tmpl", "sensor-1"), true))
+	require.True(t, provision.TopicAcl(user, provision.TopicAccepted("tmpl", "sensor-1"), false))
+	require.True(t, provision.TopicAcl(user, provision.TopicRegister("tmpl", "sensor-1")+"/+", false))
+	require.False(t, provision.TopicAcl(user, provision.TopicAccepted("tmpl", "sensor-1"), true))
+	require.False(t, provision.TopicAcl(user, provision.TopicRegister("tmpl", "sensor-1")+"/#", false))
+	require.False(t, provision.TopicAcl(user, provision.TopicRegister("tmpl", "sensor-2"), true))
+	require.False(t, provision.TopicAcl(user, provision.TopicRegister("other", "sensor-1"), true))
+	require.False(t, provision.TopicAcl(user, "$iothub/things/sensor-1/shadows/name/default/update", true))
+
+	acl := provision.Acl(func(user string) bool {
+		return user == "admin"
+	}, func(user string, topic string, write bool) bool {
+		return true
+	})
+	require.True(t, acl(user, provision.TopicRegister("tmpl", "sensor-1"), true))
+	require.False(t, acl(user, "$iothub/things/sensor-1/shadows/name/default/update", true))
+	require.True(t, acl("admin", provision.TopicRegisterAll, false))
+	for _, topic := range []string{provision.TopicAccepted("tmpl", "sensor-1"), provision.TopicRegisterAll,
+		"$iothub/+/tmpl/sensor-1/register", "$iothub/#"} {
+		require.False(t, acl("sensor-2", topic, false), topic)
+	}
+	require.True(t, acl("sensor-2", "$iothub/things/sensor-2/shadows/name/default/update", true))
+}
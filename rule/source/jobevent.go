@@ -0,0 +1,79 @@
+package source
+
+import (
+	"ruff.io/tio/job"
+	"ruff.io/tio/pkg/eventbus"
+	"ruff.io/tio/pkg/log"
+)
+
+const jobEventQueueSize = 1024
+
+// JobEventConfig events of the job center, like "completed" or "taskStatusChanged", all events if it's empty
+type JobEventConfig struct {
+	Events []string
+}
+
+func NewJobEvent(cfg JobEventConfig) Source {
+	m := &jobEventImpl{
+		config: cfg,
+	}
+	m.sub()
+	return m
+}
+
+type jobEventImpl struct {
+	config  JobEventConfig
+	handler MsgHander
+}
+
+func (*jobEventImpl) Type() string {
+	return TypeJobEvent
+}
+
+func (m *jobEventImpl) OnMsg(h MsgHander) {
+	m.handler = h
+}
+
+// sub takes events off the event bus without blocking it, the handler runs on another goroutine
+func (m *jobEventImpl) sub() {
+	ch := eventbus.Subscribe(job.EventBusName)
+	queue := make(chan job.EventMsg, jobEventQueueSize)
+	go m.handle(queue)
+	go func() {
+		for e := range ch {
+			evt, ok := e.(job.EventMsg)
+			if !ok || !m.accept(evt.EventType) {
+				continue
+			}
+			select {
+			case queue <- evt:
+			default:
+				log.Warnf("Rule source job event queue is full, event is dropped, topic=%q", evt.Topic)
+			}
+		}
+	}()
+}
+
+func (m *jobEventImpl) handle(queue <-chan job.EventMsg) {
+	for evt := range queue {
+		if m.handler != nil {
+			m.handler(Msg{
+				ThingId: evt.ThingId,
+				Topic:   evt.Topic,
+				Payload: evt.Payload,
+			})
+		}
+	}
+}
+
+func (m *jobEventImpl) accept(eventType string) bool {
+	if len(m.config.Events) == 0 {
+		return true
+	}
+	for _, e := range m.config.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
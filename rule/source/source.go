@@ -9,6 +9,7 @@ import (
 
 const (
 	TypeEmbedMqtt = "embed-mqtt"
+	TypeJobEvent  = "job-event"
 )
 
 type Msg struct {
@@ -40,6 +41,12 @@ func New(cfg Config, conn connector.Conn) (Source, error) {
 		}
 		s := NewEmbedMqtt(mcf)
 		return s, nil
+	case TypeJobEvent:
+		var jcf JobEventConfig
+		if err := mapstructure.Decode(cfg.Options, &jcf); err != nil {
+			return nil, fmt.Errorf("decode job-event options:%v", err)
+		}
+		return NewJobEvent(jcf), nil
 	default:
 		return nil, fmt.Errorf("unsupported source type %q", cfg.Type)
 	}
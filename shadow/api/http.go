@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -18,9 +19,7 @@ import (
 	rest "ruff.io/tio/pkg/restapi"
 )
 
-const (
-	defaultMethodReqTimeout = 30
-)
+const mimeEventStream = "text/event-stream"
 
 type ShadowQuery struct {
 	Query string `json:"query" description:"SQL-like query string" default:"select * from shadow"`
@@ -32,6 +31,7 @@ func Service(
 	svc shadow.Service,
 	thingSvc thing.Service,
 	method shadow.MethodHandler,
+	methodLog shadow.MethodLogService,
 ) *restful.WebService {
 	ws := thingWs
 
@@ -77,12 +77,34 @@ func Service(
 		To(InvokeMethodHandler(ctx, method, thingSvc)).
 		Operation("invoke-direct-method").
 		Doc("invoke thing direct method").
+		Notes("For long-running methods, the thing can send intermediate responses with `\"final\": false` and `progress` "+
+			"before the final one.\n"+
+			"\nTo receive them, request with query `stream=true` or header `Accept: text/event-stream`, "+
+			"then the response is a stream of server-sent events: "+
+			"event `progress` for every intermediate response, and event `result` for the final one which closes the stream.").
 		Metadata(restfulspec.KeyOpenAPITags, tags).
 		Param(ws.PathParameter("id", "thing id")).
 		Param(ws.PathParameter("name", "method name")).
+		Param(ws.QueryParameter("stream", "whether to stream intermediate responses as server-sent events").DataType("boolean")).
 		Reads(MethodInvokeReq{}).
 		Returns(200, "OK", rest.RespOK(MethodInvokeResp{Data: struct{}{}})))
 
+	ws.Route(ws.GET("/methodLogs").
+		To(QueryMethodLogHandler(ctx, methodLog)).
+		Operation("query-method-logs").
+		Doc("query audit logs of direct method invocations, newest first").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("thingId", "")).
+		Param(ws.QueryParameter("method", "method name")).
+		Param(ws.QueryParameter("caller", "like api:admin or job:<jobId>")).
+		Param(ws.QueryParameter("respCode", "").DataType("integer")).
+		Param(ws.QueryParameter("hasError", "").DataType("boolean")).
+		Param(ws.QueryParameter("from", "time in RFC3339, eg: 2022-11-01T15:00:00Z")).
+		Param(ws.QueryParameter("to", "time in RFC3339, eg: 2022-11-01T15:00:00Z")).
+		Param(ws.QueryParameter("pageIndex", "").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(shadow.MethodLogPage{})))
+
 	ws.Route(ws.PUT("/{id}/shadows/tags").
 		To(SetTagsHandler(ctx, svc)).
 		Operation("set-tags").
@@ -140,12 +162,12 @@ func getPageQuery(r *restful.Request) model.PageQuery {
 	q.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex"))
 	if err != nil {
 		q.PageIndex = 1
-		log.Infof("No valid query param withAuthValue use default value %d", q.PageIndex)
+		log.Infof("No valid query param pageIndex use default value %d", q.PageIndex)
 	}
 	q.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize"))
 	if err != nil {
 		q.PageSize = 10
-		log.Infof("No valid query param withAuthValue use default value %d", q.PageSize)
+		log.Infof("No valid query param pageSize use default value %d", q.PageSize)
 	}
 
 	return q
@@ -213,6 +235,14 @@ type MethodInvokeResp struct {
 	Data    any    `json:"data"`
 }
 
+// MethodProgress is the data of server-sent event `progress`
+type MethodProgress struct {
+	Progress *int   `json:"progress,omitempty"`
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Data     any    `json:"data"`
+}
+
 func InvokeMethodHandler(
 	ctx context.Context,
 	method shadow.MethodHandler,
@@ -237,11 +267,13 @@ func InvokeMethodHandler(
 			return
 		}
 
+		user, _, _ := r.Request.BasicAuth()
 		reqMsg := shadow.MethodReqMsg{
 			ThingId:     thingId,
 			Method:      name,
 			ConnTimeout: req.ConnTimeout,
 			RespTimeout: req.RespTimeout,
+			Caller:      shadow.CallerApiPrefix + user,
 			Req: shadow.MethodReq{
 				ClientToken: fmt.Sprintf("tk-sys-%d", time.Now().UnixNano()),
 				Data:        req.Data,
@@ -260,8 +292,10 @@ func InvokeMethodHandler(
 			return
 		}
 
-		if reqMsg.RespTimeout == 0 {
-			reqMsg.RespTimeout = defaultMethodReqTimeout
+		if r.QueryParameter("stream") == "true" || r.HeaderParameter("Accept") == mimeEventStream {
+			// the invocation stops once the client goes away
+			streamMethod(r.Request.Context(), method, reqMsg, w)
+			return
 		}
 
 		resp, err := method.InvokeMethod(ctx, reqMsg)
@@ -282,6 +316,93 @@ func InvokeMethodHandler(
 	}
 }
 
+// streamMethod relays intermediate responses as server-sent events `progress`,
+// and the final response as event `result` with the same body as the non-stream request.
+func streamMethod(ctx context.Context, method shadow.MethodHandler, reqMsg shadow.MethodReqMsg, w *restful.Response) {
+	w.Header().Set("Content-Type", mimeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	w.Flush()
+
+	send := func(event string, v any) {
+		j, err := json.Marshal(v)
+		if err != nil {
+			log.Errorf("Marshal method %s event error: %v", event, err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, j); err != nil {
+			log.Warnf("Write method %s event error: %v, thingId=%q method=%q", event, err, reqMsg.ThingId, reqMsg.Method)
+			return
+		}
+		w.Flush()
+	}
+	reqMsg.OnProgress = func(resp shadow.MethodResp) {
+		send("progress", MethodProgress{Progress: resp.Progress, Code: resp.Code, Message: resp.Message, Data: resp.Data})
+	}
+
+	resp, err := method.InvokeMethod(ctx, reqMsg)
+	if err != nil {
+		log.Errorf("Direct method request: %#v , error: %v", reqMsg, err)
+		code := 500
+		var he model.HttpErr
+		if errors.As(err, &he) {
+			code = he.Code
+		}
+		send("result", rest.Resp[any]{Code: code, Message: err.Error()})
+		return
+	}
+	send("result", rest.RespOK(MethodInvokeResp{Code: resp.Code, Message: resp.Message, Data: resp.Data}))
+	log.Debugf("Direct method request: %#v response: %#v", reqMsg, resp)
+}
+
+func QueryMethodLogHandler(ctx context.Context, svc shadow.MethodLogService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		q := shadow.MethodLogPageQuery{
+			ThingId:   r.QueryParameter("thingId"),
+			Method:    r.QueryParameter("method"),
+			Caller:    r.QueryParameter("caller"),
+			PageQuery: getPageQuery(r),
+		}
+		if s := r.QueryParameter("respCode"); s != "" {
+			c, err := strconv.Atoi(s)
+			if err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: "invalid respCode"})
+				return
+			}
+			q.RespCode = &c
+		}
+		if s := r.QueryParameter("hasError"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: "invalid hasError"})
+				return
+			}
+			q.HasError = &b
+		}
+		var err error
+		if s := r.QueryParameter("from"); s != "" {
+			if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: "invalid from time: " + err.Error()})
+				return
+			}
+		}
+		if s := r.QueryParameter("to"); s != "" {
+			if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+				rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: "invalid to time: " + err.Error()})
+				return
+			}
+		}
+
+		res, err := svc.Query(ctx, q)
+		if err != nil {
+			log.Errorf("Query method logs error: %v, query: %#v", err, q)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(res))
+	}
+}
+
 func checkHttpErrAndSend(err error, w http.ResponseWriter) bool {
 	if err != nil {
 		var he model.HttpErr
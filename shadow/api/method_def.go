@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/go-openapi/spec"
+	"ruff.io/tio/pkg/log"
+	rest "ruff.io/tio/pkg/restapi"
+	"ruff.io/tio/shadow"
+)
+
+type MethodDefReq struct {
+	Description string         `json:"description"`
+	ReqSchema   map[string]any `json:"reqSchema,omitempty" description:"JSON Schema of request data"`
+	RespSchema  map[string]any `json:"respSchema,omitempty" description:"JSON Schema of response data"`
+	ConnTimeout int            `json:"connTimeout" description:"default waiting time for the thing to come online, in seconds"`
+	RespTimeout int            `json:"respTimeout" description:"default waiting time for the thing to response, in seconds"`
+}
+
+// ServiceForMethodDef adds method definition routes of a type to the thing types web service,
+// and returns the web service for method definitions of all types,
+// which is not under the thing types path for not colliding with the type name.
+func ServiceForMethodDef(ctx context.Context, svc shadow.MethodDefService, thingTypeWs *restful.WebService) *restful.WebService {
+	allWs := new(restful.WebService)
+	allWs.
+		Path("/api/v1/methodDefs").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	tags := []string{"methods"}
+
+	allWs.Route(allWs.GET("/").
+		To(ListMethodDefHandler(ctx, svc)).
+		Operation("list-all-method-definitions").
+		Doc("get method definitions of all thing types").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Returns(200, "OK", rest.RespOK([]shadow.MethodDef{})))
+
+	ws := thingTypeWs
+	ws.Route(ws.GET("/{type}/methods").
+		To(ListMethodDefHandler(ctx, svc)).
+		Operation("list-method-definitions").
+		Doc("get method definitions of the thing type").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type")).
+		Returns(200, "OK", rest.RespOK([]shadow.MethodDef{})))
+
+	ws.Route(ws.GET("/{type}/methods/{name}").
+		To(GetMethodDefHandler(ctx, svc)).
+		Operation("get-method-definition").
+		Doc("get method definition").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type")).
+		Param(ws.PathParameter("name", "method name")).
+		Returns(200, "OK", rest.RespOK(shadow.MethodDef{})))
+
+	ws.Route(ws.PUT("/{type}/methods/{name}").
+		To(PutMethodDefHandler(ctx, svc)).
+		Operation("put-method-definition").
+		Doc("create or replace method definition").
+		Notes("Once a thing type has any method defined, only the defined methods can be invoked on things of the type, "+
+			"and request data is validated against the request schema.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type")).
+		Param(ws.PathParameter("name", "method name")).
+		Reads(MethodDefReq{}).
+		Returns(200, "OK", rest.RespOK(shadow.MethodDef{})))
+
+	ws.Route(ws.DELETE("/{type}/methods/{name}").
+		To(DeleteMethodDefHandler(ctx, svc)).
+		Operation("delete-method-definition").
+		Doc("delete method definition").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type")).
+		Param(ws.PathParameter("name", "method name")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	return allWs
+}
+
+func ListMethodDefHandler(ctx context.Context, svc shadow.MethodDefService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		l, err := svc.List(ctx, r.PathParameter("type"))
+		if err != nil {
+			log.Errorf("List method definitions error: %v", err)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(l))
+	}
+}
+
+func GetMethodDefHandler(ctx context.Context, svc shadow.MethodDefService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		d, err := svc.Get(ctx, r.PathParameter("type"), r.PathParameter("name"))
+		if err != nil {
+			if !checkHttpErrAndSend(err, w) {
+				rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			}
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(d))
+	}
+}
+
+func PutMethodDefHandler(ctx context.Context, svc shadow.MethodDefService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req MethodDefReq
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		d, err := svc.Put(ctx, shadow.MethodDef{
+			ThingType:   r.PathParameter("type"),
+			Name:        r.PathParameter("name"),
+			Description: req.Description,
+			ReqSchema:   req.ReqSchema,
+			RespSchema:  req.RespSchema,
+			ConnTimeout: req.ConnTimeout,
+			RespTimeout: req.RespTimeout,
+		})
+		if err != nil {
+			if !checkHttpErrAndSend(err, w) {
+				log.Errorf("Put method definition error: %v", err)
+				rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			}
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(d))
+	}
+}
+
+func DeleteMethodDefHandler(ctx context.Context, svc shadow.MethodDefService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		err := svc.Delete(ctx, r.PathParameter("type"), r.PathParameter("name"))
+		if err != nil {
+			log.Errorf("Delete method definition error: %v", err)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(""))
+	}
+}
+
+// MethodDefSwaggerEnricher adds a path for every defined method to the api docs,
+// with request and response data described by the schemas of the definition.
+// thingsPath is the root path of things api, like /api/v1/things
+func MethodDefSwaggerEnricher(ctx context.Context, svc shadow.MethodDefService, thingsPath string) func(*spec.Swagger) {
+	return func(swo *spec.Swagger) {
+		defs, err := svc.List(ctx, "")
+		if err != nil {
+			log.Errorf("List method definitions for api docs error: %v", err)
+			return
+		}
+		if swo.Paths == nil {
+			swo.Paths = &spec.Paths{Paths: map[string]spec.PathItem{}}
+		}
+		byName := map[string][]shadow.MethodDef{}
+		var names []string
+		for _, d := range defs {
+			if _, ok := byName[d.Name]; !ok {
+				names = append(names, d.Name)
+			}
+			byName[d.Name] = append(byName[d.Name], d)
+		}
+		for _, n := range names {
+			path := fmt.Sprintf("%s/{id}/methods/%s", thingsPath, n)
+			swo.Paths.Paths[path] = spec.PathItem{PathItemProps: spec.PathItemProps{Post: methodDefOperation(n, byName[n])}}
+		}
+		swo.Tags = append(swo.Tags, spec.Tag{TagProps: spec.TagProps{
+			Name:        "methods",
+			Description: "Direct methods defined for thing types",
+		}})
+	}
+}
+
+func methodDefOperation(name string, defs []shadow.MethodDef) *spec.Operation {
+	var desc []string
+	for _, d := range defs {
+		desc = append(desc, fmt.Sprintf("- thing type `%s`: %s", d.ThingType, d.Description))
+	}
+	// schemas are only shown when all thing types share the same ones
+	var reqData, respData *spec.Schema
+	if sameSchemas(defs) {
+		reqData = toSpecSchema(defs[0].ReqSchema)
+		respData = toSpecSchema(defs[0].RespSchema)
+	}
+
+	body := spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: spec.StringOrArray{"object"},
+		Properties: map[string]spec.Schema{
+			"connTimeout": *spec.Int64Property(),
+			"respTimeout": *spec.Int64Property(),
+		},
+	}}
+	if reqData != nil {
+		body.Properties["data"] = *reqData
+	}
+	respBody := spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: spec.StringOrArray{"object"},
+		Properties: map[string]spec.Schema{
+			"code":    *spec.Int64Property(),
+			"message": *spec.StringProperty(),
+		},
+	}}
+	if respData != nil {
+		respBody.Properties["data"] = *respData
+	}
+	resp := spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: spec.StringOrArray{"object"},
+		Properties: map[string]spec.Schema{
+			"code":    *spec.Int64Property(),
+			"message": *spec.StringProperty(),
+			"data":    respBody,
+		},
+	}}
+
+	op := spec.NewOperation("invoke-method-"+name).
+		WithSummary("invoke direct method "+name).
+		WithDescription(strings.Join(desc, "\n")).
+		WithTags("methods").
+		WithConsumes(restful.MIME_JSON).
+		WithProduces(restful.MIME_JSON).
+		AddParam(spec.PathParam("id").Typed("string", "").WithDescription("thing id")).
+		AddParam(spec.BodyParam("body", &body).AsRequired()).
+		RespondsWith(200, spec.NewResponse().WithDescription("OK").WithSchema(&resp))
+	return op
+}
+
+func sameSchemas(defs []shadow.MethodDef) bool {
+	for _, d := range defs[1:] {
+		if !reflect.DeepEqual(d.ReqSchema, defs[0].ReqSchema) || !reflect.DeepEqual(d.RespSchema, defs[0].RespSchema) {
+			return false
+		}
+	}
+	return true
+}
+
+func toSpecSchema(m map[string]any) *spec.Schema {
+	if m == nil {
+		return nil
+	}
+	var s spec.Schema
+	j, err := json.Marshal(m)
+	if err == nil {
+		err = json.Unmarshal(j, &s)
+	}
+	if err != nil {
+		log.Warnf("Convert method schema to api docs schema error: %v", err)
+		return nil
+	}
+	return &s
+}
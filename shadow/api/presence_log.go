@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+	"ruff.io/tio/shadow"
+)
+
+// ServiceForPresenceLog adds connection history routes to the things web service
+func ServiceForPresenceLog(ctx context.Context, svc shadow.PresenceLogService, thingWs *restful.WebService) *restful.WebService {
+	ws := thingWs
+
+	tags := []string{"presence"}
+
+	ws.Route(ws.GET("/{id}/presenceLogs").
+		To(QueryPresenceLogHandler(ctx, svc)).
+		Operation("query-presence-logs").
+		Doc("query connection history of the thing, newest first").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.QueryParameter("eventType", "").
+			PossibleValues([]string{connector.EventConnected, connector.EventDisconnected})).
+		Param(ws.QueryParameter("from", "time in RFC3339, eg: 2022-11-01T15:00:00Z")).
+		Param(ws.QueryParameter("to", "time in RFC3339, eg: 2022-11-01T15:00:00Z")).
+		Param(ws.QueryParameter("pageIndex", "").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(shadow.PresenceLogPage{})))
+
+	ws.Route(ws.GET("/{id}/uptime").
+		To(UptimeHandler(ctx, svc)).
+		Operation("get-uptime").
+		Doc("get connected time percentage of the thing over a time span").
+		Notes("The span ends now if `to` is absent, and lasts 24 hours if `from` is absent. "+
+			"Connection history is kept for a limited period, the thing is taken as disconnected before it.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Param(ws.QueryParameter("from", "time in RFC3339, eg: 2022-11-01T15:00:00Z")).
+		Param(ws.QueryParameter("to", "time in RFC3339, eg: 2022-11-01T15:00:00Z")).
+		Returns(200, "OK", rest.RespOK(shadow.Uptime{})))
+
+	return ws
+}
+
+func QueryPresenceLogHandler(ctx context.Context, svc shadow.PresenceLogService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		span, ok := getTimeSpan(r, w)
+		if !ok {
+			return
+		}
+		q := shadow.PresenceLogPageQuery{
+			ThingId:   r.PathParameter("id"),
+			EventType: r.QueryParameter("eventType"),
+			TimeSpan:  span,
+			PageQuery: getPageQuery(r),
+		}
+		res, err := svc.Query(ctx, q)
+		if err != nil {
+			log.Errorf("Query presence logs error: %v, query: %#v", err, q)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(res))
+	}
+}
+
+func UptimeHandler(ctx context.Context, svc shadow.PresenceLogService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		span, ok := getTimeSpan(r, w)
+		if !ok {
+			return
+		}
+		res, err := svc.Uptime(ctx, r.PathParameter("id"), span)
+		if err != nil {
+			if !checkHttpErrAndSend(err, w) {
+				log.Errorf("Get uptime error: %v", err)
+				rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			}
+			return
+		}
+		rest.SendResp(w, 200, rest.RespOK(res))
+	}
+}
+
+// getTimeSpan parses query parameters from and to, sends 400 response if it's invalid
+func getTimeSpan(r *restful.Request, w *restful.Response) (model.TimeSpan, bool) {
+	var span model.TimeSpan
+	var err error
+	if s := r.QueryParameter("from"); s != "" {
+		if span.From, err = time.Parse(time.RFC3339, s); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: "invalid from time: " + err.Error()})
+			return span, false
+		}
+	}
+	if s := r.QueryParameter("to"); s != "" {
+		if span.To, err = time.Parse(time.RFC3339, s); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: "invalid to time: " + err.Error()})
+			return span, false
+		}
+	}
+	return span, true
+}
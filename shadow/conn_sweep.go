@@ -0,0 +1,173 @@
+package shadow
+
+import (
+	"context"
+	"time"
+
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+)
+
+// Stale connection sweeper.
+// Presence events can be lost, eg. when tio restarts while things disconnect,
+// then conn status says a thing is connected while the broker doesn't know it, or the other way around.
+// The sweeper marks such things disconnected, or connected, once the disagreement lasts longer than a threshold.
+
+const (
+	DisconnectReasonStale = "stale"
+
+	defaultConnSweepInterval   = time.Minute
+	defaultConnSweepStaleAfter = 2 * time.Minute
+)
+
+type ConnSweepOptions struct {
+	// Interval of checking
+	Interval time.Duration
+	// StaleAfter things disagreeing longer than it are marked disconnected
+	StaleAfter time.Duration
+}
+
+type ConnSweeper interface {
+	// Start sweeping periodically until context done
+	Start(ctx context.Context)
+}
+
+type connSweeper struct {
+	repo    Repo
+	checker connector.ConnectChecker
+	opt     ConnSweepOptions
+
+	// things connected in conn status but not in broker, and when it's first seen
+	stale map[string]time.Time
+	// things connected in broker but not in conn status, and when it's first seen
+	missed map[string]time.Time
+}
+
+func NewConnSweeper(r Repo, checker connector.ConnectChecker, opt ConnSweepOptions) ConnSweeper {
+	if opt.Interval <= 0 {
+		opt.Interval = defaultConnSweepInterval
+	}
+	if opt.StaleAfter <= 0 {
+		opt.StaleAfter = defaultConnSweepStaleAfter
+	}
+	return &connSweeper{repo: r, checker: checker, opt: opt,
+		stale: make(map[string]time.Time), missed: make(map[string]time.Time)}
+}
+
+func (s *connSweeper) Start(ctx context.Context) {
+	go func() {
+		tick := time.NewTicker(s.opt.Interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				s.sweep(ctx, time.Now())
+			}
+		}
+	}()
+}
+
+func (s *connSweeper) sweep(ctx context.Context, now time.Time) {
+	l, err := s.repo.ListConnected(ctx)
+	if err != nil {
+		log.Errorf("Conn sweeper list connected things error: %v", err)
+		return
+	}
+	s.sweepStale(ctx, l, now)
+	s.sweepMissed(ctx, l, now)
+}
+
+// sweepStale marks things disconnected which are connected in conn status but not in broker
+func (s *connSweeper) sweepStale(ctx context.Context, connected []string, now time.Time) {
+	stale := make(map[string]time.Time)
+	var expired []string
+	for _, id := range connected {
+		ok, err := s.checker.IsConnected(id)
+		if err != nil {
+			log.Warnf("Conn sweeper check connection of %q error: %v", id, err)
+			continue
+		}
+		if ok {
+			continue
+		}
+		since, seen := s.stale[id]
+		if !seen {
+			since = now
+		}
+		if now.Sub(since) >= s.opt.StaleAfter {
+			expired = append(expired, id)
+		} else {
+			stale[id] = since
+		}
+	}
+	s.stale = stale
+	for _, id := range expired {
+		s.markDisconnected(ctx, id, now)
+	}
+}
+
+// sweepMissed marks things connected which are connected in broker but not in conn status
+func (s *connSweeper) sweepMissed(ctx context.Context, connected []string, now time.Time) {
+	clients, err := s.checker.AllClientInfo()
+	if err != nil {
+		log.Errorf("Conn sweeper get all client info error: %v", err)
+		return
+	}
+	inStatus := make(map[string]bool, len(connected))
+	for _, id := range connected {
+		inStatus[id] = true
+	}
+	missed := make(map[string]time.Time)
+	var expired []connector.ClientInfo
+	for _, c := range clients {
+		if !c.Connected || inStatus[c.ClientId] {
+			continue
+		}
+		since, seen := s.missed[c.ClientId]
+		if !seen {
+			since = now
+		}
+		if now.Sub(since) >= s.opt.StaleAfter {
+			expired = append(expired, c)
+		} else {
+			missed[c.ClientId] = since
+		}
+	}
+	s.missed = missed
+	for _, c := range expired {
+		log.Infof("Conn sweeper marks missed connection of %q connected", c.ClientId)
+		// the disconnection recorded may be later than the real connect time, which keeps the update from applying
+		c.ConnectedAt = &now
+		if err := s.repo.UpdateConnStatus(ctx, []connector.ClientInfo{c}); err != nil {
+			log.Errorf("Conn sweeper update conn status of %q error: %v", c.ClientId, err)
+		}
+	}
+}
+
+// markDisconnected reports the presence if possible, so that presence topics and subscribers get consistent too
+func (s *connSweeper) markDisconnected(ctx context.Context, thingId string, now time.Time) {
+	log.Infof("Conn sweeper marks stale connection of %q disconnected", thingId)
+	if r, ok := s.checker.(connector.PresenceReporter); ok {
+		err := r.ReportPresence(connector.PresenceEvent{
+			ThingId:          thingId,
+			EventType:        connector.EventDisconnected,
+			DisconnectReason: DisconnectReasonStale,
+			Timestamp:        now.UnixMilli(),
+		})
+		if err == nil {
+			return
+		}
+		log.Errorf("Conn sweeper report presence of %q error: %v", thingId, err)
+	}
+	err := s.repo.UpdateConnStatus(ctx, []connector.ClientInfo{{
+		ClientId:         thingId,
+		Connected:        false,
+		DisconnectedAt:   &now,
+		DisconnectReason: DisconnectReasonStale,
+	}})
+	if err != nil {
+		log.Errorf("Conn sweeper update conn status of %q error: %v", thingId, err)
+	}
+}
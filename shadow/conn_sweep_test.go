@@ -0,0 +1,128 @@
+package shadow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/db/mock"
+)
+
+type fakeConnChecker struct {
+	connector.ConnectChecker
+	connected map[string]bool
+	reported  []connector.PresenceEvent
+}
+
+func (c *fakeConnChecker) IsConnected(thingId string) (bool, error) {
+	return c.connected[thingId], nil
+}
+
+func (c *fakeConnChecker) AllClientInfo() ([]connector.ClientInfo, error) {
+	var l []connector.ClientInfo
+	for id, ok := range c.connected {
+		l = append(l, connector.ClientInfo{ClientId: id, Username: id, Connected: ok, RemoteAddr: "10.0.0.1:1883"})
+	}
+	return l, nil
+}
+
+type fakeReporter struct {
+	*fakeConnChecker
+}
+
+func (r fakeReporter) ReportPresence(evt connector.PresenceEvent) error {
+	r.reported = append(r.reported, evt)
+	return nil
+}
+
+func TestConnSweeper_Sweep(t *testing.T) {
+	ctx := context.Background()
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(&ConnStatusEntity{}))
+	for _, id := range []string{"online", "stale", "recovered"} {
+		require.NoError(t, db.Create(&ConnStatusEntity{ThingId: id, Connected: true}).Error)
+	}
+	checker := &fakeConnChecker{connected: map[string]bool{"online": true}}
+	s := NewConnSweeper(NewShadowRepo(db), checker, ConnSweepOptions{StaleAfter: time.Minute}).(*connSweeper)
+
+	now := time.Now()
+	s.sweep(ctx, now)
+	require.Len(t, s.stale, 2)
+
+	checker.connected["recovered"] = true
+	s.sweep(ctx, now.Add(30*time.Second))
+	require.Len(t, s.stale, 1, "disagreement should be forgotten once it's gone")
+
+	s.sweep(ctx, now.Add(time.Minute))
+	require.Empty(t, s.stale)
+	var e ConnStatusEntity
+	require.NoError(t, db.Take(&e, "thing_id = ?", "stale").Error)
+	require.False(t, e.Connected)
+	require.Equal(t, DisconnectReasonStale, e.DisconnectReason)
+	l, err := s.repo.ListConnected(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"online", "recovered"}, l)
+}
+
+func TestConnSweeper_Missed(t *testing.T) {
+	ctx := context.Background()
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(&ConnStatusEntity{}))
+	disc := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Create(&ConnStatusEntity{ThingId: "missed", Connected: false, DisconnectedAt: &disc}).Error)
+	require.NoError(t, db.Create(&ConnStatusEntity{ThingId: "online", Connected: true}).Error)
+	checker := &fakeConnChecker{connected: map[string]bool{"missed": true, "online": true}}
+	s := NewConnSweeper(NewShadowRepo(db), checker, ConnSweepOptions{StaleAfter: time.Minute}).(*connSweeper)
+
+	now := time.Now()
+	s.sweep(ctx, now)
+	require.Len(t, s.missed, 1)
+	require.Empty(t, s.stale)
+
+	s.sweep(ctx, now.Add(time.Minute))
+	require.Empty(t, s.missed)
+	var e ConnStatusEntity
+	require.NoError(t, db.Take(&e, "thing_id = ?", "missed").Error)
+	require.True(t, e.Connected, "thing connected in broker should be marked connected")
+	require.Equal(t, "10.0.0.1:1883", e.RemoteAddr)
+}
+
+func TestConnSweeper_ReportPresence(t *testing.T) {
+	ctx := context.Background()
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(&ConnStatusEntity{}))
+	require.NoError(t, db.Create(&ConnStatusEntity{ThingId: "stale", Connected: true}).Error)
+	checker := &fakeConnChecker{connected: map[string]bool{}}
+	s := NewConnSweeper(NewShadowRepo(db), fakeReporter{checker}, ConnSweepOptions{StaleAfter: time.Minute}).(*connSweeper)
+
+	now := time.Now()
+	s.sweep(ctx, now)
+	s.sweep(ctx, now.Add(time.Minute))
+	require.Len(t, checker.reported, 1, "presence should be reported by connector")
+	require.Equal(t, connector.EventDisconnected, checker.reported[0].EventType)
+	require.Equal(t, DisconnectReasonStale, checker.reported[0].DisconnectReason)
+}
+
+func TestShadowRepo_UpdateConnStatusFlapping(t *testing.T) {
+	ctx := context.Background()
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(&ConnStatusEntity{}))
+	require.NoError(t, db.Create(&ConnStatusEntity{ThingId: "th-1"}).Error)
+	r := NewShadowRepo(db)
+
+	now := time.Now()
+	c := toClientInfo(connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventConnected,
+		Timestamp: now.UnixMilli(), Flapping: true})
+	require.NoError(t, r.UpdateConnStatus(ctx, []connector.ClientInfo{c}))
+	var e ConnStatusEntity
+	require.NoError(t, db.Take(&e, "thing_id = ?", "th-1").Error)
+	require.True(t, e.Flapping)
+
+	c = toClientInfo(connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventDisconnected,
+		Timestamp: now.Add(time.Second).UnixMilli()})
+	require.NoError(t, r.UpdateConnStatus(ctx, []connector.ClientInfo{c}))
+	require.NoError(t, db.Take(&e, "thing_id = ?", "th-1").Error)
+	require.False(t, e.Flapping, "flapping flag should be cleared")
+}
@@ -0,0 +1,146 @@
+package shadow_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+	"ruff.io/tio/thing"
+)
+
+func TestShadowSvc_QueryThingGroup(t *testing.T) {
+	refs := map[string][]string{}
+	groupSvc := thing.NewGroupSvc(thing.NewGroupRepo(db), svc, nil, []thing.GroupRefFunc{
+		func(_ context.Context, group string) ([]string, error) { return refs[group], nil },
+	})
+
+	for _, id := range []string{"grp-a", "grp-b", "grp-c"} {
+		_, err := thingSvc.Create(ctx, thing.Thing{Id: id, Enabled: true})
+		require.NoError(t, err)
+	}
+	_, err := svc.SetDesired(ctx, "grp-c", shadow.StateReq{State: shadow.StateDR{Desired: shadow.StateValue{"fw": "v2"}}})
+	require.NoError(t, err)
+
+	_, err = groupSvc.Create(ctx, thing.Group{Name: "grp-static", Type: thing.GroupTypeStatic})
+	require.NoError(t, err)
+	require.NoError(t, groupSvc.AddThings(ctx, "grp-static", []string{"grp-a", "grp-b", "grp-a"}))
+	require.ErrorIs(t, groupSvc.AddThings(ctx, "grp-static", []string{"not-exist"}), model.ErrInvalidParams)
+
+	_, err = groupSvc.Create(ctx, thing.Group{Name: "grp-dynamic", Type: thing.GroupTypeDynamic,
+		Query: "`state.desired.fw` = 'v2'"})
+	require.NoError(t, err)
+	_, err = groupSvc.Create(ctx, thing.Group{Name: "grp-nested", Type: thing.GroupTypeDynamic,
+		Query: "thingGroup = 'grp-dynamic' or thingId = 'grp-b'"})
+	require.NoError(t, err)
+
+	query := func(where string) []string {
+		p, err := svc.Query(ctx, model.PageQuery{PageIndex: 1, PageSize: 10},
+			"select thingId from shadow where thingId like 'grp-%' and "+where+" order by thingId")
+		require.NoError(t, err, where)
+		var res []string
+		for _, c := range p.Content {
+			res = append(res, c.(map[string]any)["thingId"].(string))
+		}
+		return res
+	}
+
+	t.Run("query by group", func(t *testing.T) {
+		require.Equal(t, []string{"grp-a", "grp-b"}, query("thingGroup = 'grp-static'"))
+		require.Equal(t, []string{"grp-c"}, query("thingGroup != 'grp-static'"))
+		require.Equal(t, []string{"grp-c"}, query("thingGroup = 'grp-dynamic'"))
+		require.Equal(t, []string{"grp-b", "grp-c"}, query("thingGroup = 'grp-nested'"))
+		require.Equal(t, []string{"grp-a", "grp-b", "grp-c"}, query("thingGroup in ('grp-static', 'grp-dynamic')"))
+		require.Equal(t, []string{"grp-a"}, query("thingGroup = 'grp-static' and thingGroup not in ('grp-nested')"))
+		require.Empty(t, query("`tags.note` = '__thing_group_0' and thingGroup = 'grp-static'"),
+			"placeholder in string value is not replaced by the sub query")
+		require.Equal(t, []string{"grp-c"}, query("thingGroup = 'grp-dynamic' and `state.desired.fw` != '__thing_group_0'"))
+
+		_, err := svc.Query(ctx, model.PageQuery{PageIndex: 1, PageSize: 10},
+			"select * from shadow where thingGroup = 'not-exist'")
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+		_, err = svc.Query(ctx, model.PageQuery{PageIndex: 1, PageSize: 10},
+			"select * from shadow where thingGroup > 'grp-static'")
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+
+	t.Run("find things", func(t *testing.T) {
+		find := func(f shadow.ThingFilter) []string {
+			l, err := svc.Find(ctx, f)
+			require.NoError(t, err)
+			res := []string{}
+			for _, s := range l {
+				res = append(res, s.ThingId)
+			}
+			return res
+		}
+		require.Equal(t, []string{"grp-a", "grp-b", "grp-c"}, find(shadow.ThingFilter{Groups: []string{"grp-static", "grp-dynamic"}}))
+		require.Equal(t, []string{"grp-b", "grp-c"}, find(shadow.ThingFilter{Groups: []string{"grp-nested"}}))
+		require.Equal(t, []string{"grp-c"}, find(shadow.ThingFilter{Groups: []string{"grp-nested"}, AfterId: "grp-b"}))
+		require.Equal(t, []string{"grp-a"}, find(shadow.ThingFilter{Groups: []string{"grp-static"}, Limit: 1}))
+		require.Equal(t, []string{"grp-b"}, find(shadow.ThingFilter{ThingIds: []string{"grp-b", "grp-c"}, Groups: []string{"grp-static"}}))
+		require.Equal(t, []string{"grp-c"}, find(shadow.ThingFilter{
+			ThingIds: []string{"grp-a", "grp-c"}, Where: "thingGroup = 'grp-dynamic' or `state.desired.fw` = 'x'"}))
+		require.Empty(t, find(shadow.ThingFilter{ThingIds: []string{"grp-a'); --"}}))
+		require.Empty(t, find(shadow.ThingFilter{Where: "`tags.note` = '__thing_group_0' and thingGroup = 'grp-nested'"}))
+
+		for _, w := range []string{"thingId = 'a' order by thingId", "thingId in (select thingId from shadow)",
+			"1 = 1) or (1 = 1", "xxx = 1"} {
+			_, err := svc.Find(ctx, shadow.ThingFilter{Where: w})
+			require.ErrorIs(t, err, model.ErrInvalidParams, w)
+		}
+		_, err := svc.Find(ctx, shadow.ThingFilter{Groups: []string{"not-exist"}})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+
+	t.Run("group things", func(t *testing.T) {
+		p, err := groupSvc.ListThings(ctx, "grp-nested", model.PageQuery{PageIndex: 1, PageSize: 10})
+		require.NoError(t, err)
+		require.Equal(t, []string{"grp-b", "grp-c"}, p.Content)
+
+		l, err := groupSvc.GroupsOfThing(ctx, "grp-b")
+		require.NoError(t, err)
+		require.Equal(t, []string{"grp-static", "grp-nested"}, l)
+
+		require.NoError(t, groupSvc.RemoveThings(ctx, "grp-static", []string{"grp-b"}))
+		require.Equal(t, []string{"grp-a"}, query("thingGroup = 'grp-static'"))
+		require.ErrorIs(t, groupSvc.AddThings(ctx, "grp-dynamic", []string{"grp-a"}), model.ErrInvalidParams)
+	})
+
+	t.Run("invalid dynamic group query", func(t *testing.T) {
+		_, err := groupSvc.Create(ctx, thing.Group{Name: "grp-invalid", Type: thing.GroupTypeDynamic, Query: "xxx = 1"})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+
+		q := "thingGroup = 'grp-dynamic-self'"
+		_, err = groupSvc.Update(ctx, "grp-dynamic", thing.GroupUpdate{Query: &q})
+		require.Error(t, err)
+		q = "thingGroup = 'grp-nested'"
+		_, err = groupSvc.Update(ctx, "grp-dynamic", thing.GroupUpdate{Query: &q})
+		require.ErrorIs(t, err, model.ErrInvalidParams, "circular reference")
+		g, err := groupSvc.Get(ctx, "grp-dynamic")
+		require.NoError(t, err)
+		require.Equal(t, "`state.desired.fw` = 'v2'", g.Query, "query should not be changed")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, groupSvc.RemoveFromGroups(ctx, "grp-a"))
+		l, err := groupSvc.GroupsOfThing(ctx, "grp-a")
+		require.NoError(t, err)
+		require.Empty(t, l, "deleted thing is removed from groups")
+
+		refs["grp-static"] = []string{`job "j1"`}
+		require.ErrorIs(t, groupSvc.Delete(ctx, "grp-static"), model.ErrInUse, "referenced by job")
+		delete(refs, "grp-static")
+		require.NoError(t, groupSvc.Delete(ctx, "grp-static"))
+		var n int64
+		require.NoError(t, db.Model(&thing.GroupMemberEntity{}).Where("group_name = ?", "grp-static").Count(&n).Error)
+		require.Zero(t, n)
+
+		err = groupSvc.Delete(ctx, "grp-dynamic")
+		require.ErrorIs(t, err, model.ErrInUse, "referenced by other dynamic group")
+		require.ErrorContains(t, err, `"grp-nested"`)
+		require.NoError(t, groupSvc.Delete(ctx, "grp-nested"))
+		require.NoError(t, groupSvc.Delete(ctx, "grp-dynamic"))
+	})
+}
@@ -19,12 +19,21 @@ const (
 	TopicMethodResp   = "/resp"
 )
 
+// methodRespBufferSize responses buffered for an invocation, intermediate ones are dropped for a slow receiver
+const methodRespBufferSize = 16
+
 type MethodReqMsg struct {
 	ThingId     string `json:"thingId"`
 	Method      string `json:"method"`
 	ConnTimeout int    `json:"connTimeout"`     // seconds
 	RespTimeout int    `json:"responseTimeout"` // seconds
 	Req         MethodReq
+	// Caller who invokes the method, like `api:admin` or `job:xxx`, only for audit log
+	Caller string `json:"-"`
+	// OnProgress is called for every intermediate response, they are ignored if it's nil
+	OnProgress func(MethodResp) `json:"-"`
+	// Sensitive request data, like secrets, is not recorded in audit log
+	Sensitive bool `json:"-"`
 }
 
 type MethodReq struct {
@@ -32,11 +41,20 @@ type MethodReq struct {
 	Data        any    `json:"data,omitempty"`
 }
 
+// MethodResp is the response of the thing.
+// For long-running methods, the thing can send intermediate responses with `final` false before the final one,
+// every intermediate response restarts the waiting of response timeout.
 type MethodResp struct {
 	ClientToken string `json:"clientToken,omitempty"`
 	Data        any    `json:"data,omitempty"`
 	Code        int    `json:"code"`
 	Message     string `json:"message"`
+	Final       *bool  `json:"final,omitempty"`    // default is true
+	Progress    *int   `json:"progress,omitempty"` // percentage, for intermediate response
+}
+
+func (r MethodResp) IsFinal() bool {
+	return r.Final == nil || *r.Final
 }
 
 type MethodRespMsg struct {
@@ -148,6 +166,9 @@ func (h *mqttMethod) doInvokeMethod(ctx context.Context,
 	msg MethodReqMsg,
 ) (MethodResp, error) {
 	topic := TopicMethodRequest(msg.ThingId, msg.Method)
+	if msg.RespTimeout <= 0 {
+		msg.RespTimeout = DefaultMethodRespTimeout
+	}
 	j, err := json.Marshal(msg.Req)
 	if err != nil {
 		return MethodResp{}, errors.WithMessage(err, "request json marshal")
@@ -164,17 +185,31 @@ func (h *mqttMethod) doInvokeMethod(ctx context.Context,
 	//	return MethodResp{}, errors.Errorf("send request timeout in %d seconds", msg.RespTimeout)
 	//}
 
-	select {
-	case <-time.After(time.Second * time.Duration(msg.RespTimeout)):
-		return MethodResp{},
-			errors.Wrapf(model.ErrDirectMethodTimeout, "wait %d seconds for response", msg.RespTimeout)
-	case <-ctx.Done():
-		return MethodResp{}, errors.Errorf("interrupted by context done")
-	case res, ok := <-outCh:
-		if !ok {
-			return MethodResp{}, errors.Errorf("out channel closed")
+	timeout := time.Second * time.Duration(msg.RespTimeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return MethodResp{},
+				errors.Wrapf(model.ErrDirectMethodTimeout, "wait %d seconds for response", msg.RespTimeout)
+		case <-ctx.Done():
+			return MethodResp{}, errors.Errorf("interrupted by context done")
+		case res, ok := <-outCh:
+			if !ok {
+				return MethodResp{}, errors.Errorf("out channel closed")
+			}
+			if res.IsFinal() {
+				return res, nil
+			}
+			if msg.OnProgress != nil {
+				msg.OnProgress(res)
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
 		}
-		return res, nil
 	}
 }
 
@@ -188,7 +223,7 @@ func (h *mqttMethod) removePending(thingId, clientToken string) {
 }
 
 func (h *mqttMethod) addPending(thingId, clientToken string) <-chan MethodResp {
-	outCh := make(chan MethodResp)
+	outCh := make(chan MethodResp, methodRespBufferSize)
 	tokenMap, _ := h.pending.LoadOrStore(thingId, new(sync.Map))
 	tokenMap.(*sync.Map).Store(clientToken, pendingResp{respChan: outCh, done: make(chan struct{})})
 	return outCh
@@ -235,25 +270,24 @@ func (h *mqttMethod) subscribeThingOnline(ctx context.Context) {
 
 func (h *mqttMethod) subscribeMethodResp(ctx context.Context) error {
 	topic := TopicMethodAllResponse()
+	// responses are handled in order, for intermediate ones of a streaming method should be relayed in order
 	err := h.connector.Subscribe(ctx, topic, 1, func(msg connector.Message) {
-		go func() {
-			thingId, err := GetThingIdFromTopic(msg.Topic())
-			if err != nil {
-				log.Errorf("Got wrong topic msg topic for method response")
-				return
-			}
-			var r MethodResp
-			err = json.Unmarshal(msg.Payload(), &r)
-			if err != nil {
-				log.Errorf("Invalid message payload for method response")
-				return
-			}
-			res := MethodRespMsg{
-				ThingId: thingId,
-				Resp:    r,
-			}
-			h.sendResp(ctx, res)
-		}()
+		thingId, err := GetThingIdFromTopic(msg.Topic())
+		if err != nil {
+			log.Errorf("Got wrong topic msg topic for method response")
+			return
+		}
+		var r MethodResp
+		err = json.Unmarshal(msg.Payload(), &r)
+		if err != nil {
+			log.Errorf("Invalid message payload for method response")
+			return
+		}
+		res := MethodRespMsg{
+			ThingId: thingId,
+			Resp:    r,
+		}
+		h.sendResp(ctx, res)
 	})
 
 	return err
@@ -270,7 +304,18 @@ func (h *mqttMethod) sendResp(ctx context.Context, msg MethodRespMsg) {
 		select {
 		case <-pResp.done:
 		case pResp.respChan <- msg.Resp:
-		case <-ctx.Done():
+		default:
+			// don't hold up responses of others for a slow receiver, but the final response
+			if !msg.Resp.IsFinal() {
+				log.Warnf("Method intermediate response dropped for slow receiver, thingId=%v clientToken=%s",
+					msg.ThingId, msg.Resp.ClientToken)
+				return
+			}
+			select {
+			case <-pResp.done:
+			case pResp.respChan <- msg.Resp:
+			case <-ctx.Done():
+			}
 		}
 	} else {
 		log.Warnf("Method response got no request, thingId=%v clientToken=%s", msg.ThingId, msg.Resp.ClientToken)
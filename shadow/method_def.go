@@ -0,0 +1,308 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+	"gorm.io/datatypes"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+)
+
+// Registry of direct method definitions.
+// Methods are declared per thing type with JSON Schema of request and response data,
+// invocations to things of the type are validated against the definitions.
+// Things without type, or whose type has no method defined, can be invoked with any method.
+
+const (
+	DefaultMethodRespTimeout = 30  // seconds
+	MaxMethodTimeout         = 300 // seconds
+)
+
+var methodNameRegexp = regexp.MustCompile("^[0-9a-zA-Z_.-]+$")
+
+type MethodDef struct {
+	ThingType   string         `json:"thingType"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	ReqSchema   map[string]any `json:"reqSchema,omitempty" description:"JSON Schema of request data"`
+	RespSchema  map[string]any `json:"respSchema,omitempty" description:"JSON Schema of response data"`
+	ConnTimeout int            `json:"connTimeout" description:"default waiting time for the thing to come online, in seconds"`
+	RespTimeout int            `json:"respTimeout" description:"default waiting time for the thing to response, in seconds"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	CreatedAt   time.Time      `json:"createdAt"`
+}
+
+type MethodDefService interface {
+	// Put creates or replaces the definition
+	Put(ctx context.Context, d MethodDef) (MethodDef, error)
+	Get(ctx context.Context, thingType, name string) (MethodDef, error)
+	Delete(ctx context.Context, thingType, name string) error
+	// List definitions of the thing type, or all definitions if thingType is empty
+	List(ctx context.Context, thingType string) ([]MethodDef, error)
+}
+
+type MethodDefRepo interface {
+	Save(ctx context.Context, e *MethodDefEntity) error
+	Get(ctx context.Context, thingType, name string) (*MethodDefEntity, error)
+	Delete(ctx context.Context, thingType, name string) error
+	List(ctx context.Context, thingType string) ([]MethodDefEntity, error)
+}
+
+type MethodDefEntity struct {
+	ThingType   string `gorm:"primaryKey;size:64"`
+	Name        string `gorm:"primaryKey;size:128"`
+	Description string `gorm:"size:512;NOT NULL;default:''"`
+	ReqSchema   datatypes.JSON
+	RespSchema  datatypes.JSON
+	ConnTimeout int `gorm:"NOT NULL;default:0"`
+	RespTimeout int `gorm:"NOT NULL;default:0"`
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (MethodDefEntity) TableName() string {
+	return "method_def"
+}
+
+func toMethodDefEntity(d MethodDef) (MethodDefEntity, error) {
+	e := MethodDefEntity{
+		ThingType:   d.ThingType,
+		Name:        d.Name,
+		Description: d.Description,
+		ConnTimeout: d.ConnTimeout,
+		RespTimeout: d.RespTimeout,
+		CreatedAt:   d.CreatedAt,
+	}
+	var err error
+	if d.ReqSchema != nil {
+		if e.ReqSchema, err = json.Marshal(d.ReqSchema); err != nil {
+			return e, errors.Wrap(err, "marshal request schema")
+		}
+	}
+	if d.RespSchema != nil {
+		if e.RespSchema, err = json.Marshal(d.RespSchema); err != nil {
+			return e, errors.Wrap(err, "marshal response schema")
+		}
+	}
+	return e, nil
+}
+
+func toMethodDef(e MethodDefEntity) (MethodDef, error) {
+	d := MethodDef{
+		ThingType:   e.ThingType,
+		Name:        e.Name,
+		Description: e.Description,
+		ConnTimeout: e.ConnTimeout,
+		RespTimeout: e.RespTimeout,
+		UpdatedAt:   e.UpdatedAt,
+		CreatedAt:   e.CreatedAt,
+	}
+	if len(e.ReqSchema) > 0 {
+		if err := json.Unmarshal(e.ReqSchema, &d.ReqSchema); err != nil {
+			return d, errors.Wrap(err, "unmarshal request schema")
+		}
+	}
+	if len(e.RespSchema) > 0 {
+		if err := json.Unmarshal(e.RespSchema, &d.RespSchema); err != nil {
+			return d, errors.Wrap(err, "unmarshal response schema")
+		}
+	}
+	return d, nil
+}
+
+// ValidateReq checks the request data against the request schema
+func (d MethodDef) ValidateReq(data any) error {
+	return validateSchema(d.ReqSchema, data)
+}
+
+// ValidateResp checks the response data against the response schema
+func (d MethodDef) ValidateResp(data any) error {
+	return validateSchema(d.RespSchema, data)
+}
+
+func validateSchema(schema map[string]any, data any) error {
+	if schema == nil {
+		return nil
+	}
+	res, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return errors.Wrap(err, "validate json schema")
+	}
+	if !res.Valid() {
+		msg := make([]string, len(res.Errors()))
+		for i, e := range res.Errors() {
+			msg[i] = e.String()
+		}
+		return errors.WithMessage(model.ErrInvalidParams, strings.Join(msg, "; "))
+	}
+	return nil
+}
+
+func (d MethodDef) valid() error {
+	if d.ThingType == "" || len(d.ThingType) > 64 {
+		return errors.WithMessage(model.ErrInvalidParams, "thingType length should be between 1 and 64")
+	}
+	if !methodNameRegexp.MatchString(d.Name) || len(d.Name) > 128 {
+		return errors.WithMessagef(model.ErrInvalidParams, "method name %q", d.Name)
+	}
+	if d.ConnTimeout < 0 || d.ConnTimeout > MaxMethodTimeout {
+		return errors.WithMessagef(model.ErrInvalidParams, "connTimeout should between 0 and %d second", MaxMethodTimeout)
+	}
+	if d.RespTimeout < 0 || d.RespTimeout > MaxMethodTimeout {
+		return errors.WithMessagef(model.ErrInvalidParams, "respTimeout should between 0 and %d second", MaxMethodTimeout)
+	}
+	for n, s := range map[string]map[string]any{"reqSchema": d.ReqSchema, "respSchema": d.RespSchema} {
+		if s == nil {
+			continue
+		}
+		if _, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(s)); err != nil {
+			return errors.WithMessagef(model.ErrInvalidParams, "invalid %s: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// service implement
+
+type methodDefSvc struct {
+	repo MethodDefRepo
+}
+
+var _ MethodDefService = (*methodDefSvc)(nil)
+
+func NewMethodDefSvc(r MethodDefRepo) MethodDefService {
+	return &methodDefSvc{repo: r}
+}
+
+func (s *methodDefSvc) Put(ctx context.Context, d MethodDef) (MethodDef, error) {
+	if err := d.valid(); err != nil {
+		return MethodDef{}, err
+	}
+	old, err := s.repo.Get(ctx, d.ThingType, d.Name)
+	if err != nil {
+		return MethodDef{}, err
+	}
+	if old != nil {
+		d.CreatedAt = old.CreatedAt
+	}
+	e, err := toMethodDefEntity(d)
+	if err != nil {
+		return MethodDef{}, err
+	}
+	if err := s.repo.Save(ctx, &e); err != nil {
+		return MethodDef{}, err
+	}
+	return toMethodDef(e)
+}
+
+func (s *methodDefSvc) Get(ctx context.Context, thingType, name string) (MethodDef, error) {
+	e, err := s.repo.Get(ctx, thingType, name)
+	if err != nil {
+		return MethodDef{}, err
+	}
+	if e == nil {
+		return MethodDef{}, errors.WithMessagef(model.ErrNotFound, "method %q of thing type %q", name, thingType)
+	}
+	return toMethodDef(*e)
+}
+
+func (s *methodDefSvc) Delete(ctx context.Context, thingType, name string) error {
+	return s.repo.Delete(ctx, thingType, name)
+}
+
+func (s *methodDefSvc) List(ctx context.Context, thingType string) ([]MethodDef, error) {
+	l, err := s.repo.List(ctx, thingType)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]MethodDef, len(l))
+	for i, e := range l {
+		if res[i], err = toMethodDef(e); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// method handler with validation
+
+// ThingTypeFunc gets type of the thing
+type ThingTypeFunc func(ctx context.Context, thingId string) (string, error)
+
+type validatedMethod struct {
+	MethodHandler
+	defSvc MethodDefService
+	typeOf ThingTypeFunc
+}
+
+// NewValidatedMethodHandler wraps the method handler to validate invocations against method definitions,
+// and apply default timeouts of the definition when they are not specified in request.
+func NewValidatedMethodHandler(h MethodHandler, defSvc MethodDefService, typeOf ThingTypeFunc) MethodHandler {
+	return &validatedMethod{MethodHandler: h, defSvc: defSvc, typeOf: typeOf}
+}
+
+func (h *validatedMethod) InvokeMethod(ctx context.Context, msg MethodReqMsg) (MethodResp, error) {
+	def, err := h.findDef(ctx, msg)
+	if err != nil {
+		return MethodResp{}, err
+	}
+	if def == nil {
+		return h.MethodHandler.InvokeMethod(ctx, msg)
+	}
+
+	if err := def.ValidateReq(msg.Req.Data); err != nil {
+		return MethodResp{}, errors.WithMessagef(err, "request data of method %q", msg.Method)
+	}
+	if msg.ConnTimeout == 0 {
+		msg.ConnTimeout = def.ConnTimeout
+	}
+	if msg.RespTimeout == 0 {
+		msg.RespTimeout = def.RespTimeout
+	}
+	resp, err := h.MethodHandler.InvokeMethod(ctx, msg)
+	if err == nil && (resp.Code == 0 || resp.Code == 200) {
+		if e := def.ValidateResp(resp.Data); e != nil {
+			log.Warnf("Method response does not match the definition: %v, thingId=%q method=%q", e, msg.ThingId, msg.Method)
+		}
+	}
+	return resp, err
+}
+
+// findDef returns nil if the method is free to invoke
+func (h *validatedMethod) findDef(ctx context.Context, msg MethodReqMsg) (*MethodDef, error) {
+	tp, err := h.typeOf(ctx, msg.ThingId)
+	if err != nil {
+		return nil, err
+	}
+	return FindMethodDef(ctx, h.defSvc, tp, msg.Method)
+}
+
+// FindMethodDef finds definition of the method for things of the type, returns nil if the method is free to invoke,
+// or model.ErrInvalidParams if the type has methods defined but not this one
+func FindMethodDef(ctx context.Context, defSvc MethodDefService, thingType, method string) (*MethodDef, error) {
+	if thingType == "" {
+		return nil, nil
+	}
+	defs, err := defSvc.List(ctx, thingType)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get method definitions")
+	}
+	if len(defs) == 0 {
+		return nil, nil
+	}
+	for _, d := range defs {
+		if d.Name == method {
+			return &d, nil
+		}
+	}
+	return nil, errors.WithMessage(model.ErrInvalidParams,
+		fmt.Sprintf("method %q is not defined for thing type %q", method, thingType))
+}
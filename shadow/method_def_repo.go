@@ -0,0 +1,54 @@
+package shadow
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type methodDefRepo struct {
+	db *gorm.DB
+}
+
+func NewMethodDefRepo(db *gorm.DB) MethodDefRepo {
+	return &methodDefRepo{db: db}
+}
+
+func (r *methodDefRepo) Save(ctx context.Context, e *MethodDefEntity) error {
+	if err := r.db.WithContext(ctx).Save(e).Error; err != nil {
+		return errors.Wrap(err, "save method definition")
+	}
+	return nil
+}
+
+func (r *methodDefRepo) Get(ctx context.Context, thingType, name string) (*MethodDefEntity, error) {
+	var e MethodDefEntity
+	err := r.db.WithContext(ctx).Where("thing_type = ? AND name = ?", thingType, name).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get method definition")
+	}
+	return &e, nil
+}
+
+func (r *methodDefRepo) Delete(ctx context.Context, thingType, name string) error {
+	err := r.db.WithContext(ctx).
+		Where("thing_type = ? AND name = ?", thingType, name).
+		Delete(&MethodDefEntity{}).Error
+	return errors.Wrap(err, "delete method definition")
+}
+
+func (r *methodDefRepo) List(ctx context.Context, thingType string) ([]MethodDefEntity, error) {
+	tx := r.db.WithContext(ctx).Order("thing_type, name")
+	if thingType != "" {
+		tx = tx.Where("thing_type = ?", thingType)
+	}
+	var l []MethodDefEntity
+	if err := tx.Find(&l).Error; err != nil {
+		return nil, errors.Wrap(err, "list method definitions")
+	}
+	return l, nil
+}
@@ -0,0 +1,143 @@
+package shadow_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+func newMethodDefSvc() shadow.MethodDefService {
+	db := mock.NewSqliteConnTest()
+	_ = db.AutoMigrate(&shadow.MethodDefEntity{})
+	return shadow.NewMethodDefSvc(shadow.NewMethodDefRepo(db))
+}
+
+var rebootDef = shadow.MethodDef{
+	ThingType:   "lamp",
+	Name:        "reboot",
+	Description: "reboot the lamp",
+	ReqSchema: map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"delay": map[string]any{"type": "integer", "minimum": 0}},
+		"required":   []any{"delay"},
+	},
+	RespTimeout: 10,
+}
+
+func TestMethodDefSvc_Put(t *testing.T) {
+	ctx := context.Background()
+	svc := newMethodDefSvc()
+
+	t.Run("invalid definition", func(t *testing.T) {
+		d := rebootDef
+		d.Name = "a/b"
+		_, err := svc.Put(ctx, d)
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+
+		d = rebootDef
+		d.ReqSchema = map[string]any{"type": 1}
+		_, err = svc.Put(ctx, d)
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+
+	t.Run("create and replace", func(t *testing.T) {
+		created, err := svc.Put(ctx, rebootDef)
+		require.NoError(t, err)
+		d := rebootDef
+		d.Description = "changed"
+		_, err = svc.Put(ctx, d)
+		require.NoError(t, err)
+
+		got, err := svc.Get(ctx, "lamp", "reboot")
+		require.NoError(t, err)
+		require.Equal(t, "changed", got.Description)
+		require.Equal(t, created.CreatedAt.Unix(), got.CreatedAt.Unix())
+		require.Equal(t, rebootDef.ReqSchema["type"], got.ReqSchema["type"])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, svc.Delete(ctx, "lamp", "reboot"))
+		_, err := svc.Get(ctx, "lamp", "reboot")
+		require.ErrorIs(t, err, model.ErrNotFound)
+	})
+}
+
+type recordMethodHandler struct {
+	fakeMethodHandler
+	got *shadow.MethodReqMsg
+}
+
+func (h *recordMethodHandler) InvokeMethod(ctx context.Context, req shadow.MethodReqMsg) (shadow.MethodResp, error) {
+	h.got = &req
+	return h.fakeMethodHandler.InvokeMethod(ctx, req)
+}
+
+func TestValidatedMethodHandler_InvokeMethod(t *testing.T) {
+	ctx := context.Background()
+	svc := newMethodDefSvc()
+	_, err := svc.Put(ctx, rebootDef)
+	require.NoError(t, err)
+
+	types := map[string]string{"lamp-1": "lamp", "sensor-1": "sensor"}
+	typeOf := func(ctx context.Context, thingId string) (string, error) {
+		return types[thingId], nil
+	}
+
+	cases := []struct {
+		name    string
+		req     shadow.MethodReqMsg
+		invoked bool
+		err     error
+	}{
+		{
+			name:    "valid request",
+			req:     shadow.MethodReqMsg{ThingId: "lamp-1", Method: "reboot", Req: shadow.MethodReq{Data: map[string]any{"delay": 3}}},
+			invoked: true,
+		},
+		{
+			name: "invalid request data",
+			req:  shadow.MethodReqMsg{ThingId: "lamp-1", Method: "reboot", Req: shadow.MethodReq{Data: map[string]any{"delay": -1}}},
+			err:  model.ErrInvalidParams,
+		},
+		{
+			name: "method not defined",
+			req:  shadow.MethodReqMsg{ThingId: "lamp-1", Method: "blink"},
+			err:  model.ErrInvalidParams,
+		},
+		{
+			name:    "thing type without definitions",
+			req:     shadow.MethodReqMsg{ThingId: "sensor-1", Method: "anything"},
+			invoked: true,
+		},
+		{
+			name:    "thing without type",
+			req:     shadow.MethodReqMsg{ThingId: "other", Method: "anything"},
+			invoked: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inner := &recordMethodHandler{fakeMethodHandler: fakeMethodHandler{resp: shadow.MethodResp{Code: 200}}}
+			h := shadow.NewValidatedMethodHandler(inner, svc, typeOf)
+			_, err := h.InvokeMethod(ctx, c.req)
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, c.invoked, inner.got != nil)
+		})
+	}
+
+	t.Run("default timeout from definition", func(t *testing.T) {
+		inner := &recordMethodHandler{fakeMethodHandler: fakeMethodHandler{resp: shadow.MethodResp{Code: 200}}}
+		h := shadow.NewValidatedMethodHandler(inner, svc, typeOf)
+		_, err := h.InvokeMethod(ctx, shadow.MethodReqMsg{ThingId: "lamp-1", Method: "reboot", Req: shadow.MethodReq{Data: map[string]any{"delay": 1}}})
+		require.NoError(t, err)
+		require.Equal(t, rebootDef.RespTimeout, inner.got.RespTimeout)
+	})
+}
@@ -0,0 +1,319 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/pkg/strutil"
+)
+
+// Audit log for direct method invocations.
+// Every call of MethodHandler.InvokeMethod is recorded with its caller, request, result and latency,
+// the records are kept for a configurable period.
+
+const (
+	CallerApiPrefix = "api:"
+	CallerJobPrefix = "job:"
+
+	defaultMethodLogMaxDataSize   = 2048
+	defaultMethodLogRetentionDays = 30
+	methodLogPruneInterval        = time.Hour
+	// methodLogQueueSize logs are recorded asynchronously, they are saved in place when the queue is full
+	methodLogQueueSize   = 1024
+	methodLogSaveTimeout = 5 * time.Second
+	// methodLogDrainTimeout logs queued are saved within it on shutdown
+	methodLogDrainTimeout = 3 * time.Second
+)
+
+type MethodLog struct {
+	Id          int64     `json:"id"`
+	ThingId     string    `json:"thingId"`
+	Method      string    `json:"method"`
+	Caller      string    `json:"caller"`
+	ClientToken string    `json:"clientToken"`
+	ReqData     string    `json:"reqData" description:"json of request data, cut off when it's larger than the configured size"`
+	ReqDataSize int       `json:"reqDataSize" description:"original size of request data in byte"`
+	RespCode    int       `json:"respCode"`
+	RespMessage string    `json:"respMessage"`
+	LatencyMs   int64     `json:"latencyMs"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type MethodLogPageQuery struct {
+	ThingId  string
+	Method   string
+	Caller   string
+	RespCode *int
+	HasError *bool
+	model.TimeSpan
+	model.PageQuery
+}
+
+type MethodLogPage = model.PageData[MethodLog]
+
+type MethodLogOptions struct {
+	// MaxDataSize max size of request data saved in byte
+	MaxDataSize int
+	// RetentionDays logs older than it will be removed
+	RetentionDays int
+}
+
+type MethodLogService interface {
+	Save(ctx context.Context, l MethodLog) error
+	// Record saves the log asynchronously, out of the invocation path
+	Record(l MethodLog)
+	Query(ctx context.Context, q MethodLogPageQuery) (MethodLogPage, error)
+
+	// Start saves recorded logs and prune expired logs periodically until context done,
+	// then the logs queued are saved within a timeout
+	Start(ctx context.Context)
+	// Stopped is closed when the logs queued are saved after context done
+	Stopped() <-chan struct{}
+}
+
+type MethodLogRepo interface {
+	Create(ctx context.Context, l *MethodLogEntity) error
+	Query(ctx context.Context, q MethodLogPageQuery) (model.PageData[MethodLogEntity], error)
+	DeleteBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// MethodLogEntity for saving method invocation audit log
+type MethodLogEntity struct {
+	Id          int64  `gorm:"primaryKey;autoIncrement"`
+	ThingId     string `gorm:"size:64;NOT NULL;index"`
+	Method      string `gorm:"size:128;NOT NULL;"`
+	Caller      string `gorm:"size:128;NOT NULL;default:'';index"`
+	ClientToken string `gorm:"size:128;NOT NULL;default:''"`
+	ReqData     string `gorm:"type:text"`
+	ReqDataSize int    `gorm:"NOT NULL;default:0"`
+	RespCode    int    `gorm:"NOT NULL;default:0"`
+	RespMessage string `gorm:"size:256;NOT NULL;default:''"`
+	LatencyMs   int64  `gorm:"NOT NULL;default:0"`
+	Error       string `gorm:"size:512;NOT NULL;default:''"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;NOT NULL;index"`
+}
+
+func (MethodLogEntity) TableName() string {
+	return "method_log"
+}
+
+func toMethodLogEntity(l MethodLog) MethodLogEntity {
+	return MethodLogEntity{
+		ThingId:     l.ThingId,
+		Method:      strutil.Cut(l.Method, 128),
+		Caller:      strutil.Cut(l.Caller, 128),
+		ClientToken: strutil.Cut(l.ClientToken, 128),
+		ReqData:     l.ReqData,
+		ReqDataSize: l.ReqDataSize,
+		RespCode:    l.RespCode,
+		RespMessage: strutil.Cut(l.RespMessage, 256),
+		LatencyMs:   l.LatencyMs,
+		Error:       strutil.Cut(l.Error, 512),
+		CreatedAt:   l.CreatedAt,
+	}
+}
+
+func toMethodLog(e MethodLogEntity) MethodLog {
+	return MethodLog{
+		Id:          e.Id,
+		ThingId:     e.ThingId,
+		Method:      e.Method,
+		Caller:      e.Caller,
+		ClientToken: e.ClientToken,
+		ReqData:     e.ReqData,
+		ReqDataSize: e.ReqDataSize,
+		RespCode:    e.RespCode,
+		RespMessage: e.RespMessage,
+		LatencyMs:   e.LatencyMs,
+		Error:       e.Error,
+		CreatedAt:   e.CreatedAt,
+	}
+}
+
+// service implement
+
+type methodLogSvc struct {
+	repo    MethodLogRepo
+	opt     MethodLogOptions
+	queue   chan MethodLog
+	stopped chan struct{}
+}
+
+var _ MethodLogService = (*methodLogSvc)(nil)
+
+func NewMethodLogSvc(r MethodLogRepo, opt MethodLogOptions) MethodLogService {
+	if opt.MaxDataSize <= 0 {
+		opt.MaxDataSize = defaultMethodLogMaxDataSize
+	}
+	if opt.RetentionDays <= 0 {
+		opt.RetentionDays = defaultMethodLogRetentionDays
+	}
+	return &methodLogSvc{repo: r, opt: opt, queue: make(chan MethodLog, methodLogQueueSize), stopped: make(chan struct{})}
+}
+
+func (s *methodLogSvc) Save(ctx context.Context, l MethodLog) error {
+	l.ReqData = strutil.Cut(l.ReqData, s.opt.MaxDataSize)
+	e := toMethodLogEntity(l)
+	return s.repo.Create(ctx, &e)
+}
+
+// drain saves the logs queued on shutdown, so that the audit trail has the last calls before it
+func (s *methodLogSvc) drain(picked ...MethodLog) {
+	ctx, cancel := context.WithTimeout(context.Background(), methodLogDrainTimeout)
+	defer cancel()
+	n := 0
+	save := func(l MethodLog) error {
+		if err := s.Save(ctx, l); err != nil {
+			log.Errorf("Save method log on shutdown error: %v, thingId=%q method=%q", err, l.ThingId, l.Method)
+			return err
+		}
+		n++
+		return nil
+	}
+	for _, l := range picked {
+		if save(l) != nil && ctx.Err() != nil {
+			log.Errorf("Saving method logs on shutdown timed out, %d logs are lost", len(s.queue)+1)
+			return
+		}
+	}
+	for {
+		select {
+		case l := <-s.queue:
+			if err := save(l); err != nil && ctx.Err() != nil {
+				log.Errorf("Saving method logs on shutdown timed out, %d logs are lost", len(s.queue)+1)
+				return
+			}
+		default:
+			if n > 0 {
+				log.Infof("Saved %d queued method logs on shutdown", n)
+			}
+			return
+		}
+	}
+}
+
+func (s *methodLogSvc) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
+func (s *methodLogSvc) Record(l MethodLog) {
+	select {
+	case s.queue <- l:
+	default:
+		// every invocation is audited, the log is saved in place rather than dropped
+		ctx, cancel := context.WithTimeout(context.Background(), methodLogSaveTimeout)
+		defer cancel()
+		if err := s.Save(ctx, l); err != nil {
+			log.Errorf("Save method log error: %v, thingId=%q method=%q", err, l.ThingId, l.Method)
+		}
+	}
+}
+
+func (s *methodLogSvc) Query(ctx context.Context, q MethodLogPageQuery) (MethodLogPage, error) {
+	p, err := s.repo.Query(ctx, q)
+	if err != nil {
+		return MethodLogPage{}, err
+	}
+	res := MethodLogPage{Total: p.Total, Content: make([]MethodLog, len(p.Content))}
+	for i, e := range p.Content {
+		res.Content[i] = toMethodLog(e)
+	}
+	return res, nil
+}
+
+func (s *methodLogSvc) Start(ctx context.Context) {
+	go func() {
+		defer close(s.stopped)
+		for {
+			select {
+			case <-ctx.Done():
+				s.drain()
+				return
+			case l := <-s.queue:
+				if ctx.Err() != nil {
+					// picked after context done, it's saved with the logs queued
+					s.drain(l)
+					return
+				}
+				if err := s.Save(ctx, l); err != nil {
+					log.Errorf("Save method log error: %v, thingId=%q method=%q", err, l.ThingId, l.Method)
+				}
+			}
+		}
+	}()
+	go func() {
+		s.prune(ctx)
+		tick := time.NewTicker(methodLogPruneInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				s.prune(ctx)
+			}
+		}
+	}()
+}
+
+func (s *methodLogSvc) prune(ctx context.Context) {
+	before := time.Now().AddDate(0, 0, -s.opt.RetentionDays)
+	n, err := s.repo.DeleteBefore(ctx, before)
+	if err != nil {
+		log.Errorf("Prune method logs before %s error: %v", before, err)
+	} else if n > 0 {
+		log.Infof("Pruned %d method logs before %s", n, before)
+	}
+}
+
+// method handler with audit log
+
+type loggedMethod struct {
+	MethodHandler
+	logSvc MethodLogService
+}
+
+// NewLoggedMethodHandler wraps the method handler to record every invocation into the audit log
+func NewLoggedMethodHandler(h MethodHandler, logSvc MethodLogService) MethodHandler {
+	return &loggedMethod{MethodHandler: h, logSvc: logSvc}
+}
+
+func (h *loggedMethod) InvokeMethod(ctx context.Context, msg MethodReqMsg) (MethodResp, error) {
+	start := time.Now()
+	resp, err := h.MethodHandler.InvokeMethod(ctx, msg)
+
+	l := MethodLog{
+		ThingId:     msg.ThingId,
+		Method:      msg.Method,
+		Caller:      msg.Caller,
+		ClientToken: msg.Req.ClientToken,
+		LatencyMs:   time.Since(start).Milliseconds(),
+		CreatedAt:   start,
+	}
+	if msg.Req.Data != nil && !msg.Sensitive {
+		if buf, e := json.Marshal(msg.Req.Data); e == nil {
+			l.ReqData = string(buf)
+			l.ReqDataSize = len(buf)
+		}
+	}
+	if err != nil {
+		l.Error = err.Error()
+		var he model.HttpErr
+		if errors.As(err, &he) {
+			l.RespCode = he.Code
+		} else {
+			l.RespCode = 500
+		}
+	} else {
+		l.RespCode = resp.Code
+		l.RespMessage = resp.Message
+	}
+	h.logSvc.Record(l)
+	return resp, err
+}
@@ -0,0 +1,73 @@
+package shadow
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type methodLogRepo struct {
+	db *gorm.DB
+}
+
+func NewMethodLogRepo(db *gorm.DB) MethodLogRepo {
+	return &methodLogRepo{db: db}
+}
+
+func (r *methodLogRepo) Create(ctx context.Context, l *MethodLogEntity) error {
+	if err := r.db.WithContext(ctx).Create(l).Error; err != nil {
+		return errors.Wrap(err, "create method log")
+	}
+	return nil
+}
+
+func (r *methodLogRepo) Query(ctx context.Context, q MethodLogPageQuery) (model.PageData[MethodLogEntity], error) {
+	tx := r.db.WithContext(ctx).Model(&MethodLogEntity{})
+	if q.ThingId != "" {
+		tx = tx.Where("thing_id = ?", q.ThingId)
+	}
+	if q.Method != "" {
+		tx = tx.Where("method = ?", q.Method)
+	}
+	if q.Caller != "" {
+		tx = tx.Where("caller = ?", q.Caller)
+	}
+	if q.RespCode != nil {
+		tx = tx.Where("resp_code = ?", *q.RespCode)
+	}
+	if q.HasError != nil {
+		if *q.HasError {
+			tx = tx.Where("error <> ''")
+		} else {
+			tx = tx.Where("error = ''")
+		}
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("created_at < ?", q.To)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[MethodLogEntity]{}, errors.Wrap(err, "count method log")
+	}
+	var l []MethodLogEntity
+	err := tx.Order("id desc").Offset(q.Offset()).Limit(q.Limit()).Find(&l).Error
+	if err != nil {
+		return model.PageData[MethodLogEntity]{}, errors.Wrap(err, "query method log")
+	}
+	return model.PageData[MethodLogEntity]{Total: total, Content: l}, nil
+}
+
+func (r *methodLogRepo) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("created_at < ?", t).Delete(&MethodLogEntity{})
+	if res.Error != nil {
+		return 0, errors.Wrap(res.Error, "delete method log")
+	}
+	return res.RowsAffected, nil
+}
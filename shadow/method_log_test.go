@@ -0,0 +1,143 @@
+package shadow_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+type fakeMethodHandler struct {
+	resp shadow.MethodResp
+	err  error
+}
+
+func (h fakeMethodHandler) InvokeMethod(ctx context.Context, req shadow.MethodReqMsg) (shadow.MethodResp, error) {
+	return h.resp, h.err
+}
+
+func (h fakeMethodHandler) InitMethodHandler(ctx context.Context) error {
+	return nil
+}
+
+func newMethodLogSvc(opt shadow.MethodLogOptions) shadow.MethodLogService {
+	db := mock.NewSqliteConnTest()
+	_ = db.AutoMigrate(&shadow.MethodLogEntity{})
+	return shadow.NewMethodLogSvc(shadow.NewMethodLogRepo(db), opt)
+}
+
+func TestLoggedMethodHandler_InvokeMethod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logSvc := newMethodLogSvc(shadow.MethodLogOptions{MaxDataSize: 16})
+	logSvc.Start(ctx)
+
+	ok := shadow.NewLoggedMethodHandler(fakeMethodHandler{resp: shadow.MethodResp{Code: 200, Message: "ok"}}, logSvc)
+	_, err := ok.InvokeMethod(ctx, shadow.MethodReqMsg{
+		ThingId: "th-1",
+		Method:  "reboot",
+		Caller:  shadow.CallerApiPrefix + "admin",
+		Req:     shadow.MethodReq{ClientToken: "tk-1", Data: map[string]string{"delay": strings.Repeat("x", 32)}},
+	})
+	require.NoError(t, err)
+
+	failed := shadow.NewLoggedMethodHandler(fakeMethodHandler{err: errors.WithMessage(model.ErrDirectMethodThingOffline, "th-2")}, logSvc)
+	_, err = failed.InvokeMethod(ctx, shadow.MethodReqMsg{ThingId: "th-2", Method: "reboot", Caller: shadow.CallerJobPrefix + "job-1"})
+	require.Error(t, err)
+
+	// logs are saved asynchronously
+	require.Eventually(t, func() bool {
+		p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		return err == nil && p.Total == 2
+	}, time.Second, 5*time.Millisecond)
+
+	t.Run("query all", func(t *testing.T) {
+		p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), p.Total)
+		require.Equal(t, "th-2", p.Content[0].ThingId, "should be ordered by newest first")
+	})
+
+	t.Run("request data is cut off", func(t *testing.T) {
+		p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{ThingId: "th-1", PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		require.NoError(t, err)
+		require.Len(t, p.Content, 1)
+		l := p.Content[0]
+		require.Len(t, l.ReqData, 16)
+		require.Greater(t, l.ReqDataSize, 16)
+		require.Equal(t, 200, l.RespCode)
+		require.Equal(t, "api:admin", l.Caller)
+		require.Equal(t, "tk-1", l.ClientToken)
+	})
+
+	t.Run("query by error", func(t *testing.T) {
+		p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{HasError: model.Ref(true), PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		require.NoError(t, err)
+		require.Len(t, p.Content, 1)
+		require.Equal(t, model.ErrDirectMethodThingOffline.Code, p.Content[0].RespCode)
+		require.Equal(t, "job:job-1", p.Content[0].Caller)
+		require.NotEmpty(t, p.Content[0].Error)
+	})
+
+	t.Run("query by time span", func(t *testing.T) {
+		p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{
+			TimeSpan:  model.TimeSpan{From: time.Now().Add(time.Hour)},
+			PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10},
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(0), p.Total)
+	})
+
+	t.Run("long method name is cut off", func(t *testing.T) {
+		h := shadow.NewLoggedMethodHandler(fakeMethodHandler{resp: shadow.MethodResp{Code: 200}}, logSvc)
+		_, err := h.InvokeMethod(ctx, shadow.MethodReqMsg{ThingId: "th-3", Method: strings.Repeat("m", 200)})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{ThingId: "th-3", PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+			return err == nil && len(p.Content) == 1 && len(p.Content[0].Method) == 128
+		}, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestMethodLogSvc_Record(t *testing.T) {
+	ctx := context.Background()
+	// not started, the queue is full after so many logs
+	logSvc := newMethodLogSvc(shadow.MethodLogOptions{MaxDataSize: 16})
+	for i := 0; i < 1024; i++ {
+		logSvc.Record(shadow.MethodLog{ThingId: "th-queued", Method: "reboot", CreatedAt: time.Now()})
+	}
+
+	logSvc.Record(shadow.MethodLog{ThingId: "th-1", Method: strings.Repeat("m", 127) + "é",
+		ReqData: strings.Repeat("x", 15) + "é", CreatedAt: time.Now()})
+	p, err := logSvc.Query(ctx, shadow.MethodLogPageQuery{ThingId: "th-1", PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+	require.NoError(t, err)
+	require.Len(t, p.Content, 1, "log is saved in place when the queue is full")
+	require.Equal(t, strings.Repeat("m", 127), p.Content[0].Method, "cut off without splitting the rune")
+	require.Equal(t, strings.Repeat("x", 15), p.Content[0].ReqData)
+}
+
+func TestMethodLogSvc_Start_drain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// queued before started, they are left to be saved on shutdown
+	logSvc := newMethodLogSvc(shadow.MethodLogOptions{})
+	for i := 0; i < 10; i++ {
+		logSvc.Record(shadow.MethodLog{ThingId: "th-drained", Method: "reboot", CreatedAt: time.Now()})
+	}
+	cancel()
+	logSvc.Start(ctx)
+
+	select {
+	case <-logSvc.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("method log service is not stopped in time")
+	}
+	p, err := logSvc.Query(context.Background(), shadow.MethodLogPageQuery{ThingId: "th-drained", PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), p.Total, "logs queued should be saved on shutdown")
+}
@@ -2,6 +2,7 @@ package shadow_test
 
 import (
 	"encoding/json"
+	"sync"
 	"testing"
 	"time"
 
@@ -185,3 +186,95 @@ func TestDirectMethodHandler_Invoke(t *testing.T) {
 		xxCall.Unset()
 	}
 }
+
+func TestDirectMethodHandler_InvokeWithProgress(t *testing.T) {
+	t.Parallel()
+
+	mockMqtt := mockmq.NewMqttClient("", nil, nil)
+	mockMqtt.On("Subscribe", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAdapter := mockmq.NewAdapter(mockMqtt)
+	var outCh <-chan connector.PresenceEvent = make(chan connector.PresenceEvent)
+	mockAdapter.On("OnConnect").Return(outCh)
+	mockAdapter.On("IsConnected", "555555").Return(true, nil)
+
+	handler := shadow.NewMethodHandler(&mockAdapter)
+	require.NoError(t, handler.InitMethodHandler(ctx))
+
+	respTopic := shadow.TopicMethodResponse("555555", "scan")
+	mockMqtt.On("Publish", respTopic, mock.Anything, false, mock.Anything).Return(mockmq.NewMockToken())
+	mockMqtt.On("Publish", shadow.TopicMethodRequest("555555", "scan"), mock.Anything, false, mock.Anything).
+		Return(mockmq.NewMockToken()).
+		Run(func(args mock.Arguments) {
+			// mock thing sends progress slower than the response timeout in total, then the final response
+			go func() {
+				for _, p := range []int{30, 60, 90} {
+					time.Sleep(time.Millisecond * 600)
+					j, _ := json.Marshal(shadow.MethodResp{ClientToken: "555555", Code: 200, Final: model.Ref(false), Progress: model.Ref(p)})
+					mockMqtt.Publish(respTopic, 0, false, j)
+				}
+				time.Sleep(time.Millisecond * 100)
+				j, _ := json.Marshal(shadow.MethodResp{ClientToken: "555555", Code: 200, Data: "done"})
+				mockMqtt.Publish(respTopic, 0, false, j)
+			}()
+		})
+
+	var progress []int
+	var mu sync.Mutex
+	resp, err := handler.InvokeMethod(ctx, shadow.MethodReqMsg{
+		ThingId: "555555", Method: "scan", RespTimeout: 1,
+		Req: shadow.MethodReq{ClientToken: "555555"},
+		OnProgress: func(r shadow.MethodResp) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress = append(progress, *r.Progress)
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsFinal())
+	require.Equal(t, "done", resp.Data)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []int{30, 60, 90}, progress)
+}
+
+func TestDirectMethodHandler_InvokeWithProgressInOrder(t *testing.T) {
+	t.Parallel()
+
+	mockMqtt := mockmq.NewMqttClient("", nil, nil)
+	mockMqtt.On("Subscribe", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAdapter := mockmq.NewAdapter(mockMqtt)
+	var outCh <-chan connector.PresenceEvent = make(chan connector.PresenceEvent)
+	mockAdapter.On("OnConnect").Return(outCh)
+	mockAdapter.On("IsConnected", "666666").Return(true, nil)
+
+	handler := shadow.NewMethodHandler(&mockAdapter)
+	require.NoError(t, handler.InitMethodHandler(ctx))
+
+	respTopic := shadow.TopicMethodResponse("666666", "scan")
+	mockMqtt.On("Publish", respTopic, mock.Anything, false, mock.Anything).Return(mockmq.NewMockToken())
+	mockMqtt.On("Publish", shadow.TopicMethodRequest("666666", "scan"), mock.Anything, false, mock.Anything).
+		Return(mockmq.NewMockToken()).
+		Run(func(args mock.Arguments) {
+			// mock thing sends responses back to back
+			go func() {
+				for p := 1; p <= 10; p++ {
+					j, _ := json.Marshal(shadow.MethodResp{ClientToken: "666666", Code: 200, Final: model.Ref(false), Progress: model.Ref(p)})
+					mockMqtt.Publish(respTopic, 0, false, j)
+				}
+				j, _ := json.Marshal(shadow.MethodResp{ClientToken: "666666", Code: 200, Data: "done"})
+				mockMqtt.Publish(respTopic, 0, false, j)
+			}()
+		})
+
+	var progress []int
+	resp, err := handler.InvokeMethod(ctx, shadow.MethodReqMsg{
+		ThingId: "666666", Method: "scan", RespTimeout: 1,
+		Req: shadow.MethodReq{ClientToken: "666666"},
+		OnProgress: func(r shadow.MethodResp) {
+			progress = append(progress, *r.Progress)
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "done", resp.Data)
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, progress, "final response after all the intermediate ones")
+}
@@ -0,0 +1,266 @@
+package shadow
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/pkg/strutil"
+)
+
+// Connection history of things.
+// Every presence event is appended to the log, which is kept for a configurable period.
+// ConnStatusEntity keeps the latest status only, while the log tells how a thing is connected over time.
+
+const (
+	defaultPresenceLogRetentionDays = 30
+	presenceLogPruneInterval        = time.Hour
+	defaultUptimeSpan               = 24 * time.Hour
+	presenceLogQueueSize            = 1024
+)
+
+type PresenceLog struct {
+	Id               int64     `json:"id"`
+	ThingId          string    `json:"thingId"`
+	EventType        string    `json:"eventType" enum:"connected|disconnected"`
+	ClientId         string    `json:"clientId"`
+	RemoteAddr       string    `json:"remoteAddr"`
+	DisconnectReason string    `json:"disconnectReason,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+type PresenceLogPageQuery struct {
+	ThingId   string
+	EventType string
+	model.TimeSpan
+	model.PageQuery
+}
+
+type PresenceLogPage = model.PageData[PresenceLog]
+
+// Uptime of a thing over a time span
+type Uptime struct {
+	ThingId     string    `json:"thingId"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	ConnectedMs int64     `json:"connectedMs" description:"total connected time in the span"`
+	Percentage  float64   `json:"percentage" description:"connected time percentage of the span, from 0 to 100"`
+	Connects    int       `json:"connects" description:"count of connected events in the span"`
+	Disconnects int       `json:"disconnects" description:"count of disconnected events in the span"`
+}
+
+type PresenceLogOptions struct {
+	// RetentionDays logs older than it will be removed
+	RetentionDays int
+}
+
+type PresenceLogService interface {
+	Save(ctx context.Context, evt connector.PresenceEvent) error
+	// Query logs, newest first
+	Query(ctx context.Context, q PresenceLogPageQuery) (PresenceLogPage, error)
+	// Uptime calculates connected time of the thing in the span,
+	// the span ends now if To is zero, and lasts 24 hours if From is zero.
+	// The status before the first log kept is taken as disconnected.
+	Uptime(ctx context.Context, thingId string, span model.TimeSpan) (Uptime, error)
+
+	// Start recording presence events and prune expired logs periodically until context done
+	Start(ctx context.Context)
+}
+
+type PresenceLogRepo interface {
+	Create(ctx context.Context, l *PresenceLogEntity) error
+	Query(ctx context.Context, q PresenceLogPageQuery) (model.PageData[PresenceLogEntity], error)
+	// LastBefore gets the last log of the thing before the time, nil if not found
+	LastBefore(ctx context.Context, thingId string, t time.Time) (*PresenceLogEntity, error)
+	// ListBetween lists logs of the thing in [from, to), oldest first
+	ListBetween(ctx context.Context, thingId string, from, to time.Time) ([]PresenceLogEntity, error)
+	DeleteBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// PresenceLogEntity for saving presence events, append only
+type PresenceLogEntity struct {
+	Id               int64     `gorm:"primaryKey;autoIncrement"`
+	ThingId          string    `gorm:"size:64;NOT NULL;index:idx_presence_log_thing_ts"`
+	EventType        string    `gorm:"size:16;NOT NULL"`
+	ClientId         string    `gorm:"size:128;NOT NULL;default:''"`
+	RemoteAddr       string    `gorm:"size:128;NOT NULL;default:''"`
+	DisconnectReason string    `gorm:"size:256;NOT NULL;default:''"`
+	Timestamp        time.Time `gorm:"NOT NULL;index:idx_presence_log_thing_ts;index"`
+}
+
+func (PresenceLogEntity) TableName() string {
+	return "presence_log"
+}
+
+func toPresenceLogEntity(e connector.PresenceEvent) PresenceLogEntity {
+	ts := time.Now()
+	if e.Timestamp > 0 {
+		ts = time.UnixMilli(e.Timestamp)
+	}
+	return PresenceLogEntity{
+		ThingId:          e.ThingId,
+		EventType:        e.EventType,
+		ClientId:         strutil.Cut(e.ClientId, 128),
+		RemoteAddr:       strutil.Cut(e.RemoteAddr, 128),
+		DisconnectReason: strutil.Cut(e.DisconnectReason, 256),
+		Timestamp:        ts,
+	}
+}
+
+func toPresenceLog(e PresenceLogEntity) PresenceLog {
+	return PresenceLog{
+		Id:               e.Id,
+		ThingId:          e.ThingId,
+		EventType:        e.EventType,
+		ClientId:         e.ClientId,
+		RemoteAddr:       e.RemoteAddr,
+		DisconnectReason: e.DisconnectReason,
+		Timestamp:        e.Timestamp,
+	}
+}
+
+// service implement
+
+type presenceLogSvc struct {
+	repo    PresenceLogRepo
+	checker connector.ConnectChecker
+	opt     PresenceLogOptions
+}
+
+var _ PresenceLogService = (*presenceLogSvc)(nil)
+
+func NewPresenceLogSvc(r PresenceLogRepo, checker connector.ConnectChecker, opt PresenceLogOptions) PresenceLogService {
+	if opt.RetentionDays <= 0 {
+		opt.RetentionDays = defaultPresenceLogRetentionDays
+	}
+	return &presenceLogSvc{repo: r, checker: checker, opt: opt}
+}
+
+func (s *presenceLogSvc) Save(ctx context.Context, evt connector.PresenceEvent) error {
+	e := toPresenceLogEntity(evt)
+	return s.repo.Create(ctx, &e)
+}
+
+func (s *presenceLogSvc) Query(ctx context.Context, q PresenceLogPageQuery) (PresenceLogPage, error) {
+	p, err := s.repo.Query(ctx, q)
+	if err != nil {
+		return PresenceLogPage{}, err
+	}
+	res := PresenceLogPage{Total: p.Total, Content: make([]PresenceLog, len(p.Content))}
+	for i, e := range p.Content {
+		res.Content[i] = toPresenceLog(e)
+	}
+	return res, nil
+}
+
+func (s *presenceLogSvc) Uptime(ctx context.Context, thingId string, span model.TimeSpan) (Uptime, error) {
+	now := time.Now()
+	to, from := span.To, span.From
+	if to.IsZero() || to.After(now) {
+		to = now
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultUptimeSpan)
+	}
+	if !from.Before(to) {
+		return Uptime{}, errors.WithMessagef(model.ErrInvalidParams, "time span from %s to %s", from, to)
+	}
+
+	prev, err := s.repo.LastBefore(ctx, thingId, from)
+	if err != nil {
+		return Uptime{}, err
+	}
+	l, err := s.repo.ListBetween(ctx, thingId, from, to)
+	if err != nil {
+		return Uptime{}, err
+	}
+
+	res := Uptime{ThingId: thingId, From: from, To: to}
+	connected := prev != nil && prev.EventType == connector.EventConnected
+	var up time.Duration
+	cur := from
+	for _, e := range l {
+		if connected {
+			up += e.Timestamp.Sub(cur)
+		}
+		cur = e.Timestamp
+		connected = e.EventType == connector.EventConnected
+		if connected {
+			res.Connects++
+		} else {
+			res.Disconnects++
+		}
+	}
+	if connected {
+		up += to.Sub(cur)
+	}
+	res.ConnectedMs = up.Milliseconds()
+	res.Percentage = float64(up) / float64(to.Sub(from)) * 100
+	return res, nil
+}
+
+func (s *presenceLogSvc) Start(ctx context.Context) {
+	queue := make(chan connector.PresenceEvent, presenceLogQueueSize)
+	go s.saveWorker(ctx, queue)
+	go s.receive(ctx, queue)
+	go func() {
+		s.prune(ctx)
+		tick := time.NewTicker(presenceLogPruneInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				s.prune(ctx)
+			}
+		}
+	}()
+}
+
+// receive takes presence events off the event bus without blocking it, they are saved in order by saveWorker
+func (s *presenceLogSvc) receive(ctx context.Context, queue chan<- connector.PresenceEvent) {
+	ch := s.checker.OnConnect()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			select {
+			case queue <- evt:
+			default:
+				log.Warnf("Presence log queue is full, dropped event %q of thing %q at %d",
+					evt.EventType, evt.ThingId, evt.Timestamp)
+			}
+		}
+	}
+}
+
+func (s *presenceLogSvc) saveWorker(ctx context.Context, queue <-chan connector.PresenceEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-queue:
+			if err := s.Save(ctx, evt); err != nil {
+				log.Errorf("Save presence log error: %v, event: %#v", err, evt)
+			}
+		}
+	}
+}
+
+func (s *presenceLogSvc) prune(ctx context.Context) {
+	before := time.Now().AddDate(0, 0, -s.opt.RetentionDays)
+	n, err := s.repo.DeleteBefore(ctx, before)
+	if err != nil {
+		log.Errorf("Prune presence logs before %s error: %v", before, err)
+	} else if n > 0 {
+		log.Infof("Pruned %d presence logs before %s", n, before)
+	}
+}
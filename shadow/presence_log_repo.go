@@ -0,0 +1,85 @@
+package shadow
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type presenceLogRepo struct {
+	db *gorm.DB
+}
+
+func NewPresenceLogRepo(db *gorm.DB) PresenceLogRepo {
+	return &presenceLogRepo{db: db}
+}
+
+func (r *presenceLogRepo) Create(ctx context.Context, l *PresenceLogEntity) error {
+	if err := r.db.WithContext(ctx).Create(l).Error; err != nil {
+		return errors.Wrap(err, "create presence log")
+	}
+	return nil
+}
+
+func (r *presenceLogRepo) Query(ctx context.Context, q PresenceLogPageQuery) (model.PageData[PresenceLogEntity], error) {
+	tx := r.db.WithContext(ctx).Model(&PresenceLogEntity{})
+	if q.ThingId != "" {
+		tx = tx.Where("thing_id = ?", q.ThingId)
+	}
+	if q.EventType != "" {
+		tx = tx.Where("event_type = ?", q.EventType)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("timestamp >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("timestamp < ?", q.To)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[PresenceLogEntity]{}, errors.Wrap(err, "count presence log")
+	}
+	var l []PresenceLogEntity
+	err := tx.Order("timestamp desc, id desc").Offset(q.Offset()).Limit(q.Limit()).Find(&l).Error
+	if err != nil {
+		return model.PageData[PresenceLogEntity]{}, errors.Wrap(err, "query presence log")
+	}
+	return model.PageData[PresenceLogEntity]{Total: total, Content: l}, nil
+}
+
+func (r *presenceLogRepo) LastBefore(ctx context.Context, thingId string, t time.Time) (*PresenceLogEntity, error) {
+	var l []PresenceLogEntity
+	err := r.db.WithContext(ctx).
+		Where("thing_id = ? AND timestamp < ?", thingId, t).
+		Order("timestamp desc, id desc").Limit(1).Find(&l).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "get last presence log")
+	}
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return &l[0], nil
+}
+
+func (r *presenceLogRepo) ListBetween(ctx context.Context, thingId string, from, to time.Time) ([]PresenceLogEntity, error) {
+	var l []PresenceLogEntity
+	err := r.db.WithContext(ctx).
+		Where("thing_id = ? AND timestamp >= ? AND timestamp < ?", thingId, from, to).
+		Order("timestamp, id").Find(&l).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "list presence log")
+	}
+	return l, nil
+}
+
+func (r *presenceLogRepo) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("timestamp < ?", t).Delete(&PresenceLogEntity{})
+	if res.Error != nil {
+		return 0, errors.Wrap(res.Error, "delete presence log")
+	}
+	return res.RowsAffected, nil
+}
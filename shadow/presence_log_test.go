@@ -0,0 +1,143 @@
+package shadow_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/config"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/connector/mqtt/client"
+	"ruff.io/tio/connector/mqtt/embed"
+	"ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+type fakeConnectChecker struct {
+	connector.ConnectChecker
+	ch chan connector.PresenceEvent
+}
+
+func (c fakeConnectChecker) OnConnect() <-chan connector.PresenceEvent {
+	return c.ch
+}
+
+func newPresenceLogSvc(checker connector.ConnectChecker) shadow.PresenceLogService {
+	db := mock.NewSqliteConnTest()
+	_ = db.AutoMigrate(&shadow.PresenceLogEntity{})
+	return shadow.NewPresenceLogSvc(shadow.NewPresenceLogRepo(db), checker, shadow.PresenceLogOptions{})
+}
+
+func TestPresenceLogSvc_Start(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checker := fakeConnectChecker{ch: make(chan connector.PresenceEvent)}
+	svc := newPresenceLogSvc(checker)
+	svc.Start(ctx)
+
+	now := time.Now()
+	checker.ch <- connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventConnected,
+		ClientId: "th-1", RemoteAddr: "10.0.0.1:1883", Timestamp: now.Add(-time.Minute).UnixMilli()}
+	checker.ch <- connector.PresenceEvent{ThingId: "th-1", EventType: connector.EventDisconnected,
+		ClientId: "th-1", DisconnectReason: "keepalive timeout", Timestamp: now.UnixMilli()}
+	checker.ch <- connector.PresenceEvent{ThingId: "th-2", EventType: connector.EventConnected, Timestamp: now.UnixMilli()}
+
+	require.Eventually(t, func() bool {
+		p, err := svc.Query(ctx, shadow.PresenceLogPageQuery{PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		return err == nil && p.Total == 3
+	}, time.Second, time.Millisecond*10)
+
+	p, err := svc.Query(ctx, shadow.PresenceLogPageQuery{ThingId: "th-1", PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+	require.NoError(t, err)
+	require.Len(t, p.Content, 2)
+	require.Equal(t, connector.EventDisconnected, p.Content[0].EventType, "should be ordered by newest first")
+	require.Equal(t, "keepalive timeout", p.Content[0].DisconnectReason)
+	require.Equal(t, "10.0.0.1:1883", p.Content[1].RemoteAddr)
+
+	p, err = svc.Query(ctx, shadow.PresenceLogPageQuery{
+		ThingId:   "th-1",
+		EventType: connector.EventConnected,
+		PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10},
+	})
+	require.NoError(t, err)
+	require.Len(t, p.Content, 1)
+
+	p, err = svc.Query(ctx, shadow.PresenceLogPageQuery{
+		TimeSpan:  model.TimeSpan{From: now.Add(-time.Second)},
+		PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), p.Total)
+}
+
+func TestPresenceLogSvc_EmbedBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port := 21885
+	embed.InitBroker(embed.MochiConfig{
+		TcpPort: port,
+		AuthzFn: func(embed.ConnectParams) bool {
+			return true
+		},
+		AclFn: func(user string, topic string, write bool) bool {
+			return true
+		},
+	})
+	svc := newPresenceLogSvc(embed.NewEmbedAdapter())
+	svc.Start(ctx)
+
+	cl := client.NewClient(config.MqttClientConfig{ClientId: "th-embed", User: "th-embed", Port: port, Host: "localhost"})
+	require.NoError(t, cl.Connect(ctx))
+	count := func() int64 {
+		p, err := svc.Query(ctx, shadow.PresenceLogPageQuery{ThingId: "th-embed", PageQuery: model.PageQuery{PageIndex: 1, PageSize: 10}})
+		require.NoError(t, err)
+		return p.Total
+	}
+	require.Eventually(t, func() bool { return count() == 1 }, time.Second, time.Millisecond*10)
+	cl.Disconnect()
+	require.Eventually(t, func() bool { return count() == 2 }, time.Second, time.Millisecond*10)
+
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, int64(2), count(), "each presence event should be saved once")
+}
+
+func TestPresenceLogSvc_Uptime(t *testing.T) {
+	ctx := context.Background()
+	svc := newPresenceLogSvc(nil)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	save := func(thingId, evtType string, at time.Duration) {
+		err := svc.Save(ctx, connector.PresenceEvent{ThingId: thingId, EventType: evtType, Timestamp: base.Add(at).UnixMilli()})
+		require.NoError(t, err)
+	}
+	// connected before the span, offline for 15 minutes in the first hour, and disconnected at the end
+	save("th-1", connector.EventConnected, -time.Hour)
+	save("th-1", connector.EventDisconnected, 30*time.Minute)
+	save("th-1", connector.EventConnected, 45*time.Minute)
+	save("th-1", connector.EventDisconnected, 90*time.Minute)
+	save("th-2", connector.EventConnected, 0)
+
+	up, err := svc.Uptime(ctx, "th-1", model.TimeSpan{From: base, To: base.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, (75 * time.Minute).Milliseconds(), up.ConnectedMs)
+	require.InDelta(t, 62.5, up.Percentage, 0.001)
+	require.Equal(t, 1, up.Connects)
+	require.Equal(t, 2, up.Disconnects)
+
+	up, err = svc.Uptime(ctx, "th-1", model.TimeSpan{From: base.Add(50 * time.Minute), To: base.Add(60 * time.Minute)})
+	require.NoError(t, err)
+	require.InDelta(t, 100, up.Percentage, 0.001, "status before the span should be taken")
+
+	up, err = svc.Uptime(ctx, "th-3", model.TimeSpan{From: base, To: base.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Zero(t, up.Percentage)
+
+	up, err = svc.Uptime(ctx, "th-2", model.TimeSpan{})
+	require.NoError(t, err)
+	require.InDelta(t, 100, up.Percentage, 0.001, "span should end now and last 24 hours by default")
+	require.Equal(t, 24*time.Hour, up.To.Sub(up.From))
+
+	_, err = svc.Uptime(ctx, "th-1", model.TimeSpan{From: base, To: base})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+}
@@ -11,6 +11,8 @@ import (
 	"ruff.io/tio/connector"
 
 	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+	"gorm.io/gorm/clause"
 	"ruff.io/tio/pkg/log"
 	"ruff.io/tio/pkg/model"
 )
@@ -41,6 +43,10 @@ type StateService interface {
 	SyncConnStatus(ctx context.Context) error
 }
 
+// StateSchemaFunc gets JSON Schemas of the desired and reported state of the thing, nil if there is no schema.
+// The merged state of an update is rejected if it doesn't match the schema.
+type StateSchemaFunc func(ctx context.Context, thingId string) (desired, reported map[string]any, err error)
+
 type StateDesiredSetter interface {
 	SetDesired(ctx context.Context, thingId string, sr StateReq) (Shadow, error)
 }
@@ -50,8 +56,40 @@ type CrudService interface {
 	Delete(ctx context.Context, thingId string) error
 	Query(ctx context.Context, page model.PageQuery, query string) (Page, error)
 	Get(ctx context.Context, thingId string, opt GetOption) (ShadowWithStatus, error)
+	// Find finds things matching the filter, ordered by thing id
+	Find(ctx context.Context, f ThingFilter) ([]ShadowWithStatus, error)
+	// MatchGroups returns the groups which the thing is a member of among the given ones, in one query
+	MatchGroups(ctx context.Context, thingId string, groups []string) ([]string, error)
+}
+
+// GroupDef definition of thing group.
+// Members of a static group are recorded in table thing_group_member,
+// members of a dynamic group are things matching the query condition.
+type GroupDef struct {
+	Name  string
+	Query string // where condition of shadow query for dynamic group, empty for static group
+}
+
+// GroupDefFunc gets definitions of the thing groups, groups not found are absent in result.
+// It resolves thingGroup in query.
+type GroupDefFunc func(ctx context.Context, names []string) ([]GroupDef, error)
+
+// ThingFilter filter of things to find, things matching all the conditions present are found.
+// Values are bound as parameters rather than spliced into sql.
+type ThingFilter struct {
+	ThingIds  []string
+	ThingType string
+	Groups    []string // members of any of the thing groups
+	Where     string   // where condition of shadow query, eg: `tags.region` = 'cn' and connected = true
+
+	// AfterId and Limit are for paging by thing id, no limit if Limit is 0
+	AfterId string
+	Limit   int
 }
 
+// maxGroupDepth max nesting depth of dynamic groups which reference other groups in query
+const maxGroupDepth = 5
+
 type TagsService interface {
 	SetTag(ctx context.Context, thingId string, tag TagsReq) error
 }
@@ -74,9 +112,15 @@ type Repo interface {
 	Update(ctx context.Context, thingId string, version int64, s Shadow) (*Shadow, error)
 	Get(ctx context.Context, thingId string) (*ShadowWithEnable, error)
 	Query(ctx context.Context, q model.PageQuery, query ParsedQuerySql) (model.PageData[ShadowWithStatus], error)
+	// Find gets things matching all the conditions, ordered by thing id, no limit if limit is 0
+	Find(ctx context.Context, conds []clause.Expression, limit int) ([]ShadowWithStatus, error)
+	// MatchConds checks which of the conditions the thing matches
+	MatchConds(ctx context.Context, thingId string, conds []clause.Expression) ([]bool, error)
 
 	UpdateConnStatus(ctx context.Context, s []connector.ClientInfo) error
 	UpdateAllConnStatusDisconnect(ctx context.Context, updateTimeBefore time.Time) error
+	// ListConnected lists id of things connected in conn status
+	ListConnected(ctx context.Context) ([]string, error)
 }
 
 var _ Service = (*shadowSvc)(nil)
@@ -88,12 +132,15 @@ type shadowSvc struct {
 	deltaSubscribers    []StateDeltaSubscribe
 	acceptedSubscribers []StateAcceptedSubscribe
 	rejectedSubscribers []StateRejectedSubscribe
+	stateSchema         StateSchemaFunc
+	groupDefs           GroupDefFunc
 }
 
 var svcSingleton *shadowSvc
 var svcOnce sync.Once
 
-func NewSvc(r Repo, a connector.ConnectChecker) Service {
+// NewSvc stateSchema and groupDefs may be nil, then states are not validated and thingGroup can't be queried
+func NewSvc(r Repo, a connector.ConnectChecker, stateSchema StateSchemaFunc, groupDefs GroupDefFunc) Service {
 	svcOnce.Do(func() {
 		u := make([]StateUpdateSubscribe, 0)
 		d := make([]StateDeltaSubscribe, 0)
@@ -106,6 +153,8 @@ func NewSvc(r Repo, a connector.ConnectChecker) Service {
 			deltaSubscribers:    d,
 			acceptedSubscribers: acp,
 			rejectedSubscribers: rjt,
+			stateSchema:         stateSchema,
+			groupDefs:           groupDefs,
 		}
 	})
 	return svcSingleton
@@ -223,6 +272,9 @@ func (s *shadowSvc) Query(ctx context.Context, pq model.PageQuery, query string)
 		if err != nil {
 			return Page{}, errors.WithMessage(model.ErrInvalidParams, err.Error())
 		}
+		if parsedQ.Where, err = s.resolveGroups(ctx, parsedQ, 0); err != nil {
+			return Page{}, err
+		}
 	}
 
 	p, err := s.repo.Query(ctx, pq, parsedQ)
@@ -244,6 +296,226 @@ func (s *shadowSvc) Query(ctx context.Context, pq model.PageQuery, query string)
 	return resP, nil
 }
 
+// resolveGroups replaces placeholders of thing groups in where clause with sub queries of group members.
+// Sub queries are put into the syntax tree, so that values of the query are never taken as sql.
+func (s *shadowSvc) resolveGroups(ctx context.Context, q ParsedQuerySql, depth int) (string, error) {
+	if len(q.GroupRefs) == 0 {
+		return q.Where, nil
+	}
+	if s.groupDefs == nil {
+		return "", errors.WithMessage(model.ErrInvalidParams, "thing group is not supported")
+	}
+	if depth >= maxGroupDepth {
+		return "", errors.WithMessagef(model.ErrInvalidParams, "thing groups are nested more than %d levels", maxGroupDepth)
+	}
+	for _, ref := range q.GroupRefs {
+		defs, err := s.groupDefs(ctx, ref.Names)
+		if err != nil {
+			return "", errors.WithMessage(err, "get thing group definitions")
+		}
+		found := make(map[string]GroupDef, len(defs))
+		for _, d := range defs {
+			found[d.Name] = d
+		}
+		var static sqlparser.ValTuple
+		var subQueries []sqlparser.SelectStatement
+		for _, n := range ref.Names {
+			d, ok := found[n]
+			if !ok {
+				return "", errors.WithMessagef(model.ErrInvalidParams, "thing group %q not found", n)
+			}
+			if d.Query == "" {
+				static = append(static, sqlparser.NewStrVal([]byte(n)))
+				continue
+			}
+			dWhere, err := s.dynamicGroupWhere(ctx, d, depth+1)
+			if err != nil {
+				return "", err
+			}
+			sq, err := dynamicGroupSubQuery(dWhere)
+			if err != nil {
+				return "", errors.WithMessagef(model.ErrInvalidParams, "query of thing group %q: %v", d.Name, err)
+			}
+			subQueries = append(subQueries, sq)
+		}
+		if len(static) > 0 {
+			subQueries = append(subQueries, staticGroupSubQuery(static))
+		}
+		sel := subQueries[0]
+		for _, sq := range subQueries[1:] {
+			sel = &sqlparser.Union{Type: sqlparser.UnionStr, Left: sel, Right: sq}
+		}
+		ref.cond.Right = &sqlparser.Subquery{Select: sel}
+	}
+	return formatSql(q.where), nil
+}
+
+// dynamicGroupWhere resolves the query of the dynamic group to where clause
+func (s *shadowSvc) dynamicGroupWhere(ctx context.Context, d GroupDef, depth int) (string, error) {
+	dq, err := parseWhere(d.Query)
+	if err != nil {
+		return "", errors.WithMessagef(model.ErrInvalidParams, "query of thing group %q: %v", d.Name, err)
+	}
+	return s.resolveGroups(ctx, dq, depth)
+}
+
+// dynamicGroupSubQuery sub query of members of the dynamic group
+func dynamicGroupSubQuery(where string) (sqlparser.SelectStatement, error) {
+	stmt, err := sqlparser.Parse("SELECT shadow.thing_id FROM shadow" +
+		" INNER JOIN thing t ON t.id = shadow.thing_id" +
+		" LEFT JOIN conn_status ON conn_status.thing_id = shadow.thing_id" +
+		" WHERE " + where)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.(sqlparser.SelectStatement), nil
+}
+
+// staticGroupSubQuery sub query of members of the static groups
+func staticGroupSubQuery(names sqlparser.ValTuple) sqlparser.SelectStatement {
+	return &sqlparser.Select{
+		SelectExprs: sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent("thing_id")}}},
+		From: sqlparser.TableExprs{&sqlparser.AliasedTableExpr{
+			Expr: sqlparser.TableName{Name: sqlparser.NewTableIdent("thing_group_member")},
+		}},
+		Where: sqlparser.NewWhere(sqlparser.WhereStr, &sqlparser.ComparisonExpr{
+			Left:     &sqlparser.ColName{Name: sqlparser.NewColIdent("group_name")},
+			Operator: sqlparser.InStr,
+			Right:    names,
+		}),
+	}
+}
+
+func (s *shadowSvc) Find(ctx context.Context, f ThingFilter) ([]ShadowWithStatus, error) {
+	var conds []clause.Expression
+	if len(f.ThingIds) > 0 {
+		conds = append(conds, clause.Expr{SQL: "shadow.thing_id IN ?", Vars: []any{f.ThingIds}})
+	}
+	if f.ThingType != "" {
+		conds = append(conds, clause.Expr{SQL: "t.thing_type = ?", Vars: []any{f.ThingType}})
+	}
+	if f.AfterId != "" {
+		conds = append(conds, clause.Expr{SQL: "shadow.thing_id > ?", Vars: []any{f.AfterId}})
+	}
+	if len(f.Groups) > 0 {
+		c, err := s.groupsCond(ctx, f.Groups)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	if f.Where != "" {
+		q, err := parseWhere(f.Where)
+		if err != nil {
+			return nil, errors.WithMessage(model.ErrInvalidParams, err.Error())
+		}
+		where, err := s.resolveGroups(ctx, q, 0)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, clause.Expr{SQL: "(" + where + ")"})
+	}
+	return s.repo.Find(ctx, conds, f.Limit)
+}
+
+// groupsCond condition of members of any of the groups, names of static groups are bound as parameters
+func (s *shadowSvc) groupsCond(ctx context.Context, names []string) (clause.Expression, error) {
+	if s.groupDefs == nil {
+		return nil, errors.WithMessage(model.ErrInvalidParams, "thing group is not supported")
+	}
+	defs, err := s.groupDefs(ctx, names)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get thing group definitions")
+	}
+	found := make(map[string]GroupDef, len(defs))
+	for _, d := range defs {
+		found[d.Name] = d
+	}
+	var static []string
+	var or []clause.Expression
+	for _, n := range names {
+		d, ok := found[n]
+		if !ok {
+			return nil, errors.WithMessagef(model.ErrInvalidParams, "thing group %q not found", n)
+		}
+		if d.Query == "" {
+			static = append(static, n)
+			continue
+		}
+		where, err := s.dynamicGroupWhere(ctx, d, 1)
+		if err != nil {
+			return nil, err
+		}
+		sq, err := dynamicGroupSubQuery(where)
+		if err != nil {
+			return nil, errors.WithMessagef(model.ErrInvalidParams, "query of thing group %q: %v", d.Name, err)
+		}
+		or = append(or, clause.Expr{SQL: "shadow.thing_id IN (" + formatSql(sq) + ")"})
+	}
+	if len(static) > 0 {
+		or = append(or, clause.Expr{
+			SQL:  "shadow.thing_id IN (SELECT thing_id FROM thing_group_member WHERE group_name IN ?)",
+			Vars: []any{static},
+		})
+	}
+	if len(or) == 1 {
+		// single OR condition is joined with OR by gorm
+		return or[0], nil
+	}
+	return clause.Or(or...), nil
+}
+
+func (s *shadowSvc) MatchGroups(ctx context.Context, thingId string, groups []string) ([]string, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	if s.groupDefs == nil {
+		return nil, errors.WithMessage(model.ErrInvalidParams, "thing group is not supported")
+	}
+	defs, err := s.groupDefs(ctx, groups)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get thing group definitions")
+	}
+	found := make(map[string]GroupDef, len(defs))
+	for _, d := range defs {
+		found[d.Name] = d
+	}
+	var names []string
+	var conds []clause.Expression
+	for _, n := range groups {
+		d, ok := found[n]
+		if !ok {
+			continue
+		}
+		if d.Query == "" {
+			names = append(names, n)
+			conds = append(conds, clause.Expr{
+				SQL:  "shadow.thing_id IN (SELECT thing_id FROM thing_group_member WHERE group_name = ?)",
+				Vars: []any{n},
+			})
+			continue
+		}
+		where, err := s.dynamicGroupWhere(ctx, d, 1)
+		if err != nil {
+			log.Warnf("Check thing %q in dynamic group %q error: %v", thingId, n, err)
+			continue
+		}
+		names = append(names, n)
+		conds = append(conds, clause.Expr{SQL: where})
+	}
+	matched, err := s.repo.MatchConds(ctx, thingId, conds)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for i, m := range matched {
+		if m {
+			res = append(res, names[i])
+		}
+	}
+	return res, nil
+}
+
 // Convert ShadowWithStatus to map
 // Use json Marshal and Unmarshal to simplify it, although there is some loss of performance
 func entityToMap(list []ShadowWithStatus) ([]map[string]interface{}, error) {
@@ -275,6 +547,7 @@ func (s *shadowSvc) Get(ctx context.Context, thingId string, opt GetOption) (Sha
 			res.ConnectedAt = ci.ConnectedAt
 			res.DisconnectedAt = ci.DisconnectedAt
 			res.RemoteAddr = ci.RemoteAddr
+			res.Flapping = ci.Flapping
 		}
 	}
 	return res, nil
@@ -294,6 +567,20 @@ func (s *shadowSvc) setState(
 		me  MetaValue
 	}, 1)
 	version := sr.Version
+
+	// get schema outside the transaction
+	var schema map[string]any
+	if s.stateSchema != nil {
+		desired, reported, err := s.stateSchema(ctx, thingId)
+		if err != nil {
+			return Shadow{}, nil, errors.WithMessage(err, "get state schema")
+		}
+		schema = reported
+		if isDesired {
+			schema = desired
+		}
+	}
+
 	err := s.repo.ExecWithTx(func(txtRepo Repo) error {
 		// match version
 		ss, err := txtRepo.Get(ctx, thingId)
@@ -329,11 +616,17 @@ func (s *shadowSvc) setState(
 				return model.ErrShadowFormat
 			}
 			MergeState(&ss.State.Desired, sr.State.Desired, &ss.Metadata.Desired, &updatedMeta)
+			if err := validateSchema(schema, ss.State.Desired); err != nil {
+				return errors.WithMessage(err, "desired state doesn't match the schema")
+			}
 		} else {
 			if sr.State.Reported == nil {
 				return model.ErrShadowFormat
 			}
 			MergeState(&ss.State.Reported, sr.State.Reported, &ss.Metadata.Reported, &updatedMeta)
+			if err := validateSchema(schema, ss.State.Reported); err != nil {
+				return errors.WithMessage(err, "reported state doesn't match the schema")
+			}
 		}
 
 		// update
@@ -502,6 +795,7 @@ func toClientInfo(e connector.PresenceEvent) connector.ClientInfo {
 		Connected:        conn,
 		DisconnectReason: e.DisconnectReason,
 		RemoteAddr:       e.RemoteAddr,
+		Flapping:         e.Flapping,
 	}
 	if conn {
 		c.ConnectedAt = &t
@@ -25,13 +25,16 @@ import (
 
 func newTestSvc() (shadow.Service, thing.Service, *gorm.DB) {
 	db := mock.NewSqliteConnTest()
-	err := db.AutoMigrate(&thing.Entity{}, &shadow.Entity{}, &shadow.ConnStatusEntity{})
+	err := db.AutoMigrate(&thing.Entity{}, &thing.TypeEntity{}, &thing.GroupEntity{}, &thing.GroupMemberEntity{},
+		&shadow.Entity{}, &shadow.ConnStatusEntity{}, &shadow.MethodDefEntity{})
 	if err != nil {
 		log.Fatalf("db AutoMigrate: %v", err)
 	}
 	time.Sleep(time.Millisecond * 100)
-	svc := wire.InitSvc(db, shadowMock.NewConnectivity())
-	tsvc := thingwire.InitSvc(ctx, db, svc, shadowMock.NewConnectivity())
+	// the shadow service is a singleton, state schemas and groups are provided for all tests
+	typeSvc := thing.NewTypeSvc(thing.NewTypeRepo(db), shadow.NewMethodDefSvc(shadow.NewMethodDefRepo(db)))
+	svc := wire.InitSvc(db, shadowMock.NewConnectivity(), typeSvc.StateSchemaOf, thing.GroupDefsOf(thing.NewGroupRepo(db)))
+	tsvc := thingwire.InitSvc(ctx, db, svc, shadowMock.NewConnectivity(), nil, nil)
 	return svc, tsvc, db
 }
 
@@ -351,6 +354,61 @@ func TestSvcImpl_SetTags(t *testing.T) {
 	})
 }
 
+func TestShadowSvc_ThingType(t *testing.T) {
+	typeSvc := thing.NewTypeSvc(thing.NewTypeRepo(db), shadow.NewMethodDefSvc(shadow.NewMethodDefRepo(db)))
+	_, err := typeSvc.Create(ctx, thing.ThingType{
+		Name:        "lamp",
+		DefaultTags: map[string]any{"vendor": "ruff"},
+		DesiredSchema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"color": map[string]any{"enum": []any{"red", "green"}}},
+		},
+	})
+	require.NoError(t, err)
+	id := fmt.Sprintf("for-thing-type-%d", time.Now().UnixNano())
+	_, err = thingSvc.Create(ctx, thing.Thing{Id: id, ThingType: "lamp", Enabled: true})
+	require.NoError(t, err)
+
+	t.Run("default tags of type", func(t *testing.T) {
+		s, err := svc.Get(ctx, id, shadow.GetOption{})
+		require.NoError(t, err)
+		require.Equal(t, shadow.TagsValue{"vendor": "ruff"}, s.Tags)
+	})
+
+	t.Run("query by thing type", func(t *testing.T) {
+		p, err := svc.Query(ctx, model.PageQuery{PageIndex: 1, PageSize: 10},
+			"select thingId, thingType from shadow where thingType = 'lamp'")
+		require.NoError(t, err)
+		require.Equal(t, []any{map[string]any{"thingId": id, "thingType": "lamp"}}, p.Content)
+	})
+
+	t.Run("desired state should match the schema", func(t *testing.T) {
+		_, err := svc.SetDesired(ctx, id, shadow.StateReq{State: shadow.StateDR{Desired: shadow.StateValue{"color": "blue"}}})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+		_, err = svc.SetDesired(ctx, id, shadow.StateReq{State: shadow.StateDR{Desired: shadow.StateValue{"color": "green"}}})
+		require.NoError(t, err)
+		// no schema for reported state
+		_, err = svc.SetReported(ctx, id, shadow.StateReq{State: shadow.StateDR{Reported: shadow.StateValue{"color": "blue"}}})
+		require.NoError(t, err)
+	})
+
+	t.Run("secret delivery rejected by the schema", func(t *testing.T) {
+		_, err := typeSvc.Create(ctx, thing.ThingType{
+			Name:          "strict-lamp",
+			DesiredSchema: map[string]any{"type": "object", "additionalProperties": false},
+		})
+		require.NoError(t, err)
+		strictId := fmt.Sprintf("for-strict-type-%d", time.Now().UnixNano())
+		_, err = thingSvc.Create(ctx, thing.Thing{Id: strictId, ThingType: "strict-lamp", Enabled: true, AuthValue: "secret-old"})
+		require.NoError(t, err)
+		_, err = thingSvc.RotateSecret(ctx, strictId, thing.RotateReq{Delivery: thing.DeliveryDesired})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+		require.ErrorContains(t, err, thing.DeliveryMethod)
+		_, err = thingSvc.Authenticate(ctx, strictId, "secret-old")
+		require.NoError(t, err, "should keep the current secret")
+	})
+}
+
 type lastDelta = struct {
 	ThingId     string
 	StateNotice shadow.DeltaStateNotice
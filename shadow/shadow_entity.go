@@ -105,6 +105,7 @@ type ConnStatusEntity struct {
 	DisconnectedAt   *time.Time
 	DisconnectReason string    `gorm:"default:'';"`
 	RemoteAddr       string    `gorm:"default:'';"`
+	Flapping         bool      `gorm:"not null;default:0"`
 	UpdatedAt        time.Time `gorm:"autoUpdateTime;not null;" json:"updatedAt"`
 }
 
@@ -2,6 +2,8 @@ package shadow
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"ruff.io/tio/connector"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type shadowRepo struct {
@@ -19,7 +22,8 @@ type shadowRepo struct {
 
 type EntityWithEnable struct {
 	Entity
-	Enabled bool
+	Enabled   bool
+	ThingType string
 }
 
 func (r shadowRepo) ExecWithTx(f func(txtRepo Repo) error) error {
@@ -104,6 +108,7 @@ func (r shadowRepo) UpdateConnStatus(ctx context.Context, s []connector.ClientIn
 					"connected":    1,
 					"connected_at": c.ConnectedAt,
 					"remote_addr":  c.RemoteAddr,
+					"flapping":     c.Flapping,
 				}
 			} else {
 				up = map[string]any{
@@ -111,6 +116,7 @@ func (r shadowRepo) UpdateConnStatus(ctx context.Context, s []connector.ClientIn
 					"disconnected_at":   c.DisconnectedAt,
 					"disconnect_reason": c.DisconnectReason,
 					"remote_addr":       c.RemoteAddr,
+					"flapping":          c.Flapping,
 				}
 			}
 			if err := ex.Updates(up).Error; err != nil {
@@ -130,10 +136,16 @@ func (r shadowRepo) UpdateAllConnStatusDisconnect(ctx context.Context, updateTim
 	return res.Error
 }
 
+func (r shadowRepo) ListConnected(ctx context.Context) ([]string, error) {
+	var l []string
+	err := r.db.WithContext(ctx).Model(&ConnStatusEntity{}).Where("connected=1").Pluck("thing_id", &l).Error
+	return l, err
+}
+
 func (r shadowRepo) Get(ctx context.Context, thingId string) (*ShadowWithEnable, error) {
 	e := EntityWithEnable{}
 	res := r.db.Model(&Entity{}).
-		Select("t.enabled", "shadow.*").
+		Select("t.enabled", "t.thing_type", "shadow.*").
 		Joins("LEFT JOIN thing t ON t.id=shadow.thing_id").
 		Where("shadow.thing_id=?", thingId).
 		First(&e)
@@ -177,7 +189,7 @@ func (r shadowRepo) Query(ctx context.Context, pq model.PageQuery, q ParsedQuery
 
 	db := r.db.WithContext(ctx).
 		Model(&Entity{}).
-		Select("t.enabled", "shadow.*").
+		Select("t.enabled", "t.thing_type", "shadow.*").
 		Joins("ConnStatus").
 		Preload("ConnStatus").
 		Joins("INNER JOIN thing t ON t.id=shadow.thing_id")
@@ -212,6 +224,65 @@ func (r shadowRepo) Query(ctx context.Context, pq model.PageQuery, q ParsedQuery
 	return page, err
 }
 
+func (r shadowRepo) Find(ctx context.Context, conds []clause.Expression, limit int) ([]ShadowWithStatus, error) {
+	db := r.db.WithContext(ctx).
+		Model(&Entity{}).
+		Select("t.enabled", "t.thing_type", "shadow.*").
+		Joins("ConnStatus").
+		Preload("ConnStatus").
+		Joins("INNER JOIN thing t ON t.id=shadow.thing_id")
+	for _, c := range conds {
+		db = db.Where(c)
+	}
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	var results []EntityWithEnable
+	if err := db.Order("shadow.thing_id").Find(&results).Error; err != nil {
+		return nil, errors.Wrap(err, "find shadows")
+	}
+	return toShadowWithStatus(results)
+}
+
+func (r shadowRepo) MatchConds(ctx context.Context, thingId string, conds []clause.Expression) ([]bool, error) {
+	res := make([]bool, len(conds))
+	if len(conds) == 0 {
+		return res, nil
+	}
+	cols := make([]string, len(conds))
+	vars := make([]any, len(conds))
+	for i, c := range conds {
+		cols[i] = fmt.Sprintf("CASE WHEN (?) THEN 1 ELSE 0 END AS m%d", i)
+		vars[i] = c
+	}
+	rows, err := r.db.WithContext(ctx).
+		Table("shadow").
+		Select(strings.Join(cols, ", "), vars...).
+		Joins("INNER JOIN thing t ON t.id = shadow.thing_id").
+		Joins("LEFT JOIN conn_status ON conn_status.thing_id = shadow.thing_id").
+		Where("shadow.thing_id = ?", thingId).
+		Rows()
+	if err != nil {
+		return nil, errors.Wrap(err, "match conditions")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return res, errors.Wrap(rows.Err(), "match conditions")
+	}
+	matched := make([]int64, len(conds))
+	dest := make([]any, len(conds))
+	for i := range matched {
+		dest[i] = &matched[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, errors.Wrap(err, "scan matched conditions")
+	}
+	for i, m := range matched {
+		res[i] = m == 1
+	}
+	return res, nil
+}
+
 func toShadowWithStatus(list []EntityWithEnable) ([]ShadowWithStatus, error) {
 	res := make([]ShadowWithStatus, len(list))
 	for i, v := range list {
@@ -222,11 +293,13 @@ func toShadowWithStatus(list []EntityWithEnable) ([]ShadowWithStatus, error) {
 			ss.Shadow = s
 		}
 		ss.Enabled = v.Enabled
+		ss.ThingType = v.ThingType
 		cs := v.ConnStatus
 		ss.Connected = &cs.Connected
 		ss.ConnectedAt = cs.ConnectedAt
 		ss.DisconnectedAt = cs.DisconnectedAt
 		ss.RemoteAddr = cs.RemoteAddr
+		ss.Flapping = cs.Flapping
 		res[i] = ss
 	}
 	return res, nil
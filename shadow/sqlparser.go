@@ -20,10 +20,26 @@ const (
 
 var (
 	validColumns = map[string]bool{idColumn: true, "createdAt": true, "updatedAt": true, "version": true,
-		"connected": true, "connectedAt": true, "disconnectedAt": true, "remoteAddr": true}
+		"connected": true, "connectedAt": true, "disconnectedAt": true, "remoteAddr": true, "thingType": true}
 	validJsonColumnPrefix = []string{"tags", "state.reported", "state.desired", "metadata"}
 	statusColumns         = map[string]bool{"connected": true, "connectedAt": true, "disconnectedAt": true, "remoteAddr": true}
 	intersectionColumns   = map[string]bool{"thingId": true, "updatedAt": true}
+	// columns of table `thing` which is joined as `t`
+	thingColumns = map[string]bool{"thingType": true}
+)
+
+// groupColumn is a pseudo column for thing group membership, which can only be used in where clause like:
+//
+//	thingGroup = 'g1'
+//	thingGroup in ('g1', 'g2')
+//	thingGroup != 'g1'
+//	thingGroup not in ('g1', 'g2')
+//
+// It's converted to a condition on thing id with a placeholder for the sub query of group members,
+// the placeholder node is replaced by the sub query when groups are resolved.
+const (
+	groupColumn            = "thingGroup"
+	groupPlaceholderPrefix = "__thing_group_"
 )
 
 type ParsedQuerySql struct {
@@ -32,6 +48,19 @@ type ParsedQuerySql struct {
 	OrderBy           string
 	OriginSelectAlias map[string]string
 	SelectStatusAlias map[string]string // status field and alias
+	GroupRefs         []GroupRef        // thing groups referenced in where clause
+
+	// where the parsed where clause, it's formatted again after groups are resolved
+	where sqlparser.Expr
+}
+
+// GroupRef thing groups referenced by a condition of groupColumn
+type GroupRef struct {
+	Placeholder string
+	Names       []string
+
+	// cond the condition of thing id whose right side is replaced by the sub query of group members
+	cond *sqlparser.ComparisonExpr
 }
 
 var regMatchJsonExtr = regexp.MustCompile("`(json_extract\\([^`]+\\))`")
@@ -51,6 +80,15 @@ func parseQuerySql(qrySql string) (ParsedQuerySql, error) {
 
 	err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
 		switch node := node.(type) {
+		case *sqlparser.ComparisonExpr:
+			if col, ok := node.Left.(*sqlparser.ColName); ok && col.Name.String() == groupColumn {
+				ref, err := toGroupCondition(node, len(res.GroupRefs))
+				if err != nil {
+					return false, err
+				}
+				res.GroupRefs = append(res.GroupRefs, ref)
+				return false, nil
+			}
 		case *sqlparser.ColName:
 			err := validColumn(node.Name.String())
 			if err != nil {
@@ -60,6 +98,9 @@ func parseQuerySql(qrySql string) (ParsedQuerySql, error) {
 			if ok := intersectionColumns[node.Name.String()]; ok {
 				node.Qualifier = sqlparser.TableName{Name: sqlparser.NewTableIdent("shadow")}
 			}
+			if ok := thingColumns[node.Name.String()]; ok {
+				node.Qualifier = sqlparser.TableName{Name: sqlparser.NewTableIdent("t")}
+			}
 			n := toDbCol(node.Name.String())
 			node.Name = sqlparser.NewColIdent(n)
 		case *sqlparser.AliasedExpr:
@@ -95,9 +136,8 @@ func parseQuerySql(qrySql string) (ParsedQuerySql, error) {
 	}
 
 	if selStmt.Where != nil {
-		res.Where = sqlNodeToString(selStmt.Where.Expr)
-		// remote char ` for json_extract
-		res.Where = trimJsonExtractSpecialChar(res.Where)
+		res.where = selStmt.Where.Expr
+		res.Where = formatSql(res.where)
 	}
 
 	if selStmt.OrderBy != nil {
@@ -112,6 +152,86 @@ func parseQuerySql(qrySql string) (ParsedQuerySql, error) {
 	return res, nil
 }
 
+// formatSql formats the node to sql of the database
+func formatSql(node sqlparser.SQLNode) string {
+	// remote char ` for json_extract
+	return trimJsonExtractSpecialChar(sqlNodeToString(node))
+}
+
+// parseWhere parses a where condition of shadow query, eg: `tags.region` = 'cn' and connected = true.
+// The condition should be a single expression without sub query, order by or limit.
+func parseWhere(cond string) (ParsedQuerySql, error) {
+	q := "select * from shadow where " + cond
+	stmt, err := sqlparser.Parse(q)
+	if err != nil {
+		return ParsedQuerySql{}, err
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || sel.Where == nil || sel.OrderBy != nil || sel.Limit != nil || sel.GroupBy != nil || sel.Having != nil {
+		return ParsedQuerySql{}, errors.New("query should be a single where condition")
+	}
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if _, ok := node.(*sqlparser.Subquery); ok {
+			return false, errors.New("sub query is not supported in where condition")
+		}
+		return true, nil
+	}, sel.Where)
+	if err != nil {
+		return ParsedQuerySql{}, err
+	}
+	return parseQuerySql(q)
+}
+
+// GroupsInCondition names of thing groups referenced by the where condition of shadow query
+func GroupsInCondition(cond string) ([]string, error) {
+	q, err := parseWhere(cond)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, ref := range q.GroupRefs {
+		res = append(res, ref.Names...)
+	}
+	return res, nil
+}
+
+// toGroupCondition converts condition of groupColumn to condition of thing id in placeholder
+func toGroupCondition(node *sqlparser.ComparisonExpr, idx int) (GroupRef, error) {
+	ref := GroupRef{Placeholder: fmt.Sprintf("%s%d", groupPlaceholderPrefix, idx), cond: node}
+	var vals sqlparser.ValTuple
+	switch node.Operator {
+	case sqlparser.EqualStr, sqlparser.NotEqualStr:
+		vals = sqlparser.ValTuple{node.Right}
+	case sqlparser.InStr, sqlparser.NotInStr:
+		t, ok := node.Right.(sqlparser.ValTuple)
+		if !ok {
+			return ref, fmt.Errorf("invalid values of %q", groupColumn)
+		}
+		vals = t
+	default:
+		return ref, fmt.Errorf("operator %q is not supported for %q", node.Operator, groupColumn)
+	}
+	for _, v := range vals {
+		sv, ok := v.(*sqlparser.SQLVal)
+		if !ok || sv.Type != sqlparser.StrVal {
+			return ref, fmt.Errorf("value of %q should be string", groupColumn)
+		}
+		ref.Names = append(ref.Names, string(sv.Val))
+	}
+
+	node.Left = &sqlparser.ColName{
+		Name:      sqlparser.NewColIdent("thing_id"),
+		Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent("shadow")},
+	}
+	if node.Operator == sqlparser.EqualStr || node.Operator == sqlparser.InStr {
+		node.Operator = sqlparser.InStr
+	} else {
+		node.Operator = sqlparser.NotInStr
+	}
+	node.Right = &sqlparser.ColName{Name: sqlparser.NewColIdent(ref.Placeholder)}
+	return ref, nil
+}
+
 // updateSelectFields
 // - Delete status field in select expressions, and return theirs name alia map.
 // - If there is no id column, add it.
@@ -65,6 +65,16 @@ func TestParseQuery(t *testing.T) {
 			where:   "shadow.thing_id = 'abc' and (json_extract(desired, '$.y') = 'xy' or json_extract(reported, '$.s') = 'qs')",
 			orderBy: "shadow.thing_id desc, created_at desc",
 		},
+		{
+			sql:   "select * from shadow where thingType = 'lamp'",
+			sel:   "*",
+			where: "t.thing_type = 'lamp'",
+		},
+		{
+			sql:   "select * from shadow where thingGroup in ('g1', 'g2') or thingGroup != 'g3'",
+			sel:   "*",
+			where: "shadow.thing_id in __thing_group_0 or shadow.thing_id not in __thing_group_1",
+		},
 		{
 			sql: "select * from shadow",
 			sel: "*",
@@ -24,10 +24,12 @@ type ShadowWithEnable struct {
 
 type ShadowWithStatus struct {
 	Enabled        bool       `json:"enabled"`
+	ThingType      string     `json:"thingType,omitempty"`
 	Connected      *bool      `json:"connected,omitempty"`
 	ConnectedAt    *time.Time `json:"connectedAt,omitempty"`
 	DisconnectedAt *time.Time `json:"disconnectedAt,omitempty"`
 	RemoteAddr     string     `json:"remoteAddr,omitempty"`
+	Flapping       bool       `json:"flapping,omitempty" description:"the thing connects and disconnects frequently"`
 	Shadow
 }
 
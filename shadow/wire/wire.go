@@ -10,7 +10,7 @@ import (
 	"github.com/google/wire"
 )
 
-func InitSvc(dbConn *gorm.DB, conn connector.Connectivity) shadow.Service {
+func InitSvc(dbConn *gorm.DB, conn connector.Connectivity, ss shadow.StateSchemaFunc, gd shadow.GroupDefFunc) shadow.Service {
 	wire.Build(
 		shadow.NewSvc,
 		shadow.NewShadowRepo,
@@ -18,10 +18,17 @@ import (
 )
 
 type CreateReq struct {
-	ThingId  string `json:"thingId"`
-	Password string `json:"password"`
+	ThingId   string `json:"thingId"`
+	Password  string `json:"password"`
+	ThingType string `json:"thingType" optional:"true" description:"type of the thing, it should be created before"`
+	AuthType  string `json:"authType" optional:"true" enum:"password|certs" description:"password by default, password is ignored for certs"`
+	ParentId  string `json:"parentId" optional:"true" description:"the gateway which the thing connects through"`
+
+	Attributes thing.Attributes `json:"attributes" optional:"true" description:"registry attributes, not versioned like shadow tags"`
+}
 
-	// AuthType string `json:"authType"`
+type ResetSecretReq struct {
+	Password string `json:"password" optional:"true" description:"new password, a random one is generated if it's empty"`
 }
 
 type InvalidCreate struct {
@@ -39,6 +46,9 @@ func (req CreateReq) validate() error {
 	if len(req.ThingId) > 64 {
 		return errors.New("thingId length must be less than 64")
 	}
+	if len(req.ThingType) > 64 {
+		return errors.New("thingType length must be less than 64")
+	}
 	if len(req.Password) > 64 {
 		return errors.New("password length must be less than 64")
 	}
@@ -72,8 +82,15 @@ func Service(ctx context.Context, svc thing.Service) *restful.WebService {
 		Doc("get all things").
 		Metadata(restfulspec.KeyOpenAPITags, tags).
 		Param(ws.QueryParameter("enabled", "whether thing is enabled").DataType("boolean")).
-		Param(ws.QueryParameter("withAuthValue", "whether return authValue field").DataType("boolean")).
+		Param(ws.QueryParameter("thingType", "type of things")).
 		Param(ws.QueryParameter("withStatus", "whether return fields of status").DataType("boolean")).
+		Param(ws.QueryParameter(thing.AttrSerialNumber, "filter by attribute, a value ending with * matches by prefix")).
+		Param(ws.QueryParameter(thing.AttrModel, "filter by attribute, a value ending with * matches by prefix")).
+		Param(ws.QueryParameter(thing.AttrOwner, "filter by attribute, a value ending with * matches by prefix")).
+		Param(ws.QueryParameter(thing.AttrLocation, "filter by attribute, a value ending with * matches by prefix")).
+		Param(ws.QueryParameter("search", "search text in thing id and attributes")).
+		Param(ws.QueryParameter("sort", "sort by id, createdAt, updatedAt or an attribute, prefixed with - for descending").
+			DefaultValue(thing.SortCreatedAt)).
 		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
 		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
 		Returns(200, "OK", rest.RespOK(thing.Page{})))
@@ -108,6 +125,32 @@ func Service(ctx context.Context, svc thing.Service) *restful.WebService {
 		Param(ws.PathParameter("id", "thing id")).
 		Returns(200, "OK", rest.RespOK("")))
 
+	ws.Route(ws.POST("/{id}/password").
+		To(ResetSecretHandler(ctx, svc)).
+		Operation("reset-password").
+		Doc("reset password of the thing").
+		Notes("A random password is generated if it's empty in request. "+
+			"The new password is returned only this time, and connections with the old password are closed.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Reads(ResetSecretReq{}).
+		Returns(200, "OK", rest.RespOK(thing.Thing{})))
+
+	ws.Route(ws.POST("/{id}/password/rotation").
+		To(RotateSecretHandler(ctx, svc)).
+		Operation("rotate-password").
+		Doc("rotate password of the thing").
+		Notes("The new password is delivered to the thing via direct method `"+thing.MethodRotateCredential+
+			"` or desired state `"+thing.DesiredCredentialKey+"`, with data like: "+
+			`{"password": "xxx", "expiresAt": "2024-01-02T15:04:05Z"}`+", expiresAt is when the current password expires.\n"+
+			"\nBoth passwords are valid during the overlap, the current one is revoked once the thing connects with the new one. "+
+			"The password delivered via desired state is removed when the current one is revoked or expires. "+
+			"The new password is returned only this time.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Reads(thing.RotateReq{}).
+		Returns(200, "OK", rest.RespOK(thing.Rotation{})))
+
 	ws.Route(ws.PATCH("/{id}").
 		To(UpdateHandler(ctx, svc)).
 		Operation("update-one").
@@ -116,10 +159,46 @@ func Service(ctx context.Context, svc thing.Service) *restful.WebService {
 		Reads(thing.ThingUpdate{}).
 		Returns(200, "OK", rest.RespOK("")))
 
+	ws.Route(ws.PUT("/{id}/parent").
+		To(SetParentHandler(ctx, svc)).
+		Operation("set-parent").
+		Doc("attach the thing to a gateway").
+		Notes("The gateway is authorized to publish and subscribe topics of the sub-device, "+
+			"and reports presence of it to `"+thing.TopicSubDevicePresenceTmpl+"`, with payload like: "+
+			`{"thingId": "xxx", "eventType": "connected"}`+". "+
+			"Sub-devices can't be gateways.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Reads(thing.SetParentReq{}).
+		Returns(200, "OK", rest.RespOK("")))
+
+	ws.Route(ws.DELETE("/{id}/parent").
+		To(func(r *restful.Request, w *restful.Response) {
+			err := svc.SetParent(ctx, r.PathParameter("id"), "")
+			sendResp(w, "", err)
+		}).
+		Operation("detach-parent").
+		Doc("detach the thing from its gateway").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	ws.Route(ws.GET("/{id}/children").
+		To(func(r *restful.Request, w *restful.Response) {
+			l, err := svc.Children(ctx, r.PathParameter("id"))
+			sendResp(w, l, err)
+		}).
+		Operation("list-children").
+		Doc("get sub-devices of the gateway").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("id", "thing id")).
+		Returns(200, "OK", rest.RespOK([]thing.Thing{})))
+
 	return ws
 }
 
-func ServiceForEmqxIntegration() *restful.WebService {
+// ServiceForEmqxIntegration serves topic acl checks, acl reports whether the thing can access the topic, write is true for publish
+func ServiceForEmqxIntegration(acl func(thingId string, topic string, write bool) bool) *restful.WebService {
 	ws := new(restful.WebService)
 	ws.
 		Path("/private/api/things").
@@ -144,7 +223,8 @@ func ServiceForEmqxIntegration() *restful.WebService {
 				_ = w.WriteHeaderAndJson(400, "", "'")
 				return
 			}
-			res := thing.TopicAcl(nil, thingId, topic, action == "subscribe")
+			// publish is the write access, the check before policies passed subscribe as write by mistake
+			res := acl(thingId, topic, action == "publish")
 			resTxt := "deny"
 			if res {
 				resTxt = "allow"
@@ -172,10 +252,13 @@ func CreateHandler(ctx context.Context, svc thing.Service) restful.RouteFunction
 		}
 
 		th := thing.Thing{
-			Id:        cReq.ThingId,
-			Enabled:   true,
-			AuthType:  thing.AuthTypePassword,
-			AuthValue: cReq.Password,
+			Id:         cReq.ThingId,
+			ThingType:  cReq.ThingType,
+			ParentId:   cReq.ParentId,
+			Enabled:    true,
+			AuthType:   cReq.AuthType,
+			AuthValue:  cReq.Password,
+			Attributes: cReq.Attributes,
 		}
 		rTh, err := svc.Create(ctx, th)
 		if err != nil {
@@ -210,6 +293,77 @@ func UpdateHandler(ctx context.Context, svc thing.Service) restful.RouteFunction
 	}
 }
 
+func ResetSecretHandler(ctx context.Context, svc thing.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("id")
+		var req ResetSecretReq
+		if r.Request.ContentLength != 0 {
+			if err := r.ReadEntity(&req); err != nil {
+				log.Infof("Error decoding body for reset password: %v", err)
+				_ = w.WriteHeaderAndEntity(400, rest.Resp[string]{Code: 400, Message: err.Error()})
+				return
+			}
+		}
+		if err := (CreateReq{Password: req.Password}).validate(); err != nil {
+			_ = w.WriteHeaderAndEntity(400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		th, err := svc.ResetSecret(ctx, id, req.Password)
+		if err != nil {
+			sent := checkHttpErrAndSend(err, w)
+			if !sent {
+				rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+			}
+		} else {
+			rest.SendRespOK(w, th)
+		}
+	}
+}
+
+func RotateSecretHandler(ctx context.Context, svc thing.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		id := r.PathParameter("id")
+		var req thing.RotateReq
+		if r.Request.ContentLength != 0 {
+			if err := r.ReadEntity(&req); err != nil {
+				log.Infof("Error decoding body for rotate password: %v", err)
+				_ = w.WriteHeaderAndEntity(400, rest.Resp[string]{Code: 400, Message: err.Error()})
+				return
+			}
+		}
+		if err := (CreateReq{Password: req.Password}).validate(); err != nil {
+			_ = w.WriteHeaderAndEntity(400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		res, err := svc.RotateSecret(ctx, id, req)
+		if err != nil {
+			sent := checkHttpErrAndSend(err, w)
+			if !sent {
+				rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+			}
+		} else {
+			rest.SendRespOK(w, res)
+		}
+	}
+}
+
+func SetParentHandler(ctx context.Context, svc thing.Service) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req thing.SetParentReq
+		if err := r.ReadEntity(&req); err != nil {
+			log.Infof("Error decoding body for set parent: %v", err)
+			_ = w.WriteHeaderAndEntity(400, rest.Resp[string]{Code: 400, Message: err.Error()})
+			return
+		}
+		if req.ParentId == "" {
+			_ = w.WriteHeaderAndEntity(400, rest.Resp[string]{Code: 400, Message: "parentId is required"})
+			return
+		}
+		err := svc.SetParent(ctx, r.PathParameter("id"), req.ParentId)
+		sendResp(w, "", err)
+	}
+}
+
 func CreateBatchHandler(ctx context.Context, svc thing.Service) restful.RouteFunction {
 	return func(r *restful.Request, w *restful.Response) {
 		var resp CreateBatchResp
@@ -237,10 +391,13 @@ func CreateBatchHandler(ctx context.Context, svc thing.Service) restful.RouteFun
 			}
 
 			th := thing.Thing{
-				Id:        req.ThingId,
-				Enabled:   true,
-				AuthType:  thing.AuthTypePassword,
-				AuthValue: req.Password,
+				Id:         req.ThingId,
+				ThingType:  req.ThingType,
+				ParentId:   req.ParentId,
+				Enabled:    true,
+				AuthType:   req.AuthType,
+				AuthValue:  req.Password,
+				Attributes: req.Attributes,
 			}
 			rTh, err := svc.Create(ctx, th)
 			if err != nil {
@@ -273,20 +430,30 @@ func QueryHandler(ctx context.Context, svc thing.Service) restful.RouteFunction
 func getPgQry(r *restful.Request) thing.PageQuery {
 	var err error
 	q := thing.PageQuery{}
-	q.WithAuthValue, _ = strconv.ParseBool(r.QueryParameter("withAuthValue"))
 	q.WithStatus, _ = strconv.ParseBool(r.QueryParameter("withStatus"))
+	q.ThingType = r.QueryParameter("thingType")
+	for _, n := range thing.AttrNames {
+		if v, ok := r.Request.URL.Query()[n]; ok && len(v) > 0 {
+			if q.AttrFilters == nil {
+				q.AttrFilters = map[string]string{}
+			}
+			q.AttrFilters[n] = v[0]
+		}
+	}
+	q.Search = r.QueryParameter("search")
+	q.Sort = r.QueryParameter("sort")
 	if e, err := strconv.ParseBool(r.QueryParameter("enabled")); err == nil {
 		q.Enabled = &e
 	}
 	q.PageQuery.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex"))
 	if err != nil {
 		q.PageIndex = 1
-		log.Infof("No valid query param withAuthValue use default value %d", q.PageIndex)
+		log.Infof("No valid query param pageIndex use default value %d", q.PageIndex)
 	}
 	q.PageQuery.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize"))
 	if err != nil {
 		q.PageSize = 10
-		log.Infof("No valid query param withAuthValue use default value %d", q.PageSize)
+		log.Infof("No valid query param pageSize use default value %d", q.PageSize)
 	}
 	return q
 }
@@ -333,3 +500,13 @@ func checkHttpErrAndSend(err error, w http.ResponseWriter) bool {
 	}
 	return false
 }
+
+func sendResp[T any](w *restful.Response, data T, err error) {
+	if err != nil {
+		if !checkHttpErrAndSend(err, w) {
+			rest.SendResp(w, 500, rest.Resp[string]{Code: 500, Message: err.Error()})
+		}
+		return
+	}
+	rest.SendRespOK(w, data)
+}
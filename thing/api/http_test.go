@@ -52,7 +52,7 @@ func newServer() *httptest.Server {
 	conn := mock.NewSqliteConnTest()
 	_ = conn.AutoMigrate(&thing.Entity{}, &shadow.Entity{}, &shadow.ConnStatusEntity{})
 	repo := thing.NewThingRepo(conn)
-	svc := thing.NewSvc(repo, uuid.New(), mkSs, connector)
+	svc := thing.NewSvc(repo, uuid.New(), mkSs, connector, nil, nil)
 
 	apiSvc := api.Service(context.Background(), svc)
 	container := restful.NewContainer()
@@ -161,7 +161,7 @@ func TestCreateBatchHandler(t *testing.T) {
 	svr := newServer()
 	defer svr.Close()
 
-	vaildThing := api.CreateReq{"some-id-xxx", "password"}
+	vaildThing := api.CreateReq{ThingId: "some-id-xxx", Password: "password"}
 	noPasswordThing := api.CreateReq{ThingId: "noPasswordThing"}
 
 	doReq := func(r []api.CreateReq) (*http.Response, error) {
@@ -237,7 +237,7 @@ func TestQueryHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 
 		//query thing
-		req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/things?withAuthValue=true&pageIndex=1&pageSize=10", svr.URL), toBuf(nil))
+		req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/things?pageIndex=1&pageSize=10", svr.URL), toBuf(nil))
 		req.Header.Set("Content-Type", "application/json")
 		resp, err = client.Do(req)
 		require.NoError(t, err)
@@ -319,3 +319,26 @@ func TestDeleteHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, resD2.Code)
 	})
 }
+
+func TestServiceForEmqxIntegration(t *testing.T) {
+	t.Parallel()
+	container := restful.NewContainer()
+	container.ServeMux = http.NewServeMux()
+	container.Add(api.ServiceForEmqxIntegration(func(thingId string, topic string, write bool) bool {
+		// only subscription is allowed
+		return !write
+	}))
+	svr := httptest.NewServer(container)
+	defer svr.Close()
+
+	aclOf := func(action string) string {
+		resp, err := svr.Client().Get(fmt.Sprintf("%s/private/api/things/th-1/topicAcl?topic=a/b&action=%s", svr.URL, action))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var res map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res["result"]
+	}
+	require.Equal(t, "deny", aclOf("publish"), "publish should be checked as write")
+	require.Equal(t, "allow", aclOf("subscribe"))
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"strconv"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	rest "ruff.io/tio/pkg/restapi"
+	"ruff.io/tio/thing"
+)
+
+type GroupThingsReq struct {
+	Things []string `json:"things"`
+}
+
+// ServiceForThingGroup thing group api, and route for groups of a thing is added to thingWs
+func ServiceForThingGroup(ctx context.Context, svc thing.GroupService, thingWs *restful.WebService) *restful.WebService {
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/thingGroups").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	tags := []string{"thingGroups"}
+
+	ws.Route(ws.GET("/").
+		To(QueryGroupHandler(ctx, svc)).
+		Operation("query-thing-groups").
+		Doc("get thing groups").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(thing.GroupPage{})))
+
+	ws.Route(ws.POST("/").
+		To(CreateGroupHandler(ctx, svc)).
+		Operation("create-thing-group").
+		Doc("create thing group").
+		Notes("Things are added to static group explicitly, "+
+			"things of dynamic group are those matching the query, which is the where condition of shadow query, "+
+			"like: `tags.region` = 'cn' and thingType = 'lamp'").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(thing.Group{}).
+		Returns(200, "OK", rest.RespOK(thing.Group{})))
+
+	ws.Route(ws.GET("/{group}").
+		To(GetGroupHandler(ctx, svc)).
+		Operation("get-thing-group").
+		Doc("get thing group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("group", "thing group name")).
+		Returns(200, "OK", rest.RespOK(thing.Group{})))
+
+	ws.Route(ws.PATCH("/{group}").
+		To(UpdateGroupHandler(ctx, svc)).
+		Operation("update-thing-group").
+		Doc("update thing group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("group", "thing group name")).
+		Reads(thing.GroupUpdate{}).
+		Returns(200, "OK", rest.RespOK(thing.Group{})))
+
+	ws.Route(ws.DELETE("/{group}").
+		To(DeleteGroupHandler(ctx, svc)).
+		Operation("delete-thing-group").
+		Doc("delete thing group, refused with 409 while it is referenced by continuous jobs, acl policies or other dynamic groups").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("group", "thing group name")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	ws.Route(ws.GET("/{group}/things").
+		To(ListGroupThingsHandler(ctx, svc)).
+		Operation("list-thing-group-things").
+		Doc("get id of things in the group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("group", "thing group name")).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(thing.GroupThingsPage{})))
+
+	ws.Route(ws.POST("/{group}/things").
+		To(AddGroupThingsHandler(ctx, svc)).
+		Operation("add-thing-group-things").
+		Doc("add things to the static group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("group", "thing group name")).
+		Reads(GroupThingsReq{}).
+		Returns(200, "OK", rest.RespOK("")))
+
+	ws.Route(ws.DELETE("/{group}/things/{id}").
+		To(RemoveGroupThingHandler(ctx, svc)).
+		Operation("remove-thing-group-thing").
+		Doc("remove the thing from the static group").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("group", "thing group name")).
+		Param(ws.PathParameter("id", "thing id")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	thingWs.Route(thingWs.GET("/{id}/groups").
+		To(GroupsOfThingHandler(ctx, svc)).
+		Operation("get-groups-of-thing").
+		Doc("get names of groups the thing is in").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(thingWs.PathParameter("id", "thing id")).
+		Returns(200, "OK", rest.RespOK([]string{})))
+
+	return ws
+}
+
+func QueryGroupHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		p, err := svc.Query(ctx, pageQueryOf(r))
+		sendGroupResp(p, err, w)
+	}
+}
+
+func CreateGroupHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req thing.Group
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		g, err := svc.Create(ctx, req)
+		sendGroupResp(g, err, w)
+	}
+}
+
+func GetGroupHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		g, err := svc.Get(ctx, r.PathParameter("group"))
+		sendGroupResp(g, err, w)
+	}
+}
+
+func UpdateGroupHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req thing.GroupUpdate
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		g, err := svc.Update(ctx, r.PathParameter("group"), req)
+		sendGroupResp(g, err, w)
+	}
+}
+
+func DeleteGroupHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		err := svc.Delete(ctx, r.PathParameter("group"))
+		sendGroupResp("", err, w)
+	}
+}
+
+func ListGroupThingsHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		p, err := svc.ListThings(ctx, r.PathParameter("group"), pageQueryOf(r))
+		sendGroupResp(p, err, w)
+	}
+}
+
+func AddGroupThingsHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req GroupThingsReq
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		err := svc.AddThings(ctx, r.PathParameter("group"), req.Things)
+		sendGroupResp("", err, w)
+	}
+}
+
+func RemoveGroupThingHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		err := svc.RemoveThings(ctx, r.PathParameter("group"), []string{r.PathParameter("id")})
+		sendGroupResp("", err, w)
+	}
+}
+
+func GroupsOfThingHandler(ctx context.Context, svc thing.GroupService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		l, err := svc.GroupsOfThing(ctx, r.PathParameter("id"))
+		sendGroupResp(l, err, w)
+	}
+}
+
+func sendGroupResp(d any, err error, w *restful.Response) {
+	if err != nil {
+		if !checkHttpErrAndSend(err, w) {
+			log.Errorf("Thing group api error: %v", err)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+		}
+		return
+	}
+	rest.SendRespOK(w, d)
+}
+
+func pageQueryOf(r *restful.Request) model.PageQuery {
+	var err error
+	pq := model.PageQuery{}
+	if pq.PageIndex, err = strconv.Atoi(r.QueryParameter("pageIndex")); err != nil || pq.PageIndex < 1 {
+		pq.PageIndex = 1
+	}
+	if pq.PageSize, err = strconv.Atoi(r.QueryParameter("pageSize")); err != nil || pq.PageSize < 1 {
+		pq.PageSize = 10
+	}
+	return pq
+}
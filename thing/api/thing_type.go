@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"ruff.io/tio/pkg/log"
+	rest "ruff.io/tio/pkg/restapi"
+	"ruff.io/tio/shadow"
+	"ruff.io/tio/thing"
+)
+
+type ThingTypeReq struct {
+	Name           string             `json:"name,omitempty" optional:"true" description:"required for creating, ignored for updating"`
+	Description    string             `json:"description"`
+	DefaultTags    map[string]any     `json:"defaultTags,omitempty" optional:"true" description:"tags of shadow for things created with the type"`
+	DesiredSchema  map[string]any     `json:"desiredSchema,omitempty" optional:"true" description:"JSON Schema of desired state"`
+	ReportedSchema map[string]any     `json:"reportedSchema,omitempty" optional:"true" description:"JSON Schema of reported state"`
+	Methods        []shadow.MethodDef `json:"methods,omitempty" optional:"true" description:"methods of the type, replace all methods of the type if present, field thingType of method is ignored"`
+}
+
+func (req ThingTypeReq) toThingType() thing.ThingType {
+	return thing.ThingType{
+		Name:           req.Name,
+		Description:    req.Description,
+		DefaultTags:    req.DefaultTags,
+		DesiredSchema:  req.DesiredSchema,
+		ReportedSchema: req.ReportedSchema,
+		Methods:        req.Methods,
+	}
+}
+
+// ServiceForThingType thing type api, the web service is shared with method definitions api
+func ServiceForThingType(ctx context.Context, svc thing.TypeService) *restful.WebService {
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/thingTypes").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	tags := []string{"thingTypes"}
+
+	ws.Route(ws.GET("/").
+		To(QueryTypeHandler(ctx, svc)).
+		Operation("query-thing-types").
+		Doc("get thing types").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.QueryParameter("pageIndex", "page index, from 1").DataType("integer").DefaultValue("1")).
+		Param(ws.QueryParameter("pageSize", "page size, from 1").DataType("integer").DefaultValue("10")).
+		Returns(200, "OK", rest.RespOK(thing.TypePage{})))
+
+	ws.Route(ws.POST("/").
+		To(CreateTypeHandler(ctx, svc)).
+		Operation("create-thing-type").
+		Doc("create thing type").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(ThingTypeReq{}).
+		Returns(200, "OK", rest.RespOK(thing.ThingType{})))
+
+	ws.Route(ws.GET("/{type}").
+		To(GetTypeHandler(ctx, svc)).
+		Operation("get-thing-type").
+		Doc("get thing type").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type name")).
+		Returns(200, "OK", rest.RespOK(thing.ThingType{})))
+
+	ws.Route(ws.PUT("/{type}").
+		To(UpdateTypeHandler(ctx, svc)).
+		Operation("update-thing-type").
+		Doc("update thing type").
+		Notes("Default tags only apply to things created after the update.").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type name")).
+		Reads(ThingTypeReq{}).
+		Returns(200, "OK", rest.RespOK(thing.ThingType{})))
+
+	ws.Route(ws.DELETE("/{type}").
+		To(DeleteTypeHandler(ctx, svc)).
+		Operation("delete-thing-type").
+		Doc("delete thing type and its methods, a type in use by things can't be deleted").
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Param(ws.PathParameter("type", "thing type name")).
+		Returns(200, "OK", rest.RespOK("")))
+
+	return ws
+}
+
+func QueryTypeHandler(ctx context.Context, svc thing.TypeService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		p, err := svc.Query(ctx, pageQueryOf(r))
+		if err != nil {
+			log.Errorf("Query thing types error: %v", err)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			return
+		}
+		rest.SendRespOK(w, p)
+	}
+}
+
+func CreateTypeHandler(ctx context.Context, svc thing.TypeService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req ThingTypeReq
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		tt, err := svc.Create(ctx, req.toThingType())
+		sendTypeResp(tt, err, w)
+	}
+}
+
+func UpdateTypeHandler(ctx context.Context, svc thing.TypeService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		var req ThingTypeReq
+		if err := r.ReadEntity(&req); err != nil {
+			rest.SendResp(w, 400, rest.Resp[any]{Code: 400, Message: err.Error()})
+			return
+		}
+		req.Name = r.PathParameter("type")
+		tt, err := svc.Update(ctx, req.toThingType())
+		sendTypeResp(tt, err, w)
+	}
+}
+
+func GetTypeHandler(ctx context.Context, svc thing.TypeService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		tt, err := svc.Get(ctx, r.PathParameter("type"))
+		sendTypeResp(tt, err, w)
+	}
+}
+
+func DeleteTypeHandler(ctx context.Context, svc thing.TypeService) restful.RouteFunction {
+	return func(r *restful.Request, w *restful.Response) {
+		if err := svc.Delete(ctx, r.PathParameter("type")); err != nil {
+			if !checkHttpErrAndSend(err, w) {
+				log.Errorf("Delete thing type error: %v", err)
+				rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+			}
+			return
+		}
+		rest.SendRespOK(w, "")
+	}
+}
+
+func sendTypeResp(tt thing.ThingType, err error, w *restful.Response) {
+	if err != nil {
+		if !checkHttpErrAndSend(err, w) {
+			log.Errorf("Thing type api error: %v", err)
+			rest.SendResp(w, 500, rest.Resp[any]{Code: 500, Message: err.Error()})
+		}
+		return
+	}
+	rest.SendRespOK(w, tt)
+}
@@ -0,0 +1,391 @@
+package thing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+// Credentials of password auth type are stored as salted bcrypt hashes,
+// the plaintext secret is only returned at creation, reset or rotation.
+//
+// During rotation, the thing holds a primary (new) and a secondary (previous) secret, both valid in the overlap.
+// The new secret is delivered to the thing via direct method or shadow desired state,
+// and the secondary one is revoked once the thing authenticates with the new one.
+// The secret delivered via desired state is removed when the secondary one is revoked or expires, or the secret is reset.
+// The expiry is persisted with the credential, the secret sweeper revokes expired secondary secrets
+// and removes their delivered secrets, even if tio restarted during the overlap.
+
+const (
+	DeliveryDesired = "desired"
+	DeliveryMethod  = "method"
+	DeliveryNone    = "none"
+
+	// DesiredCredentialKey key of the desired state delivering the rotated secret, it's removed after rotation
+	DesiredCredentialKey = "credential"
+	// MethodRotateCredential direct method delivering the rotated secret
+	MethodRotateCredential = "rotateCredential"
+
+	DefaultRotationOverlap = 24 * 3600      // seconds
+	MaxRotationOverlap     = 30 * 24 * 3600 // seconds
+
+	callerRotation = "rotation"
+
+	defaultSecretSweepInterval = time.Minute
+)
+
+// Credential hashes of secrets
+type Credential struct {
+	Primary            string
+	Secondary          string
+	SecondaryExpiresAt *time.Time
+}
+
+func (c Credential) secondaryValid(now time.Time) bool {
+	return c.Secondary != "" && c.SecondaryExpiresAt != nil && now.Before(*c.SecondaryExpiresAt)
+}
+
+type RotateReq struct {
+	Password string `json:"password" optional:"true" description:"new password, a random one is generated if it's empty"`
+	Overlap  int    `json:"overlap" optional:"true" description:"seconds the current password is still valid, 1 day by default"`
+	Delivery string `json:"delivery" optional:"true" enum:"method|desired|none" description:"how to deliver the new password to the thing, method by default"`
+}
+
+type Rotation struct {
+	ThingId            string    `json:"thingId"`
+	Password           string    `json:"password" description:"the new password, it's returned only this time"`
+	Delivery           string    `json:"delivery"`
+	SecondaryExpiresAt time.Time `json:"secondaryExpiresAt" description:"the current password is valid until the time"`
+}
+
+func (r *RotateReq) valid() error {
+	if r.Delivery == "" {
+		r.Delivery = DeliveryMethod
+	}
+	if r.Delivery != DeliveryDesired && r.Delivery != DeliveryMethod && r.Delivery != DeliveryNone {
+		return errors.WithMessagef(model.ErrInvalidParams, "delivery %q", r.Delivery)
+	}
+	if r.Overlap == 0 {
+		r.Overlap = DefaultRotationOverlap
+	}
+	if r.Overlap < 0 || r.Overlap > MaxRotationOverlap {
+		return errors.WithMessagef(model.ErrInvalidParams, "overlap should be in (0, %d] seconds", MaxRotationOverlap)
+	}
+	return nil
+}
+
+func (t *thingSvc) newSecret(secret string) (string, error) {
+	if secret != "" {
+		return secret, nil
+	}
+	s, err := t.idProvider.ID()
+	if err != nil {
+		return "", errors.Wrap(err, "secret generate")
+	}
+	return s, nil
+}
+
+func (t *thingSvc) Authenticate(ctx context.Context, id string, secret string) (*Thing, error) {
+	th, err := t.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if th.AuthType != AuthTypePassword {
+		return nil, errors.WithMessagef(model.ErrAuthentication, "auth type of thing is %q", th.AuthType)
+	}
+	c, err := t.repo.GetCredential(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case secretMatch(c.Primary, secret):
+		if c.Secondary != "" {
+			t.revokeSecondary(ctx, id, c)
+		}
+	case c.secondaryValid(time.Now()) && secretMatch(c.Secondary, secret):
+		// the thing hasn't got the rotated secret yet
+		log.Infof("Thing %q authenticated with the secondary secret, which expires at %s", id, c.SecondaryExpiresAt)
+	default:
+		return nil, errors.WithMessage(model.ErrAuthentication, "wrong secret")
+	}
+	return th, nil
+}
+
+// revokeSecondary when the thing has authenticated with the rotated secret
+func (t *thingSvc) revokeSecondary(ctx context.Context, id string, c Credential) {
+	if err := t.repo.UpdateCredential(ctx, id, Credential{Primary: c.Primary}); err != nil {
+		log.Errorf("Revoke secondary secret of thing %q error: %v", id, err)
+		return
+	}
+	log.Infof("Revoked secondary secret of thing %q", id)
+	removeDeliveredSecret(ctx, t.shadowSvc, id, "")
+}
+
+// removeDeliveredSecret from desired state, only the one of the rotation expiring at `expiresAt` if it's not empty
+func removeDeliveredSecret(ctx context.Context, ss shadow.Service, id string, expiresAt string) {
+	sd, err := ss.Get(ctx, id, shadow.GetOption{})
+	if err != nil {
+		log.Errorf("Get shadow of thing %q to remove delivered secret error: %v", id, err)
+		return
+	}
+	v, ok := sd.State.Desired[DesiredCredentialKey]
+	if !ok {
+		return
+	}
+	if m, isMap := v.(map[string]any); expiresAt != "" && isMap && m["expiresAt"] != expiresAt {
+		return
+	}
+	_, err = ss.SetDesired(ctx, id, shadow.StateReq{
+		ClientToken: fmt.Sprintf("%s-%d", callerRotation, time.Now().UnixNano()),
+		State:       shadow.StateDR{Desired: shadow.StateValue{DesiredCredentialKey: nil}},
+	})
+	if err != nil {
+		log.Errorf("Remove delivered secret from desired state of thing %q error: %v", id, err)
+	}
+}
+
+func (t *thingSvc) ResetSecret(ctx context.Context, id string, secret string) (Thing, error) {
+	th, err := t.Get(ctx, id)
+	if err != nil {
+		return Thing{}, err
+	}
+	if th.AuthType != AuthTypePassword {
+		return Thing{}, errors.WithMessagef(model.ErrInvalidParams, "auth type of thing is %q", th.AuthType)
+	}
+	if secret, err = t.newSecret(secret); err != nil {
+		return Thing{}, err
+	}
+	hash, err := hashSecret(secret)
+	if err != nil {
+		return Thing{}, err
+	}
+	// the secondary one of rotation in progress is revoked too
+	if err := t.repo.UpdateCredential(ctx, id, Credential{Primary: hash}); err != nil {
+		return Thing{}, err
+	}
+	// sessions authenticated by the old secret are not valid anymore
+	t.connector.Close(id)
+	removeDeliveredSecret(ctx, t.shadowSvc, id, "")
+	log.Infof("Reset secret of thing %q", id)
+	th.AuthValue = secret
+	th.SecondaryExpiresAt = nil
+	return *th, nil
+}
+
+func (t *thingSvc) RotateSecret(ctx context.Context, id string, req RotateReq) (Rotation, error) {
+	if err := req.valid(); err != nil {
+		return Rotation{}, err
+	}
+	if req.Delivery == DeliveryMethod && t.methodHandler == nil {
+		return Rotation{}, errors.WithMessage(model.ErrInvalidParams, "direct method is not available")
+	}
+	th, err := t.Get(ctx, id)
+	if err != nil {
+		return Rotation{}, err
+	}
+	if th.AuthType != AuthTypePassword {
+		return Rotation{}, errors.WithMessagef(model.ErrInvalidParams, "auth type of thing is %q", th.AuthType)
+	}
+	old, err := t.repo.GetCredential(ctx, id)
+	if err != nil {
+		return Rotation{}, err
+	}
+	now := time.Now()
+	if old.secondaryValid(now) {
+		return Rotation{}, errors.WithMessagef(model.ErrInvalidParams,
+			"rotation is in progress until %s, reset the password instead", old.SecondaryExpiresAt.Format(time.RFC3339))
+	}
+	secret, err := t.newSecret(req.Password)
+	if err != nil {
+		return Rotation{}, err
+	}
+	hash, err := hashSecret(secret)
+	if err != nil {
+		return Rotation{}, err
+	}
+	expiresAt := now.Add(time.Duration(req.Overlap) * time.Second)
+	c := Credential{Primary: hash, Secondary: old.Primary, SecondaryExpiresAt: &expiresAt}
+	if err := t.repo.UpdateCredential(ctx, id, c); err != nil {
+		return Rotation{}, err
+	}
+	if err := t.deliverSecret(ctx, id, req.Delivery, secret, expiresAt); err != nil {
+		// the thing can't get the new secret, keep the current one
+		if e := t.repo.UpdateCredential(ctx, id, old); e != nil {
+			log.Errorf("Restore credential of thing %q after delivery failure error: %v", id, e)
+		}
+		return Rotation{}, errors.WithMessage(err, "deliver new secret")
+	}
+	log.Infof("Rotated secret of thing %q, delivery=%s, the previous one expires at %s",
+		id, req.Delivery, expiresAt.Format(time.RFC3339))
+	return Rotation{
+		ThingId:            id,
+		Password:           secret,
+		Delivery:           req.Delivery,
+		SecondaryExpiresAt: expiresAt,
+	}, nil
+}
+
+func (t *thingSvc) deliverSecret(ctx context.Context, id, delivery, secret string, expiresAt time.Time) error {
+	exp := expiresAt.Format(time.RFC3339)
+	data := map[string]any{
+		"password": secret,
+		// the current password expires at
+		"expiresAt": exp,
+	}
+	clientToken := fmt.Sprintf("%s-%d", callerRotation, time.Now().UnixNano())
+	switch delivery {
+	case DeliveryDesired:
+		_, err := t.shadowSvc.SetDesired(ctx, id, shadow.StateReq{
+			ClientToken: clientToken,
+			State:       shadow.StateDR{Desired: shadow.StateValue{DesiredCredentialKey: data}},
+		})
+		if errors.Is(err, model.ErrInvalidParams) {
+			// eg. the desired schema of the thing type doesn't allow additional properties
+			return errors.WithMessagef(err, "desired state of the thing doesn't accept %q, deliver by %q instead",
+				DesiredCredentialKey, DeliveryMethod)
+		}
+		if err == nil {
+			// don't leave the secret in desired state if the thing never authenticates with it,
+			// the secret sweeper removes it if tio restarts before
+			time.AfterFunc(time.Until(expiresAt), func() {
+				removeDeliveredSecret(context.Background(), t.shadowSvc, id, exp)
+			})
+		}
+		return err
+	case DeliveryMethod:
+		resp, err := t.methodHandler.InvokeMethod(ctx, shadow.MethodReqMsg{
+			ThingId:   id,
+			Method:    MethodRotateCredential,
+			Caller:    callerRotation,
+			Sensitive: true,
+			Req:       shadow.MethodReq{ClientToken: clientToken, Data: data},
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Code != 200 && resp.Code != 0 {
+			return errors.Errorf("thing responded code %d: %s", resp.Code, resp.Message)
+		}
+	}
+	return nil
+}
+
+// SecretSweeper revokes expired secondary secrets and removes the secrets delivered via desired state of them
+type SecretSweeper interface {
+	// Start sweeping at once and then periodically until context done
+	Start(ctx context.Context)
+}
+
+type secretSweeper struct {
+	repo      Repo
+	shadowSvc shadow.Service
+	interval  time.Duration
+}
+
+// NewSecretSweeper the interval is 1 minute by default
+func NewSecretSweeper(r Repo, ss shadow.Service, interval time.Duration) SecretSweeper {
+	if interval <= 0 {
+		interval = defaultSecretSweepInterval
+	}
+	return &secretSweeper{repo: r, shadowSvc: ss, interval: interval}
+}
+
+func (s *secretSweeper) Start(ctx context.Context) {
+	go func() {
+		s.sweep(ctx, time.Now())
+		tick := time.NewTicker(s.interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-tick.C:
+				s.sweep(ctx, now)
+			}
+		}
+	}()
+}
+
+func (s *secretSweeper) sweep(ctx context.Context, now time.Time) {
+	ids, err := s.repo.ListSecondaryExpired(ctx, now)
+	if err != nil {
+		log.Errorf("Secret sweeper list things of expired secondary secret error: %v", err)
+		return
+	}
+	for _, id := range ids {
+		c, err := s.repo.GetCredential(ctx, id)
+		if err != nil {
+			log.Errorf("Secret sweeper get credential of thing %q error: %v", id, err)
+			continue
+		}
+		// rotated again after listed
+		if c.Secondary == "" || c.SecondaryExpiresAt == nil || c.SecondaryExpiresAt.After(now) {
+			continue
+		}
+		if err := s.repo.UpdateCredential(ctx, id, Credential{Primary: c.Primary}); err != nil {
+			log.Errorf("Secret sweeper revoke expired secondary secret of thing %q error: %v", id, err)
+			continue
+		}
+		log.Infof("Secret sweeper revoked expired secondary secret of thing %q", id)
+		removeDeliveredSecret(ctx, s.shadowSvc, id, "")
+	}
+}
+
+func hashSecret(secret string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.Wrap(err, "hash secret")
+	}
+	return string(h), nil
+}
+
+func secretMatch(hash, secret string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+}
+
+func isHashed(v string) bool {
+	_, err := bcrypt.Cost([]byte(v))
+	return err == nil
+}
+
+// MigrateAuthValue hashes plaintext secrets stored before credentials were hashed.
+// It's idempotent, values already hashed are skipped.
+func MigrateAuthValue(db *gorm.DB) error {
+	var rows []Entity
+	err := db.Model(&Entity{}).
+		Select("id", "auth_value").
+		Where("auth_type = ? AND auth_value <> ''", AuthTypePassword).
+		Find(&rows).Error
+	if err != nil {
+		return errors.Wrap(err, "query thing auth values")
+	}
+	n := 0
+	for _, r := range rows {
+		if isHashed(r.AuthValue) {
+			continue
+		}
+		h, err := hashSecret(r.AuthValue)
+		if err != nil {
+			return err
+		}
+		err = db.Model(&Entity{}).Where("id = ?", r.Id).UpdateColumn("auth_value", h).Error
+		if err != nil {
+			return errors.Wrapf(err, "update auth value of thing %q", r.Id)
+		}
+		n++
+	}
+	if n > 0 {
+		log.Infof("Migrated plaintext auth value of %d things to hash", n)
+	}
+	return nil
+}
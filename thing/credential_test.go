@@ -0,0 +1,102 @@
+package thing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+	"ruff.io/tio/thing"
+)
+
+func TestThingSvc_RotateSecretByDesired(t *testing.T) {
+	svc, sdSvc := NewTestSvcWithShadow()
+	th, err := svc.Create(ctxTest, thing.Thing{Id: "rotate-by-desired", AuthValue: "secret-old"})
+	require.NoError(t, err)
+
+	r, err := svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Password: "secret-new", Overlap: 60, Delivery: thing.DeliveryDesired})
+	require.NoError(t, err)
+	require.Equal(t, "secret-new", r.Password)
+	require.Equal(t, thing.DeliveryDesired, r.Delivery)
+
+	sd, err := sdSvc.Get(ctxTest, th.Id, shadow.GetOption{})
+	require.NoError(t, err)
+	require.Equal(t, "secret-new", sd.State.Desired[thing.DesiredCredentialKey].(map[string]any)["password"])
+
+	// both are valid in overlap
+	_, err = svc.Authenticate(ctxTest, th.Id, "secret-old")
+	require.NoError(t, err)
+	_, err = svc.Authenticate(ctxTest, th.Id, "secret-new")
+	require.NoError(t, err)
+
+	// the old one is revoked after authenticated with the new one
+	_, err = svc.Authenticate(ctxTest, th.Id, "secret-old")
+	require.ErrorIs(t, err, model.ErrAuthentication)
+	got, err := svc.Get(ctxTest, th.Id)
+	require.NoError(t, err)
+	require.Nil(t, got.SecondaryExpiresAt)
+	sd, err = sdSvc.Get(ctxTest, th.Id, shadow.GetOption{})
+	require.NoError(t, err)
+	require.NotContains(t, sd.State.Desired, thing.DesiredCredentialKey, "delivered secret should be removed")
+
+	t.Run("removed on reset", func(t *testing.T) {
+		_, err := svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Overlap: 60, Delivery: thing.DeliveryDesired})
+		require.NoError(t, err)
+		connector.On("Close", th.Id).Return(nil).Once()
+		_, err = svc.ResetSecret(ctxTest, th.Id, "secret-reset")
+		require.NoError(t, err)
+		sd, err := sdSvc.Get(ctxTest, th.Id, shadow.GetOption{})
+		require.NoError(t, err)
+		require.NotContains(t, sd.State.Desired, thing.DesiredCredentialKey)
+	})
+
+	t.Run("removed on expiry", func(t *testing.T) {
+		_, err := svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Overlap: 1, Delivery: thing.DeliveryDesired})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			sd, err := sdSvc.Get(ctxTest, th.Id, shadow.GetOption{})
+			require.NoError(t, err)
+			_, ok := sd.State.Desired[thing.DesiredCredentialKey]
+			return !ok
+		}, 2*time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestSecretSweeper(t *testing.T) {
+	svc, sdSvc := NewTestSvcWithShadow()
+	th, err := svc.Create(ctxTest, thing.Thing{Id: "sweep-secret", AuthValue: "secret-old"})
+	require.NoError(t, err)
+	_, err = svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Overlap: 60, Delivery: thing.DeliveryDesired})
+	require.NoError(t, err)
+
+	// expired while tio was down
+	repo := thing.NewThingRepo(shadowTestDb)
+	c, err := repo.GetCredential(ctxTest, th.Id)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Second)
+	c.SecondaryExpiresAt = &expired
+	require.NoError(t, repo.UpdateCredential(ctxTest, th.Id, c))
+
+	ctx, cancel := context.WithCancel(ctxTest)
+	defer cancel()
+	thing.NewSecretSweeper(repo, sdSvc, time.Hour).Start(ctx)
+	require.Eventually(t, func() bool {
+		sd, err := sdSvc.Get(ctxTest, th.Id, shadow.GetOption{})
+		require.NoError(t, err)
+		_, ok := sd.State.Desired[thing.DesiredCredentialKey]
+		return !ok
+	}, time.Second, 20*time.Millisecond, "delivered secret should be removed at start")
+	c, err = repo.GetCredential(ctxTest, th.Id)
+	require.NoError(t, err)
+	require.Empty(t, c.Secondary, "expired secondary secret should be revoked")
+}
+
+func TestThingRepo_GetCredential(t *testing.T) {
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(thing.Entity{}))
+	_, err := thing.NewThingRepo(db).GetCredential(ctxTest, "not-exist")
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
@@ -24,28 +24,58 @@ type Service interface {
 	Query(ctx context.Context, pq PageQuery) (Page, error)
 	Get(ctx context.Context, id string) (*Thing, error)
 	Exist(ctx context.Context, id string) (bool, error)
+
+	// Authenticate checks the secret of the thing, model.ErrAuthentication if it mismatches
+	Authenticate(ctx context.Context, id string, secret string) (*Thing, error)
+	// ResetSecret replaces the secret of the thing, a random one is generated if it's empty.
+	// The returned thing carries the new secret, which can't be got anymore later.
+	ResetSecret(ctx context.Context, id string, secret string) (Thing, error)
+	// RotateSecret sets a new secret as primary, and keeps the current one as secondary during the overlap.
+	// The secondary one is revoked once the thing authenticates with the new one.
+	RotateSecret(ctx context.Context, id string, req RotateReq) (Rotation, error)
+
+	// SetParent attaches the thing to the gateway, or detaches it if parentId is empty
+	SetParent(ctx context.Context, id string, parentId string) error
+	// Children gets sub-devices of the gateway
+	Children(ctx context.Context, id string) ([]Thing, error)
+	Topology
 }
 
+// DeleteHook cleans up data related to the deleted thing, it's called after a thing is deleted, errors are only logged
+type DeleteHook func(ctx context.Context, thingId string) error
+
 type Page = model.PageData[ThingWithStatus]
 
 type PageQuery struct {
-	Enabled       *bool `json:"enabled"`
-	WithAuthValue bool  `json:"withAuthValue"`
-	WithStatus    bool  `json:"withStatus"`
+	Enabled    *bool  `json:"enabled"`
+	ThingType  string `json:"thingType"`
+	WithStatus bool   `json:"withStatus"`
+	// AttrFilters filters by attributes keyed by name, a value ending with * matches by prefix
+	AttrFilters map[string]string `json:"attrFilters"`
+	// Search text in id and attributes
+	Search string `json:"search"`
+	// Sort by id, createdAt, updatedAt or an attribute, prefixed with - for descending, createdAt by default
+	Sort string `json:"sort"`
 	model.PageQuery
 }
 
 type thingSvc struct {
-	repo       Repo
-	idProvider tio.IdProvider
-	shadowSvc  shadow.Service
-	connector  connector.Connectivity
+	repo          Repo
+	idProvider    tio.IdProvider
+	shadowSvc     shadow.Service
+	connector     connector.Connectivity
+	methodHandler shadow.MethodHandler
+	topo          topology
+	deleteHooks   []DeleteHook
 }
 
 var _ Service = (*thingSvc)(nil)
 
-func NewSvc(repo Repo, idProvider tio.IdProvider, ss shadow.Service, connector connector.Connectivity) Service {
-	return &thingSvc{repo: repo, idProvider: idProvider, shadowSvc: ss, connector: connector}
+// NewSvc mh delivers rotated secrets by direct method, it may be nil if only other deliveries are used
+func NewSvc(repo Repo, idProvider tio.IdProvider, ss shadow.Service, connector connector.Connectivity,
+	mh shadow.MethodHandler, deleteHooks []DeleteHook) Service {
+	return &thingSvc{repo: repo, idProvider: idProvider, shadowSvc: ss, connector: connector,
+		methodHandler: mh, deleteHooks: deleteHooks}
 }
 
 func (t *thingSvc) Create(ctx context.Context, th Thing) (Thing, error) {
@@ -68,25 +98,53 @@ func (t *thingSvc) Create(ctx context.Context, th Thing) (Thing, error) {
 			return Thing{}, model.ErrDuplicated
 		}
 	}
+	if th.ThingType != "" && !IdValid(th.ThingType) {
+		return Thing{}, errors.WithMessagef(model.ErrInvalidParams, "thing type %q", th.ThingType)
+	}
+	if err := th.Attributes.valid(); err != nil {
+		return Thing{}, err
+	}
+	if th.ParentId != "" {
+		if err := t.checkParent(ctx, th.Id, th.ParentId); err != nil {
+			return Thing{}, err
+		}
+	}
 	if th.AuthType == "" {
 		th.AuthType = AuthTypePassword
 	}
-	if th.AuthType == AuthTypePassword && th.AuthValue == "" {
-		s, err := t.idProvider.ID()
-		if err != nil {
-			return Thing{}, errors.Wrap(err, "secret generate")
+	if th.AuthType != AuthTypePassword && th.AuthType != AuthTypeCerts {
+		return Thing{}, errors.WithMessagef(model.ErrInvalidParams, "auth type %q", th.AuthType)
+	}
+	if th.AuthType == AuthTypeCerts {
+		// authenticated by certificates registered to the thing, or signed by registered CA
+		th.AuthValue = ""
+	}
+	var secret string
+	if th.AuthType == AuthTypePassword {
+		var err error
+		if secret, err = t.newSecret(th.AuthValue); err != nil {
+			return Thing{}, err
+		}
+		if th.AuthValue, err = hashSecret(secret); err != nil {
+			return Thing{}, err
 		}
-		th.AuthValue = s
 	}
 	res, err := t.repo.Create(ctx, th)
 	if err != nil {
 		return Thing{}, err
 	}
+	res.AuthValue = secret
+	t.cacheParent(res.Id, res.ParentId)
 
 	return res, err
 }
 
 func (t *thingSvc) Update(ctx context.Context, id string, tu ThingUpdate) error {
+	if tu.Attributes != nil {
+		if err := tu.Attributes.valid(); err != nil {
+			return err
+		}
+	}
 	if ok, err := t.repo.Exist(ctx, id); err != nil {
 		return err
 	} else if !ok {
@@ -105,14 +163,23 @@ func (t *thingSvc) Delete(ctx context.Context, id string) error {
 	if err != nil {
 		return err
 	}
+	t.uncacheGateway(id)
 	err = t.connector.Remove(id)
 	if err != nil {
 		log.Errorf("Failed to close thing connector client, thingId=%q : %v", id, err)
 	}
+	for _, h := range t.deleteHooks {
+		if err := h(ctx, id); err != nil {
+			log.Errorf("Failed to clean up data of deleted thing, thingId=%q : %v", id, err)
+		}
+	}
 	return nil
 }
 
 func (t *thingSvc) Query(ctx context.Context, pq PageQuery) (Page, error) {
+	if err := pq.valid(); err != nil {
+		return Page{}, err
+	}
 	p, err := t.repo.Query(ctx, pq)
 	if err != nil {
 		return Page{}, err
@@ -138,6 +205,7 @@ func (t *thingSvc) toPage(p model.PageData[Thing], withStatus bool) Page {
 			rpi.ConnectedAt = c.ConnectedAt
 			rpi.DisconnectedAt = c.DisconnectedAt
 			rpi.RemoteAddr = c.RemoteAddr
+			rpi.Flapping = c.Flapping
 		}
 	}
 	return rp
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"testing"
+	"time"
 
 	"ruff.io/tio/config"
 	"ruff.io/tio/db/mock"
@@ -12,6 +13,8 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 	"ruff.io/tio/pkg/model"
 	"ruff.io/tio/pkg/testutil"
 	"ruff.io/tio/pkg/uuid"
@@ -27,16 +30,34 @@ var (
 
 var connector = shadowMock.NewConnectivity()
 
+// shadowTestDb is the db of the shadow service, which is a singleton bound to the db it's created with first
+var shadowTestDb *gorm.DB
+
 func NewTestSvc() (thing.Service, shadow.Service) {
 	db := mock.NewSqliteConnTest()
 	_ = db.AutoMigrate(thing.Entity{}, shadow.Entity{}, &shadow.ConnStatusEntity{})
-	shadowSvc := shadowWire.InitSvc(db, connector)
-	thingSvc := wire.InitSvc(context.Background(), db, shadowSvc, connector)
+	return newTestSvc(db, nil, nil)
+}
+
+// NewTestSvcWithShadow returns services on the db of the shadow service, for tests checking shadows of things
+func NewTestSvcWithShadow() (thing.Service, shadow.Service) {
+	if shadowTestDb == nil {
+		return NewTestSvc()
+	}
+	return newTestSvc(shadowTestDb, nil, nil)
+}
+
+func newTestSvc(db *gorm.DB, mh shadow.MethodHandler, deleteHooks []thing.DeleteHook) (thing.Service, shadow.Service) {
+	shadowSvc := shadowWire.InitSvc(db, connector, nil, nil)
+	if shadowTestDb == nil {
+		shadowTestDb = db
+	}
+	thingSvc := wire.InitSvc(context.Background(), db, shadowSvc, connector, mh, deleteHooks)
 	return thingSvc, shadowSvc
 }
 
 func TestThingSvc_Create(t *testing.T) {
-	svc, sdSvc := NewTestSvc()
+	svc, sdSvc := NewTestSvcWithShadow()
 	th := thing.Thing{}
 	t.Run("create thing with no id", func(t *testing.T) {
 		th.Id = ""
@@ -110,7 +131,7 @@ func TestThingSvc_Update(t *testing.T) {
 }
 
 func TestThingSvc_Delete(t *testing.T) {
-	svc, sdSvc := NewTestSvc()
+	svc, sdSvc := NewTestSvcWithShadow()
 	randId, _ := uuid.New().ID()
 
 	connector.On("Close", randId).Return(nil).Twice()
@@ -119,9 +140,15 @@ func TestThingSvc_Delete(t *testing.T) {
 	err := svc.Delete(ctxTest, randId)
 	require.NoError(t, err, "should no error when not found")
 
+	var hooked []string
+	svc, _ = newTestSvc(shadowTestDb, nil, []thing.DeleteHook{func(ctx context.Context, thingId string) error {
+		hooked = append(hooked, thingId)
+		return nil
+	}})
 	_, _ = svc.Create(ctxTest, thing.Thing{Id: randId})
 	err = svc.Delete(ctxTest, randId)
 	require.NoError(t, err)
+	require.Equal(t, []string{randId}, hooked, "delete hook should be called")
 	_, err = sdSvc.Get(ctxTest, randId, shadow.GetOption{WithStatus: false})
 	require.Error(t, err, "shadow should get not found error when thing is deleted")
 	if herr, ok := err.(model.HttpErr); ok {
@@ -143,13 +170,65 @@ func TestThingSvc_Get(t *testing.T) {
 	_, _ = svc.Create(ctxTest, thing.Thing{Id: randId})
 
 	pq := thing.PageQuery{
-		WithAuthValue: true,
-		PageQuery:     model.PageQuery{PageIndex: 0, PageSize: 10},
+		PageQuery: model.PageQuery{PageIndex: 0, PageSize: 10},
 	}
 	page, err := svc.Query(ctxTest, pq)
 	require.NoError(t, err)
 	require.LessOrEqual(t, int64(1), page.Total, "query page total count")
 	require.LessOrEqual(t, 1, len(page.Content), "query page content count")
+	for _, th := range page.Content {
+		require.Empty(t, th.AuthValue, "secret should not be returned by query")
+	}
+}
+
+func TestThingSvc_Secret(t *testing.T) {
+	svc, _ := NewTestSvc()
+	th, err := svc.Create(ctxTest, thing.Thing{AuthValue: "secret-1"})
+	require.NoError(t, err)
+	require.Equal(t, "secret-1", th.AuthValue, "secret should be shown at creation")
+
+	got, err := svc.Get(ctxTest, th.Id)
+	require.NoError(t, err)
+	require.Empty(t, got.AuthValue, "secret should not be shown after creation")
+
+	_, err = svc.Authenticate(ctxTest, th.Id, "secret-1")
+	require.NoError(t, err)
+	_, err = svc.Authenticate(ctxTest, th.Id, "secret-2")
+	require.ErrorIs(t, err, model.ErrAuthentication)
+	_, err = svc.Authenticate(ctxTest, "not-exist-thing", "secret-1")
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	t.Run("reset secret", func(t *testing.T) {
+		closeCall := connector.On("Close", th.Id).Return(nil).Once()
+		defer closeCall.Unset()
+		res, err := svc.ResetSecret(ctxTest, th.Id, "")
+		require.NoError(t, err)
+		require.NotEmpty(t, res.AuthValue, "new secret should be generated")
+		connector.AssertExpectations(t)
+
+		_, err = svc.Authenticate(ctxTest, th.Id, "secret-1")
+		require.ErrorIs(t, err, model.ErrAuthentication, "old secret should be invalid")
+		_, err = svc.Authenticate(ctxTest, th.Id, res.AuthValue)
+		require.NoError(t, err)
+	})
+}
+
+func TestMigrateAuthValue(t *testing.T) {
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(thing.Entity{}))
+	require.NoError(t, db.Create(&thing.Entity{Id: "plain", AuthType: thing.AuthTypePassword, AuthValue: "pwd"}).Error)
+
+	require.NoError(t, thing.MigrateAuthValue(db))
+	var en thing.Entity
+	require.NoError(t, db.Take(&en, "id = ?", "plain").Error)
+	require.NotEqual(t, "pwd", en.AuthValue, "secret should be hashed")
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(en.AuthValue), []byte("pwd")))
+
+	// migrate again should keep the hash
+	require.NoError(t, thing.MigrateAuthValue(db))
+	var en2 thing.Entity
+	require.NoError(t, db.Take(&en2, "id = ?", "plain").Error)
+	require.Equal(t, en.AuthValue, en2.AuthValue)
 }
 
 func TestIdValid(t *testing.T) {
@@ -203,3 +282,51 @@ func TestTopicAcl(t *testing.T) {
 	}
 
 }
+
+type fakeMethodHandler struct {
+	shadow.MethodHandler
+	req  shadow.MethodReqMsg
+	resp shadow.MethodResp
+}
+
+func (f *fakeMethodHandler) InvokeMethod(ctx context.Context, req shadow.MethodReqMsg) (shadow.MethodResp, error) {
+	f.req = req
+	return f.resp, nil
+}
+
+func TestThingSvc_RotateSecret(t *testing.T) {
+	mh := &fakeMethodHandler{}
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(thing.Entity{}, shadow.Entity{}, &shadow.ConnStatusEntity{}))
+	svc, _ := newTestSvc(db, mh, nil)
+	th, err := svc.Create(ctxTest, thing.Thing{AuthValue: "secret-old"})
+	require.NoError(t, err)
+
+	_, err = svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Delivery: "mail"})
+	require.ErrorIs(t, err, model.ErrInvalidParams)
+
+	t.Run("deliver via method", func(t *testing.T) {
+		mh.resp = shadow.MethodResp{Code: 500, Message: "failed"}
+		_, err := svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Delivery: thing.DeliveryMethod})
+		require.Error(t, err)
+		_, err = svc.Authenticate(ctxTest, th.Id, "secret-old")
+		require.NoError(t, err, "should keep the current secret when delivery failed")
+
+		mh.resp = shadow.MethodResp{Code: 200}
+		r, err := svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Delivery: thing.DeliveryMethod, Overlap: 1})
+		require.NoError(t, err)
+		require.Equal(t, thing.MethodRotateCredential, mh.req.Method)
+		require.True(t, mh.req.Sensitive)
+		require.Equal(t, r.Password, mh.req.Req.Data.(map[string]any)["password"])
+
+		t.Run("secondary expired", func(t *testing.T) {
+			_, err := svc.RotateSecret(ctxTest, th.Id, thing.RotateReq{Delivery: thing.DeliveryNone, Overlap: 1})
+			require.ErrorIs(t, err, model.ErrInvalidParams, "should refuse rotation in progress")
+			time.Sleep(time.Second + 100*time.Millisecond)
+			_, err = svc.Authenticate(ctxTest, th.Id, "secret-old")
+			require.ErrorIs(t, err, model.ErrAuthentication, "secondary should expire after overlap")
+			_, err = svc.Authenticate(ctxTest, th.Id, r.Password)
+			require.NoError(t, err)
+		})
+	})
+}
@@ -13,16 +13,24 @@ const (
 )
 
 type Thing struct {
-	Id        string    `json:"thingId"`
-	Enabled   bool      `json:"enabled"`
-	AuthType  string    `json:"authType"`
-	AuthValue string    `json:"authValue,omitempty" optional:"true"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	CreatedAt time.Time `json:"createdAt"`
+	Id        string `json:"thingId"`
+	ThingType string `json:"thingType,omitempty" optional:"true"`
+	ParentId  string `json:"parentId,omitempty" optional:"true" description:"the gateway which the thing connects through"`
+	Enabled   bool   `json:"enabled"`
+	AuthType  string `json:"authType"`
+	AuthValue string `json:"authValue,omitempty" optional:"true" description:"secret of the thing, only returned at creation or reset"`
+	// SecondaryExpiresAt the previous secret is valid until the time during rotation
+	SecondaryExpiresAt *time.Time `json:"secondaryExpiresAt,omitempty" optional:"true" description:"the previous password is still valid until the time, during rotation"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+	CreatedAt          time.Time  `json:"createdAt"`
+
+	// Attributes in registry, not versioned like shadow tags
+	Attributes Attributes `json:"attributes"`
 }
 
 type ThingUpdate struct {
-	Enabled *bool `json:"enabled"`
+	Enabled    *bool       `json:"enabled" optional:"true"`
+	Attributes *Attributes `json:"attributes" optional:"true" description:"replaces all attributes, kept if it's absent"`
 }
 
 type ThingWithStatus struct {
@@ -31,6 +39,7 @@ type ThingWithStatus struct {
 	ConnectedAt    *time.Time `json:"connectedAt,omitempty"`
 	DisconnectedAt *time.Time `json:"disconnectedAt,omitempty"`
 	RemoteAddr     string     `json:"remoteAddr,omitempty"`
+	Flapping       bool       `json:"flapping,omitempty" description:"the thing connects and disconnects frequently"`
 }
 
 type Repo interface {
@@ -40,4 +49,14 @@ type Repo interface {
 	Query(ctx context.Context, pq PageQuery) (model.PageData[Thing], error)
 	Get(ctx context.Context, id string) (*Thing, error)
 	Exist(ctx context.Context, id string) (bool, error)
+	// GetCredential gets hashes of the secrets, empty if the thing doesn't exist
+	GetCredential(ctx context.Context, id string) (Credential, error)
+	UpdateCredential(ctx context.Context, id string, c Credential) error
+	// ListSecondaryExpired lists id of things whose secondary secret expired before the time
+	ListSecondaryExpired(ctx context.Context, before time.Time) ([]string, error)
+	// SetParent sets the gateway of the thing, empty to detach it
+	SetParent(ctx context.Context, id string, parentId string) error
+	ListChildren(ctx context.Context, parentId string) ([]Thing, error)
+	// ListParents gets parents of all sub-devices, keyed by child id
+	ListParents(ctx context.Context) (map[string]string, error)
 }
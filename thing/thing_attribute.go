@@ -0,0 +1,99 @@
+package thing
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/model"
+)
+
+// Registry attributes of things.
+// Unlike shadow tags, they are not versioned and not synced to things, they are for searching things in registry.
+
+// attribute names, used as query parameters of filtering and sorting
+const (
+	AttrSerialNumber = "serialNumber"
+	AttrModel        = "model"
+	AttrOwner        = "owner"
+	AttrLocation     = "location"
+
+	MaxAttrLength = 128
+
+	// sort fields besides attributes
+	SortId        = "id"
+	SortCreatedAt = "createdAt"
+	SortUpdatedAt = "updatedAt"
+)
+
+type Attributes struct {
+	SerialNumber string `json:"serialNumber,omitempty" optional:"true"`
+	Model        string `json:"model,omitempty" optional:"true"`
+	Owner        string `json:"owner,omitempty" optional:"true"`
+	Location     string `json:"location,omitempty" optional:"true"`
+}
+
+// AttrNames names of attributes in order
+var AttrNames = []string{AttrSerialNumber, AttrModel, AttrOwner, AttrLocation}
+
+var attrColumns = map[string]string{
+	AttrSerialNumber: "serial_number",
+	AttrModel:        "model",
+	AttrOwner:        "owner",
+	AttrLocation:     "location",
+}
+
+var sortColumns = map[string]string{
+	SortId:           "id",
+	SortCreatedAt:    "created_at",
+	SortUpdatedAt:    "updated_at",
+	AttrSerialNumber: "serial_number",
+	AttrModel:        "model",
+	AttrOwner:        "owner",
+	AttrLocation:     "location",
+}
+
+func (a Attributes) valid() error {
+	for n, v := range map[string]string{
+		AttrSerialNumber: a.SerialNumber,
+		AttrModel:        a.Model,
+		AttrOwner:        a.Owner,
+		AttrLocation:     a.Location,
+	} {
+		if len(v) > MaxAttrLength {
+			return errors.WithMessagef(model.ErrInvalidParams, "length of attribute %s should be less than %d", n, MaxAttrLength)
+		}
+	}
+	return nil
+}
+
+func (pq PageQuery) valid() error {
+	for n := range pq.AttrFilters {
+		if _, ok := attrColumns[n]; !ok {
+			return errors.WithMessagef(model.ErrInvalidParams, "attribute %q", n)
+		}
+	}
+	if pq.Sort != "" {
+		if _, ok := sortColumns[strings.TrimPrefix(pq.Sort, "-")]; !ok {
+			return errors.WithMessagef(model.ErrInvalidParams, "sort %q", pq.Sort)
+		}
+	}
+	return nil
+}
+
+// orderBy gets order clause of the sort field, prefixed with - for descending, created time ascending by default
+func orderBy(sort string) string {
+	desc := strings.HasPrefix(sort, "-")
+	col, ok := sortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return "created_at ASC, id ASC"
+	}
+	if desc {
+		return col + " DESC, id DESC"
+	}
+	return col + " ASC, id ASC"
+}
+
+// likeEscape escapes wildcards of LIKE pattern with `!`, which is used as ESCAPE for portability across databases
+func likeEscape(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
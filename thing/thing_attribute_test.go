@@ -0,0 +1,94 @@
+package thing_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/thing"
+)
+
+func TestThingSvc_Attributes(t *testing.T) {
+	svc, _ := NewTestSvc()
+	things := []thing.Thing{
+		{Id: "attr-1", Attributes: thing.Attributes{SerialNumber: "SN-001", Model: "m1", Owner: "alice", Location: "room_1"}},
+		{Id: "attr-2", Attributes: thing.Attributes{SerialNumber: "SN-002", Model: "m1", Owner: "bob", Location: "room%2"}},
+		{Id: "attr-3", Attributes: thing.Attributes{SerialNumber: "XN-003", Model: "m2", Owner: "alice"}},
+	}
+	for _, th := range things {
+		th.Enabled = true
+		_, err := svc.Create(ctxTest, th)
+		require.NoError(t, err)
+	}
+	pg := model.PageQuery{PageIndex: 1, PageSize: 10}
+	ids := func(p thing.Page) []string {
+		res := make([]string, len(p.Content))
+		for i, c := range p.Content {
+			res[i] = c.Id
+		}
+		return res
+	}
+
+	t.Run("get attributes", func(t *testing.T) {
+		th, err := svc.Get(ctxTest, "attr-1")
+		require.NoError(t, err)
+		require.Equal(t, things[0].Attributes, th.Attributes)
+	})
+
+	t.Run("filter by attributes", func(t *testing.T) {
+		p, err := svc.Query(ctxTest, thing.PageQuery{AttrFilters: map[string]string{thing.AttrModel: "m1"}, PageQuery: pg})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), p.Total)
+		require.ElementsMatch(t, []string{"attr-1", "attr-2"}, ids(p))
+
+		p, err = svc.Query(ctxTest, thing.PageQuery{
+			AttrFilters: map[string]string{thing.AttrSerialNumber: "SN-*", thing.AttrOwner: "alice"},
+			PageQuery:   pg,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"attr-1"}, ids(p))
+
+		p, err = svc.Query(ctxTest, thing.PageQuery{AttrFilters: map[string]string{thing.AttrLocation: "room%*"}, PageQuery: pg})
+		require.NoError(t, err)
+		require.Equal(t, []string{"attr-2"}, ids(p), "wildcards in value should be matched literally")
+	})
+
+	t.Run("search", func(t *testing.T) {
+		p, err := svc.Query(ctxTest, thing.PageQuery{Search: "XN", PageQuery: pg})
+		require.NoError(t, err)
+		require.Equal(t, []string{"attr-3"}, ids(p))
+
+		p, err = svc.Query(ctxTest, thing.PageQuery{Search: "_", PageQuery: pg})
+		require.NoError(t, err)
+		require.Equal(t, []string{"attr-1"}, ids(p))
+	})
+
+	t.Run("sort", func(t *testing.T) {
+		p, err := svc.Query(ctxTest, thing.PageQuery{Sort: "-" + thing.AttrSerialNumber, PageQuery: pg})
+		require.NoError(t, err)
+		require.Equal(t, []string{"attr-3", "attr-2", "attr-1"}, ids(p))
+
+		p, err = svc.Query(ctxTest, thing.PageQuery{Sort: thing.SortId, PageQuery: pg})
+		require.NoError(t, err)
+		require.Equal(t, []string{"attr-1", "attr-2", "attr-3"}, ids(p))
+	})
+
+	t.Run("update attributes", func(t *testing.T) {
+		a := thing.Attributes{Model: "m3"}
+		require.NoError(t, svc.Update(ctxTest, "attr-1", thing.ThingUpdate{Attributes: &a}))
+		th, err := svc.Get(ctxTest, "attr-1")
+		require.NoError(t, err)
+		require.Equal(t, a, th.Attributes, "attributes should be replaced")
+		require.True(t, th.Enabled)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := svc.Query(ctxTest, thing.PageQuery{AttrFilters: map[string]string{"color": "red"}, PageQuery: pg})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+		_, err = svc.Query(ctxTest, thing.PageQuery{Sort: "authValue", PageQuery: pg})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+		_, err = svc.Create(ctxTest, thing.Thing{Attributes: thing.Attributes{Owner: strings.Repeat("a", thing.MaxAttrLength+1)}})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+}
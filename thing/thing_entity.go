@@ -6,11 +6,23 @@ import (
 
 type Entity struct {
 	Id        string `gorm:"primaryKey;size:64"`
+	ThingType string `gorm:"size:64;NOT NULL;default:'';index"`
+	// ParentId the gateway which the thing connects through, empty if it connects directly
+	ParentId  string `gorm:"size:64;NOT NULL;default:'';index"`
 	Enabled   bool
-	AuthType  string    `gorm:"size=50"`
-	AuthValue string    `gorm:"size=100"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	AuthType  string `gorm:"size=50"`
+	AuthValue string `gorm:"size=100"` // hash of the secret
+	// SecondaryAuthValue hash of the previous secret during rotation, valid until SecondaryExpiresAt
+	SecondaryAuthValue string `gorm:"size:100;NOT NULL;default:''"`
+	SecondaryExpiresAt *time.Time
+	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
+	CreatedAt          time.Time `gorm:"autoCreateTime"`
+
+	// registry attributes
+	SerialNumber string `gorm:"size:128;NOT NULL;default:'';index"`
+	Model        string `gorm:"size:128;NOT NULL;default:'';index"`
+	Owner        string `gorm:"size:128;NOT NULL;default:'';index"`
+	Location     string `gorm:"size:128;NOT NULL;default:'';index"`
 }
 
 func (t Entity) TableName() string {
@@ -20,19 +32,34 @@ func (t Entity) TableName() string {
 func ToEntity(th Thing) Entity {
 	return Entity{
 		Id:        th.Id,
+		ThingType: th.ThingType,
+		ParentId:  th.ParentId,
 		Enabled:   th.Enabled,
 		AuthType:  th.AuthType,
 		AuthValue: th.AuthValue,
+
+		SerialNumber: th.Attributes.SerialNumber,
+		Model:        th.Attributes.Model,
+		Owner:        th.Attributes.Owner,
+		Location:     th.Attributes.Location,
 	}
 }
 
 func ToThing(en Entity) Thing {
 	return Thing{
-		Id:        en.Id,
-		Enabled:   en.Enabled,
-		AuthType:  en.AuthType,
-		AuthValue: en.AuthValue,
-		UpdatedAt: en.UpdatedAt,
-		CreatedAt: en.CreatedAt,
+		Id:                 en.Id,
+		ThingType:          en.ThingType,
+		ParentId:           en.ParentId,
+		Enabled:            en.Enabled,
+		AuthType:           en.AuthType,
+		SecondaryExpiresAt: en.SecondaryExpiresAt,
+		UpdatedAt:          en.UpdatedAt,
+		CreatedAt:          en.CreatedAt,
+		Attributes: Attributes{
+			SerialNumber: en.SerialNumber,
+			Model:        en.Model,
+			Owner:        en.Owner,
+			Location:     en.Location,
+		},
 	}
 }
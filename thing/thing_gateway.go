@@ -0,0 +1,337 @@
+package thing
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/config"
+	"ruff.io/tio/connector"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+// Gateways hold the only connection for their sub-devices, and proxy topics of them.
+// A sub-device is attached to a gateway by its parent, the topology has one level only,
+// that is a gateway can't be a sub-device of another one.
+//
+// The gateway reports presence of sub-devices to topic `TopicSubDevicePresenceTmpl`,
+// and it's handled as if the sub-device connected to the broker directly.
+// All sub-devices reported online are disconnected when the gateway disconnects.
+
+const (
+	TopicSubDevicePresenceTmpl = shadow.TopicThingsPrefix + "{thingId}/subdevices/presence"
+	TopicSubDevicePresenceAll  = shadow.TopicThingsPrefix + "+/subdevices/presence"
+
+	disconnectReasonGateway = "gateway disconnected"
+
+	presenceQueueSize = 1024
+)
+
+// SubDevicePresence reported by gateway
+type SubDevicePresence struct {
+	ThingId   string `json:"thingId"`
+	EventType string `json:"eventType" enum:"connected|disconnected"`
+	// Timestamp in milliseconds, now if it's zero
+	Timestamp        int64  `json:"timestamp"`
+	DisconnectReason string `json:"disconnectReason,omitempty"`
+}
+
+type SetParentReq struct {
+	ParentId string `json:"parentId" description:"id of the gateway"`
+}
+
+func TopicSubDevicePresence(gatewayId string) string {
+	return strings.ReplaceAll(TopicSubDevicePresenceTmpl, "{thingId}", gatewayId)
+}
+
+// topology caches parents of sub-devices, for acl check which is too frequent to query database
+type topology struct {
+	mu      sync.RWMutex
+	loaded  bool
+	parents map[string]string
+}
+
+func (t *thingSvc) loadTopology(ctx context.Context) error {
+	t.topo.mu.Lock()
+	defer t.topo.mu.Unlock()
+	if t.topo.loaded {
+		return nil
+	}
+	m, err := t.repo.ListParents(ctx)
+	if err != nil {
+		return err
+	}
+	t.topo.parents = m
+	t.topo.loaded = true
+	return nil
+}
+
+func (t *thingSvc) ParentOf(ctx context.Context, id string) string {
+	if err := t.loadTopology(ctx); err != nil {
+		log.Errorf("Load topology of things error: %v", err)
+		return ""
+	}
+	t.topo.mu.RLock()
+	defer t.topo.mu.RUnlock()
+	return t.topo.parents[id]
+}
+
+func (t *thingSvc) cacheParent(id, parentId string) {
+	t.topo.mu.Lock()
+	defer t.topo.mu.Unlock()
+	if !t.topo.loaded {
+		return
+	}
+	if parentId == "" {
+		delete(t.topo.parents, id)
+	} else {
+		t.topo.parents[id] = parentId
+	}
+}
+
+// uncacheGateway removes the deleted thing and its children from cache
+func (t *thingSvc) uncacheGateway(id string) {
+	t.topo.mu.Lock()
+	defer t.topo.mu.Unlock()
+	delete(t.topo.parents, id)
+	for c, p := range t.topo.parents {
+		if p == id {
+			delete(t.topo.parents, c)
+		}
+	}
+}
+
+// checkParent checks that the thing can be attached to the parent
+func (t *thingSvc) checkParent(ctx context.Context, id, parentId string) error {
+	if parentId == id {
+		return errors.WithMessage(model.ErrInvalidParams, "thing can't be parent of itself")
+	}
+	p, err := t.Get(ctx, parentId)
+	if err != nil {
+		return errors.WithMessage(err, "parent")
+	}
+	if p.ParentId != "" {
+		return errors.WithMessagef(model.ErrInvalidParams, "parent %q is a sub-device of %q", parentId, p.ParentId)
+	}
+	children, err := t.repo.ListChildren(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return errors.WithMessagef(model.ErrInvalidParams, "thing %q is a gateway of %d sub-devices", id, len(children))
+	}
+	return nil
+}
+
+func (t *thingSvc) SetParent(ctx context.Context, id string, parentId string) error {
+	th, err := t.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if th.ParentId == parentId {
+		return nil
+	}
+	if parentId != "" {
+		if err := t.checkParent(ctx, id, parentId); err != nil {
+			return err
+		}
+	}
+	if err := t.repo.SetParent(ctx, id, parentId); err != nil {
+		return err
+	}
+	t.cacheParent(id, parentId)
+	log.Infof("Set parent of thing %q to %q, previous %q", id, parentId, th.ParentId)
+	return nil
+}
+
+func (t *thingSvc) Children(ctx context.Context, id string) ([]Thing, error) {
+	if ok, err := t.repo.Exist(ctx, id); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.WithMessagef(model.ErrNotFound, "thing %q", id)
+	}
+	return t.repo.ListChildren(ctx, id)
+}
+
+// Topology gets the gateway of sub-device
+type Topology interface {
+	// ParentOf gets id of the gateway, empty if the thing connects directly
+	ParentOf(ctx context.Context, id string) string
+}
+
+// GatewayTopicAcl is TopicAcl which also allows gateways to access topics of their sub-devices
+func GatewayTopicAcl(ctx context.Context, topo Topology, superUsers []config.UserPassword, thingId string, topic string, write bool) bool {
+	if TopicAcl(superUsers, thingId, topic, write) {
+		return true
+	}
+	if thingId == "" {
+		return false
+	}
+	childId, err := shadow.GetThingIdFromTopic(topic)
+	return err == nil && topo.ParentOf(ctx, childId) == thingId
+}
+
+type GatewayService interface {
+	// Start handling presence of sub-devices reported by gateways
+	Start(ctx context.Context) error
+}
+
+// presenceTask is presence of sub-devices reported by a gateway, or a presence event of a gateway
+type presenceTask struct {
+	msg connector.Message
+	evt connector.PresenceEvent
+}
+
+type gatewaySvc struct {
+	topo     Topology
+	conn     connector.Connector
+	reporter connector.PresenceReporter
+	// presence of sub-devices and gateways is handled in order by presenceWorker
+	queue chan presenceTask
+
+	mu sync.Mutex
+	// online sub-devices of gateways, gateway id -> set of sub-device ids
+	online map[string]map[string]struct{}
+}
+
+func NewGatewaySvc(topo Topology, conn connector.Connector) GatewayService {
+	s := &gatewaySvc{
+		topo:   topo,
+		conn:   conn,
+		queue:  make(chan presenceTask, presenceQueueSize),
+		online: make(map[string]map[string]struct{}),
+	}
+	s.reporter, _ = conn.(connector.PresenceReporter)
+	return s
+}
+
+func (s *gatewaySvc) Start(ctx context.Context) error {
+	if s.reporter == nil {
+		return errors.New("presence reporting is not supported by the connector")
+	}
+	err := s.conn.Subscribe(ctx, TopicSubDevicePresenceAll, 1, func(msg connector.Message) {
+		s.enqueue(presenceTask{msg: msg})
+	})
+	if err != nil {
+		return errors.Wrap(err, "subscribe sub-device presence topic")
+	}
+	go s.presenceLoop(ctx)
+	go s.presenceWorker(ctx)
+	log.Info("Gateway service started")
+	return nil
+}
+
+func (s *gatewaySvc) enqueue(t presenceTask) {
+	select {
+	case s.queue <- t:
+	default:
+		if t.msg != nil {
+			log.Warnf("Presence queue of gateway service is full, dropped sub-device presence of topic %q", t.msg.Topic())
+		} else {
+			log.Warnf("Presence queue of gateway service is full, dropped event %q of gateway %q", t.evt.EventType, t.evt.ThingId)
+		}
+	}
+}
+
+func (s *gatewaySvc) presenceWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-s.queue:
+			if t.msg != nil {
+				s.onPresence(ctx, t.msg)
+			} else {
+				s.onGatewayDisconnected(t.evt)
+			}
+		}
+	}
+}
+
+func (s *gatewaySvc) onPresence(ctx context.Context, msg connector.Message) {
+	gatewayId, err := shadow.GetThingIdFromTopic(msg.Topic())
+	if err != nil {
+		log.Errorf("Got wrong topic for sub-device presence: %v, topic=%q", err, msg.Topic())
+		return
+	}
+	var p SubDevicePresence
+	if err := json.Unmarshal(msg.Payload(), &p); err != nil {
+		log.Errorf("Invalid payload for sub-device presence: %s, topic=%q", msg.Payload(), msg.Topic())
+		return
+	}
+	if p.EventType != connector.EventConnected && p.EventType != connector.EventDisconnected {
+		log.Errorf("Invalid event type %q of sub-device presence, topic=%q", p.EventType, msg.Topic())
+		return
+	}
+	if s.topo.ParentOf(ctx, p.ThingId) != gatewayId {
+		log.Warnf("Ignored presence of thing %q reported by %q which is not its gateway", p.ThingId, gatewayId)
+		return
+	}
+	if p.Timestamp == 0 {
+		p.Timestamp = time.Now().UnixMilli()
+	}
+
+	s.mu.Lock()
+	children := s.online[gatewayId]
+	if p.EventType == connector.EventConnected {
+		if children == nil {
+			children = make(map[string]struct{})
+			s.online[gatewayId] = children
+		}
+		children[p.ThingId] = struct{}{}
+	} else {
+		delete(children, p.ThingId)
+	}
+	s.mu.Unlock()
+
+	s.report(gatewayId, p)
+}
+
+func (s *gatewaySvc) report(gatewayId string, p SubDevicePresence) {
+	evt := connector.PresenceEvent{
+		Timestamp:        p.Timestamp,
+		EventType:        p.EventType,
+		ThingId:          p.ThingId,
+		ClientId:         p.ThingId,
+		RemoteAddr:       "gateway:" + gatewayId,
+		DisconnectReason: p.DisconnectReason,
+	}
+	if err := s.reporter.ReportPresence(evt); err != nil {
+		log.Errorf("Report presence of sub-device %q error: %v", p.ThingId, err)
+	}
+}
+
+// presenceLoop queues disconnected events of gateways, to disconnect their sub-devices
+func (s *gatewaySvc) presenceLoop(ctx context.Context) {
+	ch := s.conn.OnConnect()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			if e.EventType == connector.EventDisconnected {
+				s.enqueue(presenceTask{evt: e})
+			}
+		}
+	}
+}
+
+func (s *gatewaySvc) onGatewayDisconnected(e connector.PresenceEvent) {
+	s.mu.Lock()
+	children := s.online[e.ThingId]
+	delete(s.online, e.ThingId)
+	s.mu.Unlock()
+	for id := range children {
+		s.report(e.ThingId, SubDevicePresence{
+			ThingId:          id,
+			EventType:        connector.EventDisconnected,
+			Timestamp:        e.Timestamp,
+			DisconnectReason: disconnectReasonGateway,
+		})
+	}
+}
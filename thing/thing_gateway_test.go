@@ -0,0 +1,150 @@
+package thing_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	tioconn "ruff.io/tio/connector"
+	mqMock "ruff.io/tio/connector/mqtt/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/thing"
+)
+
+func TestThingSvc_SetParent(t *testing.T) {
+	svc, _ := NewTestSvc()
+	for _, id := range []string{"gw-1", "gw-2", "sub-1"} {
+		_, err := svc.Create(ctxTest, thing.Thing{Id: id, Enabled: true})
+		require.NoError(t, err)
+	}
+	require.Empty(t, svc.ParentOf(ctxTest, "sub-1"))
+
+	require.NoError(t, svc.SetParent(ctxTest, "sub-1", "gw-1"))
+	require.Equal(t, "gw-1", svc.ParentOf(ctxTest, "sub-1"))
+	l, err := svc.Children(ctxTest, "gw-1")
+	require.NoError(t, err)
+	require.Len(t, l, 1)
+	require.Equal(t, "sub-1", l[0].Id)
+
+	th, err := svc.Create(ctxTest, thing.Thing{Id: "sub-2", Enabled: true, ParentId: "gw-1"})
+	require.NoError(t, err)
+	require.Equal(t, "gw-1", th.ParentId)
+	require.Equal(t, "gw-1", svc.ParentOf(ctxTest, "sub-2"))
+
+	t.Run("invalid topology", func(t *testing.T) {
+		err := svc.SetParent(ctxTest, "gw-1", "gw-1")
+		require.ErrorIs(t, err, model.ErrInvalidParams, "thing can't be parent of itself")
+		err = svc.SetParent(ctxTest, "gw-2", "sub-1")
+		require.ErrorIs(t, err, model.ErrInvalidParams, "sub-device can't be gateway")
+		err = svc.SetParent(ctxTest, "gw-1", "gw-2")
+		require.ErrorIs(t, err, model.ErrInvalidParams, "gateway can't be sub-device")
+		err = svc.SetParent(ctxTest, "gw-2", "not-exist")
+		require.ErrorIs(t, err, model.ErrNotFound)
+	})
+
+	require.NoError(t, svc.SetParent(ctxTest, "sub-1", ""))
+	require.Empty(t, svc.ParentOf(ctxTest, "sub-1"))
+
+	t.Run("delete gateway", func(t *testing.T) {
+		connector.On("Close", "gw-1").Return(nil)
+		connector.On("Remove", "gw-1").Return(nil)
+		require.NoError(t, svc.Delete(ctxTest, "gw-1"))
+		require.Empty(t, svc.ParentOf(ctxTest, "sub-2"))
+		th, err := svc.Get(ctxTest, "sub-2")
+		require.NoError(t, err)
+		require.Empty(t, th.ParentId, "sub-device should be detached")
+	})
+}
+
+type topo map[string]string
+
+func (t topo) ParentOf(ctx context.Context, id string) string {
+	return t[id]
+}
+
+func TestGatewayTopicAcl(t *testing.T) {
+	tp := topo{"sub-1": "gw-1"}
+	require.True(t, thing.GatewayTopicAcl(ctxTest, tp, nil, "gw-1", "$iothub/things/gw-1/shadows/name/default/update", true))
+	require.True(t, thing.GatewayTopicAcl(ctxTest, tp, nil, "gw-1", "$iothub/things/sub-1/shadows/name/default/update", true))
+	require.True(t, thing.GatewayTopicAcl(ctxTest, tp, nil, "gw-1", "$iothub/user/things/sub-1/custom", false))
+	require.False(t, thing.GatewayTopicAcl(ctxTest, tp, nil, "gw-1", "$iothub/things/sub-2/shadows/name/default/update", true))
+	require.False(t, thing.GatewayTopicAcl(ctxTest, tp, nil, "sub-1", "$iothub/things/gw-1/shadows/name/default/update", true))
+	require.False(t, thing.GatewayTopicAcl(ctxTest, tp, nil, "", "$iothub/things/sub-2/shadows/name/default/update", true))
+}
+
+type gatewayConn struct {
+	*mqMock.AdapterImpl
+	onConn chan tioconn.PresenceEvent
+
+	mu     sync.Mutex
+	events []tioconn.PresenceEvent
+}
+
+func (c *gatewayConn) OnConnect() <-chan tioconn.PresenceEvent {
+	return c.onConn
+}
+
+func (c *gatewayConn) ReportPresence(evt tioconn.PresenceEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, evt)
+	return nil
+}
+
+func (c *gatewayConn) reported() []tioconn.PresenceEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]tioconn.PresenceEvent{}, c.events...)
+}
+
+func TestGatewaySvc_Presence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockMqtt := mqMock.NewMqttClient("", nil, nil)
+	mockMqtt.On("Subscribe", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockMqtt.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mqMock.NewMockToken())
+	adapter := mqMock.NewAdapter(mockMqtt)
+	conn := &gatewayConn{AdapterImpl: &adapter, onConn: make(chan tioconn.PresenceEvent)}
+
+	svc := thing.NewGatewaySvc(topo{"sub-1": "gw-1", "sub-2": "gw-1"}, conn)
+	require.NoError(t, svc.Start(ctx))
+
+	report := func(gw string, p thing.SubDevicePresence) {
+		b, _ := json.Marshal(p)
+		require.NoError(t, conn.Publish(thing.TopicSubDevicePresence(gw), 1, false, b))
+	}
+	report("gw-1", thing.SubDevicePresence{ThingId: "sub-1", EventType: tioconn.EventConnected})
+	report("gw-1", thing.SubDevicePresence{ThingId: "sub-2", EventType: tioconn.EventConnected})
+	report("gw-2", thing.SubDevicePresence{ThingId: "sub-1", EventType: tioconn.EventDisconnected})
+	require.Eventually(t, func() bool { return len(conn.reported()) == 2 }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+	require.Len(t, conn.reported(), 2, "presence reported by other gateway should be ignored")
+	for _, e := range conn.reported() {
+		require.Equal(t, tioconn.EventConnected, e.EventType)
+		require.NotZero(t, e.Timestamp)
+	}
+
+	conn.onConn <- tioconn.PresenceEvent{ThingId: "gw-1", EventType: tioconn.EventDisconnected, Timestamp: time.Now().UnixMilli()}
+	require.Eventually(t, func() bool { return len(conn.reported()) == 4 }, time.Second, time.Millisecond*10,
+		"sub-devices should be disconnected with the gateway")
+	for _, e := range conn.reported()[2:] {
+		require.Equal(t, tioconn.EventDisconnected, e.EventType)
+	}
+
+	for i := 0; i < 20; i++ {
+		report("gw-1", thing.SubDevicePresence{ThingId: "sub-1", EventType: tioconn.EventConnected})
+		report("gw-1", thing.SubDevicePresence{ThingId: "sub-1", EventType: tioconn.EventDisconnected})
+	}
+	require.Eventually(t, func() bool { return len(conn.reported()) == 44 }, time.Second, time.Millisecond*10)
+	for i, e := range conn.reported()[4:] {
+		want := tioconn.EventConnected
+		if i%2 == 1 {
+			want = tioconn.EventDisconnected
+		}
+		require.Equal(t, want, e.EventType, "presence should be reported in order")
+	}
+}
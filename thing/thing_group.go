@@ -0,0 +1,467 @@
+package thing
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+// Thing group, a named set of things.
+// Members of a static group are added and removed explicitly,
+// members of a dynamic group are things matching the shadow query condition of the group.
+// A thing can be in multiple groups, and group membership can be queried by `thingGroup` in shadow query.
+
+const (
+	GroupTypeStatic  = "STATIC"
+	GroupTypeDynamic = "DYNAMIC"
+
+	// MaxGroupThingsPerCall max things added to or removed from a static group in one call
+	MaxGroupThingsPerCall = 1000
+)
+
+type Group struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type" enum:"STATIC|DYNAMIC"`
+	// Query condition of shadow query for dynamic group, eg: `tags.region` = 'cn' and thingType = 'lamp'
+	Query     string    `json:"query,omitempty" optional:"true" description:"where condition of shadow query, only for dynamic group"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GroupUpdate struct {
+	Description *string `json:"description" optional:"true"`
+	Query       *string `json:"query" optional:"true" description:"only for dynamic group"`
+}
+
+type GroupPage = model.PageData[Group]
+
+type GroupThingsPage = model.PageData[string]
+
+type GroupService interface {
+	Create(ctx context.Context, g Group) (Group, error)
+	Update(ctx context.Context, name string, gu GroupUpdate) (Group, error)
+	// Delete the group, members of static group are removed together.
+	// It's refused while the group is referenced by other dynamic groups or GroupRefFunc.
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (Group, error)
+	Query(ctx context.Context, pq model.PageQuery) (GroupPage, error)
+
+	// AddThings adds things to the static group
+	AddThings(ctx context.Context, name string, thingIds []string) error
+	// RemoveThings removes things from the static group
+	RemoveThings(ctx context.Context, name string, thingIds []string) error
+	// ListThings lists id of things in the group, ordered by thing id
+	ListThings(ctx context.Context, name string, pq model.PageQuery) (GroupThingsPage, error)
+	// GroupsOfThing lists names of groups the thing is in
+	GroupsOfThing(ctx context.Context, thingId string) ([]string, error)
+	// RemoveFromGroups removes the thing from all static groups, it's called when the thing is deleted
+	RemoveFromGroups(ctx context.Context, thingId string) error
+}
+
+// GroupChangeHook is called with name of the changed group,
+// after members or the query of the group are changed, or the group is deleted
+type GroupChangeHook func(ctx context.Context, group string)
+
+// GroupRefFunc returns descriptions of what reference the group, eg: `job "j1"`.
+// A group can't be deleted while it's referenced.
+type GroupRefFunc func(ctx context.Context, group string) ([]string, error)
+
+type GroupRepo interface {
+	Create(ctx context.Context, e *GroupEntity) error
+	Update(ctx context.Context, name string, m map[string]any) error
+	// Delete the group and its members
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (*GroupEntity, error)
+	List(ctx context.Context, names []string) ([]GroupEntity, error)
+	ListByType(ctx context.Context, typ string) ([]GroupEntity, error)
+	Query(ctx context.Context, pq model.PageQuery) (model.PageData[GroupEntity], error)
+
+	AddMembers(ctx context.Context, name string, thingIds []string) error
+	RemoveMembers(ctx context.Context, name string, thingIds []string) error
+	GroupsOfMember(ctx context.Context, thingId string) ([]string, error)
+	// RemoveFromAll removes the thing from all static groups
+	RemoveFromAll(ctx context.Context, thingId string) error
+	// ExistThings returns things exist among the given ones
+	ExistThings(ctx context.Context, thingIds []string) ([]string, error)
+}
+
+type GroupEntity struct {
+	Name        string `gorm:"primaryKey;size:64"`
+	Description string `gorm:"size:512;NOT NULL;default:''"`
+	Type        string `gorm:"size:16;NOT NULL"`
+	Query       string `gorm:"size:2048;NOT NULL;default:''"`
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (GroupEntity) TableName() string {
+	return "thing_group"
+}
+
+// GroupMemberEntity member of static group
+type GroupMemberEntity struct {
+	GroupName string    `gorm:"primaryKey;size:64"`
+	ThingId   string    `gorm:"primaryKey;size:64;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (GroupMemberEntity) TableName() string {
+	return "thing_group_member"
+}
+
+func toGroup(e GroupEntity) Group {
+	return Group{
+		Name:        e.Name,
+		Description: e.Description,
+		Type:        e.Type,
+		Query:       e.Query,
+		UpdatedAt:   e.UpdatedAt,
+		CreatedAt:   e.CreatedAt,
+	}
+}
+
+func (g Group) valid() error {
+	if !IdValid(g.Name) || len(g.Name) > 64 {
+		return errors.WithMessagef(model.ErrInvalidParams, "thing group name %q", g.Name)
+	}
+	if len(g.Description) > 512 {
+		return errors.WithMessage(model.ErrInvalidParams, "description length should be less than 512")
+	}
+	switch g.Type {
+	case GroupTypeStatic:
+		if g.Query != "" {
+			return errors.WithMessage(model.ErrInvalidParams, "static group can't have query")
+		}
+	case GroupTypeDynamic:
+		if strings.TrimSpace(g.Query) == "" {
+			return errors.WithMessage(model.ErrInvalidParams, "query of dynamic group can't be empty")
+		}
+		if len(g.Query) > 2048 {
+			return errors.WithMessage(model.ErrInvalidParams, "query length should be less than 2048")
+		}
+	default:
+		return errors.WithMessagef(model.ErrInvalidParams, "thing group type %q", g.Type)
+	}
+	return nil
+}
+
+// service implement
+
+type groupSvc struct {
+	repo        GroupRepo
+	shadowSvc   shadow.Service
+	changeHooks []GroupChangeHook
+	refFuncs    []GroupRefFunc
+}
+
+var _ GroupService = (*groupSvc)(nil)
+
+func NewGroupSvc(r GroupRepo, ss shadow.Service, changeHooks []GroupChangeHook, refFuncs []GroupRefFunc) GroupService {
+	return &groupSvc{repo: r, shadowSvc: ss, changeHooks: changeHooks, refFuncs: refFuncs}
+}
+
+func (s *groupSvc) Create(ctx context.Context, g Group) (Group, error) {
+	if err := g.valid(); err != nil {
+		return Group{}, err
+	}
+	if old, err := s.repo.Get(ctx, g.Name); err != nil {
+		return Group{}, err
+	} else if old != nil {
+		return Group{}, errors.WithMessagef(model.ErrDuplicated, "thing group %q", g.Name)
+	}
+	if g.Type == GroupTypeDynamic {
+		if err := s.checkQuery(ctx, g.Query); err != nil {
+			return Group{}, err
+		}
+	}
+	e := GroupEntity{Name: g.Name, Description: g.Description, Type: g.Type, Query: g.Query}
+	if err := s.repo.Create(ctx, &e); err != nil {
+		return Group{}, err
+	}
+	log.Infof("Created thing group %q", g.Name)
+	return toGroup(e), nil
+}
+
+// checkQuery runs the query of dynamic group to check it
+func (s *groupSvc) checkQuery(ctx context.Context, query string) error {
+	_, err := s.shadowSvc.Query(ctx, model.PageQuery{PageIndex: 1, PageSize: 1}, groupSql(query))
+	if err != nil {
+		return errors.WithMessage(err, "invalid query")
+	}
+	return nil
+}
+
+func (s *groupSvc) Update(ctx context.Context, name string, gu GroupUpdate) (Group, error) {
+	e, err := s.getEntity(ctx, name)
+	if err != nil {
+		return Group{}, err
+	}
+	g := toGroup(*e)
+	toUpdate := map[string]any{}
+	if gu.Description != nil {
+		g.Description = *gu.Description
+		toUpdate["description"] = g.Description
+	}
+	if gu.Query != nil {
+		g.Query = *gu.Query
+		toUpdate["query"] = g.Query
+	}
+	if err := g.valid(); err != nil {
+		return Group{}, err
+	}
+	if len(toUpdate) == 0 {
+		return g, nil
+	}
+	if gu.Query != nil {
+		// the new query is checked as the group's own, so that reference to the group itself can be detected
+		checkCtx := context.WithValue(ctx, pendingGroupCtxKey{}, shadow.GroupDef{Name: name, Query: g.Query})
+		if err := s.checkQuery(checkCtx, g.Query); err != nil {
+			return Group{}, err
+		}
+	}
+	if err := s.repo.Update(ctx, name, toUpdate); err != nil {
+		return Group{}, err
+	}
+	if gu.Query != nil {
+		s.changed(ctx, name)
+	}
+	log.Infof("Updated thing group %q", name)
+	return s.Get(ctx, name)
+}
+
+func (s *groupSvc) Delete(ctx context.Context, name string) error {
+	refs, err := s.referrers(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		return errors.WithMessagef(model.ErrInUse, "thing group %q is referenced by %s", name, strings.Join(refs, ", "))
+	}
+	if err := s.repo.Delete(ctx, name); err != nil {
+		return err
+	}
+	s.changed(ctx, name)
+	log.Infof("Deleted thing group %q", name)
+	return nil
+}
+
+// referrers dynamic groups and others referencing the group
+func (s *groupSvc) referrers(ctx context.Context, name string) ([]string, error) {
+	var res []string
+	dynamic, err := s.repo.ListByType(ctx, GroupTypeDynamic)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range dynamic {
+		if g.Name == name {
+			continue
+		}
+		names, err := shadow.GroupsInCondition(g.Query)
+		if err != nil {
+			log.Warnf("Parse query of thing group %q error: %v", g.Name, err)
+			continue
+		}
+		if slices.Contains(names, name) {
+			res = append(res, fmt.Sprintf("thing group %q", g.Name))
+		}
+	}
+	for _, f := range s.refFuncs {
+		l, err := f(ctx, name)
+		if err != nil {
+			return nil, errors.WithMessage(err, "get references of thing group")
+		}
+		res = append(res, l...)
+	}
+	return res, nil
+}
+
+func (s *groupSvc) Get(ctx context.Context, name string) (Group, error) {
+	e, err := s.getEntity(ctx, name)
+	if err != nil {
+		return Group{}, err
+	}
+	return toGroup(*e), nil
+}
+
+func (s *groupSvc) getEntity(ctx context.Context, name string) (*GroupEntity, error) {
+	e, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, errors.WithMessagef(model.ErrNotFound, "thing group %q", name)
+	}
+	return e, nil
+}
+
+func (s *groupSvc) Query(ctx context.Context, pq model.PageQuery) (GroupPage, error) {
+	p, err := s.repo.Query(ctx, pq)
+	if err != nil {
+		return GroupPage{}, err
+	}
+	res := GroupPage{Total: p.Total, Content: make([]Group, len(p.Content))}
+	for i, e := range p.Content {
+		res.Content[i] = toGroup(e)
+	}
+	return res, nil
+}
+
+func (s *groupSvc) AddThings(ctx context.Context, name string, thingIds []string) error {
+	if err := s.checkStaticMembers(ctx, name, thingIds); err != nil {
+		return err
+	}
+	thingIds = uniq(thingIds)
+	exist, err := s.repo.ExistThings(ctx, thingIds)
+	if err != nil {
+		return err
+	}
+	if len(exist) != len(thingIds) {
+		existSet := make(map[string]bool, len(exist))
+		for _, id := range exist {
+			existSet[id] = true
+		}
+		var notFound []string
+		for _, id := range thingIds {
+			if !existSet[id] {
+				notFound = append(notFound, id)
+			}
+		}
+		return errors.WithMessagef(model.ErrInvalidParams, "things not found: %s", strings.Join(notFound, ", "))
+	}
+	if err := s.repo.AddMembers(ctx, name, exist); err != nil {
+		return err
+	}
+	s.changed(ctx, name)
+	return nil
+}
+
+func (s *groupSvc) RemoveThings(ctx context.Context, name string, thingIds []string) error {
+	if err := s.checkStaticMembers(ctx, name, thingIds); err != nil {
+		return err
+	}
+	if err := s.repo.RemoveMembers(ctx, name, thingIds); err != nil {
+		return err
+	}
+	s.changed(ctx, name)
+	return nil
+}
+
+func (s *groupSvc) checkStaticMembers(ctx context.Context, name string, thingIds []string) error {
+	if len(thingIds) == 0 || len(thingIds) > MaxGroupThingsPerCall {
+		return errors.WithMessagef(model.ErrInvalidParams, "count of things should be between 1 and %d", MaxGroupThingsPerCall)
+	}
+	e, err := s.getEntity(ctx, name)
+	if err != nil {
+		return err
+	}
+	if e.Type != GroupTypeStatic {
+		return errors.WithMessagef(model.ErrInvalidParams, "things can only be added to or removed from static group")
+	}
+	return nil
+}
+
+func (s *groupSvc) ListThings(ctx context.Context, name string, pq model.PageQuery) (GroupThingsPage, error) {
+	if _, err := s.getEntity(ctx, name); err != nil {
+		return GroupThingsPage{}, err
+	}
+	q := fmt.Sprintf("select thingId from shadow where thingGroup = %s order by thingId", quoteSqlStr(name))
+	p, err := s.shadowSvc.Query(ctx, pq, q)
+	if err != nil {
+		return GroupThingsPage{}, err
+	}
+	return GroupThingsPage{Total: p.Total, Content: thingIdsOf(p.Content)}, nil
+}
+
+func (s *groupSvc) GroupsOfThing(ctx context.Context, thingId string) ([]string, error) {
+	res, err := s.repo.GroupsOfMember(ctx, thingId)
+	if err != nil {
+		return nil, err
+	}
+	dynamic, err := s.repo.ListByType(ctx, GroupTypeDynamic)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(dynamic))
+	for i, g := range dynamic {
+		names[i] = g.Name
+	}
+	matched, err := s.shadowSvc.MatchGroups(ctx, thingId, names)
+	if err != nil {
+		return nil, errors.WithMessage(err, "match dynamic groups")
+	}
+	return append(res, matched...), nil
+}
+
+// pendingGroupCtxKey context key of the definition of a group being updated, which is not saved yet
+type pendingGroupCtxKey struct{}
+
+// GroupDefsOf gets the group definitions for shadow query from the repo, see shadow.GroupDefFunc.
+// It's given to the shadow service, which the group service depends on.
+func GroupDefsOf(r GroupRepo) shadow.GroupDefFunc {
+	return func(ctx context.Context, names []string) ([]shadow.GroupDef, error) {
+		l, err := r.List(ctx, names)
+		if err != nil {
+			return nil, err
+		}
+		pending, _ := ctx.Value(pendingGroupCtxKey{}).(shadow.GroupDef)
+		res := make([]shadow.GroupDef, len(l))
+		for i, e := range l {
+			res[i] = shadow.GroupDef{Name: e.Name, Query: e.Query}
+			if e.Name == pending.Name {
+				res[i] = pending
+			}
+		}
+		return res, nil
+	}
+}
+
+func (s *groupSvc) RemoveFromGroups(ctx context.Context, thingId string) error {
+	return s.repo.RemoveFromAll(ctx, thingId)
+}
+
+func (s *groupSvc) changed(ctx context.Context, name string) {
+	for _, h := range s.changeHooks {
+		h(ctx, name)
+	}
+}
+
+func groupSql(cond string) string {
+	return "select thingId from shadow where " + cond
+}
+
+func quoteSqlStr(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func thingIdsOf(l []any) []string {
+	res := make([]string, 0, len(l))
+	for _, c := range l {
+		if m, ok := c.(map[string]any); ok {
+			if id, ok := m["thingId"].(string); ok {
+				res = append(res, id)
+			}
+		}
+	}
+	return res
+}
+
+func uniq(l []string) []string {
+	m := make(map[string]bool, len(l))
+	res := make([]string, 0, len(l))
+	for _, s := range l {
+		if !m[s] {
+			m[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
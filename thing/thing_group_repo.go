@@ -0,0 +1,115 @@
+package thing
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ruff.io/tio/pkg/model"
+)
+
+type groupRepo struct {
+	db *gorm.DB
+}
+
+var _ GroupRepo = (*groupRepo)(nil)
+
+func NewGroupRepo(db *gorm.DB) GroupRepo {
+	return &groupRepo{db: db}
+}
+
+func (r *groupRepo) Create(ctx context.Context, e *GroupEntity) error {
+	if err := r.db.WithContext(ctx).Create(e).Error; err != nil {
+		return errors.Wrap(err, "create thing group")
+	}
+	return nil
+}
+
+func (r *groupRepo) Update(ctx context.Context, name string, m map[string]any) error {
+	err := r.db.WithContext(ctx).Model(&GroupEntity{}).Where("name = ?", name).Updates(m).Error
+	return errors.Wrap(err, "update thing group")
+}
+
+func (r *groupRepo) Delete(ctx context.Context, name string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_name = ?", name).Delete(&GroupMemberEntity{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&GroupEntity{}).Error
+	})
+	return errors.Wrap(err, "delete thing group")
+}
+
+func (r *groupRepo) Get(ctx context.Context, name string) (*GroupEntity, error) {
+	var e GroupEntity
+	err := r.db.WithContext(ctx).Where("name = ?", name).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get thing group")
+	}
+	return &e, nil
+}
+
+func (r *groupRepo) List(ctx context.Context, names []string) ([]GroupEntity, error) {
+	var l []GroupEntity
+	err := r.db.WithContext(ctx).Where("name IN ?", names).Find(&l).Error
+	return l, errors.Wrap(err, "list thing group")
+}
+
+func (r *groupRepo) ListByType(ctx context.Context, typ string) ([]GroupEntity, error) {
+	var l []GroupEntity
+	err := r.db.WithContext(ctx).Where("type = ?", typ).Order("name").Find(&l).Error
+	return l, errors.Wrap(err, "list thing group by type")
+}
+
+func (r *groupRepo) Query(ctx context.Context, pq model.PageQuery) (model.PageData[GroupEntity], error) {
+	tx := r.db.WithContext(ctx).Model(&GroupEntity{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[GroupEntity]{}, errors.Wrap(err, "count thing group")
+	}
+	l := make([]GroupEntity, 0)
+	if err := tx.Order("name").Offset(pq.Offset()).Limit(pq.Limit()).Find(&l).Error; err != nil {
+		return model.PageData[GroupEntity]{}, errors.Wrap(err, "query thing group")
+	}
+	return model.PageData[GroupEntity]{Total: total, Content: l}, nil
+}
+
+func (r *groupRepo) AddMembers(ctx context.Context, name string, thingIds []string) error {
+	l := make([]GroupMemberEntity, len(thingIds))
+	for i, id := range thingIds {
+		l[i] = GroupMemberEntity{GroupName: name, ThingId: id}
+	}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&l).Error
+	return errors.Wrap(err, "add thing group members")
+}
+
+func (r *groupRepo) RemoveMembers(ctx context.Context, name string, thingIds []string) error {
+	err := r.db.WithContext(ctx).
+		Where("group_name = ? AND thing_id IN ?", name, thingIds).
+		Delete(&GroupMemberEntity{}).Error
+	return errors.Wrap(err, "remove thing group members")
+}
+
+func (r *groupRepo) GroupsOfMember(ctx context.Context, thingId string) ([]string, error) {
+	l := make([]string, 0)
+	err := r.db.WithContext(ctx).Model(&GroupMemberEntity{}).
+		Where("thing_id = ?", thingId).
+		Order("group_name").
+		Pluck("group_name", &l).Error
+	return l, errors.Wrap(err, "list groups of thing")
+}
+
+func (r *groupRepo) RemoveFromAll(ctx context.Context, thingId string) error {
+	err := r.db.WithContext(ctx).Where("thing_id = ?", thingId).Delete(&GroupMemberEntity{}).Error
+	return errors.Wrap(err, "remove thing from groups")
+}
+
+func (r *groupRepo) ExistThings(ctx context.Context, thingIds []string) ([]string, error) {
+	var l []string
+	err := r.db.WithContext(ctx).Model(&Entity{}).Where("id IN ?", thingIds).Pluck("id", &l).Error
+	return l, errors.Wrap(err, "list exist things")
+}
@@ -2,6 +2,8 @@ package thing
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -27,14 +29,27 @@ func (t thingRepo) Create(ctx context.Context, th Thing) (Thing, error) {
 			return er
 		}
 
-		// create Shadow
+		// create Shadow, with default tags of the thing type
 		defaultObj := []byte("{}")
+		tags := defaultObj
+		if th.ThingType != "" {
+			tt, err := getType(tx, th.ThingType)
+			if err != nil {
+				return err
+			}
+			if tt == nil {
+				return errors.WithMessagef(model.ErrInvalidParams, "thing type %q not found", th.ThingType)
+			}
+			if len(tt.DefaultTags) > 0 {
+				tags = tt.DefaultTags
+			}
+		}
 		shd := shadow.Entity{
 			ThingId:  th.Id,
 			Desired:  defaultObj,
 			Reported: defaultObj,
 			Metadata: defaultObj,
-			Tags:     defaultObj,
+			Tags:     tags,
 			Version:  1,
 		}
 		if err := tx.Create(&shd).Error; err != nil {
@@ -51,10 +66,20 @@ func (t thingRepo) Create(ctx context.Context, th Thing) (Thing, error) {
 }
 
 func (t *thingRepo) Update(ctx context.Context, id string, tu ThingUpdate) error {
-	if tu.Enabled == nil {
+	up := map[string]any{}
+	if tu.Enabled != nil {
+		up["enabled"] = *tu.Enabled
+	}
+	if a := tu.Attributes; a != nil {
+		up["serial_number"] = a.SerialNumber
+		up["model"] = a.Model
+		up["owner"] = a.Owner
+		up["location"] = a.Location
+	}
+	if len(up) == 0 {
 		return nil
 	}
-	res := t.db.Model(&Entity{}).Where("id = ?", id).Update("enabled", *tu.Enabled)
+	res := t.db.Model(&Entity{}).Where("id = ?", id).Updates(up)
 	return res.Error
 }
 
@@ -72,6 +97,10 @@ func (t *thingRepo) Delete(ctx context.Context, id string) error {
 		if er := tx.Delete(&shadow.ConnStatusEntity{ThingId: id}).Error; er != nil {
 			return er
 		}
+		// detach sub-devices of the gateway
+		if er := tx.Model(&Entity{}).Where("parent_id = ?", id).Update("parent_id", "").Error; er != nil {
+			return er
+		}
 		return nil
 	})
 	return err
@@ -82,27 +111,56 @@ func (t *thingRepo) Query(ctx context.Context, pq PageQuery) (model.PageData[Thi
 	limit := pq.Limit()
 	var page model.PageData[Thing]
 	var total int64
-	t.db.WithContext(ctx).Model(&Entity{}).Count(&total)
+	cq := t.filter(t.db.WithContext(ctx).Model(&Entity{}), pq)
+	if err := cq.Count(&total).Error; err != nil {
+		return page, errors.Wrap(err, "count things")
+	}
 	if total == 0 {
 		page.Content = []Thing{}
 		return page, nil
 	}
 	page.Total = total
-	q := t.db.WithContext(ctx).Model(&Entity{}).
-		Order("created_at ASC").
+	q := t.filter(t.db.WithContext(ctx).Model(&Entity{}), pq).
+		Order(orderBy(pq.Sort)).
 		Offset(offset).
 		Limit(limit)
-	if pq.Enabled != nil {
-		q.Where("enabled = ?", *pq.Enabled)
+
+	var l []Entity
+	if err := q.Find(&l).Error; err != nil {
+		return page, errors.Wrap(err, "query things")
+	}
+	page.Content = make([]Thing, len(l))
+	for i, e := range l {
+		page.Content[i] = ToThing(e)
 	}
+	return page, nil
+}
 
-	q.Find(&page.Content)
-	if !pq.WithAuthValue {
-		for i := range page.Content {
-			page.Content[i].AuthValue = ""
+func (t *thingRepo) filter(q *gorm.DB, pq PageQuery) *gorm.DB {
+	if pq.Enabled != nil {
+		q = q.Where("enabled = ?", *pq.Enabled)
+	}
+	if pq.ThingType != "" {
+		q = q.Where("thing_type = ?", pq.ThingType)
+	}
+	for _, n := range AttrNames {
+		v, ok := pq.AttrFilters[n]
+		if !ok {
+			continue
+		}
+		col := attrColumns[n]
+		if p, found := strings.CutSuffix(v, "*"); found {
+			q = q.Where(col+" LIKE ? ESCAPE '!'", likeEscape(p)+"%")
+		} else {
+			q = q.Where(col+" = ?", v)
 		}
 	}
-	return page, nil
+	if pq.Search != "" {
+		s := "%" + likeEscape(pq.Search) + "%"
+		q = q.Where("id LIKE ? ESCAPE '!' OR serial_number LIKE ? ESCAPE '!' OR model LIKE ? ESCAPE '!' "+
+			"OR owner LIKE ? ESCAPE '!' OR location LIKE ? ESCAPE '!'", s, s, s, s, s)
+	}
+	return q
 }
 
 func (t *thingRepo) Get(ctx context.Context, id string) (*Thing, error) {
@@ -125,3 +183,72 @@ func (t *thingRepo) Exist(ctx context.Context, id string) (bool, error) {
 		Error
 	return exists, err
 }
+
+func (t *thingRepo) GetCredential(ctx context.Context, id string) (Credential, error) {
+	var l []Entity
+	err := t.db.WithContext(ctx).
+		Select("auth_value", "secondary_auth_value", "secondary_expires_at").
+		Where("id = ?", id).
+		Limit(1).
+		Find(&l).Error
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "get thing credential")
+	}
+	if len(l) == 0 {
+		return Credential{}, errors.WithMessagef(model.ErrNotFound, "thing %q", id)
+	}
+	return Credential{
+		Primary:            l[0].AuthValue,
+		Secondary:          l[0].SecondaryAuthValue,
+		SecondaryExpiresAt: l[0].SecondaryExpiresAt,
+	}, nil
+}
+
+func (t *thingRepo) UpdateCredential(ctx context.Context, id string, c Credential) error {
+	err := t.db.WithContext(ctx).Model(&Entity{}).Where("id = ?", id).Updates(map[string]any{
+		"auth_value":           c.Primary,
+		"secondary_auth_value": c.Secondary,
+		"secondary_expires_at": c.SecondaryExpiresAt,
+	}).Error
+	return errors.Wrap(err, "update thing credential")
+}
+
+func (t *thingRepo) ListSecondaryExpired(ctx context.Context, before time.Time) ([]string, error) {
+	var ids []string
+	err := t.db.WithContext(ctx).Model(&Entity{}).
+		Where("secondary_auth_value <> '' AND secondary_expires_at < ?", before).
+		Order("id").
+		Pluck("id", &ids).Error
+	return ids, errors.Wrap(err, "list things of expired secondary secret")
+}
+
+func (t *thingRepo) SetParent(ctx context.Context, id string, parentId string) error {
+	err := t.db.WithContext(ctx).Model(&Entity{}).Where("id = ?", id).Update("parent_id", parentId).Error
+	return errors.Wrap(err, "set thing parent")
+}
+
+func (t *thingRepo) ListChildren(ctx context.Context, parentId string) ([]Thing, error) {
+	var l []Entity
+	err := t.db.WithContext(ctx).Where("parent_id = ?", parentId).Order("id").Find(&l).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "list thing children")
+	}
+	res := make([]Thing, len(l))
+	for i, e := range l {
+		res[i] = ToThing(e)
+	}
+	return res, nil
+}
+
+func (t *thingRepo) ListParents(ctx context.Context) (map[string]string, error) {
+	var l []Entity
+	err := t.db.WithContext(ctx).Select("id", "parent_id").Where("parent_id <> ''").Find(&l).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "list thing parents")
+	}
+	res := make(map[string]string, len(l))
+	for _, e := range l {
+		res[e.Id] = e.ParentId
+	}
+	return res, nil
+}
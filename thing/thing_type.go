@@ -0,0 +1,307 @@
+package thing
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+	"gorm.io/datatypes"
+	"ruff.io/tio/pkg/log"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+)
+
+// Thing type, also known as product model.
+// Things reference a type at creation, the type provides default tags of their shadow,
+// schemas of the desired and reported state, and direct methods they support.
+
+type ThingType struct {
+	Name           string             `json:"name"`
+	Description    string             `json:"description"`
+	DefaultTags    map[string]any     `json:"defaultTags,omitempty" description:"tags of shadow for things created with the type"`
+	DesiredSchema  map[string]any     `json:"desiredSchema,omitempty" description:"JSON Schema of desired state"`
+	ReportedSchema map[string]any     `json:"reportedSchema,omitempty" description:"JSON Schema of reported state"`
+	Methods        []shadow.MethodDef `json:"methods"`
+	UpdatedAt      time.Time          `json:"updatedAt"`
+	CreatedAt      time.Time          `json:"createdAt"`
+}
+
+type TypePage = model.PageData[ThingType]
+
+type TypeService interface {
+	// Create the type, and the methods if any
+	Create(ctx context.Context, tt ThingType) (ThingType, error)
+	// Update replaces the type, methods are replaced only when it's not nil
+	Update(ctx context.Context, tt ThingType) (ThingType, error)
+	// Delete the type and its methods, a type in use by things can't be deleted
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (ThingType, error)
+	Query(ctx context.Context, pq model.PageQuery) (TypePage, error)
+
+	// StateSchemaOf gets the state schemas of the thing's type, see shadow.StateSchemaFunc
+	StateSchemaOf(ctx context.Context, thingId string) (desired, reported map[string]any, err error)
+}
+
+type TypeRepo interface {
+	Create(ctx context.Context, e *TypeEntity) error
+	Save(ctx context.Context, e *TypeEntity) error
+	Delete(ctx context.Context, name string) error
+	Get(ctx context.Context, name string) (*TypeEntity, error)
+	Query(ctx context.Context, pq model.PageQuery) (model.PageData[TypeEntity], error)
+	// GetOfThing gets type of the thing, nil if the thing has no type
+	GetOfThing(ctx context.Context, thingId string) (*TypeEntity, error)
+	CountThings(ctx context.Context, name string) (int64, error)
+}
+
+type TypeEntity struct {
+	Name           string `gorm:"primaryKey;size:64"`
+	Description    string `gorm:"size:512;NOT NULL;default:''"`
+	DefaultTags    datatypes.JSON
+	DesiredSchema  datatypes.JSON
+	ReportedSchema datatypes.JSON
+
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (TypeEntity) TableName() string {
+	return "thing_type"
+}
+
+func toTypeEntity(tt ThingType) (TypeEntity, error) {
+	e := TypeEntity{
+		Name:        tt.Name,
+		Description: tt.Description,
+		CreatedAt:   tt.CreatedAt,
+	}
+	var err error
+	if e.DefaultTags, err = marshalNullable(tt.DefaultTags); err != nil {
+		return e, errors.Wrap(err, "marshal default tags")
+	}
+	if e.DesiredSchema, err = marshalNullable(tt.DesiredSchema); err != nil {
+		return e, errors.Wrap(err, "marshal desired schema")
+	}
+	if e.ReportedSchema, err = marshalNullable(tt.ReportedSchema); err != nil {
+		return e, errors.Wrap(err, "marshal reported schema")
+	}
+	return e, nil
+}
+
+func toThingType(e TypeEntity) (ThingType, error) {
+	tt := ThingType{
+		Name:        e.Name,
+		Description: e.Description,
+		Methods:     []shadow.MethodDef{},
+		UpdatedAt:   e.UpdatedAt,
+		CreatedAt:   e.CreatedAt,
+	}
+	if err := unmarshalNullable(e.DefaultTags, &tt.DefaultTags); err != nil {
+		return tt, errors.Wrap(err, "unmarshal default tags")
+	}
+	if err := unmarshalNullable(e.DesiredSchema, &tt.DesiredSchema); err != nil {
+		return tt, errors.Wrap(err, "unmarshal desired schema")
+	}
+	if err := unmarshalNullable(e.ReportedSchema, &tt.ReportedSchema); err != nil {
+		return tt, errors.Wrap(err, "unmarshal reported schema")
+	}
+	return tt, nil
+}
+
+func marshalNullable(m map[string]any) (datatypes.JSON, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func unmarshalNullable(j datatypes.JSON, m *map[string]any) error {
+	if len(j) == 0 {
+		return nil
+	}
+	return json.Unmarshal(j, m)
+}
+
+func (tt ThingType) valid() error {
+	// "methods" is reserved for the path of method definitions api
+	if !IdValid(tt.Name) || len(tt.Name) > 64 || tt.Name == "methods" {
+		return errors.WithMessagef(model.ErrInvalidParams, "thing type name %q", tt.Name)
+	}
+	if len(tt.Description) > 512 {
+		return errors.WithMessage(model.ErrInvalidParams, "description length should be less than 512")
+	}
+	for n, s := range map[string]map[string]any{"desiredSchema": tt.DesiredSchema, "reportedSchema": tt.ReportedSchema} {
+		if s == nil {
+			continue
+		}
+		if _, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(s)); err != nil {
+			return errors.WithMessagef(model.ErrInvalidParams, "invalid %s: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// service implement
+
+type typeSvc struct {
+	repo   TypeRepo
+	defSvc shadow.MethodDefService
+}
+
+var _ TypeService = (*typeSvc)(nil)
+
+func NewTypeSvc(r TypeRepo, defSvc shadow.MethodDefService) TypeService {
+	return &typeSvc{repo: r, defSvc: defSvc}
+}
+
+func (s *typeSvc) Create(ctx context.Context, tt ThingType) (ThingType, error) {
+	if err := tt.valid(); err != nil {
+		return ThingType{}, err
+	}
+	if old, err := s.repo.Get(ctx, tt.Name); err != nil {
+		return ThingType{}, err
+	} else if old != nil {
+		return ThingType{}, errors.WithMessagef(model.ErrDuplicated, "thing type %q", tt.Name)
+	}
+	e, err := toTypeEntity(tt)
+	if err != nil {
+		return ThingType{}, err
+	}
+	if err := s.repo.Create(ctx, &e); err != nil {
+		return ThingType{}, err
+	}
+	if tt.Methods != nil {
+		if err := s.putMethods(ctx, tt.Name, tt.Methods); err != nil {
+			return ThingType{}, err
+		}
+	}
+	log.Infof("Created thing type %q", tt.Name)
+	return s.Get(ctx, tt.Name)
+}
+
+func (s *typeSvc) Update(ctx context.Context, tt ThingType) (ThingType, error) {
+	if err := tt.valid(); err != nil {
+		return ThingType{}, err
+	}
+	old, err := s.repo.Get(ctx, tt.Name)
+	if err != nil {
+		return ThingType{}, err
+	}
+	if old == nil {
+		return ThingType{}, errors.WithMessagef(model.ErrNotFound, "thing type %q", tt.Name)
+	}
+	tt.CreatedAt = old.CreatedAt
+	e, err := toTypeEntity(tt)
+	if err != nil {
+		return ThingType{}, err
+	}
+	if err := s.repo.Save(ctx, &e); err != nil {
+		return ThingType{}, err
+	}
+	if tt.Methods != nil {
+		if err := s.putMethods(ctx, tt.Name, tt.Methods); err != nil {
+			return ThingType{}, err
+		}
+	}
+	log.Infof("Updated thing type %q", tt.Name)
+	return s.Get(ctx, tt.Name)
+}
+
+// putMethods replaces methods of the type
+func (s *typeSvc) putMethods(ctx context.Context, typeName string, methods []shadow.MethodDef) error {
+	keep := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		m.ThingType = typeName
+		if _, err := s.defSvc.Put(ctx, m); err != nil {
+			return errors.WithMessagef(err, "put method %q", m.Name)
+		}
+		keep[m.Name] = true
+	}
+	old, err := s.defSvc.List(ctx, typeName)
+	if err != nil {
+		return err
+	}
+	for _, m := range old {
+		if keep[m.Name] {
+			continue
+		}
+		if err := s.defSvc.Delete(ctx, typeName, m.Name); err != nil {
+			return errors.WithMessagef(err, "delete method %q", m.Name)
+		}
+	}
+	return nil
+}
+
+func (s *typeSvc) Delete(ctx context.Context, name string) error {
+	n, err := s.repo.CountThings(ctx, name)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errors.WithMessagef(model.ErrInvalidParams, "thing type %q is in use by %d things", name, n)
+	}
+	if err := s.repo.Delete(ctx, name); err != nil {
+		return err
+	}
+	if err := s.putMethods(ctx, name, nil); err != nil {
+		return errors.WithMessage(err, "delete methods of thing type")
+	}
+	log.Infof("Deleted thing type %q", name)
+	return nil
+}
+
+func (s *typeSvc) Get(ctx context.Context, name string) (ThingType, error) {
+	e, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return ThingType{}, err
+	}
+	if e == nil {
+		return ThingType{}, errors.WithMessagef(model.ErrNotFound, "thing type %q", name)
+	}
+	tt, err := toThingType(*e)
+	if err != nil {
+		return ThingType{}, err
+	}
+	if l, err := s.defSvc.List(ctx, name); err != nil {
+		return ThingType{}, err
+	} else {
+		tt.Methods = append(tt.Methods, l...)
+	}
+	return tt, nil
+}
+
+func (s *typeSvc) Query(ctx context.Context, pq model.PageQuery) (TypePage, error) {
+	p, err := s.repo.Query(ctx, pq)
+	if err != nil {
+		return TypePage{}, err
+	}
+	defs, err := s.defSvc.List(ctx, "")
+	if err != nil {
+		return TypePage{}, err
+	}
+	byType := map[string][]shadow.MethodDef{}
+	for _, d := range defs {
+		byType[d.ThingType] = append(byType[d.ThingType], d)
+	}
+	res := TypePage{Total: p.Total, Content: make([]ThingType, len(p.Content))}
+	for i, e := range p.Content {
+		if res.Content[i], err = toThingType(e); err != nil {
+			return TypePage{}, err
+		}
+		res.Content[i].Methods = append(res.Content[i].Methods, byType[e.Name]...)
+	}
+	return res, nil
+}
+
+func (s *typeSvc) StateSchemaOf(ctx context.Context, thingId string) (desired, reported map[string]any, err error) {
+	e, err := s.repo.GetOfThing(ctx, thingId)
+	if err != nil || e == nil {
+		return nil, nil, err
+	}
+	tt, err := toThingType(*e)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tt.DesiredSchema, tt.ReportedSchema, nil
+}
@@ -0,0 +1,88 @@
+package thing
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"ruff.io/tio/pkg/model"
+)
+
+type typeRepo struct {
+	db *gorm.DB
+}
+
+var _ TypeRepo = (*typeRepo)(nil)
+
+func NewTypeRepo(db *gorm.DB) TypeRepo {
+	return &typeRepo{db: db}
+}
+
+func (r *typeRepo) Create(ctx context.Context, e *TypeEntity) error {
+	if err := r.db.WithContext(ctx).Create(e).Error; err != nil {
+		return errors.Wrap(err, "create thing type")
+	}
+	return nil
+}
+
+func (r *typeRepo) Save(ctx context.Context, e *TypeEntity) error {
+	if err := r.db.WithContext(ctx).Save(e).Error; err != nil {
+		return errors.Wrap(err, "save thing type")
+	}
+	return nil
+}
+
+func (r *typeRepo) Delete(ctx context.Context, name string) error {
+	err := r.db.WithContext(ctx).Where("name = ?", name).Delete(&TypeEntity{}).Error
+	return errors.Wrap(err, "delete thing type")
+}
+
+func (r *typeRepo) Get(ctx context.Context, name string) (*TypeEntity, error) {
+	return getType(r.db.WithContext(ctx), name)
+}
+
+func getType(db *gorm.DB, name string) (*TypeEntity, error) {
+	var e TypeEntity
+	err := db.Where("name = ?", name).Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get thing type")
+	}
+	return &e, nil
+}
+
+func (r *typeRepo) Query(ctx context.Context, pq model.PageQuery) (model.PageData[TypeEntity], error) {
+	tx := r.db.WithContext(ctx).Model(&TypeEntity{})
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return model.PageData[TypeEntity]{}, errors.Wrap(err, "count thing type")
+	}
+	l := make([]TypeEntity, 0)
+	if err := tx.Order("name").Offset(pq.Offset()).Limit(pq.Limit()).Find(&l).Error; err != nil {
+		return model.PageData[TypeEntity]{}, errors.Wrap(err, "query thing type")
+	}
+	return model.PageData[TypeEntity]{Total: total, Content: l}, nil
+}
+
+func (r *typeRepo) GetOfThing(ctx context.Context, thingId string) (*TypeEntity, error) {
+	var e TypeEntity
+	err := r.db.WithContext(ctx).
+		Joins("INNER JOIN thing t ON t.thing_type = thing_type.name").
+		Where("t.id = ?", thingId).
+		Take(&e).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get thing type of thing")
+	}
+	return &e, nil
+}
+
+func (r *typeRepo) CountThings(ctx context.Context, name string) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&Entity{}).Where("thing_type = ?", name).Count(&n).Error
+	return n, errors.Wrap(err, "count things of type")
+}
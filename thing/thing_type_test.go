@@ -0,0 +1,100 @@
+package thing_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"ruff.io/tio/db/mock"
+	"ruff.io/tio/pkg/model"
+	"ruff.io/tio/shadow"
+	"ruff.io/tio/thing"
+)
+
+func TestTypeSvc(t *testing.T) {
+	db := mock.NewSqliteConnTest()
+	require.NoError(t, db.AutoMigrate(thing.Entity{}, thing.TypeEntity{}, shadow.Entity{}, &shadow.ConnStatusEntity{},
+		&shadow.MethodDefEntity{}))
+	repo := thing.NewThingRepo(db)
+	svc := thing.NewTypeSvc(thing.NewTypeRepo(db), shadow.NewMethodDefSvc(shadow.NewMethodDefRepo(db)))
+
+	t.Run("create", func(t *testing.T) {
+		tt, err := svc.Create(ctxTest, thing.ThingType{
+			Name:          "light",
+			Description:   "smart light",
+			DefaultTags:   map[string]any{"vendor": "ruff"},
+			DesiredSchema: map[string]any{"type": "object"},
+			Methods:       []shadow.MethodDef{{Name: "turnOn"}, {Name: "turnOff"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "smart light", tt.Description)
+		require.Equal(t, map[string]any{"vendor": "ruff"}, tt.DefaultTags)
+		require.Len(t, tt.Methods, 2)
+		require.Equal(t, "light", tt.Methods[0].ThingType)
+
+		_, err = svc.Create(ctxTest, thing.ThingType{Name: "light"})
+		require.ErrorIs(t, err, model.ErrDuplicated)
+		_, err = svc.Create(ctxTest, thing.ThingType{Name: "bad", DesiredSchema: map[string]any{"type": 1}})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+		_, err = svc.Create(ctxTest, thing.ThingType{Name: "methods"})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+	})
+
+	t.Run("update replaces methods", func(t *testing.T) {
+		tt, err := svc.Update(ctxTest, thing.ThingType{
+			Name:    "light",
+			Methods: []shadow.MethodDef{{Name: "blink"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "", tt.Description)
+		require.Nil(t, tt.DefaultTags)
+		require.Len(t, tt.Methods, 1)
+		require.Equal(t, "blink", tt.Methods[0].Name)
+
+		tt, err = svc.Update(ctxTest, thing.ThingType{Name: "light", DefaultTags: map[string]any{"vendor": "ruff"}})
+		require.NoError(t, err)
+		require.Len(t, tt.Methods, 1, "methods should be kept when not specified")
+
+		_, err = svc.Update(ctxTest, thing.ThingType{Name: "not-exist"})
+		require.ErrorIs(t, err, model.ErrNotFound)
+	})
+
+	t.Run("query", func(t *testing.T) {
+		_, err := svc.Create(ctxTest, thing.ThingType{Name: "switch"})
+		require.NoError(t, err)
+		p, err := svc.Query(ctxTest, model.PageQuery{PageIndex: 1, PageSize: 10})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), p.Total)
+		require.Equal(t, "light", p.Content[0].Name)
+		require.Len(t, p.Content[0].Methods, 1)
+		require.Equal(t, "switch", p.Content[1].Name)
+		require.Empty(t, p.Content[1].Methods)
+	})
+
+	t.Run("create thing with type", func(t *testing.T) {
+		_, err := repo.Create(ctxTest, thing.Thing{Id: "light-1", ThingType: "light"})
+		require.NoError(t, err)
+		var se shadow.Entity
+		require.NoError(t, db.Where("thing_id = ?", "light-1").Take(&se).Error)
+		require.JSONEq(t, `{"vendor":"ruff"}`, string(se.Tags))
+
+		_, err = repo.Create(ctxTest, thing.Thing{Id: "light-2", ThingType: "not-exist"})
+		require.ErrorIs(t, err, model.ErrInvalidParams)
+
+		d, _, err := svc.StateSchemaOf(ctxTest, "light-1")
+		require.NoError(t, err)
+		require.Nil(t, d)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := svc.Delete(ctxTest, "light")
+		require.ErrorIs(t, err, model.ErrInvalidParams, "type in use can't be deleted")
+
+		require.NoError(t, repo.Delete(ctxTest, "light-1"))
+		require.NoError(t, svc.Delete(ctxTest, "light"))
+		_, err = svc.Get(ctxTest, "light")
+		require.ErrorIs(t, err, model.ErrNotFound)
+		p, err := svc.Query(ctxTest, model.PageQuery{PageIndex: 1, PageSize: 10})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), p.Total)
+	})
+}
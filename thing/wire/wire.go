@@ -14,7 +14,8 @@ import (
 	"ruff.io/tio/shadow"
 )
 
-func InitSvc(ctx context.Context, dbConn *gorm.DB, shadowSvc shadow.Service, connector connector.Connectivity) thing.Service {
+func InitSvc(ctx context.Context, dbConn *gorm.DB, shadowSvc shadow.Service, connector connector.Connectivity,
+	mh shadow.MethodHandler, deleteHooks []thing.DeleteHook) thing.Service {
 	wire.Build(
 		thing.NewThingRepo,
 		uuid.New,
@@ -17,9 +17,10 @@ import (
 
 // Injectors from wire.go:
 
-func InitSvc(ctx context.Context, dbConn *gorm.DB, shadowSvc shadow.Service, connector connector.Connectivity) thing.Service {
+func InitSvc(ctx context.Context, dbConn *gorm.DB, shadowSvc shadow.Service, connector connector.Connectivity,
+	mh shadow.MethodHandler, deleteHooks []thing.DeleteHook) thing.Service {
 	repo := thing.NewThingRepo(dbConn)
 	idProvider := uuid.New()
-	service := thing.NewSvc(repo, idProvider, shadowSvc, connector)
+	service := thing.NewSvc(repo, idProvider, shadowSvc, connector, mh, deleteHooks)
 	return service
 }
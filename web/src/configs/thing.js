@@ -17,11 +17,6 @@ export const metaFields = [
     tips: "",
     type: "tag",
   },
-  {
-    key: "authValue",
-    label: "Auth Value",
-    type: "password",
-  },
   {
     key: "createAt",
     label: "Created At",
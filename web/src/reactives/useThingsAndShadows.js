@@ -7,6 +7,7 @@ export default () => {
   const store = useStore();
   const route = useRoute();
   const things = computed(() => store.state.app.things);
+  const thingSecrets = computed(() => store.state.app.thingSecrets);
   const shadowListUpdateTag = computed(
     () => store.state.app.shadowListUpdateTag
   );
@@ -24,7 +25,6 @@ export default () => {
         const { data } = await getThings({
           pageIndex,
           pageSize: 9999,
-          withAuthValue: true,
         });
         things.push(...data.content);
         if (things.length < data.total) {
@@ -57,6 +57,13 @@ export default () => {
         password,
       });
       console.log("addThing res:", res);
+      // the password is only returned at creation
+      const { thingId: createdId, authValue } = res.data || {};
+      if (createdId && authValue) {
+        store.commit("app/setState", {
+          thingSecrets: { ...store.state.app.thingSecrets, [createdId]: authValue },
+        });
+      }
       updateThings();
       store.commit("app/setState", {
         shadowListUpdateTag: store.state.app.shadowListUpdateTag + 1,
@@ -110,6 +117,7 @@ export default () => {
   return {
     route,
     things,
+    thingSecrets,
     shadowListUpdateTag,
     selectedThingId,
     currentShadow,
@@ -20,6 +20,8 @@ export default {
     return {
       tioConfig: {},
       things: [],
+      // passwords returned when things are created here, they can't be got from the API later
+      thingSecrets: {},
       shadowListUpdateTag: 0,
       currentShadow: {},
       httpRequestLogs: [],
@@ -49,6 +51,7 @@ export default {
 } as StoreOptions<{
   tioConfig: {};
   things: any[];
+  thingSecrets: Record<string, string>;
   shadowListUpdateTag: number;
   currentShadow: Record<string, any>;
   httpRequestLogs: Array<HTTPRequestLog>;